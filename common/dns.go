@@ -0,0 +1,36 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+type IResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// NewResolver asks the given DNS server (host:port) everything, an empty address is the system
+// resolver
+func NewResolver(address string) IResolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, address)
+		}}
+}
+
+// IsDNSNotFound is an answer without records (ie. NXDOMAIN, no MX), as opposed to a lookup that
+// never got an answer
+func IsDNSNotFound(err error) bool {
+	dnsErr := &net.DNSError{}
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
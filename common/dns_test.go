@@ -0,0 +1,21 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package common
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver(t *testing.T) {
+	assert.Equal(t, net.DefaultResolver, NewResolver(""))
+	assert.NotEqual(t, net.DefaultResolver, NewResolver("127.0.0.1:53"))
+}
+
+func TestIsDNSNotFound(t *testing.T) {
+	assert.True(t, IsDNSNotFound(&net.DNSError{Err: "no such host", IsNotFound: true}))
+	assert.False(t, IsDNSNotFound(&net.DNSError{Err: "i/o timeout", IsTimeout: true}))
+	assert.False(t, IsDNSNotFound(errors.New("nope")))
+}
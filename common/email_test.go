@@ -0,0 +1,44 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package common
+
+import (
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/mcnijman/go-emailaddress"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockSender struct {
+	fail    bool
+	content []byte
+}
+
+func (x *MockSender) SendMail(server string, auth smtp.Auth, from string, recipients []string, content []byte) error {
+	if x.fail {
+		return errors.New("email failed")
+	}
+	x.content = content
+	return nil
+}
+
+func TestSendEmail(t *testing.T) {
+	sender := &MockSender{}
+	email := NewEmail(sender)
+	recipient, _ := emailaddress.Parse("test@example.com")
+
+	assert.Nil(t, email.Send("test", "this is the body", recipient, false))
+	assert.True(t, strings.HasSuffix(string(sender.content), "Subject: test\n\nthis is the body"))
+	assert.Nil(t, email.Send("test", "this is the body", recipient, true))
+	assert.True(t, strings.Contains(string(sender.content), "<html><body><p>this is the body</p></body></html>"))
+
+	sender.fail = true
+	assert.NotNil(t, email.Send("test", "this is the body", recipient, false))
+}
+
+func TestNewSender(t *testing.T) {
+	err := NewSender().SendMail("", nil, "", nil, nil)
+	assert.NotNil(t, err)
+}
@@ -2,6 +2,8 @@
 package common
 
 import (
+	"sync"
+
 	"github.com/fsnotify/fsnotify"
 	"github.com/osintami/fingerprintz/log"
 )
@@ -12,6 +14,7 @@ type IFileWatcher interface {
 }
 
 type FileWatcher struct {
+	mu      sync.RWMutex
 	watcher *fsnotify.Watcher
 	watched map[string]func()
 }
@@ -31,7 +34,10 @@ func (x *FileWatcher) Add(file string, refresh func()) error {
 		return err
 	}
 
+	// NOTE:  files replaced by a rename are added again to follow the new file
+	x.mu.Lock()
 	x.watched[file] = refresh
+	x.mu.Unlock()
 	return nil
 }
 
@@ -43,7 +49,9 @@ func (x *FileWatcher) Listen() {
 			for {
 				event, ok := <-x.watcher.Events
 				if ok && event.Op == fsnotify.Chmod {
+					x.mu.RLock()
 					refresh := x.watched[event.Name]
+					x.mu.RUnlock()
 					if refresh != nil {
 						refresh()
 					}
@@ -86,6 +86,8 @@ type DataResult struct {
 	Str  *string  `json:",omitempty"`
 	Num  *float64 `json:",omitempty"`
 	Bool *bool    `json:",omitempty"`
+	// a rule that couldn't decide because its inputs were missing
+	Unknown bool `json:",omitempty"`
 }
 
 func (x DataResult) IsEmpty() bool {
@@ -108,6 +110,12 @@ const (
 	INPUT_KEY  = "key"
 	INPUT_RULE = "rule"
 	INPUT_CSV  = "csv"
+	// maximum total latency for a request
+	INPUT_BUDGET = "budget"
+	// trace how a rule came to its answer
+	INPUT_EXPLAIN = "explain"
+	// what an ad-hoc rule does with missing inputs
+	INPUT_MISSING = "missing"
 )
 
 type DataInputs map[string]string
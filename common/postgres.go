@@ -22,7 +22,7 @@ type PostgresConfig struct {
 }
 
 func OpenDB(cfg *PostgresConfig, logPath string) (*gorm.DB, error) {
-	fh, err := os.OpenFile(logPath+"/postgres.log", os.O_CREATE|os.O_APPEND, 0664)
+	fh, err := os.OpenFile(logPath+"/postgres.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
 	if err != nil {
 		return nil, err
 	}
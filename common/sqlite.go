@@ -0,0 +1,29 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package common
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	gorm_log "gorm.io/gorm/logger"
+)
+
+func OpenSQLite(fileName string, logPath string) (*gorm.DB, error) {
+	fh, err := os.OpenFile(logPath+"/sqlite.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		return nil, err
+	}
+	myLog := gorm_log.New(log.New(fh, "\r\n", log.LstdFlags),
+		gorm_log.Config{
+			SlowThreshold:             time.Second,
+			LogLevel:                  gorm_log.Error,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  true,
+		},
+	)
+
+	return gorm.Open(sqlite.Open(fileName), &gorm.Config{Logger: myLog})
+}
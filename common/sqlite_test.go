@@ -0,0 +1,24 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package common
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteOpen(t *testing.T) {
+	db, err := OpenSQLite("/tmp/test.sqlite", ".")
+	assert.NotNil(t, db)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Exec("select 1").Error)
+	os.Remove("/tmp/test.sqlite")
+	os.Remove("./sqlite.log")
+}
+
+func TestSQLiteOpenBadPath(t *testing.T) {
+	db, err := OpenSQLite("/tmp/test.sqlite", "...")
+	assert.Nil(t, db)
+	assert.NotNil(t, err)
+}
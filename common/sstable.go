@@ -0,0 +1,275 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/osintami/fingerprintz/log"
+)
+
+// sorted string table layout:
+//   blocks  - (uvarint key length, key, uvarint value length, value)...
+//   index   - (uvarint key length, first key, uvarint block offset, uvarint block length)...
+//   footer  - uint64 index offset, uint64 block count, 8 byte magic
+
+const SSTABLE_BLOCK_SIZE = 64 * 1024
+const SSTABLE_FOOTER_SIZE = 24
+
+var SSTABLE_MAGIC = []byte("OSSTBL01")
+
+var ErrSortOrder = errors.New("keys out of order")
+var ErrBadSSTable = errors.New("bad sstable file")
+
+type sstIndex struct {
+	firstKey string
+	offset   uint64
+	length   uint64
+}
+
+type SSTableWriter struct {
+	fh       *os.File
+	w        *bufio.Writer
+	block    bytes.Buffer
+	first    string
+	last     string
+	count    int
+	offset   uint64
+	index    []sstIndex
+	scratch  [binary.MaxVarintLen64]byte
+	hasFirst bool
+}
+
+func NewSSTableWriter(fileName string) (*SSTableWriter, error) {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		log.Error().Err(err).Str("component", "sstable").Str("file", fileName).Msg("create")
+		return nil, err
+	}
+	return &SSTableWriter{fh: fh, w: bufio.NewWriterSize(fh, SSTABLE_BLOCK_SIZE)}, nil
+}
+
+// Append adds the next key, keys must arrive in strictly increasing order
+func (x *SSTableWriter) Append(key string, value []byte) error {
+	if x.count > 0 && key <= x.last {
+		return ErrSortOrder
+	}
+	if !x.hasFirst {
+		x.first = key
+		x.hasFirst = true
+	}
+	x.putBytes(&x.block, []byte(key))
+	x.putBytes(&x.block, value)
+	x.last = key
+	x.count++
+
+	if x.block.Len() >= SSTABLE_BLOCK_SIZE {
+		return x.flushBlock()
+	}
+	return nil
+}
+
+func (x *SSTableWriter) Count() int {
+	return x.count
+}
+
+func (x *SSTableWriter) Close() error {
+	defer x.fh.Close()
+
+	if err := x.flushBlock(); err != nil {
+		return err
+	}
+
+	indexOffset := x.offset
+	var index bytes.Buffer
+	for _, entry := range x.index {
+		x.putBytes(&index, []byte(entry.firstKey))
+		x.putUvarint(&index, entry.offset)
+		x.putUvarint(&index, entry.length)
+	}
+
+	var footer [SSTABLE_FOOTER_SIZE]byte
+	binary.BigEndian.PutUint64(footer[0:8], indexOffset)
+	binary.BigEndian.PutUint64(footer[8:16], uint64(len(x.index)))
+	copy(footer[16:], SSTABLE_MAGIC)
+
+	if _, err := x.w.Write(index.Bytes()); err != nil {
+		return err
+	}
+	if _, err := x.w.Write(footer[:]); err != nil {
+		return err
+	}
+	return x.w.Flush()
+}
+
+func (x *SSTableWriter) flushBlock() error {
+	if x.block.Len() == 0 {
+		return nil
+	}
+	x.index = append(x.index, sstIndex{firstKey: x.first, offset: x.offset, length: uint64(x.block.Len())})
+	x.offset += uint64(x.block.Len())
+	_, err := x.w.Write(x.block.Bytes())
+	x.block.Reset()
+	x.hasFirst = false
+	return err
+}
+
+func (x *SSTableWriter) putBytes(buf *bytes.Buffer, data []byte) {
+	x.putUvarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+func (x *SSTableWriter) putUvarint(buf *bytes.Buffer, value uint64) {
+	n := binary.PutUvarint(x.scratch[:], value)
+	buf.Write(x.scratch[:n])
+}
+
+// SSTableReader keeps only the block index in memory, a lookup reads a single block
+type SSTableReader struct {
+	fh    *os.File
+	index []sstIndex
+}
+
+func NewSSTableReader(fileName string) (*SSTableReader, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	x := &SSTableReader{fh: fh}
+	if err := x.loadIndex(); err != nil {
+		log.Error().Err(err).Str("component", "sstable").Str("file", fileName).Msg("load index")
+		fh.Close()
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *SSTableReader) loadIndex() error {
+	info, err := x.fh.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < SSTABLE_FOOTER_SIZE {
+		return ErrBadSSTable
+	}
+
+	var footer [SSTABLE_FOOTER_SIZE]byte
+	if _, err := x.fh.ReadAt(footer[:], info.Size()-SSTABLE_FOOTER_SIZE); err != nil {
+		return err
+	}
+	if !bytes.Equal(footer[16:], SSTABLE_MAGIC) {
+		return ErrBadSSTable
+	}
+	indexOffset := binary.BigEndian.Uint64(footer[0:8])
+	blocks := binary.BigEndian.Uint64(footer[8:16])
+	indexEnd := uint64(info.Size() - SSTABLE_FOOTER_SIZE)
+	if indexOffset > indexEnd {
+		return ErrBadSSTable
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(x.fh, int64(indexOffset), int64(indexEnd-indexOffset)))
+	x.index = make([]sstIndex, 0, blocks)
+	for i := uint64(0); i < blocks; i++ {
+		key, err := readBytes(r)
+		if err != nil {
+			return ErrBadSSTable
+		}
+		offset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return ErrBadSSTable
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return ErrBadSSTable
+		}
+		x.index = append(x.index, sstIndex{firstKey: string(key), offset: offset, length: length})
+	}
+	return nil
+}
+
+func (x *SSTableReader) Get(key string) ([]byte, bool) {
+	// last block whose first key is <= key
+	i := sort.Search(len(x.index), func(i int) bool { return x.index[i].firstKey > key }) - 1
+	if i < 0 {
+		return nil, false
+	}
+
+	block := make([]byte, x.index[i].length)
+	if _, err := x.fh.ReadAt(block, int64(x.index[i].offset)); err != nil {
+		log.Error().Err(err).Str("component", "sstable").Str("file", x.fh.Name()).Msg("read block")
+		return nil, false
+	}
+
+	r := bytes.NewReader(block)
+	for r.Len() > 0 {
+		k, err := readBytes(r)
+		if err != nil {
+			return nil, false
+		}
+		v, err := readBytes(r)
+		if err != nil {
+			return nil, false
+		}
+		switch c := bytes.Compare(k, []byte(key)); {
+		case c == 0:
+			return v, true
+		case c > 0:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Walk hands every key to fn in order, one block in memory at a time
+func (x *SSTableReader) Walk(fn func(key string, value []byte) error) error {
+	for _, index := range x.index {
+		r := bufio.NewReader(io.NewSectionReader(x.fh, int64(index.offset), int64(index.length)))
+		for {
+			key, err := readBytes(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return ErrBadSSTable
+			}
+			value, err := readBytes(r)
+			if err != nil {
+				return ErrBadSSTable
+			}
+			if err := fn(string(key), value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (x *SSTableReader) FileName() string {
+	return x.fh.Name()
+}
+
+func (x *SSTableReader) Close() error {
+	return x.fh.Close()
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readBytes(r byteReader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
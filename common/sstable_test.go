@@ -0,0 +1,89 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package common
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSTable(t *testing.T) {
+	fileName := "/tmp/test.sst"
+	writer, err := NewSSTableWriter(fileName)
+	assert.Nil(t, err)
+
+	// enough rows to span several blocks
+	for i := 0; i < 20000; i++ {
+		assert.Nil(t, writer.Append(fmt.Sprintf("key%08d", i*2), []byte(fmt.Sprintf(`{"value":%d}`, i))))
+	}
+	assert.Equal(t, ErrSortOrder, writer.Append("key00000000", nil))
+	assert.Equal(t, 20000, writer.Count())
+	assert.Nil(t, writer.Close())
+
+	reader, err := NewSSTableReader(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, fileName, reader.FileName())
+	assert.Greater(t, len(reader.index), 1)
+
+	value, found := reader.Get("key00000000")
+	assert.True(t, found)
+	assert.Equal(t, `{"value":0}`, string(value))
+	value, found = reader.Get("key00039998")
+	assert.True(t, found)
+	assert.Equal(t, `{"value":19999}`, string(value))
+	value, found = reader.Get("key00020000")
+	assert.True(t, found)
+	assert.Equal(t, `{"value":10000}`, string(value))
+
+	// gaps, before the first and after the last key
+	_, found = reader.Get("key00000001")
+	assert.False(t, found)
+	_, found = reader.Get("a")
+	assert.False(t, found)
+	_, found = reader.Get("z")
+	assert.False(t, found)
+
+	// a walk sees every key in order
+	count := 0
+	assert.Nil(t, reader.Walk(func(key string, value []byte) error {
+		assert.Equal(t, fmt.Sprintf("key%08d", count*2), key)
+		assert.Equal(t, fmt.Sprintf(`{"value":%d}`, count), string(value))
+		count++
+		return nil
+	}))
+	assert.Equal(t, 20000, count)
+	assert.Equal(t, ErrBadSSTable, reader.Walk(func(key string, value []byte) error { return ErrBadSSTable }))
+
+	assert.Nil(t, reader.Close())
+	os.Remove(fileName)
+}
+
+func TestSSTableErrors(t *testing.T) {
+	_, err := NewSSTableWriter("/nope/test.sst")
+	assert.NotNil(t, err)
+
+	_, err = NewSSTableReader("/nope/test.sst")
+	assert.NotNil(t, err)
+
+	os.WriteFile("/tmp/bad.sst", []byte("nope"), 0644)
+	_, err = NewSSTableReader("/tmp/bad.sst")
+	assert.Equal(t, ErrBadSSTable, err)
+
+	os.WriteFile("/tmp/bad.sst", []byte("0123456789012345678901234567890123456789"), 0644)
+	_, err = NewSSTableReader("/tmp/bad.sst")
+	assert.Equal(t, ErrBadSSTable, err)
+	os.Remove("/tmp/bad.sst")
+
+	// empty table
+	writer, err := NewSSTableWriter("/tmp/empty.sst")
+	assert.Nil(t, err)
+	assert.Nil(t, writer.Close())
+	reader, err := NewSSTableReader("/tmp/empty.sst")
+	assert.Nil(t, err)
+	_, found := reader.Get("key")
+	assert.False(t, found)
+	reader.Close()
+	os.Remove("/tmp/empty.sst")
+}
@@ -0,0 +1,24 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"github.com/mcnijman/go-emailaddress"
+	"github.com/osintami/fingerprintz/common"
+)
+
+type EmailAlerter struct {
+	mail      *common.Email
+	recipient *emailaddress.EmailAddress
+}
+
+func NewEmailAlerter(mailer common.IMail, recipient string) (*EmailAlerter, error) {
+	address, err := emailaddress.Parse(recipient)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailAlerter{mail: common.NewEmail(mailer), recipient: address}, nil
+}
+
+func (x *EmailAlerter) Alert(alert *Alert) error {
+	return x.mail.Send(alert.Subject(), alert.String(), x.recipient, false)
+}
@@ -0,0 +1,32 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"github.com/go-resty/resty/v2"
+	"github.com/osintami/fingerprintz/log"
+)
+
+type WebhookAlerter struct {
+	client *resty.Client
+	url    string
+}
+
+func NewWebhookAlerter(client *resty.Client, url string) *WebhookAlerter {
+	return &WebhookAlerter{client: client, url: url}
+}
+
+func (x *WebhookAlerter) Alert(alert *Alert) error {
+	resp, err := x.client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(alert).
+		Post(x.url)
+	if err != nil {
+		log.Error().Err(err).Str("component", "alerts").Str("url", x.url).Msg("webhook")
+		return err
+	}
+	if resp.IsError() {
+		log.Error().Err(ErrWebhookFailed).Str("component", "alerts").Str("url", x.url).Int("status", resp.StatusCode()).Msg("webhook")
+		return ErrWebhookFailed
+	}
+	return nil
+}
@@ -6,3 +6,10 @@ var ErrBadSourceData = errors.New("bad source data")
 var ErrVendorNotFound = errors.New("vendor not found")
 var ErrVendorDisabled = errors.New("vendor is disabled")
 var ErrEmptySchema = errors.New("empty schema")
+var ErrScriptMissing = errors.New("script transform function missing")
+var ErrScriptLimit = errors.New("script limit exceeded")
+var ErrScriptOutput = errors.New("script output malformed")
+var ErrScriptInputType = errors.New("script input type not supported")
+var ErrQualityGate = errors.New("quality gate rejected data")
+var ErrWebhookFailed = errors.New("alert webhook failed")
+var ErrExportDatabase = errors.New("export database not supported")
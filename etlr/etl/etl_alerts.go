@@ -0,0 +1,168 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	PHASE_PREPARE   = "prepare"
+	PHASE_EXTRACT   = "extract"
+	PHASE_TRANSFORM = "transform"
+	PHASE_LOAD      = "load"
+	PHASE_QUALITY   = "quality"
+	PHASE_PUBLISH   = "publish"
+	PHASE_EXPORT    = "export"
+)
+
+const (
+	ALERT_FAILURES = "consecutiveFailures"
+	ALERT_DATA_AGE = "dataAge"
+	ALERT_QUALITY  = "qualityGate"
+)
+
+type Alert struct {
+	Source        string
+	Rule          string
+	Phase         string `json:",omitempty"`
+	Error         string `json:",omitempty"`
+	Failures      int
+	LastSuccessAt string `json:",omitempty"`
+	DataAgeHours  int    `json:",omitempty"`
+	Time          string
+}
+
+func (x *Alert) Subject() string {
+	return fmt.Sprintf("ETLr alert: %s %s", x.Source, x.Rule)
+}
+
+func (x *Alert) String() string {
+	lastSuccess := x.LastSuccessAt
+	if lastSuccess == "" {
+		lastSuccess = "never"
+	}
+	return fmt.Sprintf("source: %s\r\nrule: %s\r\nphase: %s\r\nerror: %s\r\nfailures: %d\r\nlast success: %s\r\ndata age hours: %d\r\ntime: %s\r\n",
+		x.Source, x.Rule, x.Phase, x.Error, x.Failures, lastSuccess, x.DataAgeHours, x.Time)
+}
+
+type IAlerter interface {
+	Alert(alert *Alert) error
+}
+
+// Alerts fans an alert out to every configured delivery channel
+type Alerts []IAlerter
+
+func (x Alerts) Alert(alert *Alert) error {
+	var last error
+	for _, alerter := range x {
+		if err := alerter.Alert(alert); err != nil {
+			log.Error().Err(err).Str("component", "alerts").Str("vendor", alert.Source).Str("rule", alert.Rule).Msg("deliver alert")
+			last = err
+		}
+	}
+	return last
+}
+
+type JobHealth struct {
+	mu          sync.Mutex
+	failures    int
+	phase       string
+	lastError   string
+	lastSuccess time.Time
+	ageAlerted  bool
+}
+
+func (x *JobHealth) Record(job IETLJob, phase string, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err == nil {
+		x.failures = 0
+		x.phase = ""
+		x.lastError = ""
+		x.lastSuccess = time.Now()
+		x.ageAlerted = false
+		return
+	}
+
+	x.failures++
+	x.phase = phase
+	x.lastError = err.Error()
+
+	rules := job.Source().Alerts
+	if rules == nil {
+		return
+	}
+	if err == ErrQualityGate && rules.QualityGate {
+		x.send(job, ALERT_QUALITY, 0)
+	}
+	// NOTE:  alert once per outage, not on every failed run after the threshold
+	if rules.MaxFailures > 0 && x.failures == rules.MaxFailures {
+		x.send(job, ALERT_FAILURES, 0)
+	}
+}
+
+func (x *JobHealth) CheckDataAge(job IETLJob, now time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	rules := job.Source().Alerts
+	if rules == nil || rules.MaxAgeHours <= 0 || x.ageAlerted {
+		return
+	}
+
+	lastSuccess := x.lastSuccessAt(job)
+	if lastSuccess.IsZero() {
+		return
+	}
+
+	age := int(now.Sub(lastSuccess).Hours())
+	if age >= rules.MaxAgeHours {
+		x.ageAlerted = true
+		x.send(job, ALERT_DATA_AGE, age)
+	}
+}
+
+func (x *JobHealth) Failures() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.failures
+}
+
+// the published file outlives a restart, the in memory timestamp does not
+func (x *JobHealth) lastSuccessAt(job IETLJob) time.Time {
+	if !x.lastSuccess.IsZero() {
+		return x.lastSuccess
+	}
+	if info, err := os.Stat(job.Info().outputFile); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+func (x *JobHealth) send(job IETLJob, rule string, age int) {
+	alerter := job.Tools().Alerter
+	if alerter == nil {
+		return
+	}
+
+	alert := &Alert{
+		Source:       job.Source().Name,
+		Rule:         rule,
+		Phase:        x.phase,
+		Error:        x.lastError,
+		Failures:     x.failures,
+		DataAgeHours: age,
+		Time:         time.Now().UTC().Format(time.RFC3339)}
+	if lastSuccess := x.lastSuccessAt(job); !lastSuccess.IsZero() {
+		alert.LastSuccessAt = lastSuccess.UTC().Format(time.RFC3339)
+	}
+
+	log.Warn().Str("component", "alerts").Str("vendor", alert.Source).Str("rule", rule).Str("phase", alert.Phase).Msg("alert")
+	alerter.Alert(alert)
+}
@@ -0,0 +1,174 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"errors"
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockAlerter struct {
+	alerts []*Alert
+}
+
+func (x *MockAlerter) Alert(alert *Alert) error {
+	x.alerts = append(x.alerts, alert)
+	return nil
+}
+
+type MockSender struct {
+	fail    bool
+	content []byte
+}
+
+func (x *MockSender) SendMail(server string, auth smtp.Auth, from string, recipients []string, content []byte) error {
+	if x.fail {
+		return errors.New("email failed")
+	}
+	x.content = content
+	return nil
+}
+
+func createAlertJob(rules *AlertInfo) (*ETLJob, *MockAlerter) {
+	job := createETLJob(resty.New(), "csv", "mmdb")
+	alerter := &MockAlerter{}
+	job.Tools().Alerter = alerter
+	job.Source().Alerts = rules
+	return job, alerter
+}
+
+func TestAlertConsecutiveFailures(t *testing.T) {
+	job, alerter := createAlertJob(&AlertInfo{MaxFailures: 2})
+	job.extract = NewFileExtractor("/tmp/nope.csv")
+
+	assert.NotNil(t, job.Refresh())
+	assert.Equal(t, 0, len(alerter.alerts))
+	assert.NotNil(t, job.Refresh())
+	assert.Equal(t, 1, len(alerter.alerts))
+	// only once per outage
+	assert.NotNil(t, job.Refresh())
+	assert.Equal(t, 1, len(alerter.alerts))
+
+	alert := alerter.alerts[0]
+	assert.Equal(t, "test", alert.Source)
+	assert.Equal(t, ALERT_FAILURES, alert.Rule)
+	assert.Equal(t, PHASE_EXTRACT, alert.Phase)
+	assert.Equal(t, 2, alert.Failures)
+	assert.Equal(t, "", alert.LastSuccessAt)
+
+	// recovery resets the count and records the success
+	job.extract = NewFileExtractor("/tmp/test.csv")
+	assert.Nil(t, job.Refresh())
+	assert.Equal(t, 0, job.Health().Failures())
+
+	job.extract = NewFileExtractor("/tmp/nope.csv")
+	job.Refresh()
+	job.Refresh()
+	assert.Equal(t, 2, len(alerter.alerts))
+	assert.NotEqual(t, "", alerter.alerts[1].LastSuccessAt)
+
+	cleanup()
+}
+
+func TestAlertQualityGate(t *testing.T) {
+	job, alerter := createAlertJob(&AlertInfo{QualityGate: true})
+	job.Source().Quality = &QualityInfo{MinBytes: 1 << 30}
+
+	assert.Equal(t, ErrQualityGate, job.Refresh())
+	assert.Equal(t, 1, len(alerter.alerts))
+	assert.Equal(t, ALERT_QUALITY, alerter.alerts[0].Rule)
+	assert.Equal(t, PHASE_QUALITY, alerter.alerts[0].Phase)
+	// rejected data is never published
+	_, err := os.Stat(job.Info().outputFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// shrinking feed
+	job.Source().Quality = &QualityInfo{}
+	assert.Nil(t, job.Refresh())
+	os.WriteFile(job.Info().outputFile, make([]byte, 1<<20), 0644)
+	job.Source().Quality = &QualityInfo{MaxShrinkPercent: 50}
+	assert.Equal(t, ErrQualityGate, job.Refresh())
+	assert.Equal(t, 2, len(alerter.alerts))
+
+	cleanup()
+}
+
+func TestAlertDataAge(t *testing.T) {
+	job, alerter := createAlertJob(&AlertInfo{MaxAgeHours: 24})
+
+	// nothing published yet, nothing to compare against
+	job.Health().CheckDataAge(job, time.Now())
+	assert.Equal(t, 0, len(alerter.alerts))
+
+	assert.Nil(t, job.Refresh())
+	job.Health().CheckDataAge(job, time.Now())
+	assert.Equal(t, 0, len(alerter.alerts))
+
+	job.Health().CheckDataAge(job, time.Now().Add(25*time.Hour))
+	assert.Equal(t, 1, len(alerter.alerts))
+	assert.Equal(t, ALERT_DATA_AGE, alerter.alerts[0].Rule)
+	assert.Equal(t, 25, alerter.alerts[0].DataAgeHours)
+	job.Health().CheckDataAge(job, time.Now().Add(26*time.Hour))
+	assert.Equal(t, 1, len(alerter.alerts))
+
+	// a restart falls back to the published file
+	job.health = &JobHealth{}
+	job.Health().CheckDataAge(job, time.Now().Add(25*time.Hour))
+	assert.Equal(t, 2, len(alerter.alerts))
+
+	cleanup()
+}
+
+func TestAlertNoRules(t *testing.T) {
+	job, alerter := createAlertJob(nil)
+	job.extract = NewFileExtractor("/tmp/nope.csv")
+	job.Refresh()
+	job.Health().CheckDataAge(job, time.Now())
+	assert.Equal(t, 0, len(alerter.alerts))
+
+	// no alerter configured
+	job.Source().Alerts = &AlertInfo{MaxFailures: 1}
+	job.Tools().Alerter = nil
+	job.health = &JobHealth{}
+	assert.NotNil(t, job.Refresh())
+
+	cleanup()
+}
+
+func TestAlertDelivery(t *testing.T) {
+	alert := &Alert{Source: "test", Rule: ALERT_FAILURES, Phase: PHASE_LOAD, Error: "boom", Failures: 3, Time: "now"}
+
+	sender := &MockSender{}
+	email, err := NewEmailAlerter(sender, "ops@osintami.com")
+	assert.Nil(t, err)
+	assert.Nil(t, email.Alert(alert))
+	assert.True(t, strings.Contains(string(sender.content), "Subject: ETLr alert: test consecutiveFailures"))
+	assert.True(t, strings.Contains(string(sender.content), "phase: load"))
+	assert.True(t, strings.Contains(string(sender.content), "last success: never"))
+
+	_, err = NewEmailAlerter(sender, "nope")
+	assert.NotNil(t, err)
+
+	client := resty.New()
+	httpmock.ActivateNonDefault(client.GetClient())
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", "https://hooks.osintami.com/ok", httpmock.NewStringResponder(200, ""))
+	httpmock.RegisterResponder("POST", "https://hooks.osintami.com/fail", httpmock.NewStringResponder(500, ""))
+
+	assert.Nil(t, NewWebhookAlerter(client, "https://hooks.osintami.com/ok").Alert(alert))
+	assert.Equal(t, ErrWebhookFailed, NewWebhookAlerter(client, "https://hooks.osintami.com/fail").Alert(alert))
+	assert.NotNil(t, NewWebhookAlerter(client, "https://hooks.osintami.com/nope").Alert(alert))
+
+	// one failed channel doesn't stop the others
+	alerts := Alerts{email, NewWebhookAlerter(client, "https://hooks.osintami.com/fail"), &MockAlerter{}}
+	sender.fail = true
+	assert.NotNil(t, alerts.Alert(alert))
+	assert.Equal(t, 1, len(alerts[2].(*MockAlerter).alerts))
+}
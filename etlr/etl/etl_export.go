@@ -0,0 +1,280 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+)
+
+const EXPORT_BATCH_SIZE = 1000
+
+type IExporter interface {
+	Export(job IETLJob) error
+}
+
+// ExportVersion tracks every load of a dataset, the active one backs the dataset's view
+type ExportVersion struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Dataset   string `gorm:"index:idx_export_dataset"`
+	Version   int
+	Snapshot  string
+	Rows      int
+	Active    bool
+}
+
+type ExportRow struct {
+	Key     string
+	StartIP *string
+	EndIP   *string
+	Fields  string
+}
+
+type SQLExporter struct {
+	gorm *gorm.DB
+	keep int
+}
+
+var exportNameRegex = regexp.MustCompile(`[^a-z0-9_]+`)
+
+func NewSQLExporter(gorm *gorm.DB, keep int) (*SQLExporter, error) {
+	if keep < 1 {
+		keep = 1
+	}
+	if err := gorm.AutoMigrate(&ExportVersion{}); err != nil {
+		log.Error().Err(err).Str("component", "export").Msg("migrate")
+		return nil, err
+	}
+	return &SQLExporter{gorm: gorm, keep: keep}, nil
+}
+
+// exportReader walks a published dataset, handing each row to add as it goes
+type exportReader func(fileName string, add func(ExportRow) error) error
+
+// Export copies the published dataset into a new versioned table and then, in a single
+// transaction, points the dataset's view at it so readers never see a partial load.
+func (x *SQLExporter) Export(job IETLJob) error {
+	var read exportReader
+	switch job.Source().OutputType {
+	case "mmdb":
+		read = x.readMMDB
+	case "fast":
+		read = x.readFastDB
+	case "sst":
+		read = x.readSSTDB
+	default:
+		log.Debug().Str("component", "export").Str("vendor", job.Source().Name).Str("type", job.Source().OutputType).Msg("export not supported")
+		return nil
+	}
+	fileName := job.Info().outputFile
+	if _, err := os.Stat(fileName); err != nil {
+		log.Error().Err(err).Str("component", "export").Str("vendor", job.Source().Name).Msg("read dataset")
+		return err
+	}
+
+	dataset := exportName(job.Source().Name)
+	version, err := x.nextVersion(dataset)
+	if err != nil {
+		return err
+	}
+	snapshot := fmt.Sprintf("%s_v%d", dataset, version)
+
+	rows, err := x.createSnapshot(snapshot, func(add func(ExportRow) error) error {
+		return read(fileName, add)
+	})
+	if err != nil {
+		log.Error().Err(err).Str("component", "export").Str("vendor", job.Source().Name).Str("table", snapshot).Msg("load snapshot")
+		x.gorm.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, snapshot))
+		return err
+	}
+
+	err = x.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf(`DROP VIEW IF EXISTS "%s"`, dataset)).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(fmt.Sprintf(`CREATE VIEW "%s" AS SELECT * FROM "%s"`, dataset, snapshot)).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&ExportVersion{}).Where("dataset = ?", dataset).Update("active", false).Error; err != nil {
+			return err
+		}
+		return tx.Create(&ExportVersion{
+			Dataset:  dataset,
+			Version:  version,
+			Snapshot: snapshot,
+			Rows:     rows,
+			Active:   true}).Error
+	})
+	if err != nil {
+		log.Error().Err(err).Str("component", "export").Str("vendor", job.Source().Name).Str("table", snapshot).Msg("swap version")
+		x.gorm.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, snapshot))
+		return err
+	}
+
+	log.Info().Str("component", "export").Str("vendor", job.Source().Name).Str("table", snapshot).Int("rows", rows).Msg("export")
+	x.prune(dataset, version)
+	return nil
+}
+
+func (x *SQLExporter) nextVersion(dataset string) (int, error) {
+	var version int
+	err := x.gorm.Model(&ExportVersion{}).
+		Select("COALESCE(MAX(version), 0)").
+		Where("dataset = ?", dataset).
+		Scan(&version).Error
+	if err != nil {
+		log.Error().Err(err).Str("component", "export").Str("dataset", dataset).Msg("next version")
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+// createSnapshot loads the rows as walk hands them over, EXPORT_BATCH_SIZE at a time, so a
+// dataset is never held in memory as a whole
+func (x *SQLExporter) createSnapshot(snapshot string, walk func(add func(ExportRow) error) error) (int, error) {
+	// NOTE:  postgres gets native types for range queries and JSON operators, sqlite stores text
+	ipType, jsonType := "TEXT", "TEXT"
+	if x.gorm.Dialector.Name() == "postgres" {
+		ipType, jsonType = "INET", "JSONB"
+	}
+
+	ddl := fmt.Sprintf(`CREATE TABLE "%s" ("key" TEXT NOT NULL, "start_ip" %s, "end_ip" %s, "fields" %s)`,
+		snapshot, ipType, ipType, jsonType)
+	if err := x.gorm.Exec(ddl).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	batch := make([]ExportRow, 0, EXPORT_BATCH_SIZE)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := x.gorm.Table(snapshot).CreateInBatches(batch, EXPORT_BATCH_SIZE).Error
+		count += len(batch)
+		batch = batch[:0]
+		return err
+	}
+	err := walk(func(row ExportRow) error {
+		batch = append(batch, row)
+		if len(batch) < EXPORT_BATCH_SIZE {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (x *SQLExporter) prune(dataset string, version int) {
+	stale := []ExportVersion{}
+	x.gorm.Where("dataset = ? AND version <= ?", dataset, version-x.keep).Find(&stale)
+	for _, old := range stale {
+		if err := x.gorm.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, old.Snapshot)).Error; err != nil {
+			log.Error().Err(err).Str("component", "export").Str("table", old.Snapshot).Msg("prune")
+			continue
+		}
+		x.gorm.Delete(&old)
+	}
+}
+
+func (x *SQLExporter) readMMDB(fileName string, add func(ExportRow) error) error {
+	reader, err := maxminddb.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var fields interface{}
+		network, err := networks.Network(&fields)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		start, end := ipRange(network)
+		err = add(ExportRow{
+			Key:     network.String(),
+			StartIP: &start,
+			EndIP:   &end,
+			Fields:  string(data)})
+		if err != nil {
+			return err
+		}
+	}
+	return networks.Err()
+}
+
+// NOTE:  the fast cache only loads whole, use sst for datasets too large for that
+func (x *SQLExporter) readFastDB(fileName string, add func(ExportRow) error) error {
+	fast := cache.New(-1, -1)
+	if err := fast.LoadFile(fileName); err != nil {
+		return err
+	}
+
+	for key, item := range fast.Items() {
+		data, ok := item.Object.([]byte)
+		if !ok || !json.Valid(data) {
+			var err error
+			if data, err = json.Marshal(item.Object); err != nil {
+				return err
+			}
+		}
+		if err := add(ExportRow{Key: key, Fields: string(data)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *SQLExporter) readSSTDB(fileName string, add func(ExportRow) error) error {
+	reader, err := common.NewSSTableReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return reader.Walk(func(key string, value []byte) error {
+		if !json.Valid(value) {
+			data, err := json.Marshal(string(value))
+			if err != nil {
+				return err
+			}
+			value = data
+		}
+		return add(ExportRow{Key: key, Fields: string(value)})
+	})
+}
+
+func ipRange(network *net.IPNet) (string, string) {
+	start := network.IP.Mask(network.Mask)
+	end := make(net.IP, len(start))
+	for i := range start {
+		end[i] = start[i] | ^network.Mask[i]
+	}
+	return start.String(), end.String()
+}
+
+func exportName(name string) string {
+	return strings.Trim(exportNameRegex.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
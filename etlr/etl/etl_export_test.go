@@ -0,0 +1,175 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-resty/resty/v2"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func createExporter(t *testing.T) *SQLExporter {
+	os.Remove("/tmp/export.sqlite")
+	db, err := common.OpenSQLite("/tmp/export.sqlite", "/tmp")
+	assert.Nil(t, err)
+	exporter, err := NewSQLExporter(db, 2)
+	assert.Nil(t, err)
+	return exporter
+}
+
+func cleanupExporter() {
+	os.Remove("/tmp/export.sqlite")
+	os.Remove("/tmp/sqlite.log")
+}
+
+func TestExportMMDB(t *testing.T) {
+	script, err := os.ReadFile("./test/source/script.star")
+	assert.Nil(t, err)
+	input, err := os.ReadFile("./test/source/script.csv")
+	assert.Nil(t, err)
+
+	job := createScriptJob(t, string(script), string(input), "csv", "mmdb", &ScriptInfo{})
+	exporter := createExporter(t)
+	job.Tools().Exporter = exporter
+
+	assert.Nil(t, job.Refresh())
+
+	rows := []ExportRow{}
+	assert.Nil(t, exporter.gorm.Table("script").Order(`"key"`).Find(&rows).Error)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, "1.2.3.4/32", rows[0].Key)
+	assert.Equal(t, "1.2.3.4", *rows[0].StartIP)
+	assert.Equal(t, "1.2.3.4", *rows[0].EndIP)
+	assert.Equal(t, "Paris", gjson.Get(rows[0].Fields, "script.geo.city").String())
+	assert.Equal(t, "DE", gjson.Get(rows[1].Fields, "script.geo.countryCode").String())
+
+	// each load is a new version, the view follows the active one
+	assert.Nil(t, job.Refresh())
+	assert.Nil(t, job.Refresh())
+	versions := []ExportVersion{}
+	exporter.gorm.Where("dataset = ?", "script").Order("version").Find(&versions)
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, "script_v2", versions[0].Snapshot)
+	assert.False(t, versions[0].Active)
+	assert.Equal(t, "script_v3", versions[1].Snapshot)
+	assert.True(t, versions[1].Active)
+	assert.Equal(t, 2, versions[1].Rows)
+	assert.False(t, exporter.gorm.Migrator().HasTable("script_v1"))
+	assert.True(t, exporter.gorm.Migrator().HasTable("script_v2"))
+
+	cleanupScriptJob()
+	cleanupExporter()
+}
+
+func TestExportFastDB(t *testing.T) {
+	script := `
+items = [{"Item": "phone/script/Name", "GJSON": "Result.Name", "Type": "String", "Description": "Name."}]
+
+def transform(row):
+    return {"key": row[0], "fields": {"Result": {"Name": row[1]}}}
+`
+	job := createScriptJob(t, script, "a,alpha\nb,beta\n", "csv", "fast", &ScriptInfo{})
+	exporter := createExporter(t)
+	job.Tools().Exporter = exporter
+	job.Source().Name = "x4bnet.Tor"
+
+	assert.Nil(t, job.Refresh())
+
+	row := ExportRow{}
+	assert.Nil(t, exporter.gorm.Table("x4bnet_tor").Where(`"key" = ?`, "b").First(&row).Error)
+	assert.Nil(t, row.StartIP)
+	assert.Equal(t, "beta", gjson.Get(row.Fields, "Result.Name").String())
+
+	cleanupScriptJob()
+	cleanupExporter()
+}
+
+func TestExportSSTDB(t *testing.T) {
+	script := `
+items = [{"Item": "phone/script/Name", "GJSON": "Result.Name", "Type": "String", "Description": "Name."}]
+
+def transform(row):
+    return {"key": row[0], "fields": {"Result": {"Name": row[1]}}}
+`
+	// more rows than a batch so the load goes through in pieces
+	input := strings.Builder{}
+	for i := 0; i < EXPORT_BATCH_SIZE*2+10; i++ {
+		fmt.Fprintf(&input, "key%05d,name%d\n", i, i)
+	}
+	job := createScriptJob(t, script, input.String(), "csv", "sst", &ScriptInfo{})
+	exporter := createExporter(t)
+	job.Tools().Exporter = exporter
+
+	assert.Nil(t, job.Refresh())
+
+	row := ExportRow{}
+	assert.Nil(t, exporter.gorm.Table("script").Where(`"key" = ?`, "key02009").First(&row).Error)
+	assert.Equal(t, "name2009", gjson.Get(row.Fields, "Result.Name").String())
+	version := ExportVersion{}
+	assert.Nil(t, exporter.gorm.Where("dataset = ? AND active = ?", "script", true).First(&version).Error)
+	assert.Equal(t, EXPORT_BATCH_SIZE*2+10, version.Rows)
+
+	cleanupScriptJob()
+	cleanupExporter()
+}
+
+func TestExportReadFailure(t *testing.T) {
+	exporter := createExporter(t)
+
+	// rows are loaded as they're read, a full batch is in before the reader fails
+	_, err := exporter.createSnapshot("broken_v1", func(add func(ExportRow) error) error {
+		for i := 0; i < EXPORT_BATCH_SIZE+1; i++ {
+			if err := add(ExportRow{Key: strconv.Itoa(i), Fields: "{}"}); err != nil {
+				return err
+			}
+		}
+		return ErrBadSourceData
+	})
+	assert.Equal(t, ErrBadSourceData, err)
+	var count int64
+	exporter.gorm.Table("broken_v1").Count(&count)
+	assert.Equal(t, int64(EXPORT_BATCH_SIZE), count)
+
+	cleanupExporter()
+}
+
+func TestExportUnsupported(t *testing.T) {
+	exporter := createExporter(t)
+	job := createETLJob(resty.New(), "csv", "mmdb")
+
+	// nothing published yet
+	assert.NotNil(t, exporter.Export(job))
+
+	job.Source().OutputType = "csv"
+	assert.Nil(t, exporter.Export(job))
+	assert.False(t, exporter.gorm.Migrator().HasTable("test_v1"))
+
+	cleanup()
+	cleanupExporter()
+}
+
+func TestExportPostgres(t *testing.T) {
+	db, mock := common.CreateMockDatabase()
+	exporter := &SQLExporter{gorm: db, keep: 1}
+
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE "test_v1" ("key" TEXT NOT NULL, "start_ip" INET, "end_ip" INET, "fields" JSONB)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rows, err := exporter.createSnapshot("test_v1", func(add func(ExportRow) error) error { return nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 0, rows)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM "export_versions"`)).
+		WillReturnError(ErrBadSourceData)
+	_, err = exporter.nextVersion("test")
+	assert.Equal(t, ErrBadSourceData, err)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
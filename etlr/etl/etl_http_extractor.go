@@ -22,7 +22,7 @@ func (x *HttpExtractor) Extract(job IETLJob) error {
 			apiKey := x.tools.Secrets.Find(x.source.ApiKey)
 			url = strings.ReplaceAll(x.source.URL, "{key}", apiKey)
 		}
-		x.tools.Network.DownloadFile(url, job.Info().inputFile)
+		return x.tools.Network.DownloadFile(url, job.Info().inputFile)
 	}
 	return nil
 }
@@ -41,6 +41,7 @@ type ETLJob struct {
 	extract   IExtract
 	transform ITransform
 	load      ILoad
+	health    *JobHealth
 }
 
 type IExtract interface {
@@ -55,6 +56,11 @@ type ITransform interface {
 	Transform(IETLJob) error
 }
 
+// IPublish is for loaders with more than the one data file, they publish the others themselves
+type IPublish interface {
+	Publish(IETLJob) error
+}
+
 func NewETLJob(tools *Toolbox, source *Source, dataPath string, writer IWriter, extract IExtract, transform ITransform, load ILoad) *ETLJob {
 
 	tmpPath := "/tmp/"
@@ -82,7 +88,8 @@ func NewETLJob(tools *Toolbox, source *Source, dataPath string, writer IWriter,
 		writer:    writer,
 		extract:   extract,
 		transform: transform,
-		load:      load}
+		load:      load,
+		health:    &JobHealth{}}
 
 }
 
@@ -102,25 +109,48 @@ func (x *ETLJob) Refresh() error {
 
 	log.Info().Str("component", "etlr").Str("state", "start").Str("vendor", x.source.Name).Msg("refresh")
 
-	if err := x.cleanupETL(); err != nil {
+	phase, err := x.refresh()
+	x.health.Record(x, phase, err)
+	if err != nil {
 		return err
 	}
+
+	log.Info().Str("component", "etlr").Str("state", "finish").Str("vendor", x.source.Name).Msg("refresh")
+	return nil
+}
+
+func (x *ETLJob) refresh() (string, error) {
+	if err := x.cleanupETL(); err != nil {
+		return PHASE_PREPARE, err
+	}
 	if err := x.prepareETL(); err != nil {
-		return err
+		return PHASE_PREPARE, err
 	}
 	if err := x.Extract(); err != nil {
-		return err
+		return PHASE_EXTRACT, err
 	}
 	if err := x.Transform(); err != nil {
-		return err
+		return PHASE_TRANSFORM, err
 	}
 	if err := x.Load(); err != nil {
-		return err
+		return PHASE_LOAD, err
+	}
+	if err := x.qualityGate(); err != nil {
+		return PHASE_QUALITY, err
 	}
-	err := x.Publish()
+	if err := x.Publish(); err != nil {
+		return PHASE_PUBLISH, err
+	}
+	if x.tools.Exporter != nil {
+		if err := x.tools.Exporter.Export(x); err != nil {
+			return PHASE_EXPORT, err
+		}
+	}
+	return PHASE_PUBLISH, nil
+}
 
-	log.Info().Str("component", "etlr").Str("state", "finish").Str("vendor", x.source.Name).Msg("refresh")
-	return err
+func (x *ETLJob) Health() *JobHealth {
+	return x.health
 }
 
 func (x *ETLJob) Extract() error {
@@ -137,6 +167,9 @@ func (x *ETLJob) Load() error {
 
 func (x *ETLJob) Publish() error {
 	err1 := x.publishDatums()
+	if publisher, ok := x.load.(IPublish); ok && err1 == nil {
+		err1 = publisher.Publish(x)
+	}
 	err2 := x.publishSchema()
 	if err1 != nil {
 		return err1
@@ -156,7 +189,7 @@ func (x *ETLJob) prepareETL() error {
 	// NOTE:  csv output works, but very little is instrumented, on hold for now
 	// case "csv":
 	// 	err = x.writer.Create(x.info.workingPath + x.info.snapshotName)
-	case "fast":
+	case "fast", "sst":
 		err = x.writer.Create(x.info.workingPath + x.info.snapshotName)
 	}
 	if err != nil {
@@ -166,6 +199,34 @@ func (x *ETLJob) prepareETL() error {
 	return nil
 }
 
+func (x *ETLJob) qualityGate() error {
+	quality := x.source.Quality
+	if quality == nil {
+		return nil
+	}
+
+	snapshot, err := os.Stat(x.info.snapshotFile)
+	if err != nil {
+		log.Error().Err(err).Str("component", "etl").Str("vendor", x.source.Name).Str("file", x.info.snapshotFile).Msg("quality gate")
+		return ErrQualityGate
+	}
+	if snapshot.Size() < quality.MinBytes {
+		log.Error().Err(ErrQualityGate).Str("component", "etl").Str("vendor", x.source.Name).Int64("size", snapshot.Size()).Int64("minimum", quality.MinBytes).Msg("quality gate")
+		return ErrQualityGate
+	}
+
+	// NOTE:  a feed that suddenly shrinks usually means the vendor changed the format
+	published, err := os.Stat(x.info.outputFile)
+	if err == nil && quality.MaxShrinkPercent > 0 && published.Size() > 0 {
+		shrink := 100 - snapshot.Size()*100/published.Size()
+		if shrink > int64(quality.MaxShrinkPercent) {
+			log.Error().Err(ErrQualityGate).Str("component", "etl").Str("vendor", x.source.Name).Int64("size", snapshot.Size()).Int64("published", published.Size()).Msg("quality gate")
+			return ErrQualityGate
+		}
+	}
+	return nil
+}
+
 func (x *ETLJob) publishDatums() error {
 	inFile := x.info.snapshotFile
 	outFile := x.info.outputFile
@@ -2,6 +2,8 @@
 package etl
 
 import (
+	"time"
+
 	"github.com/osintami/fingerprintz/log"
 	"github.com/robfig/cron/v3"
 )
@@ -73,6 +75,9 @@ func (x *ETLManager) refreshHourly() {
 
 	// api gateway hackers
 	x.Refresh("unwanted")
+
+	// stale data alerts
+	x.CheckDataAge()
 }
 
 func (x ETLManager) refreshDaily() {
@@ -122,6 +127,7 @@ func (x ETLManager) refreshWeekly() {
 	x.Refresh("ip2location")
 	x.Refresh("ip2proxy")
 	x.Refresh("ayra")
+	x.Refresh("asn")
 	x.Refresh("lightswitch.junk")
 	x.Refresh("lightswitch.aggressive")
 }
@@ -140,6 +146,13 @@ func (x *ETLManager) Refresh(vendorName string) error {
 	return job.Refresh()
 }
 
+func (x *ETLManager) CheckDataAge() {
+	now := time.Now()
+	for _, job := range x.jobs {
+		job.Health().CheckDataAge(job, now)
+	}
+}
+
 func (x *ETLManager) FindJob(sourceName string) *ETLJob {
 	return x.jobs[sourceName]
 }
@@ -162,7 +175,25 @@ func (x *ETLManager) createInstance(source Source) (*ETLJob, error) {
 	// most common configuration
 	switch source.OutputType {
 	case "mmdb":
-		writer = NewMMDBWriter()
+		// very large feeds sort on disk and stream the tree out
+		if source.Stream != nil {
+			writer = NewStreamMMDBWriter(source.Stream)
+		} else {
+			writer = NewMMDBWriter()
+		}
+		if source.Aggregate != nil {
+			if merger, ok := writer.(IMergeWriter); ok {
+				writer = NewPrefixAggregator(merger, source.Aggregate)
+			} else {
+				log.Warn().Str("component", "etlr").Str("vendor", source.Name).Msg("prefix aggregation needs the in memory mmdb writer")
+			}
+		}
+		loader = writer
+	case "fast":
+		writer = NewFastDBWriter()
+		loader = writer
+	case "sst":
+		writer = NewSSTDBWriter(source.Stream)
 		loader = writer
 		// case "csv":
 		// 	writer = NewCSVWriter()
@@ -175,6 +206,18 @@ func (x *ETLManager) createInstance(source Source) (*ETLJob, error) {
 		extractor = NewHttpExtractor(x.tools, &source)
 	}
 
+	// scripted sources need no Go code of their own
+	if source.Script != nil {
+		return NewETLJob(
+			x.tools,
+			&source,
+			x.dataPath,
+			writer,
+			extractor,
+			NewScriptTransform(writer, source.Script),
+			loader), nil
+	}
+
 	switch source.Name {
 	case "akamai":
 		transformer = NewAkamai(writer)
@@ -272,6 +315,10 @@ func (x *ETLManager) createInstance(source Source) (*ETLJob, error) {
 		loader = maxmind
 	case "unwanted":
 		transformer = NewUnwanted(writer)
+	case "asn":
+		asn := NewASN(writer, NewFastDBWriter())
+		transformer = asn
+		loader = asn
 	default:
 		log.Error().Str("component", "etlr").Str("vendor", source.Name).Msg("ETLr not found")
 		return nil, ErrVendorNotFound
@@ -0,0 +1,456 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+
+	"github.com/biter777/countries"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+	"github.com/tidwall/gjson"
+	"go.starlark.net/starlark"
+)
+
+const (
+	DEFAULT_SCRIPT_TIMEOUT_MS = 250
+	DEFAULT_SCRIPT_MAX_STEPS  = 100000
+	DEFAULT_SCRIPT_MAX_MEMORY = 64
+	SCRIPT_WATCHDOG_INTERVAL  = 5 * time.Millisecond
+)
+
+// ScriptTransform runs a Starlark script against every CSV row or JSON element
+// of a source, so one-off feed formats don't need a new Go file.  The script
+// defines transform(row) returning None to skip the row or a dict with a "key"
+// (ip/cidr for mmdb, string for fast) and the "fields" to insert, plus an
+// items list that becomes the published data dictionary, i.e.
+//
+//	items = [{"Item": "ip/vendor/geo.city", "GJSON": "vendor.geo.city", "Type": "String", "Description": "City."}]
+//
+//	def transform(row):
+//	    return {"key": row[0], "fields": {"vendor": {"geo": {"city": row[3].split(",")[0]}}}}
+//
+// Scripts have no load(), file or network access.  Each call is bounded by
+// execution steps and wall clock time, and is cancelled when the heap grows by
+// more than the memory limit while it runs (sampled, so approximate).
+type ScriptTransform struct {
+	writer   IWriter
+	script   *ScriptInfo
+	timeout  time.Duration
+	maxSteps uint64
+	maxBytes uint64
+	current  atomic.Pointer[scriptRun]
+}
+
+type scriptRun struct {
+	thread   *starlark.Thread
+	started  time.Time
+	baseline uint64
+	reason   atomic.Pointer[string]
+}
+
+func (x *scriptRun) trip(reason string) {
+	if x.reason.CompareAndSwap(nil, &reason) {
+		x.thread.Cancel(reason)
+	}
+}
+
+func NewScriptTransform(writer IWriter, script *ScriptInfo) ITransform {
+	x := &ScriptTransform{
+		writer:   writer,
+		script:   script,
+		timeout:  DEFAULT_SCRIPT_TIMEOUT_MS * time.Millisecond,
+		maxSteps: DEFAULT_SCRIPT_MAX_STEPS,
+		maxBytes: DEFAULT_SCRIPT_MAX_MEMORY << 20}
+	if script.TimeoutMs > 0 {
+		x.timeout = time.Duration(script.TimeoutMs) * time.Millisecond
+	}
+	if script.MaxSteps > 0 {
+		x.maxSteps = script.MaxSteps
+	}
+	if script.MaxMemoryMB > 0 {
+		x.maxBytes = script.MaxMemoryMB << 20
+	}
+	return x
+}
+
+func (x *ScriptTransform) Transform(job IETLJob) error {
+	done := make(chan struct{})
+	defer close(done)
+	go x.watchdog(done)
+
+	globals, err := x.compile(job)
+	if err != nil {
+		return err
+	}
+	transform, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		log.Error().Err(ErrScriptMissing).Str("component", job.Source().Name).Str("file", x.script.File).Msg("script load")
+		return ErrScriptMissing
+	}
+
+	// NOTE:  a blown limit is fatal for the run, remaining rows are skipped quietly
+	var fatal error
+	apply := func(row starlark.Value) error {
+		if fatal != nil {
+			return nil
+		}
+		err := x.apply(job, transform, row)
+		if err == ErrScriptLimit {
+			fatal = err
+			return nil
+		}
+		return err
+	}
+
+	switch job.Source().InputType {
+	case "csv":
+		err = job.Tools().CSV.ProcessFile(job.Info().inputFile, rune(job.Source().Separator[0]), func(values []string) error {
+			row := make([]starlark.Value, len(values))
+			for i, value := range values {
+				row[i] = starlark.String(value)
+			}
+			return apply(starlark.NewList(row))
+		})
+	case "json":
+		err = x.processJSON(job, apply)
+	default:
+		log.Error().Err(ErrScriptInputType).Str("component", job.Source().Name).Str("type", job.Source().InputType).Msg("script input")
+		return ErrScriptInputType
+	}
+	if err != nil {
+		return err
+	}
+	if fatal != nil {
+		return fatal
+	}
+
+	return x.publishItems(job, globals["items"])
+}
+
+func (x *ScriptTransform) compile(job IETLJob) (starlark.StringDict, error) {
+	src, err := os.ReadFile(x.script.File)
+	if err != nil {
+		log.Error().Err(err).Str("component", job.Source().Name).Str("file", x.script.File).Msg("script read")
+		return nil, err
+	}
+	predeclared := starlark.StringDict{
+		"alpha2": starlark.NewBuiltin("alpha2", scriptAlpha2),
+	}
+	var globals starlark.StringDict
+	err = x.run(job, func(thread *starlark.Thread) error {
+		var err error
+		globals, err = starlark.ExecFile(thread, x.script.File, src, predeclared)
+		return err
+	})
+	if err != nil {
+		log.Error().Err(err).Str("component", job.Source().Name).Str("file", x.script.File).Msg("script compile")
+		return nil, err
+	}
+	return globals, nil
+}
+
+func (x *ScriptTransform) processJSON(job IETLJob, apply func(starlark.Value) error) error {
+	data, err := os.ReadFile(job.Info().inputFile)
+	if err != nil {
+		log.Error().Err(err).Str("component", job.Source().Name).Str("file", job.Info().inputFile).Msg("read json")
+		return err
+	}
+	elements := gjson.ParseBytes(data)
+	if x.script.JSONPath != "" {
+		elements = gjson.GetBytes(data, x.script.JSONPath)
+	}
+	if !elements.IsArray() {
+		log.Error().Err(ErrBadSourceData).Str("component", job.Source().Name).Str("path", x.script.JSONPath).Msg("json elements")
+		return ErrBadSourceData
+	}
+	for _, element := range elements.Array() {
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader([]byte(element.Raw)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			log.Error().Err(err).Str("component", job.Source().Name).Str("element", element.Raw).Msg("decode element")
+			continue
+		}
+		if err := apply(toStarlark(value)); err != nil {
+			log.Error().Err(err).Str("component", job.Source().Name).Str("element", element.Raw).Msg("parse element")
+		}
+	}
+	return nil
+}
+
+func (x *ScriptTransform) apply(job IETLJob, transform starlark.Callable, row starlark.Value) error {
+	var out starlark.Value
+	err := x.run(job, func(thread *starlark.Thread) error {
+		var err error
+		out, err = starlark.Call(thread, transform, starlark.Tuple{row}, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if out == starlark.None {
+		return nil
+	}
+
+	dict, ok := out.(*starlark.Dict)
+	if !ok {
+		return ErrScriptOutput
+	}
+	value, _, _ := dict.Get(starlark.String("key"))
+	key, ok := starlark.AsString(value)
+	if !ok || key == "" {
+		return ErrScriptOutput
+	}
+	value, _, _ = dict.Get(starlark.String("fields"))
+	fields, ok := value.(*starlark.Dict)
+	if !ok {
+		return ErrScriptOutput
+	}
+
+	switch x.writer.Type() {
+	case "mmdb":
+		cidr, err := job.Tools().Network.ParseCIDR(key)
+		if err != nil {
+			log.Error().Err(err).Str("component", job.Source().Name).Str("cidr", key).Msg("parse cidr")
+			return ErrBadSourceData
+		}
+		entry, err := toMMDB(fields)
+		if err != nil {
+			return err
+		}
+		return x.writer.Insert(cidr, entry)
+	default:
+		entry, err := fromStarlark(fields)
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return x.writer.Insert(key, raw)
+	}
+}
+
+func (x *ScriptTransform) publishItems(job IETLJob, value starlark.Value) error {
+	if value == nil {
+		return nil
+	}
+	list, err := fromStarlark(value)
+	if err != nil {
+		return err
+	}
+	raw, _ := json.Marshal(list)
+	items := []json.RawMessage{}
+	if err := json.Unmarshal(raw, &items); err != nil {
+		log.Error().Err(err).Str("component", job.Source().Name).Msg("script items")
+		return ErrScriptOutput
+	}
+	for _, data := range items {
+		item := Item{Enabled: true}
+		if err := json.Unmarshal(data, &item); err != nil || item.Item == "" {
+			log.Error().Err(err).Str("component", job.Source().Name).Str("item", string(data)).Msg("script item")
+			return ErrScriptOutput
+		}
+		if common.Null.ToDataType(item.Type).String() != item.Type {
+			log.Error().Err(ErrScriptOutput).Str("component", job.Source().Name).Str("item", item.Item).Str("type", item.Type).Msg("script item type")
+			return ErrScriptOutput
+		}
+		job.Tools().Items[item.Item] = item
+	}
+	return nil
+}
+
+func (x *ScriptTransform) run(job IETLJob, call func(*starlark.Thread) error) error {
+	run := &scriptRun{started: time.Now(), baseline: heapBytes()}
+	run.thread = &starlark.Thread{
+		Name: job.Source().Name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Debug().Str("component", job.Source().Name).Str("message", msg).Msg("script print")
+		},
+		OnMaxSteps: func(*starlark.Thread) { run.trip("too many steps") },
+	}
+	run.thread.SetMaxExecutionSteps(x.maxSteps)
+
+	x.current.Store(run)
+	err := call(run.thread)
+	x.current.Store(nil)
+
+	if reason := run.reason.Load(); reason != nil {
+		log.Error().Err(ErrScriptLimit).Str("component", job.Source().Name).Str("reason", *reason).Msg("script limit")
+		return ErrScriptLimit
+	}
+	return err
+}
+
+func (x *ScriptTransform) watchdog(done chan struct{}) {
+	ticker := time.NewTicker(SCRIPT_WATCHDOG_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			run := x.current.Load()
+			if run == nil {
+				continue
+			}
+			if time.Since(run.started) > x.timeout {
+				run.trip("timeout")
+			} else if heap := heapBytes(); heap > run.baseline && heap-run.baseline > x.maxBytes {
+				run.trip("memory")
+			}
+		}
+	}
+}
+
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+func scriptAlpha2(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &name); err != nil {
+		return nil, err
+	}
+	country := countries.ByName(name)
+	if !country.IsValid() {
+		return starlark.String(""), nil
+	}
+	return starlark.String(country.Alpha2()), nil
+}
+
+func toStarlark(value interface{}) starlark.Value {
+	switch v := value.(type) {
+	case bool:
+		return starlark.Bool(v)
+	case string:
+		return starlark.String(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return starlark.MakeInt64(i)
+		}
+		f, _ := v.Float64()
+		return starlark.Float(f)
+	case []interface{}:
+		list := make([]starlark.Value, len(v))
+		for i, elem := range v {
+			list[i] = toStarlark(elem)
+		}
+		return starlark.NewList(list)
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for k, elem := range v {
+			dict.SetKey(starlark.String(k), toStarlark(elem))
+		}
+		return dict
+	}
+	return starlark.None
+}
+
+func fromStarlark(value starlark.Value) (interface{}, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.Indexable:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			elem, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = elem
+		}
+		return list, nil
+	case *starlark.Dict:
+		dict := make(map[string]interface{}, v.Len())
+		for _, kv := range v.Items() {
+			key, ok := starlark.AsString(kv[0])
+			if !ok {
+				return nil, ErrScriptOutput
+			}
+			elem, err := fromStarlark(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = elem
+		}
+		return dict, nil
+	}
+	return nil, ErrScriptOutput
+}
+
+func toMMDB(fields *starlark.Dict) (mmdbtype.Map, error) {
+	value, err := fromStarlark(fields)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := goToMMDB(value)
+	if err != nil {
+		return nil, err
+	}
+	return entry.(mmdbtype.Map), nil
+}
+
+func goToMMDB(value interface{}) (mmdbtype.DataType, error) {
+	switch v := value.(type) {
+	case bool:
+		return mmdbtype.Bool(v), nil
+	case string:
+		return mmdbtype.String(v), nil
+	case int64:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return mmdbtype.Int32(v), nil
+		}
+		if v >= 0 {
+			return mmdbtype.Uint64(v), nil
+		}
+		return mmdbtype.Float64(v), nil
+	case float64:
+		return mmdbtype.Float64(v), nil
+	case []interface{}:
+		slice := make(mmdbtype.Slice, len(v))
+		for i, elem := range v {
+			out, err := goToMMDB(elem)
+			if err != nil {
+				return nil, err
+			}
+			slice[i] = out
+		}
+		return slice, nil
+	case map[string]interface{}:
+		entry := make(mmdbtype.Map, len(v))
+		for k, elem := range v {
+			out, err := goToMMDB(elem)
+			if err != nil {
+				return nil, err
+			}
+			entry[mmdbtype.String(k)] = out
+		}
+		return entry, nil
+	}
+	// NOTE:  mmdb has no null, the script must leave the field out instead
+	return nil, ErrScriptOutput
+}
@@ -0,0 +1,185 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func createScriptJob(t *testing.T, script, input, inputType, outputType string, info *ScriptInfo) *ETLJob {
+	os.RemoveAll("/tmp/script/")
+	os.RemoveAll("/tmp/dbs/")
+	os.Mkdir("/tmp/dbs", 01777)
+
+	assert.Nil(t, os.WriteFile("/tmp/script.star", []byte(script), 0644))
+	assert.Nil(t, os.WriteFile("/tmp/script."+inputType, []byte(input), 0644))
+
+	info.File = "/tmp/script.star"
+	source := &Source{
+		Name:       "script",
+		Enabled:    true,
+		File:       "/tmp/script." + inputType,
+		InputType:  inputType,
+		OutputType: outputType,
+		Separator:  ",",
+		Script:     info}
+
+	var writer IWriter
+	switch outputType {
+	case "mmdb":
+		writer = NewMMDBWriter()
+	case "fast":
+		writer = NewFastDBWriter()
+	case "sst":
+		writer = NewSSTDBWriter(nil)
+	}
+
+	return NewETLJob(fillToolbox(nil), source, "/tmp/dbs/", writer, NewFileExtractor(source.File), NewScriptTransform(writer, info), writer)
+}
+
+func cleanupScriptJob() {
+	os.Remove("/tmp/script.star")
+	os.Remove("/tmp/script.csv")
+	os.Remove("/tmp/script.json")
+	os.RemoveAll("/tmp/script/")
+	os.RemoveAll("/tmp/dbs/")
+}
+
+func TestScriptTransformCSV(t *testing.T) {
+	script, err := os.ReadFile("./test/source/script.star")
+	assert.Nil(t, err)
+	input, err := os.ReadFile("./test/source/script.csv")
+	assert.Nil(t, err)
+
+	job := createScriptJob(t, string(script), string(input), "csv", "mmdb", &ScriptInfo{})
+	assert.Nil(t, job.Refresh())
+	assert.Equal(t, 5, len(job.Tools().Items))
+	assert.Equal(t, "script.geo.city", job.Tools().Items["ip/script.test/geo.city"].GJSON)
+	assert.True(t, job.Tools().Items["ip/script.test/geo.city"].Enabled)
+
+	mmdb, err := common.NewMaxmindReader("/tmp/dbs/script.mmdb")
+	assert.Nil(t, err)
+	data, err := mmdb.Lookup(net.ParseIP("1.2.3.4"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Paris", gjson.GetBytes(data, "script.geo.city").String())
+	assert.Equal(t, "Ile-de-France", gjson.GetBytes(data, "script.geo.region").String())
+	assert.Equal(t, "FR", gjson.GetBytes(data, "script.geo.countryCode").String())
+	assert.Equal(t, true, gjson.GetBytes(data, "script.geo.isListed").Bool())
+	assert.Equal(t, int64(42), gjson.GetBytes(data, "script.geo.score").Int())
+
+	data, err = mmdb.Lookup(net.ParseIP("5.6.7.8"))
+	assert.Nil(t, err)
+	assert.Equal(t, "DE", gjson.GetBytes(data, "script.geo.countryCode").String())
+
+	cleanupScriptJob()
+}
+
+func TestScriptTransformJSON(t *testing.T) {
+	script := `
+items = [{"Item": "phone/script/IsDisposable", "GJSON": "Result.IsDisposable", "Type": "Boolean", "Description": "Burner."}]
+
+def transform(row):
+    if row["number"] == "skip":
+        return None
+    return {"key": row["number"], "fields": {"Key": row["number"], "Result": {"IsDisposable": True, "Tags": row["tags"], "Age": row["age"]}}}
+`
+	input := `{"data": {"numbers": [{"number": "15121234567", "tags": ["a", "b"], "age": 1.5}, {"number": "skip"}]}}`
+
+	job := createScriptJob(t, script, input, "json", "fast", &ScriptInfo{JSONPath: "data.numbers"})
+	assert.Nil(t, job.Refresh())
+
+	fast := common.NewFastCache().LoadFile("/tmp/dbs/script.fast")
+	obj, found := fast.Get("15121234567")
+	assert.True(t, found)
+	data := obj.([]uint8)
+	assert.True(t, gjson.GetBytes(data, "Result.IsDisposable").Bool())
+	assert.Equal(t, "b", gjson.GetBytes(data, "Result.Tags.1").String())
+	assert.Equal(t, 1.5, gjson.GetBytes(data, "Result.Age").Float())
+	_, found = fast.Get("skip")
+	assert.False(t, found)
+
+	// JSON path that isn't an array
+	job = createScriptJob(t, script, input, "json", "fast", &ScriptInfo{JSONPath: "data"})
+	assert.Equal(t, ErrBadSourceData, job.Refresh())
+
+	cleanupScriptJob()
+}
+
+func TestScriptTransformLimits(t *testing.T) {
+	steps := `
+def transform(row):
+    for i in range(100000000):
+        pass
+`
+	job := createScriptJob(t, steps, "1.2.3.4\n", "csv", "mmdb", &ScriptInfo{MaxSteps: 1000})
+	assert.Equal(t, ErrScriptLimit, job.Refresh())
+
+	job = createScriptJob(t, steps, "1.2.3.4\n", "csv", "mmdb", &ScriptInfo{MaxSteps: 1 << 40, TimeoutMs: 20})
+	assert.Equal(t, ErrScriptLimit, job.Refresh())
+
+	memory := `
+def transform(row):
+    blob = "x" * (64 * 1024 * 1024)
+    for i in range(100000000):
+        pass
+    return {"key": row[0], "fields": {"blob": blob}}
+`
+	job = createScriptJob(t, memory, "1.2.3.4\n", "csv", "mmdb", &ScriptInfo{MaxSteps: 1 << 40, TimeoutMs: 60000, MaxMemoryMB: 8})
+	assert.Equal(t, ErrScriptLimit, job.Refresh())
+
+	// top level code is bounded too
+	toplevel := `
+[i for i in range(100000000)]
+`
+	job = createScriptJob(t, toplevel, "1.2.3.4\n", "csv", "mmdb", &ScriptInfo{MaxSteps: 1000})
+	assert.Equal(t, ErrScriptLimit, job.Refresh())
+
+	cleanupScriptJob()
+}
+
+func TestScriptTransformErrors(t *testing.T) {
+	// no transform function
+	job := createScriptJob(t, "x = 1\n", "1.2.3.4\n", "csv", "mmdb", &ScriptInfo{})
+	assert.Equal(t, ErrScriptMissing, job.Refresh())
+
+	// no file or network access
+	job = createScriptJob(t, "load('os', 'open')\n", "1.2.3.4\n", "csv", "mmdb", &ScriptInfo{})
+	assert.NotNil(t, job.Refresh())
+
+	// syntax error
+	job = createScriptJob(t, "def transform(row)\n", "1.2.3.4\n", "csv", "mmdb", &ScriptInfo{})
+	assert.NotNil(t, job.Refresh())
+
+	// unsupported input type
+	job = createScriptJob(t, "def transform(row):\n    return None\n", "", "yaml", "mmdb", &ScriptInfo{})
+	assert.Equal(t, ErrScriptInputType, job.Transform())
+
+	// bad item type
+	badType := `
+items = [{"Item": "ip/script/x", "GJSON": "x", "Type": "Nope", "Description": "x"}]
+def transform(row):
+    return None
+`
+	job = createScriptJob(t, badType, "1.2.3.4\n", "csv", "mmdb", &ScriptInfo{})
+	assert.Equal(t, ErrScriptOutput, job.Refresh())
+
+	// malformed rows are skipped and logged, not fatal
+	malformed := `
+items = [{"Item": "ip/script/x", "GJSON": "x", "Type": "Boolean", "Description": "x"}]
+def transform(row):
+    if row[0] == "1.2.3.4":
+        return "nope"
+    if row[0] == "2.3.4.5":
+        return {"key": row[0], "fields": {"x": None}}
+    return {"key": row[0]}
+`
+	job = createScriptJob(t, malformed, "1.2.3.4\n2.3.4.5\n3.4.5.6\n", "csv", "mmdb", &ScriptInfo{})
+	assert.Nil(t, job.Refresh())
+
+	cleanupScriptJob()
+}
@@ -0,0 +1,342 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	SORT_RECORD_OVERHEAD = 64
+	SORT_BUFFER_SIZE     = 64 * 1024
+	DEFAULT_MAX_MEMORY   = 256
+)
+
+type sortRecord struct {
+	key   []byte
+	value []byte
+}
+
+// ExternalSorter buffers records up to a memory budget, spills sorted runs to disk and
+// merges them back in key order, records with equal keys keep their insertion order
+type ExternalSorter struct {
+	dir      string
+	maxBytes int64
+	used     int64
+	records  []sortRecord
+	runs     []string
+}
+
+func NewExternalSorter(dir string, maxBytes int64) *ExternalSorter {
+	if maxBytes < 2*SORT_BUFFER_SIZE {
+		maxBytes = 2 * SORT_BUFFER_SIZE
+	}
+	return &ExternalSorter{dir: dir, maxBytes: maxBytes}
+}
+
+func (x *ExternalSorter) Add(key []byte, value []byte) error {
+	// NOTE:  one allocation per record, callers are free to reuse their buffers
+	data := make([]byte, len(key)+len(value))
+	copy(data, key)
+	copy(data[len(key):], value)
+	x.records = append(x.records, sortRecord{key: data[:len(key):len(key)], value: data[len(key):]})
+
+	x.used += int64(len(data)) + SORT_RECORD_OVERHEAD
+	if x.used >= x.maxBytes {
+		return x.spill()
+	}
+	return nil
+}
+
+// Merge streams every record in key order
+func (x *ExternalSorter) Merge(fn func(key []byte, value []byte) error) error {
+	defer x.Close()
+
+	iterator, err := x.Iterator()
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	for {
+		ok, err := iterator.Next()
+		if err != nil || !ok {
+			return err
+		}
+		if err := fn(iterator.Key(), iterator.Value()); err != nil {
+			return err
+		}
+	}
+}
+
+// Iterator hands back records in key order, callers close the iterator and then the sorter
+func (x *ExternalSorter) Iterator() (*SortIterator, error) {
+	if len(x.runs) == 0 {
+		x.sort()
+		return &SortIterator{records: x.records, pos: -1}, nil
+	}
+
+	if len(x.records) > 0 {
+		if err := x.spill(); err != nil {
+			return nil, err
+		}
+	}
+
+	// each open run holds a read buffer, so wide merges happen in passes
+	fanIn := int(x.maxBytes / (2 * SORT_BUFFER_SIZE))
+	if fanIn < 2 {
+		fanIn = 2
+	}
+	for len(x.runs) > fanIn {
+		runs := []string{}
+		for i := 0; i < len(x.runs); i += fanIn {
+			end := i + fanIn
+			if end > len(x.runs) {
+				end = len(x.runs)
+			}
+			run, err := x.mergeToRun(x.runs[i:end])
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, run)
+		}
+		x.runs = runs
+	}
+
+	return openRuns(x.runs)
+}
+
+func (x *ExternalSorter) Close() {
+	for _, run := range x.runs {
+		os.Remove(run)
+	}
+	x.runs = nil
+	x.records = nil
+	x.used = 0
+}
+
+func (x *ExternalSorter) sort() {
+	sort.SliceStable(x.records, func(i, j int) bool {
+		return bytes.Compare(x.records[i].key, x.records[j].key) < 0
+	})
+}
+
+func (x *ExternalSorter) spill() error {
+	x.sort()
+
+	fh, err := os.CreateTemp(x.dir, "run-*.sort")
+	if err != nil {
+		log.Error().Err(err).Str("component", "sorter").Str("dir", x.dir).Msg("create run")
+		return err
+	}
+	defer fh.Close()
+	x.runs = append(x.runs, fh.Name())
+
+	w := bufio.NewWriterSize(fh, SORT_BUFFER_SIZE)
+	for _, record := range x.records {
+		if err := writeSortRecord(w, record.key, record.value); err != nil {
+			log.Error().Err(err).Str("component", "sorter").Str("file", fh.Name()).Msg("write run")
+			return err
+		}
+	}
+	x.records = nil
+	x.used = 0
+	return w.Flush()
+}
+
+func (x *ExternalSorter) mergeToRun(runs []string) (string, error) {
+	fh, err := os.CreateTemp(x.dir, "run-*.sort")
+	if err != nil {
+		log.Error().Err(err).Str("component", "sorter").Str("dir", x.dir).Msg("create run")
+		return "", err
+	}
+	defer fh.Close()
+
+	iterator, err := openRuns(runs)
+	if err == nil {
+		w := bufio.NewWriterSize(fh, SORT_BUFFER_SIZE)
+		for {
+			var ok bool
+			if ok, err = iterator.Next(); err != nil || !ok {
+				break
+			}
+			if err = writeSortRecord(w, iterator.Key(), iterator.Value()); err != nil {
+				break
+			}
+		}
+		iterator.Close()
+		if err == nil {
+			err = w.Flush()
+		}
+	}
+
+	for _, run := range runs {
+		os.Remove(run)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("component", "sorter").Str("file", fh.Name()).Msg("merge runs")
+		os.Remove(fh.Name())
+		return "", err
+	}
+	return fh.Name(), nil
+}
+
+type SortIterator struct {
+	records []sortRecord
+	pos     int
+	readers *runHeap
+	files   []*os.File
+	key     []byte
+	value   []byte
+	started bool
+}
+
+func openRuns(runs []string) (*SortIterator, error) {
+	x := &SortIterator{readers: &runHeap{}}
+	for i, run := range runs {
+		fh, err := os.Open(run)
+		if err != nil {
+			log.Error().Err(err).Str("component", "sorter").Str("file", run).Msg("open run")
+			x.Close()
+			return nil, err
+		}
+		x.files = append(x.files, fh)
+
+		reader := &runReader{index: i, r: bufio.NewReaderSize(fh, SORT_BUFFER_SIZE)}
+		ok, err := reader.next()
+		if err != nil {
+			x.Close()
+			return nil, err
+		}
+		if ok {
+			heap.Push(x.readers, reader)
+		}
+	}
+	return x, nil
+}
+
+func (x *SortIterator) Next() (bool, error) {
+	if x.readers == nil {
+		x.pos++
+		if x.pos >= len(x.records) {
+			return false, nil
+		}
+		x.key, x.value = x.records[x.pos].key, x.records[x.pos].value
+		return true, nil
+	}
+
+	// advance the reader that produced the previous record
+	if x.started && x.readers.Len() > 0 {
+		ok, err := (*x.readers)[0].next()
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			heap.Fix(x.readers, 0)
+		} else {
+			heap.Pop(x.readers)
+		}
+	}
+	x.started = true
+
+	if x.readers.Len() == 0 {
+		return false, nil
+	}
+	reader := (*x.readers)[0]
+	x.key, x.value = reader.key, reader.value
+	return true, nil
+}
+
+func (x *SortIterator) Key() []byte {
+	return x.key
+}
+
+func (x *SortIterator) Value() []byte {
+	return x.value
+}
+
+func (x *SortIterator) Close() {
+	for _, fh := range x.files {
+		fh.Close()
+	}
+	x.files = nil
+	x.records = nil
+}
+
+func writeSortRecord(w *bufio.Writer, key []byte, value []byte) error {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(key)))
+	if _, err := w.Write(size[:n]); err != nil {
+		return err
+	}
+	if _, err := w.Write(key); err != nil {
+		return err
+	}
+	n = binary.PutUvarint(size[:], uint64(len(value)))
+	if _, err := w.Write(size[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(value)
+	return err
+}
+
+type runReader struct {
+	index int
+	r     *bufio.Reader
+	key   []byte
+	value []byte
+}
+
+func (x *runReader) next() (bool, error) {
+	size, err := binary.ReadUvarint(x.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	x.key = make([]byte, size)
+	if _, err := io.ReadFull(x.r, x.key); err != nil {
+		return false, err
+	}
+	size, err = binary.ReadUvarint(x.r)
+	if err != nil {
+		return false, err
+	}
+	x.value = make([]byte, size)
+	if _, err := io.ReadFull(x.r, x.value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type runHeap []*runReader
+
+func (x runHeap) Len() int { return len(x) }
+
+func (x runHeap) Less(i, j int) bool {
+	if c := bytes.Compare(x[i].key, x[j].key); c != 0 {
+		return c < 0
+	}
+	// earlier runs hold earlier inserts
+	return x[i].index < x[j].index
+}
+
+func (x runHeap) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+
+func (x *runHeap) Push(v interface{}) { *x = append(*x, v.(*runReader)) }
+
+func (x *runHeap) Pop() interface{} {
+	old := *x
+	n := len(old)
+	v := old[n-1]
+	*x = old[:n-1]
+	return v
+}
@@ -0,0 +1,75 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sortAll(t *testing.T, sorter *ExternalSorter) ([]string, []string) {
+	keys, values := []string{}, []string{}
+	err := sorter.Merge(func(key []byte, value []byte) error {
+		keys = append(keys, string(key))
+		values = append(values, string(value))
+		return nil
+	})
+	assert.Nil(t, err)
+	return keys, values
+}
+
+func TestExternalSorter(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "sorter-")
+	defer os.RemoveAll(dir)
+
+	// the smallest budget forces spills and several merge passes
+	sorter := NewExternalSorter(dir, 0)
+	assert.Equal(t, int64(2*SORT_BUFFER_SIZE), sorter.maxBytes)
+
+	order := rand.Perm(20000)
+	for _, i := range order {
+		assert.Nil(t, sorter.Add([]byte(fmt.Sprintf("%06d", i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	// equal keys keep insertion order across runs
+	assert.Nil(t, sorter.Add([]byte("000042"), []byte("second")))
+	assert.Greater(t, len(sorter.runs), 2)
+
+	keys, values := sortAll(t, sorter)
+	assert.Equal(t, 20001, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.LessOrEqual(t, keys[i-1], keys[i])
+	}
+	assert.Equal(t, "000042", keys[42])
+	assert.Equal(t, "v42", values[42])
+	assert.Equal(t, "000042", keys[43])
+	assert.Equal(t, "second", values[43])
+
+	// run files are cleaned up
+	files, _ := os.ReadDir(dir)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestExternalSorterInMemory(t *testing.T) {
+	sorter := NewExternalSorter("/nope", 1<<20)
+	key := []byte("b")
+	assert.Nil(t, sorter.Add(key, []byte("2")))
+	// callers may reuse their buffers
+	key[0] = 'a'
+	assert.Nil(t, sorter.Add(key, []byte("1")))
+	assert.Nil(t, sorter.Add([]byte("b"), []byte("3")))
+
+	keys, values := sortAll(t, sorter)
+	assert.Equal(t, []string{"a", "b", "b"}, keys)
+	assert.Equal(t, []string{"1", "2", "3"}, values)
+
+	// spilling into a missing directory fails
+	sorter = NewExternalSorter("/nope", 0)
+	var err error
+	for i := 0; i < 10000 && err == nil; i++ {
+		err = sorter.Add([]byte(fmt.Sprintf("%06d", i)), make([]byte, 64))
+	}
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,301 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	ASN_TYPE_HOSTING   = "hosting"
+	ASN_TYPE_EDUCATION = "education"
+	ASN_TYPE_ISP       = "isp"
+)
+
+// network owner keywords, checked in order against the lowercase AS description, anything not
+// matched is an ISP
+var ASN_TYPES = []struct {
+	Type     string
+	Keywords []string
+}{
+	{ASN_TYPE_EDUCATION, []string{"university", "universidad", "universite", "universitaet", "college", "school", "academ", "research and education", "education network", "institute of technology"}},
+	{ASN_TYPE_HOSTING, []string{"hosting", "host", "cloud", "data center", "datacenter", "server", "vps", "colocation", "dedicated", "leaseweb", "hetzner", "ovh", "linode", "vultr", "choopa", "contabo", "digitalocean", "amazon", "google", "microsoft", "oracle", "alibaba", "tencent"}},
+}
+
+type ASNInfo struct {
+	Number    uint32 `json:"number"`
+	Org       string `json:"org,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Registry  string `json:"registry,omitempty"`
+	Allocated string `json:"allocated,omitempty"`
+}
+
+// ASN joins a prefix to AS table (ie. iptoasn.com ip2asn-combined.tsv: range start, range end,
+// AS number, country, AS description) with the RIR delegated-extended stats listed in Files,
+// prefixes go to the mmdb and the AS numbers to a fast db published next to it
+type ASN struct {
+	writer  IWriter
+	numbers IWriter
+}
+
+func NewASN(writer IWriter, numbers IWriter) *ASN {
+	return &ASN{writer: writer, numbers: numbers}
+}
+
+func (x *ASN) numbersFile(job IETLJob) string {
+	return job.Info().workingPath + job.Source().Name + ".fast"
+}
+
+func (x *ASN) Transform(job IETLJob) error {
+	if err := x.numbers.Create(x.numbersFile(job)); err != nil {
+		return err
+	}
+
+	registered := make(map[uint32]*ASNInfo)
+	for _, file := range job.Source().Files {
+		if err := x.delegated(job, file, registered); err != nil {
+			return err
+		}
+	}
+
+	// NOTE:  AS descriptions carry stray quotes, the csv reader gives up on the whole file
+	separator := job.Source().Separator
+	numbers := make(map[uint32]*ASNInfo)
+	err := readLines(job, job.Info().inputFile, func(line string) {
+		values := strings.Split(line, separator)
+		if len(values) < 5 {
+			return
+		}
+		first, err1 := netip.ParseAddr(values[0])
+		last, err2 := netip.ParseAddr(values[1])
+		number, err3 := strconv.ParseUint(values[2], 10, 32)
+		if err1 != nil || err2 != nil || err3 != nil {
+			log.Error().Err(ErrBadSourceData).Str("component", job.Source().Name).Str("line", line).Msg("parse line")
+			return
+		}
+		// AS 0 is the table's way of saying not routed
+		if number == 0 {
+			return
+		}
+
+		info, ok := numbers[uint32(number)]
+		if !ok {
+			info = &ASNInfo{Number: uint32(number), Org: values[4], Country: values[3]}
+			if registry, ok := registered[info.Number]; ok {
+				info.Country = registry.Country
+				info.Registry = registry.Registry
+				info.Allocated = registry.Allocated
+			}
+			info.Type = ASNType(info.Org)
+			numbers[info.Number] = info
+		}
+
+		entry := mmdbtype.Map{"asn": info.mmdb()}
+		for _, cidr := range RangeCIDRs(first, last) {
+			if err := x.writer.Insert(cidr, entry); err != nil {
+				log.Error().Err(err).Str("component", job.Source().Name).Str("cidr", cidr.String()).Msg("insert")
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// NOTE:  registered numbers nobody announces still answer for asn lookups
+	for number, registry := range registered {
+		if _, ok := numbers[number]; !ok {
+			numbers[number] = registry
+		}
+	}
+	for number, info := range numbers {
+		raw, _ := json.Marshal(map[string]*ASNInfo{"asn": info})
+		x.numbers.Insert(strconv.FormatUint(uint64(number), 10), raw)
+	}
+
+	for _, category := range []string{"ip", "asn"} {
+		job.Tools().Items[category+".asn.number"] = Item{
+			Item:        category + "/asn/number",
+			Enabled:     true,
+			GJSON:       "asn.number",
+			Description: "Autonomous system number.",
+			Type:        common.Integer.String()}
+		job.Tools().Items[category+".asn.org"] = Item{
+			Item:        category + "/asn/org",
+			Enabled:     true,
+			GJSON:       "asn.org",
+			Description: "Network owner.",
+			Type:        common.String.String()}
+		job.Tools().Items[category+".asn.type"] = Item{
+			Item:        category + "/asn/type",
+			Enabled:     true,
+			GJSON:       "asn.type",
+			Description: "Network type, hosting, isp or education.",
+			Type:        common.String.String()}
+		job.Tools().Items[category+".asn.country"] = Item{
+			Item:        category + "/asn/country",
+			Enabled:     true,
+			GJSON:       "asn.country",
+			Description: "Country the AS is registered in.",
+			Type:        common.String.String()}
+		job.Tools().Items[category+".asn.registry"] = Item{
+			Item:        category + "/asn/registry",
+			Enabled:     true,
+			GJSON:       "asn.registry",
+			Description: "Regional internet registry (ie. arin, ripencc).",
+			Type:        common.String.String()}
+		job.Tools().Items[category+".asn.allocated"] = Item{
+			Item:        category + "/asn/allocated",
+			Enabled:     true,
+			GJSON:       "asn.allocated",
+			Description: "Date the AS number was handed out.",
+			Type:        common.Date.String()}
+	}
+
+	return nil
+}
+
+// delegated reads the asn rows of a delegated-extended file, registry|cc|asn|start|count|date|status|id,
+// the version and summary lines have fewer fields so it isn't a csv file either
+func (x *ASN) delegated(job IETLJob, file string, registered map[uint32]*ASNInfo) error {
+	return readLines(job, file, func(line string) {
+		values := strings.Split(line, "|")
+		if len(values) < 7 || values[2] != "asn" || values[1] == "*" {
+			return
+		}
+		if values[6] != "assigned" && values[6] != "allocated" {
+			return
+		}
+		start, err1 := strconv.ParseUint(values[3], 10, 32)
+		count, err2 := strconv.ParseUint(values[4], 10, 32)
+		if err1 != nil || err2 != nil {
+			log.Error().Err(ErrBadSourceData).Str("component", job.Source().Name).Str("line", line).Msg("parse line")
+			return
+		}
+		// NOTE:  registries only have the day, rules compare dates as YYYY-MM-DD
+		allocated := ""
+		if date, err := time.Parse("20060102", values[5]); err == nil {
+			allocated = date.Format("2006-01-02")
+		}
+		for number := start; number < start+count; number++ {
+			registered[uint32(number)] = &ASNInfo{
+				Number:    uint32(number),
+				Country:   values[1],
+				Registry:  values[0],
+				Allocated: allocated}
+		}
+	})
+}
+
+func readLines(job IETLJob, file string, parseLine func(string)) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		log.Error().Err(err).Str("component", job.Source().Name).Str("file", file).Msg("open")
+		return err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parseLine(line)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error().Err(err).Str("component", job.Source().Name).Str("file", file).Msg("read")
+		return err
+	}
+	return nil
+}
+
+func (x *ASN) Load(job IETLJob) error {
+	if err := x.writer.Load(job); err != nil {
+		return err
+	}
+	return x.numbers.Load(job)
+}
+
+// Publish moves the AS number database next to the mmdb, nods serves both as one source
+func (x *ASN) Publish(job IETLJob) error {
+	outputFile := job.Info().outputFile
+	outFile := strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".fast"
+	err := job.Tools().FileSystem.Move(x.numbersFile(job), outFile)
+	if err != nil {
+		log.Error().Err(err).Str("component", "etl").Str("vendor", job.Source().Name).Str("outFile", outFile).Msg("data publish")
+		return err
+	}
+
+	uname, err := user.Current()
+	if err == nil {
+		job.Tools().FileSystem.Chown(outFile, uname.Username)
+	}
+	return nil
+}
+
+func (x *ASNInfo) mmdb() mmdbtype.Map {
+	out := mmdbtype.Map{"number": mmdbtype.Uint32(x.Number)}
+	for key, value := range map[mmdbtype.String]string{"org": x.Org, "type": x.Type, "country": x.Country, "registry": x.Registry, "allocated": x.Allocated} {
+		if value != "" {
+			out[key] = mmdbtype.String(value)
+		}
+	}
+	return out
+}
+
+// ASNType classifies a network owner by name
+func ASNType(org string) string {
+	org = strings.ToLower(org)
+	for _, kind := range ASN_TYPES {
+		for _, keyword := range kind.Keywords {
+			if strings.Contains(org, keyword) {
+				return kind.Type
+			}
+		}
+	}
+	return ASN_TYPE_ISP
+}
+
+// RangeCIDRs splits an address range into the networks that cover exactly that range, the
+// prefix tables list ranges and most of them are not a single network
+func RangeCIDRs(first, last netip.Addr) []*net.IPNet {
+	out := []*net.IPNet{}
+	for first.IsValid() && first.BitLen() == last.BitLen() && first.Compare(last) <= 0 {
+		bits := first.BitLen()
+		for bits > 0 {
+			wider := netip.PrefixFrom(first, bits-1).Masked()
+			if wider.Addr() != first || lastAddr(wider).Compare(last) > 0 {
+				break
+			}
+			bits--
+		}
+		out = append(out, &net.IPNet{IP: net.IP(first.AsSlice()), Mask: net.CIDRMask(bits, first.BitLen())})
+		first = lastAddr(netip.PrefixFrom(first, bits)).Next()
+	}
+	return out
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().As16()
+	for bit := 128 - prefix.Addr().BitLen() + prefix.Bits(); bit < 128; bit++ {
+		addr[bit/8] |= 1 << (7 - bit%8)
+	}
+	last := netip.AddrFrom16(addr)
+	if prefix.Addr().Is4() {
+		return last.Unmap()
+	}
+	return last
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"strings"
 	"testing"
@@ -39,6 +40,7 @@ func buildETLManager() *ETLManager {
 func cleanETLFragments(sourceName string) {
 	os.RemoveAll(fmt.Sprintf("/tmp/%s/", sourceName))
 	os.Remove(fmt.Sprintf("./test/data/%s.mmdb", sourceName))
+	os.Remove(fmt.Sprintf("./test/data/%s.fast", sourceName))
 	os.Remove(fmt.Sprintf("./test/data/%s.json", sourceName))
 }
 
@@ -176,6 +178,8 @@ func TestSources(t *testing.T) {
 		"lightswitch.junk",
 		"lightswitch.aggressive",
 		"akamai",
+		"script.test",
+		"asn",
 	}
 
 	manager := buildETLManager()
@@ -241,3 +245,67 @@ func TestSources(t *testing.T) {
 		}
 	}
 }
+
+func TestASNSource(t *testing.T) {
+	manager := buildETLManager()
+	job := manager.FindJob("asn")
+	assert.Nil(t, job.Refresh())
+
+	mmdb, err := common.NewMaxmindReader("./test/data/asn.mmdb")
+	assert.Nil(t, err)
+	data, err := mmdb.Lookup(net.ParseIP("1.2.3.4"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(64500), gjson.GetBytes(data, "asn.number").Int())
+	assert.Equal(t, "hosting", gjson.GetBytes(data, "asn.type").String())
+	assert.Equal(t, "arin", gjson.GetBytes(data, "asn.registry").String())
+	assert.Equal(t, "2015-06-01", gjson.GetBytes(data, "asn.allocated").String())
+
+	// ranges that aren't one network don't spill over
+	data, _ = mmdb.Lookup(net.ParseIP("1.2.3.10"))
+	assert.Equal(t, "education", gjson.GetBytes(data, "asn.type").String())
+	data, _ = mmdb.Lookup(net.ParseIP("1.2.5.0"))
+	assert.False(t, gjson.GetBytes(data, "asn").Exists())
+	data, _ = mmdb.Lookup(net.ParseIP("2a0e:1c80::1"))
+	assert.Equal(t, int64(64500), gjson.GetBytes(data, "asn.number").Int())
+	data, _ = mmdb.Lookup(net.ParseIP("10.1.1.1"))
+	assert.False(t, gjson.GetBytes(data, "asn").Exists())
+
+	fast := common.NewFastCache()
+	fast.LoadFile("./test/data/asn.fast")
+	obj, found := fast.Get("3356")
+	assert.True(t, found)
+	assert.Equal(t, "LEVEL3 \"Level 3 Parent, LLC\"", gjson.GetBytes(obj.([]uint8), "asn.org").String())
+	assert.Equal(t, "isp", gjson.GetBytes(obj.([]uint8), "asn.type").String())
+	// registered, not announced
+	obj, found = fast.Get("24940")
+	assert.True(t, found)
+	assert.Equal(t, "DE", gjson.GetBytes(obj.([]uint8), "asn.country").String())
+	assert.False(t, gjson.GetBytes(obj.([]uint8), "asn.type").Exists())
+	_, found = fast.Get("64510")
+	assert.False(t, found)
+
+	assert.Equal(t, "asn/asn/type", manager.tools.Items["asn.asn.type"].Item)
+}
+
+func TestRangeCIDRs(t *testing.T) {
+	cidrs := []string{}
+	for _, cidr := range RangeCIDRs(netip.MustParseAddr("1.2.3.0"), netip.MustParseAddr("1.2.3.9")) {
+		cidrs = append(cidrs, cidr.String())
+	}
+	assert.Equal(t, []string{"1.2.3.0/29", "1.2.3.8/31"}, cidrs)
+
+	cidrs = []string{}
+	for _, cidr := range RangeCIDRs(netip.MustParseAddr("2a0e:1c80::"), netip.MustParseAddr("2a0e:1c80::ffff")) {
+		cidrs = append(cidrs, cidr.String())
+	}
+	assert.Equal(t, []string{"2a0e:1c80::/112"}, cidrs)
+
+	assert.Equal(t, 1, len(RangeCIDRs(netip.MustParseAddr("0.0.0.0"), netip.MustParseAddr("255.255.255.255"))))
+	assert.Empty(t, RangeCIDRs(netip.MustParseAddr("1.2.3.9"), netip.MustParseAddr("1.2.3.0")))
+}
+
+func TestASNType(t *testing.T) {
+	assert.Equal(t, ASN_TYPE_HOSTING, ASNType("HETZNER-AS"))
+	assert.Equal(t, ASN_TYPE_EDUCATION, ASNType("MIT-GATEWAYS - Massachusetts Institute of Technology"))
+	assert.Equal(t, ASN_TYPE_ISP, ASNType("COMCAST-7922"))
+}
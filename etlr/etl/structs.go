@@ -11,6 +11,8 @@ type Toolbox struct {
 	FileSystem utils.IFileSystem
 	CSV        utils.ICSV
 	Secrets    common.ISecrets
+	Alerter    IAlerter
+	Exporter   IExporter
 	Items      map[string]Item
 }
 
@@ -25,12 +27,48 @@ type Item struct {
 type Source struct {
 	Name       string
 	Enabled    bool
-	URL        string `json:",omitempty"`
-	File       string `json:",omitempty"`
-	ApiKey     string `json:",omitempty"`
+	URL        string   `json:",omitempty"`
+	File       string   `json:",omitempty"`
+	Files      []string `json:",omitempty"` // more local inputs for sources that join feeds (ie. asn)
+	ApiKey     string   `json:",omitempty"`
 	InputType  string
 	OutputType string
 	Separator  string
+	Script     *ScriptInfo  `json:",omitempty"`
+	Alerts     *AlertInfo   `json:",omitempty"`
+	Quality    *QualityInfo `json:",omitempty"`
+	Aggregate  *PrefixInfo  `json:",omitempty"`
+	Stream     *StreamInfo  `json:",omitempty"`
+}
+
+type StreamInfo struct {
+	MaxMemoryMB int    `json:",omitempty"`
+	TempPath    string `json:",omitempty"`
+}
+
+type PrefixInfo struct {
+	IPv4Prefix int `json:",omitempty"`
+	IPv6Prefix int `json:",omitempty"`
+	MinHits    int `json:",omitempty"`
+}
+
+type AlertInfo struct {
+	MaxFailures int  `json:",omitempty"`
+	MaxAgeHours int  `json:",omitempty"`
+	QualityGate bool `json:",omitempty"`
+}
+
+type QualityInfo struct {
+	MinBytes         int64 `json:",omitempty"`
+	MaxShrinkPercent int   `json:",omitempty"`
+}
+
+type ScriptInfo struct {
+	File        string
+	JSONPath    string `json:",omitempty"`
+	TimeoutMs   int    `json:",omitempty"`
+	MaxSteps    uint64 `json:",omitempty"`
+	MaxMemoryMB uint64 `json:",omitempty"`
 }
 
 type IWriter interface {
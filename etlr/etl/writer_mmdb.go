@@ -67,3 +67,11 @@ func (x *MMDBWriter) Insert(key interface{}, value interface{}) error {
 	// NOTE:  mmdb tree inserts are not thread safe
 	return x.tree.InsertFunc(key.(*net.IPNet), inserter.TopLevelMergeWith(value.(mmdbtype.Map)))
 }
+
+// Merge deep merges into whatever is already in the tree, so a covering network can
+// add fields to the more specific records beneath it without clobbering them
+func (x *MMDBWriter) Merge(key interface{}, value interface{}) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.tree.InsertFunc(key.(*net.IPNet), inserter.DeepMergeWith(value.(mmdbtype.Map)))
+}
@@ -0,0 +1,528 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/osintami/fingerprintz/log"
+)
+
+var ErrStreamNetwork = errors.New("unsupported network")
+var ErrStreamTooLarge = errors.New("mmdb too large for 32 bit records")
+
+var MMDB_METADATA_MARKER = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// search tree records are tagged while the tree is built, node ids and data offsets are
+// only final once the node count is known
+const (
+	recordEmpty = uint64(0)
+	recordNode  = uint64(1) << 62
+	recordData  = uint64(2) << 62
+	recordKind  = uint64(3) << 62
+	recordValue = recordNode - 1
+)
+
+// StreamMMDBWriter is the bounded memory alternative to MMDBWriter, networks are sorted on disk
+// and the search tree is written in one sequential pass instead of being held in memory.
+// Like MMDBWriter it merges top level keys, later inserts win.  Unlike MMDBWriter it does not
+// alias IPv4 into ::ffff:0:0/96 or drop reserved networks.
+type StreamMMDBWriter struct {
+	info    *StreamInfo
+	name    string
+	tmpPath string
+	sorter  *ExternalSorter
+	seq     uint64
+	mutex   sync.Mutex
+}
+
+func NewStreamMMDBWriter(info *StreamInfo) IWriter {
+	if info == nil {
+		info = &StreamInfo{}
+	}
+	return &StreamMMDBWriter{info: info}
+}
+
+func (x *StreamMMDBWriter) Type() string {
+	return "mmdb"
+}
+
+func (x *StreamMMDBWriter) Create(mmdbName string) error {
+	tmpPath, err := os.MkdirTemp(x.info.TempPath, "mmdb-")
+	if err != nil {
+		log.Error().Err(err).Str("component", "mmdb").Str("path", x.info.TempPath).Msg("create sort directory")
+		return err
+	}
+	x.name = mmdbName
+	x.tmpPath = tmpPath
+	x.seq = 0
+	x.sorter = NewExternalSorter(tmpPath, x.info.maxBytes())
+	return nil
+}
+
+func (x *StreamMMDBWriter) Insert(key interface{}, value interface{}) error {
+	sortKey, err := streamKey(key.(*net.IPNet))
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+
+	entries := value.(mmdbtype.Map)
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	payload.Write(scratch[:binary.PutUvarint(scratch[:], x.seq)])
+	payload.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(entries)))])
+	for k, v := range entries {
+		encoded := &mmdbEncoder{}
+		if _, err := v.WriteTo(encoded); err != nil {
+			return err
+		}
+		payload.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(k)))])
+		payload.WriteString(string(k))
+		payload.Write(scratch[:binary.PutUvarint(scratch[:], uint64(encoded.Len()))])
+		payload.Write(encoded.Bytes())
+	}
+	x.seq++
+
+	return x.sorter.Add(sortKey, payload.Bytes())
+}
+
+func (x *StreamMMDBWriter) Load(job IETLJob) error {
+	defer os.RemoveAll(x.tmpPath)
+	defer x.sorter.Close()
+
+	mmdbFile := job.Info().snapshotFile
+	iterator, err := x.sorter.Iterator()
+	if err != nil {
+		log.Error().Err(err).Str("component", "mmdb").Str("file", mmdbFile).Msg("sort networks")
+		return err
+	}
+	defer iterator.Close()
+
+	builder, err := newMMDBBuilder(x.tmpPath, iterator, x.info.maxBytes())
+	if err != nil {
+		return err
+	}
+	defer builder.close()
+
+	if err := builder.build(); err != nil {
+		log.Error().Err(err).Str("component", "mmdb").Str("file", mmdbFile).Msg("build search tree")
+		return err
+	}
+
+	fh, err := os.Create(mmdbFile)
+	if err != nil {
+		log.Error().Err(err).Str("component", "mmdb").Str("file", mmdbFile).Msg("create output mmdb database")
+		return err
+	}
+	defer fh.Close()
+
+	if err := builder.writeTo(fh, x.name); err != nil {
+		log.Error().Err(err).Str("file", mmdbFile).Msg("write output mmdb database")
+		return err
+	}
+	return nil
+}
+
+// IPv4 networks live in ::/96 of the IPv6 tree, the key sorts by address then prefix length
+func streamKey(network *net.IPNet) ([]byte, error) {
+	ones, bits := network.Mask.Size()
+	key := make([]byte, 17)
+	switch bits {
+	case 32:
+		copy(key[12:16], network.IP.Mask(network.Mask).To4())
+		key[16] = byte(96 + ones)
+	case 128:
+		copy(key[:16], network.IP.Mask(network.Mask).To16())
+		key[16] = byte(ones)
+	default:
+		return nil, ErrStreamNetwork
+	}
+	return key, nil
+}
+
+type mmdbEntry struct {
+	key   string
+	value []byte
+}
+
+type streamNetwork struct {
+	ip      [16]byte
+	bits    int
+	seq     uint64
+	entries []mmdbEntry
+}
+
+type mmdbBuilder struct {
+	iterator   *SortIterator
+	peek       *streamNetwork
+	nodeFile   *os.File
+	nodes      *bufio.Writer
+	nodeCount  uint64
+	dataFile   *os.File
+	data       *bufio.Writer
+	dataSize   uint64
+	dedupe     map[[16]byte]uint64
+	maxDedupe  int
+	lastLayers []*streamNetwork
+	lastLeaf   uint64
+}
+
+func newMMDBBuilder(tmpPath string, iterator *SortIterator, maxBytes int64) (*mmdbBuilder, error) {
+	nodeFile, err := os.CreateTemp(tmpPath, "nodes-")
+	if err != nil {
+		return nil, err
+	}
+	dataFile, err := os.CreateTemp(tmpPath, "data-")
+	if err != nil {
+		nodeFile.Close()
+		return nil, err
+	}
+	return &mmdbBuilder{
+		iterator:  iterator,
+		nodeFile:  nodeFile,
+		nodes:     bufio.NewWriterSize(nodeFile, SORT_BUFFER_SIZE),
+		dataFile:  dataFile,
+		data:      bufio.NewWriterSize(dataFile, SORT_BUFFER_SIZE),
+		dedupe:    make(map[[16]byte]uint64),
+		maxDedupe: int(maxBytes / 4 / SORT_RECORD_OVERHEAD)}, nil
+}
+
+func (x *mmdbBuilder) close() {
+	x.nodeFile.Close()
+	x.dataFile.Close()
+}
+
+func (x *mmdbBuilder) build() error {
+	if err := x.advance(); err != nil {
+		return err
+	}
+	root, err := x.subtree([16]byte{}, 0, nil)
+	if err != nil {
+		return err
+	}
+	// the root has to be a node, even for an empty or fully covered tree
+	if root&recordKind != recordNode {
+		if _, err := x.emit(root, root); err != nil {
+			return err
+		}
+	}
+	if err := x.nodes.Flush(); err != nil {
+		return err
+	}
+	return x.data.Flush()
+}
+
+func (x *mmdbBuilder) advance() error {
+	ok, err := x.iterator.Next()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		x.peek = nil
+		return nil
+	}
+
+	key := x.iterator.Key()
+	network := &streamNetwork{bits: int(key[16])}
+	copy(network.ip[:], key[:16])
+
+	r := bytes.NewReader(x.iterator.Value())
+	if network.seq, err = binary.ReadUvarint(r); err != nil {
+		return err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		k, err := readStreamBytes(r)
+		if err != nil {
+			return err
+		}
+		v, err := readStreamBytes(r)
+		if err != nil {
+			return err
+		}
+		network.entries = append(network.entries, mmdbEntry{key: string(k), value: v})
+	}
+	x.peek = network
+	return nil
+}
+
+// subtree walks the address space depth first, nodes are emitted children first
+func (x *mmdbBuilder) subtree(ip [16]byte, depth int, layers []*streamNetwork) (uint64, error) {
+	for x.peek != nil && x.peek.bits == depth && x.peek.ip == ip {
+		layers = append(layers[:len(layers):len(layers)], x.peek)
+		if err := x.advance(); err != nil {
+			return 0, err
+		}
+	}
+	if depth == 128 || x.peek == nil || !samePrefix(x.peek.ip, ip, depth) {
+		return x.leaf(layers)
+	}
+
+	left, err := x.subtree(ip, depth+1, layers)
+	if err != nil {
+		return 0, err
+	}
+	ip[depth/8] |= 0x80 >> (depth % 8)
+	right, err := x.subtree(ip, depth+1, layers)
+	if err != nil {
+		return 0, err
+	}
+	if left == right && left&recordKind != recordNode {
+		return left, nil
+	}
+	return x.emit(left, right)
+}
+
+func (x *mmdbBuilder) leaf(layers []*streamNetwork) (uint64, error) {
+	if len(layers) == 0 {
+		return recordEmpty, nil
+	}
+	if sameLayers(layers, x.lastLayers) {
+		return x.lastLeaf, nil
+	}
+
+	ordered := append([]*streamNetwork{}, layers...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].seq < ordered[j].seq })
+	merged := make(map[string][]byte)
+	for _, layer := range ordered {
+		for _, entry := range layer.entries {
+			merged[entry.key] = entry.value
+		}
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	encoded := &mmdbEncoder{}
+	encoded.mapHeader(len(keys))
+	for _, k := range keys {
+		if _, err := mmdbtype.String(k).WriteTo(encoded); err != nil {
+			return 0, err
+		}
+		encoded.Write(merged[k])
+	}
+
+	offset, err := x.writeData(encoded.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	x.lastLayers = layers
+	x.lastLeaf = recordData | offset
+	return x.lastLeaf, nil
+}
+
+func (x *mmdbBuilder) writeData(value []byte) (uint64, error) {
+	sum := sha256.Sum256(value)
+	var hash [16]byte
+	copy(hash[:], sum[:16])
+	if offset, ok := x.dedupe[hash]; ok {
+		return offset, nil
+	}
+
+	offset := x.dataSize
+	if _, err := x.data.Write(value); err != nil {
+		return 0, err
+	}
+	x.dataSize += uint64(len(value))
+	if len(x.dedupe) < x.maxDedupe {
+		x.dedupe[hash] = offset
+	}
+	return offset, nil
+}
+
+func (x *mmdbBuilder) emit(left uint64, right uint64) (uint64, error) {
+	var node [16]byte
+	binary.BigEndian.PutUint64(node[0:8], left)
+	binary.BigEndian.PutUint64(node[8:16], right)
+	if _, err := x.nodes.Write(node[:]); err != nil {
+		return 0, err
+	}
+	id := x.nodeCount
+	x.nodeCount++
+	return recordNode | id, nil
+}
+
+// writeTo reverses the post order node file so the root becomes node 0, then appends the
+// data section and metadata
+func (x *mmdbBuilder) writeTo(w io.Writer, name string) error {
+	nodeCount := x.nodeCount
+	recordSize := 24
+	switch max := nodeCount + 16 + x.dataSize; {
+	case max >= 1<<32:
+		return ErrStreamTooLarge
+	case max >= 1<<28:
+		recordSize = 32
+	case max >= 1<<24:
+		recordSize = 28
+	}
+
+	resolve := func(record uint64) uint64 {
+		switch record & recordKind {
+		case recordNode:
+			return nodeCount - 1 - record&recordValue
+		case recordData:
+			return nodeCount + 16 + record&recordValue
+		}
+		return nodeCount
+	}
+
+	out := bufio.NewWriterSize(w, SORT_BUFFER_SIZE)
+	chunk := make([]byte, 4096*16)
+	node := make([]byte, recordSize/4)
+	for end := int64(nodeCount) * 16; end > 0; {
+		start := end - int64(len(chunk))
+		if start < 0 {
+			start = 0
+		}
+		buf := chunk[:end-start]
+		if _, err := x.nodeFile.ReadAt(buf, start); err != nil {
+			return err
+		}
+		for i := len(buf) - 16; i >= 0; i -= 16 {
+			left := resolve(binary.BigEndian.Uint64(buf[i : i+8]))
+			right := resolve(binary.BigEndian.Uint64(buf[i+8 : i+16]))
+			writeNode(node, recordSize, left, right)
+			if _, err := out.Write(node); err != nil {
+				return err
+			}
+		}
+		end = start
+	}
+
+	if _, err := out.Write(make([]byte, 16)); err != nil {
+		return err
+	}
+	if _, err := x.dataFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, x.dataFile); err != nil {
+		return err
+	}
+
+	metadata := mmdbtype.Map{
+		"binary_format_major_version": mmdbtype.Uint16(2),
+		"binary_format_minor_version": mmdbtype.Uint16(0),
+		"build_epoch":                 mmdbtype.Uint64(time.Now().Unix()),
+		"database_type":               mmdbtype.String(name),
+		"description":                 mmdbtype.Map{"en": mmdbtype.String(name)},
+		"ip_version":                  mmdbtype.Uint16(6),
+		"languages":                   mmdbtype.Slice{mmdbtype.String("en")},
+		"node_count":                  mmdbtype.Uint32(nodeCount),
+		"record_size":                 mmdbtype.Uint16(recordSize),
+	}
+	encoded := &mmdbEncoder{}
+	if _, err := metadata.WriteTo(encoded); err != nil {
+		return err
+	}
+	if _, err := out.Write(MMDB_METADATA_MARKER); err != nil {
+		return err
+	}
+	if _, err := out.Write(encoded.Bytes()); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func writeNode(node []byte, recordSize int, left uint64, right uint64) {
+	switch recordSize {
+	case 24:
+		node[0], node[1], node[2] = byte(left>>16), byte(left>>8), byte(left)
+		node[3], node[4], node[5] = byte(right>>16), byte(right>>8), byte(right)
+	case 28:
+		node[0], node[1], node[2] = byte(left>>16), byte(left>>8), byte(left)
+		node[3] = byte((left>>24)&0x0F)<<4 | byte((right>>24)&0x0F)
+		node[4], node[5], node[6] = byte(right>>16), byte(right>>8), byte(right)
+	case 32:
+		binary.BigEndian.PutUint32(node[0:4], uint32(left))
+		binary.BigEndian.PutUint32(node[4:8], uint32(right))
+	}
+}
+
+func samePrefix(a [16]byte, b [16]byte, bits int) bool {
+	full := bits / 8
+	if !bytes.Equal(a[:full], b[:full]) {
+		return false
+	}
+	if rest := bits % 8; rest != 0 {
+		mask := byte(0xFF) << (8 - rest)
+		return a[full]&mask == b[full]&mask
+	}
+	return true
+}
+
+func sameLayers(a []*streamNetwork, b []*streamNetwork) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func readStreamBytes(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+// mmdbEncoder satisfies the writer mmdbtype values serialize themselves to, nothing
+// is deduplicated by pointer, values are written inline
+type mmdbEncoder struct {
+	bytes.Buffer
+}
+
+func (x *mmdbEncoder) WriteOrWritePointer(value mmdbtype.DataType) (int64, error) {
+	return value.WriteTo(x)
+}
+
+// mapHeader writes the control byte(s) for a map of size entries
+func (x *mmdbEncoder) mapHeader(size int) {
+	const mapType = byte(7 << 5)
+	switch {
+	case size < 29:
+		x.WriteByte(mapType | byte(size))
+	case size < 285:
+		x.WriteByte(mapType | 29)
+		x.WriteByte(byte(size - 29))
+	case size < 65821:
+		size -= 285
+		x.WriteByte(mapType | 30)
+		x.WriteByte(byte(size >> 8))
+		x.WriteByte(byte(size))
+	default:
+		size -= 65821
+		x.WriteByte(mapType | 31)
+		x.WriteByte(byte(size >> 16))
+		x.WriteByte(byte(size >> 8))
+		x.WriteByte(byte(size))
+	}
+}
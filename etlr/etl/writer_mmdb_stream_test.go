@@ -0,0 +1,240 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type testNetwork struct {
+	network *net.IPNet
+	value   mmdbtype.Map
+}
+
+// random public networks, nested and overlapping on purpose
+func randomNetworks(rnd *rand.Rand, count int) []testNetwork {
+	networks := []testNetwork{}
+	for i := 0; i < count; i++ {
+		var ip net.IP
+		var ones, bits int
+		if rnd.Intn(2) == 0 {
+			ip = net.IPv4(byte(20+rnd.Intn(4)), byte(rnd.Intn(4)), byte(rnd.Intn(256)), byte(rnd.Intn(256))).To4()
+			ones, bits = []int{32, 32, 28, 24, 16}[rnd.Intn(5)], 32
+		} else {
+			ip = make(net.IP, 16)
+			ip[0], ip[1], ip[7], ip[15] = 0x2a, byte(rnd.Intn(2)), byte(rnd.Intn(4)), byte(rnd.Intn(256))
+			ones, bits = []int{128, 128, 64, 48}[rnd.Intn(4)], 128
+		}
+		mask := net.CIDRMask(ones, bits)
+		value := mmdbtype.Map{
+			"test": mmdbtype.Map{
+				"id":     mmdbtype.Int32(i),
+				"name":   mmdbtype.String(fmt.Sprintf("net-%d", i%7)),
+				"listed": mmdbtype.Bool(true),
+			},
+		}
+		if i%3 == 0 {
+			value["extra"] = mmdbtype.Slice{mmdbtype.Float64(1.5), mmdbtype.Uint32(uint32(i))}
+		}
+		networks = append(networks, testNetwork{network: &net.IPNet{IP: ip.Mask(mask), Mask: mask}, value: value})
+	}
+	return networks
+}
+
+func writeMMDB(t testing.TB, writer IWriter, networks []testNetwork, fileName string) {
+	job := NewMockETLJob(fillToolbox(nil), &Source{Name: "test"}, &ETLJobInfo{snapshotFile: fileName})
+	assert.Nil(t, writer.Create("test"))
+	for _, n := range networks {
+		assert.Nil(t, writer.Insert(n.network, n.value))
+	}
+	assert.Nil(t, writer.Load(job))
+}
+
+func TestStreamMMDBWriter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	networks := randomNetworks(rnd, 3000)
+
+	writeMMDB(t, NewMMDBWriter(), networks, "/tmp/tree.mmdb")
+	// tiny budget so the sort spills to disk
+	stream := NewStreamMMDBWriter(&StreamInfo{MaxMemoryMB: 1, TempPath: "/tmp"})
+	assert.Equal(t, "mmdb", stream.Type())
+	writeMMDB(t, stream, networks, "/tmp/stream.mmdb")
+
+	db, err := maxminddb.Open("/tmp/stream.mmdb")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Verify())
+	assert.Equal(t, uint(6), db.Metadata.IPVersion)
+	assert.Equal(t, "test", db.Metadata.DatabaseType)
+	db.Close()
+
+	tree, err := common.NewMaxmindReader("/tmp/tree.mmdb")
+	assert.Nil(t, err)
+	streamed, err := common.NewMaxmindReader("/tmp/stream.mmdb")
+	assert.Nil(t, err)
+
+	// every lookup, inside and around each network, matches the in memory tree
+	for _, n := range networks {
+		ips := []net.IP{n.network.IP, make(net.IP, len(n.network.IP)), make(net.IP, len(n.network.IP))}
+		for i := range n.network.IP {
+			ips[1][i] = n.network.IP[i] | (^n.network.Mask[i] & byte(rnd.Intn(256)))
+			ips[2][i] = n.network.IP[i] | ^n.network.Mask[i]
+		}
+		ips = append(ips, net.IP{byte(20 + rnd.Intn(4)), byte(rnd.Intn(4)), byte(rnd.Intn(256)), byte(rnd.Intn(256))})
+		for _, ip := range ips {
+			expected, expectedErr := tree.Lookup(ip)
+			actual, actualErr := streamed.Lookup(ip)
+			assert.Equal(t, expectedErr, actualErr, ip.String())
+			assert.JSONEq(t, string(orNull(expected)), string(orNull(actual)), ip.String())
+		}
+	}
+
+	// sort directories are cleaned up
+	files, _ := os.ReadDir(stream.(*StreamMMDBWriter).tmpPath)
+	assert.Equal(t, 0, len(files))
+
+	os.Remove("/tmp/tree.mmdb")
+	os.Remove("/tmp/stream.mmdb")
+}
+
+func TestStreamMMDBWriterEdges(t *testing.T) {
+	// empty database still has a root node
+	writeMMDB(t, NewStreamMMDBWriter(nil), nil, "/tmp/stream.mmdb")
+	db, err := maxminddb.Open("/tmp/stream.mmdb")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Verify())
+	assert.Equal(t, uint(1), db.Metadata.NodeCount)
+	db.Close()
+
+	// the whole address space is one record
+	_, all, _ := net.ParseCIDR("::/0")
+	writeMMDB(t, NewStreamMMDBWriter(nil), []testNetwork{{network: all, value: mmdbtype.Map{"a": mmdbtype.Bool(true)}}}, "/tmp/stream.mmdb")
+	reader, err := common.NewMaxmindReader("/tmp/stream.mmdb")
+	assert.Nil(t, err)
+	data, err := reader.Lookup(net.ParseIP("1.2.3.4"))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":true}`, string(data))
+
+	// later inserts win per top level key, whatever the prefix length
+	_, outer, _ := net.ParseCIDR("30.0.0.0/8")
+	_, inner, _ := net.ParseCIDR("30.1.2.3/32")
+	writeMMDB(t, NewStreamMMDBWriter(nil), []testNetwork{
+		{network: inner, value: mmdbtype.Map{"a": mmdbtype.Int32(1), "b": mmdbtype.Int32(1)}},
+		{network: outer, value: mmdbtype.Map{"a": mmdbtype.Int32(2)}},
+	}, "/tmp/stream.mmdb")
+	reader.Resync()
+	data, err = reader.Lookup(net.ParseIP("30.1.2.3"))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":2,"b":1}`, string(data))
+	data, err = reader.Lookup(net.ParseIP("30.9.9.9"))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":2}`, string(data))
+
+	// wide maps
+	wide := mmdbtype.Map{}
+	for i := 0; i < 300; i++ {
+		wide[mmdbtype.String(fmt.Sprintf("k%03d", i))] = mmdbtype.Int32(i)
+	}
+	writeMMDB(t, NewStreamMMDBWriter(nil), []testNetwork{{network: inner, value: wide}}, "/tmp/stream.mmdb")
+	reader.Resync()
+	data, err = reader.Lookup(net.ParseIP("30.1.2.3"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"k299":299`)
+
+	writer := NewStreamMMDBWriter(nil)
+	writer.Create("test")
+	assert.Equal(t, ErrStreamNetwork, writer.Insert(&net.IPNet{IP: net.IP{1, 2, 3}, Mask: net.IPMask{255, 255, 255}}, mmdbtype.Map{}))
+	assert.NotNil(t, NewStreamMMDBWriter(&StreamInfo{TempPath: "/nope"}).Create("test"))
+
+	os.Remove("/tmp/stream.mmdb")
+}
+
+func TestWriteNode(t *testing.T) {
+	node := make([]byte, 7)
+	writeNode(node, 28, 0x0ABCDEF1, 0x01234567)
+	assert.Equal(t, []byte{0xBC, 0xDE, 0xF1, 0xA1, 0x23, 0x45, 0x67}, node)
+	node = make([]byte, 6)
+	writeNode(node, 24, 0xABCDEF, 0x123456)
+	assert.Equal(t, []byte{0xAB, 0xCD, 0xEF, 0x12, 0x34, 0x56}, node)
+	node = make([]byte, 8)
+	writeNode(node, 32, 0xABCDEF12, 0x12345678)
+	assert.Equal(t, []byte{0xAB, 0xCD, 0xEF, 0x12, 0x12, 0x34, 0x56, 0x78}, node)
+}
+
+func orNull(data []byte) []byte {
+	if data == nil {
+		return []byte("null")
+	}
+	return data
+}
+
+// peakHeap samples live heap while fn runs and returns the high water mark above the baseline
+func peakHeap(fn func()) uint64 {
+	runtime.GC()
+	base := heapBytes()
+	var peak uint64
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if used := heapBytes(); used > base && used-base > atomic.LoadUint64(&peak) {
+					atomic.StoreUint64(&peak, used-base)
+				}
+			}
+		}
+	}()
+	fn()
+	close(done)
+	return atomic.LoadUint64(&peak)
+}
+
+// go test -run XXX -bench BenchmarkMMDBWriters -benchtime 1x
+func BenchmarkMMDBWriters(b *testing.B) {
+	networks := 500000
+	rows := func(writer IWriter) {
+		writer.Create("bench")
+		for i := 0; i < networks; i++ {
+			ip := net.IPv4(byte(20+i>>24), byte(i>>16), byte(i>>8), byte(i)).To4()
+			writer.Insert(&net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}, mmdbtype.Map{
+				"bench": mmdbtype.Map{
+					"id":      mmdbtype.Uint32(uint32(i)),
+					"country": mmdbtype.String(fmt.Sprintf("C%d", i%200)),
+				},
+			})
+		}
+		writer.Load(NewMockETLJob(fillToolbox(nil), &Source{Name: "bench"}, &ETLJobInfo{snapshotFile: "/tmp/bench.mmdb"}))
+	}
+
+	for _, bench := range []struct {
+		name   string
+		writer func() IWriter
+	}{
+		{"tree", NewMMDBWriter},
+		{"stream-16MB", func() IWriter { return NewStreamMMDBWriter(&StreamInfo{MaxMemoryMB: 16}) }},
+		{"stream-64MB", func() IWriter { return NewStreamMMDBWriter(&StreamInfo{MaxMemoryMB: 64}) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				peak = peakHeap(func() { rows(bench.writer()) })
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+		})
+	}
+	os.Remove("/tmp/bench.mmdb")
+}
@@ -0,0 +1,172 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	DEFAULT_IPV4_PREFIX = 24
+	DEFAULT_IPV6_PREFIX = 64
+	DEFAULT_MIN_HITS    = 3
+)
+
+type IMergeWriter interface {
+	IWriter
+	Merge(key interface{}, value interface{}) error
+}
+
+type prefixHits struct {
+	network *net.IPNet
+	hosts   map[string]bool
+}
+
+// PrefixAggregator rolls individually listed addresses up to their covering prefix, attackers
+// rotate through a v6 /64 (or a v4 /24) so exact matches alone miss the neighbours
+type PrefixAggregator struct {
+	writer     IMergeWriter
+	ipv4Prefix int
+	ipv6Prefix int
+	minHits    int
+	path       []string
+	prefixes   map[string]*prefixHits
+	mutex      sync.Mutex
+}
+
+func NewPrefixAggregator(writer IMergeWriter, info *PrefixInfo) IWriter {
+	x := &PrefixAggregator{
+		writer:     writer,
+		ipv4Prefix: info.IPv4Prefix,
+		ipv6Prefix: info.IPv6Prefix,
+		minHits:    info.MinHits}
+	if x.ipv4Prefix <= 0 || x.ipv4Prefix > 32 {
+		x.ipv4Prefix = DEFAULT_IPV4_PREFIX
+	}
+	if x.ipv6Prefix <= 0 || x.ipv6Prefix > 128 {
+		x.ipv6Prefix = DEFAULT_IPV6_PREFIX
+	}
+	if x.minHits <= 0 {
+		x.minHits = DEFAULT_MIN_HITS
+	}
+	return x
+}
+
+func (x *PrefixAggregator) Type() string {
+	return x.writer.Type()
+}
+
+func (x *PrefixAggregator) Create(name string) error {
+	x.path = nil
+	x.prefixes = make(map[string]*prefixHits)
+	return x.writer.Create(name)
+}
+
+func (x *PrefixAggregator) Insert(key interface{}, value interface{}) error {
+	if err := x.writer.Insert(key, value); err != nil {
+		return err
+	}
+
+	network := key.(*net.IPNet)
+	ones, bits := network.Mask.Size()
+	prefix := x.ipv4Prefix
+	if bits == 128 {
+		prefix = x.ipv6Prefix
+	}
+	// ranges at or above the aggregation prefix already cover their neighbours
+	if ones <= prefix {
+		return nil
+	}
+
+	covering := &net.IPNet{IP: network.IP.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if x.path == nil {
+		x.path = namespace(value.(mmdbtype.Map))
+	}
+	hits, ok := x.prefixes[covering.String()]
+	if !ok {
+		hits = &prefixHits{network: covering, hosts: make(map[string]bool)}
+		x.prefixes[covering.String()] = hits
+	}
+	hits.hosts[network.String()] = true
+	return nil
+}
+
+func (x *PrefixAggregator) Load(job IETLJob) error {
+	if len(x.path) > 0 {
+		listed := 0
+		for _, hits := range x.prefixes {
+			if len(hits.hosts) < x.minHits {
+				continue
+			}
+			entry := nest(x.path, mmdbtype.Map{
+				"prefixListed":   mmdbtype.Bool(true),
+				"prefixHitCount": mmdbtype.Int32(len(hits.hosts)),
+			})
+			if err := x.writer.Merge(hits.network, entry); err != nil {
+				log.Error().Err(err).Str("component", job.Source().Name).Str("cidr", hits.network.String()).Msg("prefix merge")
+				return err
+			}
+			listed++
+		}
+		log.Info().Str("component", job.Source().Name).Int("prefixes", listed).Msg("prefix aggregation")
+		x.publishItems(job)
+	}
+	return x.writer.Load(job)
+}
+
+func (x *PrefixAggregator) publishItems(job IETLJob) {
+	gjson := strings.Join(x.path, ".")
+	item := strings.Join(x.path[1:], ".")
+	if item != "" {
+		item += "."
+	}
+
+	job.Tools().Items[gjson+".prefixListed"] = Item{
+		Item:        "ip/" + job.Source().Name + "/" + item + "prefixListed",
+		Enabled:     true,
+		GJSON:       gjson + ".prefixListed",
+		Description: "IP falls in a densely listed prefix.",
+		Type:        common.Boolean.String()}
+	job.Tools().Items[gjson+".prefixHitCount"] = Item{
+		Item:        "ip/" + job.Source().Name + "/" + item + "prefixHitCount",
+		Enabled:     true,
+		GJSON:       gjson + ".prefixHitCount",
+		Description: "Listed addresses in the covering prefix.",
+		Type:        common.Integer.String()}
+}
+
+// namespace follows single key maps down to the vendor's fields, e.g. dshield.blacklist
+func namespace(value mmdbtype.Map) []string {
+	path := []string{}
+	for len(value) == 1 {
+		var next mmdbtype.Map
+		for key, child := range value {
+			if m, ok := child.(mmdbtype.Map); ok {
+				path = append(path, string(key))
+				next = m
+			}
+		}
+		if next == nil {
+			break
+		}
+		value = next
+	}
+	return path
+}
+
+func nest(path []string, leaf mmdbtype.Map) mmdbtype.Map {
+	value := leaf
+	for i := len(path) - 1; i >= 0; i-- {
+		value = mmdbtype.Map{mmdbtype.String(path[i]): value}
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+var PREFIX_CONTENT = "2a01:4f8:1:1::1\t5\n2a01:4f8:1:1::2\t3\n2a01:4f8:1:1::3\t1\n2a01:4f8:1:1::3\t1\n2a01:4f8:2::1\t2\n" +
+	"5.6.7.1\t1\n5.6.7.2\t1\n5.6.7.3\t1\n8.8.8.8\t1\n9.9.9.0/24\t1\n"
+
+func TestPrefixAggregator(t *testing.T) {
+	os.RemoveAll("/tmp/ipsum/")
+	os.RemoveAll("/tmp/dbs/")
+	os.Mkdir("/tmp/dbs", 01777)
+	os.WriteFile("/tmp/prefix.csv", []byte(PREFIX_CONTENT), 0644)
+
+	source := &Source{
+		Name:       "ipsum",
+		Enabled:    true,
+		File:       "/tmp/prefix.csv",
+		InputType:  "csv",
+		OutputType: "mmdb",
+		Separator:  "\t",
+		Aggregate:  &PrefixInfo{}}
+
+	writer := NewPrefixAggregator(NewMMDBWriter().(IMergeWriter), source.Aggregate)
+	assert.Equal(t, "mmdb", writer.Type())
+	job := NewETLJob(fillToolbox(nil), source, "/tmp/dbs/", writer, NewFileExtractor(source.File), NewIpSUM(writer), writer)
+	assert.Nil(t, job.Refresh())
+
+	item := job.Tools().Items["ipsum.blacklist.prefixListed"]
+	assert.Equal(t, "ip/ipsum/blacklist.prefixListed", item.Item)
+	assert.Equal(t, common.Boolean.String(), item.Type)
+	assert.Equal(t, "ipsum.blacklist.prefixHitCount", job.Tools().Items["ipsum.blacklist.prefixHitCount"].GJSON)
+
+	mmdb, err := common.NewMaxmindReader("/tmp/dbs/ipsum.mmdb")
+	assert.Nil(t, err)
+
+	// rotated address in a dense /64, prefix only
+	data, err := mmdb.Lookup(net.ParseIP("2a01:4f8:1:1::ffff"))
+	assert.Nil(t, err)
+	assert.True(t, gjson.GetBytes(data, "ipsum.blacklist.prefixListed").Bool())
+	assert.Equal(t, int64(3), gjson.GetBytes(data, "ipsum.blacklist.prefixHitCount").Int())
+	assert.False(t, gjson.GetBytes(data, "ipsum.blacklist.isBlacklisted").Exists())
+
+	// exact hits keep their own fields
+	data, err = mmdb.Lookup(net.ParseIP("2a01:4f8:1:1::1"))
+	assert.Nil(t, err)
+	assert.True(t, gjson.GetBytes(data, "ipsum.blacklist.isBlacklisted").Bool())
+	assert.Equal(t, int64(5), gjson.GetBytes(data, "ipsum.blacklist.blacklistCount").Int())
+	assert.True(t, gjson.GetBytes(data, "ipsum.blacklist.prefixListed").Bool())
+
+	// below the density threshold
+	data, err = mmdb.Lookup(net.ParseIP("2a01:4f8:2::1"))
+	assert.Nil(t, err)
+	assert.False(t, gjson.GetBytes(data, "ipsum.blacklist.prefixListed").Exists())
+	_, err = mmdb.Lookup(net.ParseIP("2a01:4f8:2::2"))
+	assert.Equal(t, common.ErrNoDataPresent, err)
+
+	// IPv4 /24
+	data, err = mmdb.Lookup(net.ParseIP("5.6.7.200"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), gjson.GetBytes(data, "ipsum.blacklist.prefixHitCount").Int())
+	_, err = mmdb.Lookup(net.ParseIP("8.8.8.9"))
+	assert.Equal(t, common.ErrNoDataPresent, err)
+
+	// ranges already as wide as the prefix aren't counted
+	data, err = mmdb.Lookup(net.ParseIP("9.9.9.9"))
+	assert.Nil(t, err)
+	assert.False(t, gjson.GetBytes(data, "ipsum.blacklist.prefixListed").Exists())
+
+	os.Remove("/tmp/prefix.csv")
+	os.RemoveAll("/tmp/ipsum/")
+	os.RemoveAll("/tmp/dbs/")
+}
+
+func TestPrefixAggregatorConfig(t *testing.T) {
+	writer := NewPrefixAggregator(NewMMDBWriter().(IMergeWriter), &PrefixInfo{IPv4Prefix: 33, IPv6Prefix: 48, MinHits: 10}).(*PrefixAggregator)
+	assert.Equal(t, DEFAULT_IPV4_PREFIX, writer.ipv4Prefix)
+	assert.Equal(t, 48, writer.ipv6Prefix)
+	assert.Equal(t, 10, writer.minHits)
+
+	assert.Equal(t, []string{"a", "b"}, namespace(mmdbtype.Map{"a": mmdbtype.Map{"b": mmdbtype.Map{"c": mmdbtype.Bool(true), "d": mmdbtype.Bool(true)}}}))
+	assert.Equal(t, []string{"a"}, namespace(mmdbtype.Map{"a": mmdbtype.Map{"c": mmdbtype.Bool(true)}}))
+	assert.Equal(t, []string{}, namespace(mmdbtype.Map{"c": mmdbtype.Bool(true)}))
+
+	manager := NewETLManager(fillToolbox(nil), "/tmp/dbs/", []Source{{Name: "ipsum", Enabled: true, OutputType: "mmdb", Aggregate: &PrefixInfo{}}})
+	_, ok := manager.FindJob("ipsum").writer.(*PrefixAggregator)
+	assert.True(t, ok)
+}
@@ -0,0 +1,95 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+// SSTDBWriter is the bounded memory alternative to FastDBWriter, rows are sorted on disk
+// and streamed into a sorted block key/value file that NODS reads without loading it all
+type SSTDBWriter struct {
+	info     *StreamInfo
+	fileName string
+	tmpPath  string
+	sorter   *ExternalSorter
+}
+
+func NewSSTDBWriter(info *StreamInfo) IWriter {
+	if info == nil {
+		info = &StreamInfo{}
+	}
+	return &SSTDBWriter{info: info}
+}
+
+func (x *SSTDBWriter) Type() string {
+	return "sst"
+}
+
+func (x *SSTDBWriter) Create(fileName string) error {
+	tmpPath, err := os.MkdirTemp(x.info.TempPath, "sst-")
+	if err != nil {
+		log.Error().Err(err).Str("component", "sst").Str("path", x.info.TempPath).Msg("create sort directory")
+		return err
+	}
+	x.fileName = fileName
+	x.tmpPath = tmpPath
+	x.sorter = NewExternalSorter(tmpPath, x.info.maxBytes())
+	return nil
+}
+
+func (x *SSTDBWriter) Load(job IETLJob) error {
+	defer os.RemoveAll(x.tmpPath)
+	defer x.sorter.Close()
+
+	writer, err := common.NewSSTableWriter(x.fileName)
+	if err != nil {
+		return err
+	}
+
+	// NOTE:  duplicate keys arrive together in insert order, last one wins like the fast cache
+	var pendingKey, pendingValue []byte
+	err = x.sorter.Merge(func(key []byte, value []byte) error {
+		if pendingKey != nil && !bytes.Equal(key, pendingKey) {
+			if err := writer.Append(string(pendingKey), pendingValue); err != nil {
+				return err
+			}
+		}
+		pendingKey, pendingValue = key, value
+		return nil
+	})
+	if err == nil && pendingKey != nil {
+		err = writer.Append(string(pendingKey), pendingValue)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("component", "sst").Str("file", x.fileName).Msg("sorted load")
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func (x *SSTDBWriter) Insert(key interface{}, value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return x.sorter.Add([]byte(key.(string)), v)
+	case string:
+		return x.sorter.Add([]byte(key.(string)), []byte(v))
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return x.sorter.Add([]byte(key.(string)), raw)
+}
+
+func (x *StreamInfo) maxBytes() int64 {
+	if x == nil || x.MaxMemoryMB <= 0 {
+		return DEFAULT_MAX_MEMORY << 20
+	}
+	return int64(x.MaxMemoryMB) << 20
+}
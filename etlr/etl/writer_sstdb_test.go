@@ -0,0 +1,82 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package etl
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSTDBWriter(t *testing.T) {
+	writer := NewSSTDBWriter(&StreamInfo{MaxMemoryMB: 1, TempPath: "/tmp"})
+	assert.Equal(t, "sst", writer.Type())
+
+	testFile := "/tmp/sst-writer-test.sst"
+	err := writer.Create(testFile)
+	assert.Nil(t, err)
+
+	for i := 30000; i > 0; i-- {
+		assert.Nil(t, writer.Insert(fmt.Sprintf("domain%05d.com", i), []byte(fmt.Sprintf(`{"id":%d}`, i))))
+	}
+	// last insert wins
+	assert.Nil(t, writer.Insert("domain00042.com", `{"id":"second"}`))
+	assert.Nil(t, writer.Insert("nope.com", map[string]bool{"IsFake": true}))
+
+	err = writer.Load(nil)
+	assert.Nil(t, err)
+
+	reader, err := common.NewSSTableReader(testFile)
+	assert.Nil(t, err)
+	defer reader.Close()
+
+	entry, found := reader.Get("domain00001.com")
+	assert.True(t, found)
+	assert.Equal(t, `{"id":1}`, string(entry))
+	entry, found = reader.Get("domain00042.com")
+	assert.True(t, found)
+	assert.Equal(t, `{"id":"second"}`, string(entry))
+	entry, found = reader.Get("nope.com")
+	assert.True(t, found)
+	assert.Equal(t, `{"IsFake":true}`, string(entry))
+	_, found = reader.Get("nunya.com")
+	assert.False(t, found)
+
+	// sort directory is cleaned up
+	_, err = os.Stat(writer.(*SSTDBWriter).tmpPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// cleanup
+	os.Remove(testFile)
+}
+
+// go test -run XXX -bench BenchmarkKVWriters -benchtime 1x
+func BenchmarkKVWriters(b *testing.B) {
+	rows := 1000000
+	for _, bench := range []struct {
+		name   string
+		writer func() IWriter
+	}{
+		{"fast", NewFastDBWriter},
+		{"sst-16MB", func() IWriter { return NewSSTDBWriter(&StreamInfo{MaxMemoryMB: 16}) }},
+		{"sst-64MB", func() IWriter { return NewSSTDBWriter(&StreamInfo{MaxMemoryMB: 64}) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				peak = peakHeap(func() {
+					writer := bench.writer()
+					writer.Create("/tmp/bench.kv")
+					for j := 0; j < rows; j++ {
+						writer.Insert(fmt.Sprintf("domain%07d.com", (j*7919)%rows), []byte(fmt.Sprintf(`{"Result":{"IsFake":true,"Id":%d}}`, j)))
+					}
+					writer.Load(nil)
+				})
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+		})
+	}
+	os.Remove("/tmp/bench.kv")
+}
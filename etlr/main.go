@@ -12,6 +12,7 @@ import (
 	"github.com/osintami/fingerprintz/etlr/server"
 	"github.com/osintami/fingerprintz/etlr/utils"
 	"github.com/osintami/fingerprintz/log"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -34,6 +35,33 @@ func main() {
 		CSV:        utils.NewCSVReader(),
 		Items:      make(map[string]etl.Item)}
 
+	// source failure and stale data alerts
+	alerts := etl.Alerts{}
+	if svrConfig.AlertEmail != "" {
+		email, err := etl.NewEmailAlerter(common.NewSender(), svrConfig.AlertEmail)
+		if err != nil {
+			log.Fatal().Err(err).Str("component", "etlr").Str("email", svrConfig.AlertEmail).Msg("alert recipient")
+			return
+		}
+		alerts = append(alerts, email)
+	}
+	if svrConfig.AlertHook != "" {
+		alerts = append(alerts, etl.NewWebhookAlerter(resty, svrConfig.AlertHook))
+	}
+	if len(alerts) > 0 {
+		tools.Alerter = alerts
+	}
+
+	// optional relational copy of every published dataset for analytics
+	if svrConfig.ExportDB != "" {
+		exporter, err := openExporter(svrConfig)
+		if err != nil {
+			log.Fatal().Err(err).Str("component", "etlr").Str("db", svrConfig.ExportDB).Msg("export database")
+			return
+		}
+		tools.Exporter = exporter
+	}
+
 	// load the ETL instructions
 	sources := []etl.Source{}
 	err := common.LoadJson("config.json", &sources)
@@ -60,3 +88,25 @@ func main() {
 		log.Info().Str("component", "etlr").Str("state", "stopped").Msg("orchestration")
 	}
 }
+
+func openExporter(svrConfig *server.ServerConfig) (*etl.SQLExporter, error) {
+	var db *gorm.DB
+	var err error
+	switch svrConfig.ExportDB {
+	case "postgres":
+		db, err = common.OpenDB(&common.PostgresConfig{
+			PgHost:     svrConfig.PgHost,
+			PgPort:     svrConfig.PgPort,
+			PgUser:     svrConfig.PgUser,
+			PgPassword: svrConfig.PgPassword,
+			PgDB:       svrConfig.PgDB}, svrConfig.LogPath)
+	case "sqlite":
+		db, err = common.OpenSQLite(svrConfig.ExportFile, svrConfig.LogPath)
+	default:
+		return nil, etl.ErrExportDatabase
+	}
+	if err != nil {
+		return nil, err
+	}
+	return etl.NewSQLExporter(db, svrConfig.ExportKeep)
+}
@@ -15,6 +15,16 @@ type ServerConfig struct {
 	LogLevel   string `env:"LOG_LEVEL" envDefault:"INFO"`
 	PathPrefix string `env:"PATH_PREFIX" envDefault:"/etlr"`
 	ListenAddr string `env:"LISTEN_ADDR" envDefault:"127.0.0.1:8081"`
+	AlertEmail string `env:"ALERT_EMAIL_TO"`
+	AlertHook  string `env:"ALERT_WEBHOOK_URL"`
+	ExportDB   string `env:"EXPORT_DB"`
+	ExportFile string `env:"EXPORT_SQLITE_FILE" envDefault:"/home/osintami/data/etlr.sqlite"`
+	ExportKeep int    `env:"EXPORT_KEEP" envDefault:"2"`
+	PgDB       string `env:"POSTGRES_DB" envDefault:"osintami"`
+	PgHost     string `env:"POSTGRES_HOST" envDefault:"127.0.0.1"`
+	PgPassword string `env:"POSTGRES_PASSWORD" envDefault:"postgres"`
+	PgPort     string `env:"POSTGRES_PORT" envDefault:"5432"`
+	PgUser     string `env:"POSTGRES_USER" envDefault:"postgres"`
 }
 
 type Message struct {
@@ -43,7 +43,7 @@ func main() {
 	gorm.AutoMigrate(&server.Pixel{})
 
 	// first time setup
-	accounts := server.NewAccounts(gorm, server.NewSender(), "welcome.template")
+	accounts := server.NewAccounts(gorm, common.NewSender(), "welcome.template")
 	user, err := accounts.FindByEmail(context.Background(), "admin@osintami.com")
 	if user == nil || err != nil {
 		// create admin user, see postgres osintami/accounts table for API key
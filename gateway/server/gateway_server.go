@@ -70,6 +70,7 @@ func (x *GatewayServer) ReverseProxyHandler(w http.ResponseWriter, r *http.Reque
 		// strip out security concerns before forwarding
 		values.Del("key")
 		values.Del("role")
+		values.Del("account")
 
 		role := service.ACL
 		// bump ACL's up for the service based on user ACL vs service minimum ACL
@@ -77,6 +78,8 @@ func (x *GatewayServer) ReverseProxyHandler(w http.ResponseWriter, r *http.Reque
 			role = ROLE_ADMIN
 		}
 		values.Add("role", role)
+		// who made the call, for services that keep an audit trail
+		values.Add("account", user.Email)
 		r.URL.RawQuery = values.Encode()
 	}
 
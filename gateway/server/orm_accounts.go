@@ -56,14 +56,14 @@ var ErrUserExists = errors.New("user exists")
 
 type Accounts struct {
 	gorm         *gorm.DB
-	mail         *Email
+	mail         *common.Email
 	templateFile string
 }
 
-func NewAccounts(gorm *gorm.DB, sender IMail, templateFile string) *Accounts {
+func NewAccounts(gorm *gorm.DB, sender common.IMail, templateFile string) *Accounts {
 	return &Accounts{
 		gorm:         gorm,
-		mail:         NewEmail(sender),
+		mail:         common.NewEmail(sender),
 		templateFile: templateFile}
 }
 
@@ -153,7 +153,7 @@ func (x *Accounts) WelcomeEmail(account *Account) error {
 	receipt := time.Now().Format(common.GO_DEFAULT_DATE)
 	subject := fmt.Sprintf("OSINTAMI Receipt - %s", receipt)
 
-	body, err := x.mail.loadTemplate(x.templateFile)
+	body, err := loadTemplate(x.templateFile)
 
 	if err == nil {
 		content := string(body)
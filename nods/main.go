@@ -2,6 +2,9 @@
 package main
 
 import (
+	"context"
+	"os"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-resty/resty/v2"
@@ -20,22 +23,52 @@ func main() {
 		common.PrintEnvironment()
 	}
 
-	watcher := common.NewFileWatcher()
-	if watcher == nil {
+	rulesFile := svrConfig.SchemaPath + server.SOURCE_OSINTAMI_NAME + ".json"
+	// nods rules test [fixtures.json]
+	if len(os.Args) > 2 && os.Args[1] == "rules" && os.Args[2] == "test" {
+		fixturesFile := svrConfig.SchemaPath + server.SOURCE_OSINTAMI_NAME + ".fixtures.json"
+		if len(os.Args) > 3 {
+			fixturesFile = os.Args[3]
+		}
+		schema := server.NewDataSchema(nil, common.NewFastCache(), svrConfig.ConfigPath, svrConfig.SchemaPath)
+		if !server.RunRulesTest(os.Stdout, schema, rulesFile, fixturesFile) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	fileWatcher := common.NewFileWatcher()
+	if fileWatcher == nil {
 		log.Fatal().Str("component", "nods").Msg("file watcher")
 	}
+	// NOTE:  every reload goes through here so cached rule outputs know when they are stale
+	watcher := server.NewSourceWatcher(fileWatcher)
 	cache := common.NewFastCache()
 	client := resty.New()
 	schema := server.NewDataSchema(watcher, cache, svrConfig.ConfigPath, svrConfig.SchemaPath)
 	secrets := LoadSecrets()
+	shadows := server.NewShadowStore(server.SHADOW_SAMPLES)
+
+	// NOTE:  a rule with a typo would otherwise quietly answer false forever
+	if errs := server.LintRules(schema, rulesFile); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error().Err(err).Str("component", "nods").Str("file", rulesFile).Msg("rules lint")
+		}
+		log.Fatal().Str("component", "nods").Msg("rules lint")
+	}
 
 	tools := server.Toolbox{
-		Client:   client,
-		Cache:    cache,
-		Watcher:  watcher,
-		Schema:   schema,
-		Secrets:  secrets,
-		DataPath: svrConfig.DataPath,
+		Client:    client,
+		Cache:     cache,
+		Watcher:   watcher,
+		Schema:    schema,
+		Secrets:   secrets,
+		DataPath:  svrConfig.DataPath,
+		Shadows:   shadows,
+		Resolver:  svrConfig.DNSResolver,
+		SMTPPort:  svrConfig.SMTPPort,
+		SMTPHello: svrConfig.SMTPHello,
+		SMTPFrom:  svrConfig.SMTPFrom,
 	}
 
 	router := server.NewDataRouter(&tools)
@@ -43,6 +76,21 @@ func main() {
 
 	rules := server.NewRuleProvider(router, schema)
 	handlers := server.NewNormalizedDataServer(schema, router, secrets, server.NewComplexProvider(rules))
+	handlers.SetBatchLimits(svrConfig.BatchWorkers, svrConfig.BatchMaxRows)
+
+	jobs := server.NewJobManager(handlers.Batch(), svrConfig.JobsPath, svrConfig.JobChunkRows, svrConfig.JobMaxUpload)
+	if err := jobs.Start(context.Background(), svrConfig.JobWorkers); err != nil {
+		log.Fatal().Err(err).Str("component", "nods").Msg("enrichment jobs")
+	}
+	handlers.SetJobManager(jobs)
+
+	ruleStore, err := server.NewRuleStore(schema, rulesFile, svrConfig.RulesAuditFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("component", "nods").Msg("rule store")
+	}
+	handlers.SetRuleStore(ruleStore)
+	handlers.SetShadowStore(shadows)
+
 	mux := chi.NewMux()
 	mux.Route(svrConfig.PathPrefix, func(r chi.Router) {
 		r.Use(middleware.RequestID)
@@ -54,9 +102,25 @@ func main() {
 		// query a item across a category
 		r.Get("/v1/data/category/{category}", handlers.GetCategoryHandler)
 		r.Post("/v1/data/category/{category}", handlers.PostCategoryHandler)
+		// query many inputs at once
+		r.Post("/v1/data/batch", handlers.PostBatchHandler)
+		// bulk file enrichment
+		r.Post("/v1/data/jobs", handlers.PostJobHandler)
+		r.Get("/v1/data/jobs/{id}", handlers.GetJobHandler)
+		r.Get("/v1/data/jobs/{id}/output", handlers.GetJobOutputHandler)
 		// test a custom rule
 		r.Get("/v1/data/rule", handlers.GetEvaluateHandler)
 		r.Post("/v1/data/rule", handlers.PostEvaluateHandler)
+		// every version of a risk score model
+		r.Get("/v1/data/score/{model}", handlers.GetScoreHandler)
+		r.Post("/v1/data/score/{model}", handlers.PostScoreHandler)
+		// manage the rules, admin only
+		r.Get("/v1/data/rules", handlers.ListRulesHandler)
+		r.Post("/v1/data/rules", handlers.CreateRuleHandler)
+		r.Get("/v1/data/rules/audit", handlers.RuleAuditHandler)
+		r.Get("/v1/data/rules/shadow", handlers.RuleShadowHandler)
+		r.Put("/v1/data/rules/{name}", handlers.UpdateRuleHandler)
+		r.Delete("/v1/data/rules/{name}", handlers.DeleteRuleHandler)
 		// whoami aggregate information
 		r.Get("/v1/data/whoami", handlers.GetWhoamiHandler)
 		r.Post("/v1/data/whoami", handlers.PostWhoamiHandler)
@@ -64,7 +128,7 @@ func main() {
 
 	watcher.Listen()
 
-	err := common.ListenAndServe(svrConfig.ListenAddr, "", "", mux)
+	err = common.ListenAndServe(svrConfig.ListenAddr, "", "", mux)
 	if err != nil {
 		log.Fatal().Err(err).Str("component", "nods").Str("state", "stopped").Msg("orchestration")
 	} else {
@@ -8,13 +8,17 @@ const (
 	CATEGORY_PHONE    = "phone"
 	CATEGORY_BROWSER  = "browser"
 	CATEGORY_RULE     = "rule"
+	CATEGORY_ASN      = "asn"
+	CATEGORY_URL      = "url"
 )
 
 var CATEGORIES = []string{
+	CATEGORY_ASN,
 	CATEGORY_BROWSER,
 	CATEGORY_DOMAIN,
 	CATEGORY_EMAIL,
 	CATEGORY_IPADDR,
 	CATEGORY_PASSWORD,
 	CATEGORY_PHONE,
-	CATEGORY_RULE}
+	CATEGORY_RULE,
+	CATEGORY_URL}
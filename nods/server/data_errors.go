@@ -11,3 +11,27 @@ var ErrStatusNotOk = errors.New("status != http.StatusOK")
 var ErrSourceNotFound = errors.New("vendor not found")
 var ErrBadItemNomenclature = errors.New("malformed item {category}.{vendor}.{name}")
 var ErrKeyMissing = errors.New("item key missing ip, email or phone")
+var ErrSourceTimeout = errors.New("source timed out")
+var ErrBudgetExceeded = errors.New("request budget exceeded")
+var ErrInvalidBudget = errors.New("budget must be milliseconds or a duration like 750ms")
+var ErrInvalidRule = errors.New("rule does not parse")
+var ErrInvalidRuleDate = errors.New("rule date must look like 1.years.ago")
+var ErrInvalidRuleReference = errors.New("rule references an invalid item")
+var ErrUnknownRule = errors.New("rule references an unknown rule")
+var ErrRuleCycle = errors.New("rule dependency cycle")
+var ErrRuleDependency = errors.New("rule depends on a broken rule")
+var ErrUnknownRuleItem = errors.New("rule references an unknown item")
+var ErrUnknownRuleSource = errors.New("rule references an unknown vendor")
+var ErrDuplicateRule = errors.New("rule is defined more than once")
+var ErrInvalidShadow = errors.New("rule shadow query is invalid")
+var ErrInvalidMissingPolicy = errors.New("rule missing policy must be false, unknown or fail")
+var ErrRuleInputMissing = errors.New("rule input missing")
+var ErrRuleUnknown = errors.New("rule result unknown")
+var ErrUnknownList = errors.New("list not found")
+var ErrRuleFunction = errors.New("rule function failed")
+var ErrInvalidScore = errors.New("score needs a model, a version and conditions with a query and a reason")
+var ErrInvalidASN = errors.New("asn must be a number like 13335 or AS13335")
+var ErrDNSLookup = errors.New("dns lookup failed")
+var ErrInvalidEmail = errors.New("invalid email address")
+var ErrSMTPConnect = errors.New("mail exchanger unreachable")
+var ErrSMTPRejected = errors.New("mail exchanger refused the session")
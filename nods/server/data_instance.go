@@ -59,11 +59,33 @@ func (x *DataInstance) CategoryInfo(ctx context.Context, categoryName string, in
 		return []byte("{}"), ErrMissingInputs
 	}
 
+	return x.memoCategoryInfo(ctx, categoryName, inputs)
+}
+
+func (x *DataInstance) memoCategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	// NOTE:  rule output depends on more than the rule name, rules are never memoized here
+	memo := MemoFrom(ctx)
+	if memo == nil || categoryName == CATEGORY_RULE {
+		return x.gatedCategoryInfo(ctx, categoryName, inputs)
+	}
+	return memo.Lookup(ctx, memoKey(x.name, categoryName, inputs[categoryName]), func() (json.RawMessage, error) {
+		return x.gatedCategoryInfo(ctx, categoryName, inputs)
+	})
+}
+
+// gatedCategoryInfo waits on the source's batch gate, if the request has one, so a lookup a rule
+// makes counts against the source limit like one asked for directly
+func (x *DataInstance) gatedCategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	ctx, release, err := acquireGate(ctx, x.name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	return x.provider.CategoryInfo(ctx, categoryName, inputs)
 }
 
 func (x *DataInstance) ItemValue(ctx context.Context, item Item, inputs common.DataInputs) (*common.DataOutput, error) {
-	data, err := x.provider.CategoryInfo(ctx, item.CategoryName, inputs)
+	data, err := x.memoCategoryInfo(ctx, item.CategoryName, inputs)
 	if err != nil {
 		log.Warn().Err(err).Str("component", "source").Str("source", x.name).Str("data", item.Path).Str("inputs", inputs.String()).Str("value", "default").Msg("category info")
 		return x.response.EmptyResponse(item.Type, item.Path, inputs, err), common.ErrNoDataPresent
@@ -72,11 +94,21 @@ func (x *DataInstance) ItemValue(ctx context.Context, item Item, inputs common.D
 	var out gjson.Result
 	if item.Type == common.JSON && item.Gjson == PASSTHROUGH {
 		// NOTE:  special case for rules
-		out = gjson.Result{Type: gjson.JSON, Raw: string(data)}
+		out = gjson.ParseBytes(data)
 	} else {
 		out = gjson.GetBytes(data, item.Gjson)
 	}
+	// a rule that couldn't decide, its defaults go along for rules that treat missing as false
+	if item.CategoryName == CATEGORY_RULE && out.Raw == "null" {
+		output := x.response.EmptyResponse(item.Type, item.Path, inputs, ErrRuleUnknown)
+		output.Result.Unknown = true
+		return output, common.ErrNoDataPresent
+	}
 	if out.Exists() {
+		// NOTE:  rules hand JSON over as a string and dates as unix times
+		if item.CategoryName == CATEGORY_RULE {
+			out = ruleOutputValue(item.Type, out)
+		}
 		return &common.DataOutput{
 			Item:   item.Path,
 			Keys:   inputs,
@@ -0,0 +1,598 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	JOB_QUEUED  = "queued"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+
+	JOB_FORMAT_CSV    = "csv"
+	JOB_FORMAT_NDJSON = "ndjson"
+
+	JOB_CHUNK_ROWS = 500
+	JOB_STATE_FILE = "job.json"
+	JOB_INPUT_FILE = "input"
+	JOB_LINE_LIMIT = 1 << 20
+	JOB_QUEUE_SIZE = 1024
+	JOB_MAX_UPLOAD = 1 << 30
+)
+
+// EnrichJob is saved after every chunk, RowsDone and OutputBytes are the restart point
+type EnrichJob struct {
+	ID          string
+	Format      string
+	Mapping     map[string]string // category -> column or field
+	Items       []string          `json:",omitempty"`
+	Categories  []string          `json:",omitempty"`
+	Status      string
+	RowsDone    int
+	OutputBytes int64
+	Error       string `json:",omitempty"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (x *EnrichJob) IsFinished() bool {
+	return x.Status == JOB_DONE || x.Status == JOB_FAILED
+}
+
+func (x *EnrichJob) OutputName() string {
+	return "output." + x.Format
+}
+
+// JobManager runs bulk enrichment jobs one chunk at a time through the batch runner, so
+// jobs share the router, its cache and the per source limits with interactive traffic
+type JobManager struct {
+	runner    *BatchRunner
+	jobsPath  string
+	chunkRows int
+	maxUpload int64
+	jobs      map[string]*EnrichJob
+	queue     chan string
+	mu        sync.Mutex
+}
+
+func NewJobManager(runner *BatchRunner, jobsPath string, chunkRows int, maxUpload int64) *JobManager {
+	if chunkRows <= 0 {
+		chunkRows = JOB_CHUNK_ROWS
+	}
+	if maxUpload <= 0 {
+		maxUpload = JOB_MAX_UPLOAD
+	}
+	if chunkRows > runner.maxRows {
+		chunkRows = runner.maxRows
+	}
+	return &JobManager{
+		runner:    runner,
+		jobsPath:  jobsPath,
+		chunkRows: chunkRows,
+		maxUpload: maxUpload,
+		jobs:      make(map[string]*EnrichJob),
+		queue:     make(chan string, JOB_QUEUE_SIZE),
+	}
+}
+
+// Start reloads saved jobs, queues the unfinished ones and works the queue until ctx ends
+func (x *JobManager) Start(ctx context.Context, workers int) error {
+	if err := os.MkdirAll(x.jobsPath, 0755); err != nil {
+		log.Error().Err(err).Str("component", "jobs").Str("path", x.jobsPath).Msg("create jobs path")
+		return err
+	}
+
+	dirs, err := os.ReadDir(x.jobsPath)
+	if err != nil {
+		return err
+	}
+	pending := []*EnrichJob{}
+	x.mu.Lock()
+	for _, dir := range dirs {
+		// NOTE:  jobs submitted before Start are already queued
+		if _, ok := x.jobs[dir.Name()]; ok {
+			continue
+		}
+		job, err := x.loadState(dir.Name())
+		if err != nil {
+			continue
+		}
+		x.jobs[job.ID] = job
+		if !job.IsFinished() {
+			pending = append(pending, job)
+		}
+	}
+	x.mu.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+	ids := []string{}
+	for _, job := range pending {
+		log.Info().Str("component", "jobs").Str("job", job.ID).Int("rows", job.RowsDone).Msg("resuming")
+		ids = append(ids, job.ID)
+	}
+
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-x.queue:
+					x.run(ctx, id)
+				}
+			}
+		}()
+	}
+	for _, id := range ids {
+		x.queue <- id
+	}
+	return nil
+}
+
+// Submit saves the upload and queues the job
+func (x *JobManager) Submit(spec *EnrichJob, input io.Reader) (*EnrichJob, error) {
+	if spec.Format != JOB_FORMAT_CSV && spec.Format != JOB_FORMAT_NDJSON {
+		return nil, ErrInvalidJob
+	}
+	if len(spec.Mapping) == 0 {
+		return nil, ErrInvalidJob
+	}
+	for categoryName := range spec.Mapping {
+		if categoryName == CATEGORY_RULE || !x.runner.schema.IsValidCategory(categoryName) {
+			return nil, ErrInvalidCategoryParam
+		}
+	}
+	if _, err := x.runner.expand(&BatchRequest{Items: spec.Items, Categories: spec.Categories}); err != nil {
+		return nil, err
+	}
+	if len(spec.Items) == 0 && len(spec.Categories) == 0 {
+		return nil, ErrInvalidJob
+	}
+
+	job := &EnrichJob{
+		ID:         uuid.NewString(),
+		Format:     spec.Format,
+		Mapping:    spec.Mapping,
+		Items:      spec.Items,
+		Categories: spec.Categories,
+		Status:     JOB_QUEUED,
+		CreatedAt:  time.Now().UTC(),
+	}
+	dir := filepath.Join(x.jobsPath, job.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Error().Err(err).Str("component", "jobs").Str("path", dir).Msg("create job")
+		return nil, err
+	}
+	fh, err := os.Create(filepath.Join(dir, JOB_INPUT_FILE))
+	if err != nil {
+		return nil, err
+	}
+	// NOTE:  one byte past the limit is enough to know the upload is too large
+	size, err := io.Copy(fh, io.LimitReader(input, x.maxUpload+1))
+	fh.Close()
+	if err == nil && size > x.maxUpload {
+		err = ErrJobTooLarge
+	}
+	if err != nil {
+		log.Error().Err(err).Str("component", "jobs").Str("job", job.ID).Msg("save upload")
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := x.saveState(job); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	x.mu.Lock()
+	x.jobs[job.ID] = job
+	x.mu.Unlock()
+	// NOTE:  Submit runs in the request, a full queue is turned away rather than waited on
+	select {
+	case x.queue <- job.ID:
+	default:
+		log.Warn().Err(ErrJobQueueFull).Str("component", "jobs").Str("job", job.ID).Msg("queue job")
+		x.mu.Lock()
+		delete(x.jobs, job.ID)
+		x.mu.Unlock()
+		os.RemoveAll(dir)
+		return nil, ErrJobQueueFull
+	}
+	return x.Status(job.ID)
+}
+
+// Status hands back a copy, the worker keeps changing the original
+func (x *JobManager) Status(id string) (*EnrichJob, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	job, ok := x.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	status := *job
+	return &status, nil
+}
+
+// Output copies finished chunks to w, following the job until it ends or ctx is cancelled
+func (x *JobManager) Output(ctx context.Context, id string, w io.Writer, poll time.Duration) error {
+	job, err := x.Status(id)
+	if err != nil {
+		return err
+	}
+	fh, err := os.Open(filepath.Join(x.jobsPath, id, job.OutputName()))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if fh != nil {
+		defer fh.Close()
+	}
+
+	var sent int64
+	for {
+		job, _ = x.Status(id)
+		// NOTE:  only committed bytes, anything past OutputBytes may be rewritten on resume
+		if job.OutputBytes > sent {
+			if fh == nil {
+				if fh, err = os.Open(filepath.Join(x.jobsPath, id, job.OutputName())); err != nil {
+					return err
+				}
+				defer fh.Close()
+			}
+			n, err := io.Copy(w, io.NewSectionReader(fh, sent, job.OutputBytes-sent))
+			sent += n
+			if err != nil {
+				return err
+			}
+			if flusher, ok := w.(interface{ Flush() }); ok {
+				flusher.Flush()
+			}
+		}
+		if job.IsFinished() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+func (x *JobManager) run(ctx context.Context, id string) {
+	x.mu.Lock()
+	job := x.jobs[id]
+	job.Status = JOB_RUNNING
+	x.mu.Unlock()
+
+	err := x.enrich(ctx, id)
+	if err == context.Canceled {
+		// shutting down, the saved state picks this back up on the next start
+		return
+	}
+
+	x.mu.Lock()
+	if err != nil {
+		log.Error().Err(err).Str("component", "jobs").Str("job", id).Msg("enrich")
+		job.Status = JOB_FAILED
+		job.Error = err.Error()
+	} else {
+		job.Status = JOB_DONE
+	}
+	x.mu.Unlock()
+	x.saveState(job)
+}
+
+func (x *JobManager) enrich(ctx context.Context, id string) error {
+	job, _ := x.Status(id)
+	uris, err := x.runner.expand(&BatchRequest{Items: job.Items, Categories: job.Categories})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(x.jobsPath, id)
+	in, err := os.Open(filepath.Join(dir, JOB_INPUT_FILE))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(filepath.Join(dir, job.OutputName()), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	// drop anything written after the last saved chunk
+	if err := out.Truncate(job.OutputBytes); err != nil {
+		return err
+	}
+	if _, err := out.Seek(job.OutputBytes, io.SeekStart); err != nil {
+		return err
+	}
+
+	var reader jobReader
+	var writer jobWriter
+	if job.Format == JOB_FORMAT_CSV {
+		csvReader, err := newCSVJobReader(in, job.Mapping)
+		if err != nil {
+			return err
+		}
+		reader = csvReader
+		writer = newCSVJobWriter(out, csvReader.header, uris, job.OutputBytes == 0)
+	} else {
+		reader = newNDJSONJobReader(in, job.Mapping)
+		writer = newNDJSONJobWriter(out, uris)
+	}
+
+	// skip what a previous run already wrote
+	for i := 0; i < job.RowsDone; i++ {
+		if _, _, err := reader.Next(); err != nil && !errors.Is(err, ErrInvalidRow) {
+			return err
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return context.Canceled
+		}
+		records := []interface{}{}
+		rowErrors := []error{}
+		batch := &BatchRequest{Items: job.Items, Categories: job.Categories}
+		for len(records) < x.chunkRows {
+			record, inputs, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			// NOTE:  a bad row is written out with its error, it doesn't fail the job
+			if err != nil && !errors.Is(err, ErrInvalidRow) {
+				return err
+			}
+			records = append(records, record)
+			rowErrors = append(rowErrors, err)
+			batch.Inputs = append(batch.Inputs, inputs)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		resp, err := x.runner.Run(ctx, batch)
+		if err != nil {
+			return err
+		}
+		// a chunk cut short by shutdown is redone on resume, not saved with errors
+		if ctx.Err() != nil {
+			return context.Canceled
+		}
+		for i, row := range resp.Rows {
+			if rowErrors[i] != nil {
+				row.Error = rowErrors[i].Error()
+			}
+			if err := writer.Write(records[i], row); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if err := out.Sync(); err != nil {
+			return err
+		}
+		offset, err := out.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		x.mu.Lock()
+		job = x.jobs[id]
+		job.RowsDone += len(records)
+		job.OutputBytes = offset
+		x.mu.Unlock()
+		if err := x.saveState(job); err != nil {
+			return err
+		}
+	}
+}
+
+func (x *JobManager) saveState(job *EnrichJob) error {
+	x.mu.Lock()
+	job.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(job, "", "    ")
+	x.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// NOTE:  write and rename so a crash never leaves half a state file
+	fileName := filepath.Join(x.jobsPath, job.ID, JOB_STATE_FILE)
+	if err := os.WriteFile(fileName+".tmp", data, 0644); err != nil {
+		log.Error().Err(err).Str("component", "jobs").Str("job", job.ID).Msg("save state")
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+func (x *JobManager) loadState(id string) (*EnrichJob, error) {
+	data, err := os.ReadFile(filepath.Join(x.jobsPath, id, JOB_STATE_FILE))
+	if err != nil {
+		return nil, err
+	}
+	job := &EnrichJob{}
+	if err := json.Unmarshal(data, job); err != nil {
+		log.Error().Err(err).Str("component", "jobs").Str("job", id).Msg("load state")
+		return nil, err
+	}
+	return job, nil
+}
+
+type jobReader interface {
+	Next() (interface{}, common.DataInputs, error)
+}
+
+type jobWriter interface {
+	Write(record interface{}, row BatchRow) error
+	Flush() error
+}
+
+type csvJobReader struct {
+	reader  *csv.Reader
+	header  []string
+	columns map[string]int
+}
+
+func newCSVJobReader(r io.Reader, mapping map[string]string) (*csvJobReader, error) {
+	x := &csvJobReader{reader: csv.NewReader(r), columns: make(map[string]int)}
+	x.reader.FieldsPerRecord = -1
+	header, err := x.reader.Read()
+	if err != nil {
+		return nil, ErrInvalidJob
+	}
+	x.header = header
+	for categoryName, column := range mapping {
+		found := false
+		for i, name := range header {
+			if name == column {
+				x.columns[categoryName] = i
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: column %s", ErrInvalidJob, column)
+		}
+	}
+	return x, nil
+}
+
+func (x *csvJobReader) Next() (interface{}, common.DataInputs, error) {
+	record, err := x.reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	inputs := common.DataInputs{}
+	for categoryName, i := range x.columns {
+		if i < len(record) && record[i] != "" {
+			inputs[categoryName] = record[i]
+		}
+	}
+	return record, inputs, nil
+}
+
+type csvJobWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVJobWriter(w io.Writer, header []string, uris []*DataURI, writeHeader bool) *csvJobWriter {
+	x := &csvJobWriter{writer: csv.NewWriter(w)}
+	if writeHeader {
+		columns := append([]string{}, header...)
+		for _, uri := range uris {
+			columns = append(columns, uri.Key())
+		}
+		x.writer.Write(append(columns, "error"))
+	}
+	return x
+}
+
+func (x *csvJobWriter) Write(record interface{}, row BatchRow) error {
+	columns := append([]string{}, record.([]string)...)
+	for _, output := range row.Outputs {
+		columns = append(columns, resultString(output.Result))
+	}
+	return x.writer.Write(append(columns, row.Error))
+}
+
+func (x *csvJobWriter) Flush() error {
+	x.writer.Flush()
+	return x.writer.Error()
+}
+
+func resultString(result common.DataResult) string {
+	switch {
+	case result.Bool != nil:
+		return strconv.FormatBool(*result.Bool)
+	case result.Num != nil:
+		return strconv.FormatFloat(*result.Num, 'f', -1, 64)
+	case result.Str != nil:
+		return *result.Str
+	}
+	return ""
+}
+
+type ndjsonJobReader struct {
+	scanner *bufio.Scanner
+	mapping map[string]string
+}
+
+func newNDJSONJobReader(r io.Reader, mapping map[string]string) *ndjsonJobReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), JOB_LINE_LIMIT)
+	return &ndjsonJobReader{scanner: scanner, mapping: mapping}
+}
+
+func (x *ndjsonJobReader) Next() (interface{}, common.DataInputs, error) {
+	if !x.scanner.Scan() {
+		if err := x.scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, io.EOF
+	}
+	record := make(map[string]interface{})
+	// NOTE:  numbers stay as written, a float64 turns a long phone number into 1.4155552671e+10
+	decoder := json.NewDecoder(bytes.NewReader(x.scanner.Bytes()))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return make(map[string]interface{}), common.DataInputs{}, fmt.Errorf("%w: %s", ErrInvalidRow, err.Error())
+	}
+	inputs := common.DataInputs{}
+	for categoryName, field := range x.mapping {
+		if value, ok := record[field]; ok && value != nil {
+			inputs[categoryName] = fmt.Sprint(value)
+		}
+	}
+	return record, inputs, nil
+}
+
+type ndjsonJobWriter struct {
+	writer *bufio.Writer
+	uris   []*DataURI
+}
+
+func newNDJSONJobWriter(w io.Writer, uris []*DataURI) *ndjsonJobWriter {
+	return &ndjsonJobWriter{writer: bufio.NewWriter(w), uris: uris}
+}
+
+func (x *ndjsonJobWriter) Write(record interface{}, row BatchRow) error {
+	enriched := record.(map[string]interface{})
+	results := make(map[string]common.DataResult)
+	for i, output := range row.Outputs {
+		results[x.uris[i].Key()] = output.Result
+	}
+	enriched["nods"] = map[string]interface{}{"Results": results, "Error": row.Error}
+	data, err := json.Marshal(enriched)
+	if err != nil {
+		return err
+	}
+	x.writer.Write(data)
+	return x.writer.WriteByte('\n')
+}
+
+func (x *ndjsonJobWriter) Flush() error {
+	return x.writer.Flush()
+}
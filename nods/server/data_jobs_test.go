@@ -0,0 +1,305 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+const TEST_JOB_CSV = "name,client_ip\nfirst,1.2.3.4\nsecond,5.6.7.8\nthird,\n\"fourth, quoted\",1.2.3.4\n"
+
+func newTestJobs(t *testing.T) (*JobManager, string) {
+	dir := t.TempDir()
+	runner := NewBatchRunner(NewMockDataRouter(false), NewMockDataSchema(), 4, 0)
+	// two rows a chunk so every test crosses a chunk boundary
+	return NewJobManager(runner, dir, 2, 0), dir
+}
+
+func waitForJob(t *testing.T, jobs *JobManager, id string) *EnrichJob {
+	for i := 0; i < 200; i++ {
+		job, err := jobs.Status(id)
+		assert.Nil(t, err)
+		if job.IsFinished() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("job did not finish")
+	return nil
+}
+
+func TestJobCSV(t *testing.T) {
+	jobs, dir := newTestJobs(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	assert.Nil(t, jobs.Start(ctx, 1))
+
+	job, err := jobs.Submit(&EnrichJob{
+		Format:  JOB_FORMAT_CSV,
+		Mapping: map[string]string{"ip": "client_ip"},
+		Items:   []string{"ip/ipsum/blacklist.isBlacklisted", "ip/nope/nope"},
+	}, strings.NewReader(TEST_JOB_CSV))
+	assert.Nil(t, err)
+	assert.Equal(t, JOB_QUEUED, job.Status)
+
+	job = waitForJob(t, jobs, job.ID)
+	assert.Equal(t, JOB_DONE, job.Status)
+	assert.Equal(t, 4, job.RowsDone)
+
+	output := &bytes.Buffer{}
+	assert.Nil(t, jobs.Output(ctx, job.ID, output, time.Millisecond))
+	// a row without an ip is turned away
+	expected := "name,client_ip,ip/ipsum/blacklist.isBlacklisted,ip/nope/nope,error\n" +
+		"first,1.2.3.4,true,,vendor not found\n" +
+		"second,5.6.7.8,,,vendor not found\n" +
+		"third,,,,missing input\n" +
+		"\"fourth, quoted\",1.2.3.4,true,,vendor not found\n"
+	assert.Equal(t, expected, output.String())
+
+	// state survives on disk
+	saved, err := jobs.loadState(job.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, JOB_DONE, saved.Status)
+	assert.Equal(t, int64(len(expected)), saved.OutputBytes)
+	_, err = os.Stat(filepath.Join(dir, job.ID, "output.csv"))
+	assert.Nil(t, err)
+}
+
+func TestJobNDJSON(t *testing.T) {
+	jobs, _ := newTestJobs(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	assert.Nil(t, jobs.Start(ctx, 1))
+
+	input := `{"id":1,"addr":"1.2.3.4"}` + "\n" + `{"id":14155552671,"addr":null}` + "\n"
+	job, err := jobs.Submit(&EnrichJob{
+		Format:     JOB_FORMAT_NDJSON,
+		Mapping:    map[string]string{"ip": "addr"},
+		Categories: []string{"ip"},
+	}, strings.NewReader(input))
+	assert.Nil(t, err)
+	job = waitForJob(t, jobs, job.ID)
+	assert.Equal(t, JOB_DONE, job.Status)
+
+	output := &bytes.Buffer{}
+	assert.Nil(t, jobs.Output(ctx, job.ID, output, time.Millisecond))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	// NOTE:  the mock router only knows the ipsum item
+	assert.JSONEq(t, `{"id":1,"addr":"1.2.3.4","nods":{"Error":"item not found","Results":{
+		"ip/ipsum/blacklist.isBlacklisted":{"Type":1,"Bool":true},
+		"ip/uhb/blacklist.isBlacklisted":{"Type":0}}}}`, lines[0])
+	assert.JSONEq(t, `{"id":14155552671,"addr":null,"nods":{"Error":"missing input","Results":{
+		"ip/ipsum/blacklist.isBlacklisted":{"Type":0},
+		"ip/uhb/blacklist.isBlacklisted":{"Type":0}}}}`, lines[1])
+}
+
+func TestJobResume(t *testing.T) {
+	jobs, dir := newTestJobs(t)
+
+	// a previous run finished one chunk and crashed part way into the next
+	header := "name,client_ip,ip/ipsum/blacklist.isBlacklisted,error\n"
+	chunk := header + "first,1.2.3.4,true,\nsecond,5.6.7.8,,\n"
+	job := &EnrichJob{
+		ID:          "resume-me",
+		Format:      JOB_FORMAT_CSV,
+		Mapping:     map[string]string{"ip": "client_ip"},
+		Items:       []string{"ip/ipsum/blacklist.isBlacklisted"},
+		Status:      JOB_RUNNING,
+		RowsDone:    2,
+		OutputBytes: int64(len(chunk)),
+	}
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, job.ID), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, job.ID, JOB_INPUT_FILE), []byte(TEST_JOB_CSV), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, job.ID, "output.csv"), []byte(chunk+"third,,GARBAGE"), 0644))
+	assert.Nil(t, jobs.saveState(job))
+	// finished jobs are loaded, not rerun
+	done := &EnrichJob{ID: "done", Format: JOB_FORMAT_CSV, Status: JOB_DONE}
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, done.ID), 0755))
+	assert.Nil(t, jobs.saveState(done))
+	// junk in the jobs directory is ignored
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "junk"), 0755))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	assert.Nil(t, jobs.Start(ctx, 1))
+
+	job = waitForJob(t, jobs, job.ID)
+	assert.Equal(t, JOB_DONE, job.Status)
+	assert.Equal(t, 4, job.RowsDone)
+	status, err := jobs.Status("done")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status.RowsDone)
+
+	output := &bytes.Buffer{}
+	assert.Nil(t, jobs.Output(ctx, job.ID, output, time.Millisecond))
+	assert.Equal(t, chunk+"third,,,missing input\n\"fourth, quoted\",1.2.3.4,true,\n", output.String())
+}
+
+func TestJobFailures(t *testing.T) {
+	jobs, _ := newTestJobs(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	assert.Nil(t, jobs.Start(ctx, 1))
+
+	for _, test := range []struct {
+		spec *EnrichJob
+		err  error
+	}{
+		{&EnrichJob{Format: "xml", Mapping: map[string]string{"ip": "ip"}, Items: []string{"ip/ipsum/blacklist.isBlacklisted"}}, ErrInvalidJob},
+		{&EnrichJob{Format: JOB_FORMAT_CSV, Items: []string{"ip/ipsum/blacklist.isBlacklisted"}}, ErrInvalidJob},
+		{&EnrichJob{Format: JOB_FORMAT_CSV, Mapping: map[string]string{"nope": "ip"}, Items: []string{"ip/ipsum/blacklist.isBlacklisted"}}, ErrInvalidCategoryParam},
+		{&EnrichJob{Format: JOB_FORMAT_CSV, Mapping: map[string]string{"ip": "ip"}}, ErrInvalidJob},
+		{&EnrichJob{Format: JOB_FORMAT_CSV, Mapping: map[string]string{"ip": "ip"}, Items: []string{"nope"}}, ErrInvalidItemParam},
+	} {
+		_, err := jobs.Submit(test.spec, strings.NewReader(TEST_JOB_CSV))
+		assert.Equal(t, test.err, err)
+	}
+
+	// a missing column fails the job once it runs
+	job, err := jobs.Submit(&EnrichJob{
+		Format:  JOB_FORMAT_CSV,
+		Mapping: map[string]string{"ip": "nope"},
+		Items:   []string{"ip/ipsum/blacklist.isBlacklisted"},
+	}, strings.NewReader(TEST_JOB_CSV))
+	assert.Nil(t, err)
+	job = waitForJob(t, jobs, job.ID)
+	assert.Equal(t, JOB_FAILED, job.Status)
+	assert.Equal(t, "job needs csv or ndjson, a category mapping and items or categories: column nope", job.Error)
+
+	// a bad line is only that row's error
+	job, _ = jobs.Submit(&EnrichJob{
+		Format:  JOB_FORMAT_NDJSON,
+		Mapping: map[string]string{"ip": "ip"},
+		Items:   []string{"ip/ipsum/blacklist.isBlacklisted"},
+	}, strings.NewReader("{\"ip\":\"1.2.3.4\"}\nnope\n{\"ip\":\"1.2.3.4\"}\n"))
+	job = waitForJob(t, jobs, job.ID)
+	assert.Equal(t, JOB_DONE, job.Status)
+	assert.Equal(t, 3, job.RowsDone)
+	output := &bytes.Buffer{}
+	assert.Nil(t, jobs.Output(ctx, job.ID, output, time.Millisecond))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, "invalid row: invalid character 'o' in literal null (expecting 'u')", gjson.Get(lines[1], "nods.Error").Str)
+	assert.True(t, gjson.Get(lines[2], "nods.Results.ip/ipsum/blacklist\\.isBlacklisted.Bool").Bool())
+
+	_, err = jobs.Status("nope")
+	assert.Equal(t, ErrJobNotFound, err)
+	assert.Equal(t, ErrJobNotFound, jobs.Output(ctx, "nope", &bytes.Buffer{}, time.Millisecond))
+}
+
+func TestHandlerJobs(t *testing.T) {
+	jobs, _ := newTestJobs(t)
+	server := nodsServer(false)
+	server.SetJobManager(jobs)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/data/jobs?format=csv&map=ip:client_ip&items=ip/ipsum/blacklist.isBlacklisted", strings.NewReader(TEST_JOB_CSV))
+	w := httptest.NewRecorder()
+	server.PostJobHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	job := &EnrichJob{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), job))
+	assert.Equal(t, JOB_QUEUED, job.Status)
+	assert.Equal(t, map[string]string{"ip": "client_ip"}, job.Mapping)
+
+	// the download follows the job until it finishes
+	done := make(chan string)
+	go func() {
+		r := common.BuildRequest(http.MethodGet, "/v1/data/jobs/"+job.ID+"/output", map[string]string{"id": job.ID}, nil)
+		w := httptest.NewRecorder()
+		server.GetJobOutputHandler(w, r)
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		done <- w.Body.String()
+	}()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	assert.Nil(t, jobs.Start(ctx, 1))
+	assert.Equal(t, 5, strings.Count(<-done, "\n"))
+
+	r = common.BuildRequest(http.MethodGet, "/v1/data/jobs/"+job.ID, map[string]string{"id": job.ID}, nil)
+	w = httptest.NewRecorder()
+	server.GetJobHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), job))
+	assert.Equal(t, JOB_DONE, job.Status)
+
+	r = common.BuildRequest(http.MethodGet, "/v1/data/jobs/nope", map[string]string{"id": "nope"}, nil)
+	w = httptest.NewRecorder()
+	server.GetJobHandler(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	server.GetJobOutputHandler(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	r = httptest.NewRequest(http.MethodPost, "/v1/data/jobs?format=csv&map=ip", strings.NewReader(TEST_JOB_CSV))
+	w = httptest.NewRecorder()
+	server.PostJobHandler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, common.BuildErrorResponse(ErrInvalidJob), w.Body.String())
+}
+
+func TestJobQueueFull(t *testing.T) {
+	jobs, dir := newTestJobs(t)
+	server := nodsServer(false)
+	server.SetJobManager(jobs)
+	// nobody is working the queue and there's no room in it
+	jobs.queue = make(chan string)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/data/jobs?format=csv&map=ip:client_ip&items=ip/ipsum/blacklist.isBlacklisted", strings.NewReader(TEST_JOB_CSV))
+	w := httptest.NewRecorder()
+	server.PostJobHandler(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, common.BuildErrorResponse(ErrJobQueueFull), w.Body.String())
+
+	// the upload isn't kept for a job that never ran
+	dirs, _ := os.ReadDir(dir)
+	assert.Empty(t, dirs)
+	assert.Empty(t, jobs.jobs)
+}
+
+func TestJobNDJSONNumbers(t *testing.T) {
+	// a number is read as written, not as a float
+	reader := newNDJSONJobReader(strings.NewReader(`{"phone":14155552671,"score":0.5}`+"\n"), map[string]string{"phone": "phone", "ip": "score"})
+	_, inputs, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, common.DataInputs{"phone": "14155552671", "ip": "0.5"}, inputs)
+	_, _, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestJobTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	runner := NewBatchRunner(NewMockDataRouter(false), NewMockDataSchema(), 4, 0)
+	jobs := NewJobManager(runner, dir, 2, int64(len(TEST_JOB_CSV)-1))
+	server := nodsServer(false)
+	server.SetJobManager(jobs)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/data/jobs?format=csv&map=ip:client_ip&items=ip/ipsum/blacklist.isBlacklisted", strings.NewReader(TEST_JOB_CSV))
+	w := httptest.NewRecorder()
+	server.PostJobHandler(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, common.BuildErrorResponse(ErrJobTooLarge), w.Body.String())
+	dirs, _ := os.ReadDir(dir)
+	assert.Empty(t, dirs)
+
+	// right at the limit is fine
+	jobs.maxUpload = int64(len(TEST_JOB_CSV))
+	r = httptest.NewRequest(http.MethodPost, "/v1/data/jobs?format=csv&map=ip:client_ip&items=ip/ipsum/blacklist.isBlacklisted", strings.NewReader(TEST_JOB_CSV))
+	w = httptest.NewRecorder()
+	server.PostJobHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,93 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
+type memoContextKey struct{}
+
+// RequestMemo remembers source lookups for the life of one request, so every item of a
+// source, and every rule that touches it, shares a single CategoryInfo call per input
+type RequestMemo struct {
+	mu      sync.Mutex
+	entries map[string]*memoEntry
+	lookups int64
+	hits    int64
+}
+
+type memoEntry struct {
+	done chan bool
+	data json.RawMessage
+	err  error
+}
+
+func NewRequestMemo() *RequestMemo {
+	return &RequestMemo{entries: make(map[string]*memoEntry)}
+}
+
+// WithMemo adds a memo to the context unless one is already there
+func WithMemo(ctx context.Context) context.Context {
+	if MemoFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, memoContextKey{}, NewRequestMemo())
+}
+
+// withoutMemo hides the memo from a source wrapped by one that already memoizes its answers,
+// both would take the same key and the inner lookup would wait on the outer one forever
+func withoutMemo(ctx context.Context) context.Context {
+	if MemoFrom(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, memoContextKey{}, (*RequestMemo)(nil))
+}
+
+func MemoFrom(ctx context.Context) *RequestMemo {
+	memo, _ := ctx.Value(memoContextKey{}).(*RequestMemo)
+	return memo
+}
+
+func memoKey(sourceName, categoryName, input string) string {
+	return sourceName + "/" + categoryName + "/" + input
+}
+
+// Lookup calls fn once per key, concurrent callers wait for the first one to finish or for
+// their own context to give up
+func (x *RequestMemo) Lookup(ctx context.Context, key string, fn func() (json.RawMessage, error)) (json.RawMessage, error) {
+	x.mu.Lock()
+	entry, found := x.entries[key]
+	if !found {
+		entry = &memoEntry{done: make(chan bool)}
+		x.entries[key] = entry
+	}
+	x.mu.Unlock()
+
+	if found {
+		atomic.AddInt64(&x.hits, 1)
+		select {
+		case <-entry.done:
+			return entry.data, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	atomic.AddInt64(&x.lookups, 1)
+	entry.data, entry.err = fn()
+	close(entry.done)
+	return entry.data, entry.err
+}
+
+// Lookups is the number of calls that reached a source
+func (x *RequestMemo) Lookups() int64 {
+	return atomic.LoadInt64(&x.lookups)
+}
+
+// Hits is the number of calls answered from the memo
+func (x *RequestMemo) Hits() int64 {
+	return atomic.LoadInt64(&x.hits)
+}
@@ -0,0 +1,262 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestMemo(t *testing.T) {
+	ctx := context.TODO()
+	assert.Nil(t, MemoFrom(ctx))
+	ctx = WithMemo(ctx)
+	memo := MemoFrom(ctx)
+	assert.NotNil(t, memo)
+	// nested calls keep the outer memo
+	assert.Equal(t, memo, MemoFrom(WithMemo(ctx)))
+
+	calls := int32(0)
+	lookup := func() (json.RawMessage, error) {
+		atomic.AddInt32(&calls, 1)
+		return json.RawMessage(`{"a":1}`), nil
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := memo.Lookup(ctx, "ip/ipsum/1.2.3.4", lookup)
+			assert.Nil(t, err)
+			assert.Equal(t, `{"a":1}`, string(data))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+	assert.Equal(t, int64(1), memo.Lookups())
+	assert.Equal(t, int64(19), memo.Hits())
+
+	// failures are remembered too, a vendor that just failed isn't asked again
+	nope := errors.New("nope")
+	_, err := memo.Lookup(ctx, "ip/ipinfo/1.2.3.4", func() (json.RawMessage, error) { return nil, nope })
+	assert.Equal(t, nope, err)
+	_, err = memo.Lookup(ctx, "ip/ipinfo/1.2.3.4", lookup)
+	assert.Equal(t, nope, err)
+	assert.Equal(t, int32(1), calls)
+
+	// a waiter gives up with its own request, not with the first caller
+	hung := make(chan bool)
+	defer close(hung)
+	go memo.Lookup(ctx, "ip/slow/1.2.3.4", func() (json.RawMessage, error) {
+		<-hung
+		return nil, nil
+	})
+	assert.Eventually(t, func() bool { return memo.Lookups() == 3 }, time.Second, time.Millisecond)
+	waiter, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = memo.Lookup(waiter, "ip/slow/1.2.3.4", lookup)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+// countingProvider answers every field with true and counts the lookups that reach it
+type countingProvider struct {
+	calls int64
+}
+
+func (x *countingProvider) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	atomic.AddInt64(&x.calls, 1)
+	data := `{"location":{"city":"Council Bluffs","country":"US"}`
+	for i := 0; i < MEMO_TEST_FIELDS; i++ {
+		data += fmt.Sprintf(`,"field%d":true`, i)
+	}
+	return json.RawMessage(data + "}"), nil
+}
+
+func (x *countingProvider) IsCached() bool {
+	return false
+}
+
+const MEMO_TEST_FIELDS = 8
+
+// memoSchema has several items per source and whoami rules that overlap like the real ones
+type memoSchema struct {
+	MockDataSchema
+	items []Item
+}
+
+func newMemoSchema() *memoSchema {
+	x := &memoSchema{}
+	for _, source := range []string{"ipsum", "uhb", "maxmind"} {
+		for i := 0; i < MEMO_TEST_FIELDS; i++ {
+			x.items = append(x.items, Item{
+				Path:         fmt.Sprintf("ip/%s/field%d", source, i),
+				CategoryName: CATEGORY_IPADDR,
+				SourceName:   source,
+				Gjson:        fmt.Sprintf("field%d", i),
+				Type:         common.Boolean,
+			})
+		}
+	}
+	x.items = append(x.items, Item{Path: "ip/maxmind/location", CategoryName: CATEGORY_IPADDR, SourceName: "maxmind", Gjson: "location", Type: common.JSON})
+	rules := map[string]string{
+		"isTor":         "[ip/ipsum/field0] || [ip/uhb/field1]",
+		"isCloudNode":   "[ip/ipsum/field2] || [ip/uhb/field2] || [ip/maxmind/field2]",
+		"isProxy":       "[ip/ipsum/field3] || [ip/uhb/field3] || [rule/osintami/isTor]",
+		"isVPN":         "[ip/ipsum/field4] || [ip/maxmind/field4]",
+		"isBot":         "[rule/osintami/isCloudNode] && [rule/osintami/isProxy]",
+		"isBlacklisted": "[ip/ipsum/field5] || [ip/uhb/field5] || [ip/maxmind/field5]",
+	}
+	for name, query := range rules {
+		x.items = append(x.items, Item{Path: "rule/osintami/" + name, CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: name, Type: common.Boolean, Query: query})
+	}
+	return x
+}
+
+func (x *memoSchema) Item(dataURI *DataURI) (Item, error) {
+	for _, item := range x.items {
+		if item.Path == dataURI.Key() {
+			return item, nil
+		}
+	}
+	return Item{}, ErrItemNotFound
+}
+
+func (x *memoSchema) ListItems() []Item {
+	return x.items
+}
+
+func (x *memoSchema) ListItemsByCategory(categoryName string) []Item {
+	out := []Item{}
+	for _, item := range x.items {
+		if item.CategoryName == categoryName {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func (x *memoSchema) ListRulesItems() map[string]Item {
+	rules := make(map[string]Item)
+	for _, item := range x.items {
+		if item.Query != "" || item.Score != nil {
+			rules[item.Path] = item
+		}
+	}
+	return rules
+}
+
+func memoRouter() (*DataRouter, *countingProvider) {
+	return memoSchemaRouter(newMemoSchema())
+}
+
+func memoSchemaRouter(schema *memoSchema) (*DataRouter, *countingProvider) {
+	tools := mockToolbox()
+	tools.Schema = schema
+	router := NewDataRouter(tools)
+	provider := &countingProvider{}
+	for _, source := range []string{"ipsum", "uhb", "maxmind"} {
+		router.instances[source] = NewDataInstance(tools, source, provider)
+	}
+	router.instances[SOURCE_OSINTAMI_NAME] = NewRuleSource(tools, router, schema)
+	return router, provider
+}
+
+func TestMemoCategoryValues(t *testing.T) {
+	router, provider := memoRouter()
+
+	outputs, err := router.CategoryValues(context.TODO(), CATEGORY_IPADDR, common.DataInputs{"ip": "1.2.3.4"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3*MEMO_TEST_FIELDS+1, len(outputs))
+	assert.True(t, *outputs[0].Result.Bool)
+	// one lookup per source, not per item
+	assert.Equal(t, int64(3), provider.calls)
+
+	// a new request looks up again
+	router.CategoryValues(context.TODO(), CATEGORY_IPADDR, common.DataInputs{"ip": "1.2.3.4"})
+	assert.Equal(t, int64(6), provider.calls)
+}
+
+func TestMemoRules(t *testing.T) {
+	router, provider := memoRouter()
+
+	// isBot pulls in isCloudNode, isProxy and isTor, seven source items over three sources
+	uri := NewItemSplitter("rule/osintami/isBot")
+	output, err := router.DataValue(context.TODO(), uri, common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: uri.Key()})
+	assert.Nil(t, err)
+	assert.True(t, *output.Result.Bool)
+	assert.Equal(t, int64(3), provider.calls)
+
+	// without a memo every item is a lookup
+	provider.calls = 0
+	router.instances["ipsum"].ItemValue(context.TODO(), newMemoSchema().items[0], common.DataInputs{"ip": "1.2.3.4"})
+	router.instances["ipsum"].ItemValue(context.TODO(), newMemoSchema().items[0], common.DataInputs{"ip": "1.2.3.4"})
+	assert.Equal(t, int64(2), provider.calls)
+}
+
+func TestMemoWhoami(t *testing.T) {
+	router, provider := memoRouter()
+	server := NewNormalizedDataServer(router.schema, router, NewMockSecretsManager(), nil)
+
+	out := server.WhoamiInfo(context.TODO(), map[string]string{"ip": "1.2.3.4"})
+	assert.True(t, out.Tor)
+	assert.True(t, out.Bot)
+	assert.True(t, out.Blacklist)
+	assert.Equal(t, "Council Bluffs", out.City)
+	assert.Equal(t, int64(3), provider.calls)
+}
+
+func TestMemoBatchRows(t *testing.T) {
+	router, provider := memoRouter()
+	runner := NewBatchRunner(router, router.schema, 4, 0)
+
+	resp, err := runner.Run(context.TODO(), &BatchRequest{
+		Inputs:     []common.DataInputs{{"ip": "1.2.3.4"}, {"ip": "5.6.7.8"}},
+		Categories: []string{CATEGORY_IPADDR},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", resp.Rows[1].Error)
+	// each row looks every source up once
+	assert.Equal(t, int64(6), provider.calls)
+}
+
+// go test -run XXX -bench BenchmarkMemo
+func BenchmarkMemo(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		run  func(ctx context.Context, router *DataRouter, server *NormalizedDataServer)
+	}{
+		{"category", func(ctx context.Context, router *DataRouter, server *NormalizedDataServer) {
+			router.CategoryValues(ctx, CATEGORY_IPADDR, common.DataInputs{"ip": "1.2.3.4"})
+		}},
+		{"rule", func(ctx context.Context, router *DataRouter, server *NormalizedDataServer) {
+			uri := NewItemSplitter("rule/osintami/isBot")
+			router.DataValue(ctx, uri, common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: uri.Key()})
+		}},
+		{"whoami", func(ctx context.Context, router *DataRouter, server *NormalizedDataServer) {
+			server.WhoamiInfo(ctx, map[string]string{"ip": "1.2.3.4"})
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			router, provider := memoRouter()
+			server := NewNormalizedDataServer(router.schema, router, NewMockSecretsManager(), nil)
+			hits := int64(0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ctx := WithMemo(context.TODO())
+				bench.run(ctx, router, server)
+				hits += MemoFrom(ctx).Hits()
+			}
+			// without the memo every hit would have been a lookup
+			b.ReportMetric(float64(provider.calls+hits)/float64(b.N), "unmemoized-lookups/op")
+			b.ReportMetric(float64(provider.calls)/float64(b.N), "lookups/op")
+		})
+	}
+}
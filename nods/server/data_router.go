@@ -4,6 +4,7 @@ package server
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -59,10 +60,22 @@ func (x *DataRouter) Init() {
 			}
 			provider := NewMMDBProvider(x.tools.Watcher, reader)
 			x.instances[vendor.Name] = NewDataInstance(x.tools, vendor.Name, provider)
+		case "asn":
+			log.Info().Str("component", "router").Str("source", vendor.Name).Msg("instantiating ASN provider")
+			reader, err := common.NewMaxmindReader(x.tools.DataPath + vendor.Name + ".mmdb")
+			if err != nil {
+				log.Error().Err(err).Str("component", "router").Str("source", vendor.Name).Msg("MMDB file missing")
+			}
+			provider, _ := NewASNProvider(x.tools, reader, vendor.Name)
+			x.instances[vendor.Name] = NewDataInstance(x.tools, vendor.Name, provider)
 		case "fast":
 			log.Info().Str("component", "router").Str("source", vendor.Name).Msg("instantiating FastDB provider")
 			provider, _ := NewFastDBProvider(x.tools, vendor.Name)
 			x.instances[vendor.Name] = NewDataInstance(x.tools, vendor.Name, provider)
+		case "sst":
+			log.Info().Str("component", "router").Str("source", vendor.Name).Msg("instantiating SSTDB provider")
+			provider, _ := NewSSTDBProvider(x.tools, vendor.Name)
+			x.instances[vendor.Name] = NewDataInstance(x.tools, vendor.Name, provider)
 		case "code":
 			x.instances[vendor.Name] = x.newInstance(vendor.Name)
 		}
@@ -80,6 +93,9 @@ func (x *DataRouter) DataValue(ctx context.Context, dataURI *DataURI, inputs com
 		return x.response.EmptyResponse(common.Null, dataURI.ItemName, inputs, ErrCategoryNotFound), ErrCategoryNotFound
 	}
 
+	// a url stands in for the domain and ip of its host
+	inputs = RouteURLInputs(inputs)
+
 	// make sure we have the correct input parameters for this category
 	if dataURI.CategoryName != CATEGORY_RULE && inputs[dataURI.CategoryName] == "" {
 		return x.response.EmptyResponse(common.Null, dataURI.Key(), inputs, ErrMissingInputs), common.ErrNoDataPresent
@@ -102,39 +118,171 @@ func (x *DataRouter) DataValue(ctx context.Context, dataURI *DataURI, inputs com
 	return source.ItemValue(ctx, item, inputs)
 }
 
+type sourceResult struct {
+	source  string
+	outputs []*common.DataOutput
+}
+
+// CategoryValues resolves every source of a category at the same time, a source that misses
+// its deadline, or the request budget, leaves timeout errors on its items instead of stalling
 func (x *DataRouter) CategoryValues(ctx context.Context, categoryName string, inputs common.DataInputs) ([]*common.DataOutput, error) {
 
 	itemResults := []*common.DataOutput{}
+	ctx = WithMemo(ctx)
+	inputs = RouteURLInputs(inputs)
 
-	items := x.schema.ListItemsByCategory(categoryName)
-	for _, item := range items {
-		if source := x.findSourceInstance(item.SourceName); source != nil {
-			// strip out internal use only keys from response
-			delete(inputs, common.INPUT_ROLE)
-			delete(inputs, common.INPUT_KEY)
-			delete(inputs, common.INPUT_TYPE)
-			delete(inputs, common.INPUT_RULE)
-			delete(inputs, common.INPUT_CSV)
-			resp, _ := source.ItemValue(ctx, item, inputs)
-			itemResults = append(itemResults, resp)
-		}
+	budget, err := RequestBudget(inputs)
+	if err != nil {
+		return itemResults, err
+	}
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
 	}
 
-	if len(itemResults) == 0 {
+	// strip out internal use only keys from response
+	delete(inputs, common.INPUT_ROLE)
+	delete(inputs, common.INPUT_KEY)
+	delete(inputs, common.INPUT_TYPE)
+	delete(inputs, common.INPUT_RULE)
+	delete(inputs, common.INPUT_CSV)
+	delete(inputs, common.INPUT_BUDGET)
+
+	// group the items by source, keeping the schema order for the response
+	order := []string{}
+	bySource := make(map[string][]Item)
+	for _, item := range x.schema.ListItemsByCategory(categoryName) {
+		if x.findSourceInstance(item.SourceName) == nil {
+			continue
+		}
+		if _, ok := bySource[item.SourceName]; !ok {
+			order = append(order, item.SourceName)
+		}
+		bySource[item.SourceName] = append(bySource[item.SourceName], item)
+	}
+	if len(order) == 0 {
 		return itemResults, ErrItemNotFound
 	}
 
+	// NOTE:  buffered so a source that finishes after we give up doesn't leak its goroutine
+	results := make(chan sourceResult, len(order))
+	for _, sourceName := range order {
+		go x.sourceValues(ctx, sourceName, bySource[sourceName], inputs, results)
+	}
+
+	outputs := make(map[string][]*common.DataOutput)
+	timers := make(map[string]*time.Timer)
+	expired := make(chan string, len(order))
+	for _, sourceName := range order {
+		name := sourceName
+		timers[name] = time.AfterFunc(x.sourceTimeout(name), func() { expired <- name })
+	}
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+
+	for pending := len(order); pending > 0; {
+		select {
+		case result := <-results:
+			if _, done := outputs[result.source]; !done {
+				outputs[result.source] = result.outputs
+				pending--
+			}
+		case name := <-expired:
+			if _, done := outputs[name]; !done {
+				log.Warn().Err(ErrSourceTimeout).Str("component", "router").Str("source", name).Str("category", categoryName).Msg("category values")
+				outputs[name] = x.timeoutValues(bySource[name], inputs, ErrSourceTimeout)
+				pending--
+			}
+		case <-ctx.Done():
+			for _, name := range order {
+				if _, done := outputs[name]; !done {
+					log.Warn().Err(ErrBudgetExceeded).Str("component", "router").Str("source", name).Str("category", categoryName).Msg("category values")
+					outputs[name] = x.timeoutValues(bySource[name], inputs, ErrBudgetExceeded)
+				}
+			}
+			pending = 0
+		}
+	}
+
+	for _, sourceName := range order {
+		itemResults = append(itemResults, outputs[sourceName]...)
+	}
 	return itemResults, nil
 }
 
+func (x *DataRouter) sourceValues(ctx context.Context, sourceName string, items []Item, inputs common.DataInputs, results chan sourceResult) {
+	sourceCtx, cancel := context.WithTimeout(ctx, x.sourceTimeout(sourceName))
+	defer cancel()
+
+	// NOTE:  every source gets its own inputs, some of them write to the map
+	keys := common.DataInputs{}
+	for k, v := range inputs {
+		keys[k] = v
+	}
+
+	source := x.findSourceInstance(sourceName)
+	outputs := []*common.DataOutput{}
+	for _, item := range items {
+		resp, _ := source.ItemValue(sourceCtx, item, keys)
+		// sources that honor the context give up on their own, report it the same way
+		if sourceCtx.Err() != nil && resp.Error != "" {
+			err := ErrSourceTimeout
+			if ctx.Err() != nil {
+				err = ErrBudgetExceeded
+			}
+			resp = x.response.EmptyResponse(item.Type, item.Path, keys, err)
+		}
+		outputs = append(outputs, resp)
+	}
+	results <- sourceResult{source: sourceName, outputs: outputs}
+}
+
+func (x *DataRouter) timeoutValues(items []Item, inputs common.DataInputs, err error) []*common.DataOutput {
+	outputs := []*common.DataOutput{}
+	for _, item := range items {
+		outputs = append(outputs, x.response.EmptyResponse(item.Type, item.Path, inputs, err))
+	}
+	return outputs
+}
+
+func (x *DataRouter) sourceTimeout(sourceName string) time.Duration {
+	if info, err := x.schema.Source(sourceName); err == nil && info.TimeoutMS > 0 {
+		return time.Duration(info.TimeoutMS) * time.Millisecond
+	}
+	return DEFAULT_SOURCE_TIMEOUT
+}
+
+// RequestBudget reads the optional budget input, plain numbers are milliseconds
+func RequestBudget(inputs common.DataInputs) (time.Duration, error) {
+	value := inputs[common.INPUT_BUDGET]
+	if value == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	if budget, err := time.ParseDuration(value); err == nil && budget > 0 {
+		return budget, nil
+	}
+	return 0, ErrInvalidBudget
+}
+
 const (
 	// remote data sources
 	SOURCE_PWNED_NAME    = "pwned"
 	SOURCE_WHOIS_NAME    = "whois"
 	SOURCE_IPINFO_NAME   = "ipinfo"
 	SOURCE_SPAMHAUS_NAME = "spamhaus"
+	SOURCE_DNS_NAME      = "dns"
+	SOURCE_SMTP_NAME     = "smtp"
 	// coded data sources
 	SOURCE_OSINTAMI_NAME = "osintami"
+	// category fan-out deadline for sources without a TimeoutMS
+	DEFAULT_SOURCE_TIMEOUT = 5 * time.Second
 )
 
 func (x *DataRouter) newInstance(sourceName string) IDataSource {
@@ -161,6 +309,10 @@ func (x *DataRouter) newInstance(sourceName string) IDataSource {
 		return NewIpInfoSource(x.tools, ipinfo)
 	case sourceName == SOURCE_SPAMHAUS_NAME:
 		return NewSpamhausSource(x.tools, common.NewRealtimeBlackholeList())
+	case sourceName == SOURCE_DNS_NAME:
+		return NewDNSSource(x.tools, common.NewResolver(x.tools.Resolver), x)
+	case sourceName == SOURCE_SMTP_NAME:
+		return NewSMTPSource(x.tools, common.NewResolver(x.tools.Resolver))
 	case sourceName == SOURCE_OSINTAMI_NAME:
 		return NewInternalSource(x, x.tools)
 	}
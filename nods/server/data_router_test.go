@@ -3,7 +3,10 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/osintami/fingerprintz/common"
 	"github.com/stretchr/testify/assert"
@@ -25,6 +28,95 @@ func TestDataRouterDataValue(t *testing.T) {
 	assert.True(t, value.Result.Boolean())
 }
 
+func TestDataRouterDataValueURL(t *testing.T) {
+	tools := mockToolbox()
+	tools.DataPath = "./test/"
+	router := NewDataRouter(tools)
+	router.Init()
+
+	// the ip items take the host of the url
+	inputs := common.DataInputs{CATEGORY_URL: "http://1.2.3.4/login"}
+	uri := NewDataURI(CATEGORY_IPADDR, "ipsum", "blacklist.isBlacklisted")
+	value, err := router.DataValue(context.TODO(), uri, inputs)
+	assert.Nil(t, err)
+	assert.True(t, value.Result.Boolean())
+	assert.Equal(t, "", inputs[CATEGORY_IPADDR])
+
+	// an ip given with it wins
+	inputs[CATEGORY_IPADDR] = "0.0.0.0"
+	_, err = router.DataValue(context.TODO(), uri, inputs)
+	assert.Equal(t, common.ErrNoDataPresent, err)
+}
+
+// TestDataRouterOsintamiMemo looks up osintami items with a memo, the browser, email, phone and url
+// sources are DataInstances inside the DataInstance that wraps them
+func TestDataRouterOsintamiMemo(t *testing.T) {
+	schema, dir := ruleStoreSchema(t)
+	store, err := NewRuleStore(schema, dir+"osintami.json", dir+"rules_audit.json")
+	assert.Nil(t, err)
+	_, err = store.Create(Item{Path: "rule/osintami/isGooglebot", Enabled: true, Query: "[browser/osintami/major] == '2'"}, "admin@osintami.com")
+	assert.Nil(t, err)
+
+	tools := mockToolbox()
+	tools.DataPath = "./test/"
+	tools.Schema = schema
+	router := NewDataRouter(tools)
+	router.instances[SOURCE_OSINTAMI_NAME] = NewInternalSource(router, tools)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		// rules always look their items up with a memo
+		uri := NewItemSplitter("rule/osintami/isGooglebot")
+		inputs := common.DataInputs{CATEGORY_BROWSER: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", common.INPUT_RULE: uri.Key()}
+		value, err := router.DataValue(context.TODO(), uri, inputs)
+		assert.Nil(t, err)
+		assert.True(t, value.Result.Boolean())
+
+		source := router.instances[SOURCE_OSINTAMI_NAME]
+		_, err = source.CategoryInfo(WithMemo(context.TODO()), CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "jane.doe@example.com"})
+		assert.Nil(t, err)
+		_, err = source.CategoryInfo(WithMemo(context.TODO()), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "+13125550123"})
+		assert.Nil(t, err)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("osintami lookups with a memo never answered")
+	}
+}
+
+// TestDataRouterPhishingURL runs the production rule over the url source, not fixtures
+func TestDataRouterPhishingURL(t *testing.T) {
+	tools := mockToolbox()
+	tools.DataPath = "./test/"
+	tools.Schema = NewDataSchema(NewMockWatcher(), NewMockCache(), "../", "../schema/")
+	router := NewDataRouter(tools)
+	router.instances[SOURCE_OSINTAMI_NAME] = NewInternalSource(router, tools)
+
+	uri := NewItemSplitter("rule/osintami/isPhishingURL")
+	for url, expected := range map[string]bool{
+		"http://paypal.com@example.com/":        true,
+		"http://0x7f.1/wp-login.php":            true,
+		"https://secure.example.tk/signin":      true,
+		"https://www.example.com/blog/":         false,
+		"https://a.b.c.d.example.com/about-us/": false} {
+		done := make(chan bool)
+		go func() {
+			defer close(done)
+			value, err := router.DataValue(context.TODO(), uri, common.DataInputs{CATEGORY_URL: url, common.INPUT_RULE: uri.Key()})
+			assert.Nil(t, err, url)
+			assert.Equal(t, expected, value.Result.Boolean(), url)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("rule never answered", url)
+		}
+	}
+}
+
 func TestDataRouterDataValueInvalidCategory(t *testing.T) {
 	tools := mockToolbox()
 	tools.DataPath = "./test/"
@@ -159,3 +251,107 @@ func TestDataRouterAPI(t *testing.T) {
 func TestDataRouterFastDB(t *testing.T) {
 	// TODO:
 }
+
+// slowProvider holds every lookup until the context gives up or the delay passes
+type slowProvider struct {
+	countingProvider
+	delay time.Duration
+}
+
+func (x *slowProvider) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(x.delay):
+		return x.countingProvider.CategoryInfo(ctx, categoryName, inputs)
+	}
+}
+
+type timeoutSchema struct {
+	*memoSchema
+	timeouts map[string]int
+}
+
+func (x *timeoutSchema) Source(sourceName string) (SourceInfo, error) {
+	return SourceInfo{Name: sourceName, TimeoutMS: x.timeouts[sourceName]}, nil
+}
+
+func slowRouter(delay time.Duration, timeouts map[string]int) *DataRouter {
+	router, _ := memoRouter()
+	schema := &timeoutSchema{memoSchema: router.schema.(*memoSchema), timeouts: timeouts}
+	router.schema = schema
+	router.tools.Schema = schema
+	router.instances["maxmind"] = NewDataInstance(router.tools, "maxmind", &slowProvider{delay: delay})
+	return router
+}
+
+func TestDataRouterCategorySourceTimeout(t *testing.T) {
+	router := slowRouter(time.Second, map[string]int{"maxmind": 20})
+
+	start := time.Now()
+	outputs, err := router.CategoryValues(context.TODO(), CATEGORY_IPADDR, common.DataInputs{"ip": "1.2.3.4"})
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// partial results in schema order, the slow source has per item timeouts
+	assert.Equal(t, 3*MEMO_TEST_FIELDS+1, len(outputs))
+	for _, output := range outputs {
+		if strings.HasPrefix(output.Item, "ip/maxmind/") {
+			assert.Equal(t, ErrSourceTimeout.Error(), output.Error)
+		} else {
+			assert.Equal(t, "", output.Error)
+			assert.True(t, *output.Result.Bool)
+		}
+	}
+	assert.Equal(t, "ip/ipsum/field0", outputs[0].Item)
+	assert.Equal(t, "ip/maxmind/location", outputs[len(outputs)-1].Item)
+}
+
+func TestDataRouterCategoryParallel(t *testing.T) {
+	router := slowRouter(50*time.Millisecond, map[string]int{})
+	router.instances["ipsum"] = NewDataInstance(router.tools, "ipsum", &slowProvider{delay: 50 * time.Millisecond})
+	router.instances["uhb"] = NewDataInstance(router.tools, "uhb", &slowProvider{delay: 50 * time.Millisecond})
+
+	// three slow sources side by side take about as long as one
+	start := time.Now()
+	outputs, err := router.CategoryValues(context.TODO(), CATEGORY_IPADDR, common.DataInputs{"ip": "1.2.3.4"})
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), 140*time.Millisecond)
+	for _, output := range outputs {
+		assert.Equal(t, "", output.Error)
+	}
+}
+
+func TestDataRouterCategoryBudget(t *testing.T) {
+	router := slowRouter(time.Second, map[string]int{})
+
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_BUDGET: "30ms"}
+	start := time.Now()
+	outputs, err := router.CategoryValues(context.TODO(), CATEGORY_IPADDR, inputs)
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, ErrBudgetExceeded.Error(), outputs[len(outputs)-1].Error)
+	assert.Equal(t, "", outputs[0].Error)
+	_, found := outputs[0].Keys[common.INPUT_BUDGET]
+	assert.False(t, found)
+
+	_, err = router.CategoryValues(context.TODO(), CATEGORY_IPADDR, common.DataInputs{"ip": "1.2.3.4", common.INPUT_BUDGET: "soon"})
+	assert.Equal(t, ErrInvalidBudget, err)
+}
+
+func TestRequestBudget(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"":      0,
+		"250":   250 * time.Millisecond,
+		"1.5s":  1500 * time.Millisecond,
+		"750ms": 750 * time.Millisecond,
+	} {
+		budget, err := RequestBudget(common.DataInputs{common.INPUT_BUDGET: value})
+		assert.Nil(t, err)
+		assert.Equal(t, expected, budget)
+	}
+	for _, value := range []string{"-5", "0", "nope", "-1s"} {
+		_, err := RequestBudget(common.DataInputs{common.INPUT_BUDGET: value})
+		assert.Equal(t, ErrInvalidBudget, err)
+	}
+}
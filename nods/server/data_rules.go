@@ -0,0 +1,288 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	RULE_CREATE = "create"
+	RULE_UPDATE = "update"
+	RULE_DELETE = "delete"
+)
+
+// RuleChange is one line of the audit log, Before and After are the rule on either side
+type RuleChange struct {
+	Version int64
+	Time    time.Time
+	Account string
+	Action  string
+	Rule    string
+	Before  *Item `json:",omitempty"`
+	After   *Item `json:",omitempty"`
+}
+
+type RuleList struct {
+	Version int64
+	Rules   []Item
+}
+
+// RuleStore edits the rules in the schema file of the osintami source, every change is
+// compiled against the rest of the rules first, written with a rename, reloaded into the
+// schema and appended to the audit log with the next version number
+type RuleStore struct {
+	mu        sync.Mutex
+	schema    IDataSchema
+	rulesFile string
+	auditFile string
+	version   int64
+}
+
+func NewRuleStore(schema IDataSchema, rulesFile, auditFile string) (*RuleStore, error) {
+	x := &RuleStore{
+		schema:    schema,
+		rulesFile: rulesFile,
+		auditFile: auditFile}
+
+	// pick the version up where the audit log left off
+	changes, err := x.Audit("")
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		x.version = changes[len(changes)-1].Version
+	}
+	return x, nil
+}
+
+func (x *RuleStore) List() *RuleList {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	list := &RuleList{Version: x.version, Rules: []Item{}}
+	for _, item := range x.schema.ListRulesItems() {
+		if item.CategoryName == CATEGORY_RULE {
+			list.Rules = append(list.Rules, item)
+		}
+	}
+	sort.Slice(list.Rules, func(i, j int) bool {
+		return list.Rules[i].Path < list.Rules[j].Path
+	})
+	return list
+}
+
+func (x *RuleStore) Create(rule Item, account string) (*RuleChange, error) {
+	return x.change(RULE_CREATE, rule.Path, &rule, account)
+}
+
+func (x *RuleStore) Update(path string, rule Item, account string) (*RuleChange, error) {
+	rule.Path = path
+	return x.change(RULE_UPDATE, path, &rule, account)
+}
+
+func (x *RuleStore) Delete(path, account string) (*RuleChange, error) {
+	return x.change(RULE_DELETE, path, nil, account)
+}
+
+// Audit lists the changes, oldest first, for one rule or for all of them
+func (x *RuleStore) Audit(path string) ([]RuleChange, error) {
+	changes := []RuleChange{}
+	file, err := os.Open(x.auditFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return changes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		change := RuleChange{}
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			return nil, err
+		}
+		if path == "" || change.Rule == path {
+			changes = append(changes, change)
+		}
+	}
+	return changes, scanner.Err()
+}
+
+func (x *RuleStore) change(action, path string, rule *Item, account string) (*RuleChange, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// NOTE:  the file is the truth, it may have been edited by hand since the last change
+	items := []Item{}
+	if err := common.LoadJson(x.rulesFile, &items); err != nil {
+		return nil, err
+	}
+	rules := make(map[string]Item)
+	index := -1
+	for i, item := range items {
+		if strings.HasPrefix(item.Path, CATEGORY_RULE+"/") {
+			item.CategoryName = CATEGORY_RULE
+			item.SourceName = SOURCE_OSINTAMI_NAME
+			rules[item.Path] = item
+		}
+		if item.Path == path {
+			index = i
+		}
+	}
+
+	change := &RuleChange{Time: time.Now().UTC(), Account: account, Action: action, Rule: path}
+	switch action {
+	case RULE_CREATE:
+		if index >= 0 {
+			return nil, ErrRuleExists
+		}
+	case RULE_UPDATE, RULE_DELETE:
+		if index < 0 {
+			return nil, ErrRuleNotFound
+		}
+		before := items[index]
+		change.Before = &before
+	}
+
+	if rule != nil {
+		if err := x.validate(rule, rules); err != nil {
+			return nil, err
+		}
+		change.After = rule
+	}
+
+	switch action {
+	case RULE_CREATE:
+		items = append(items, *rule)
+	case RULE_UPDATE:
+		items[index] = *rule
+	case RULE_DELETE:
+		dependents := NewRuleGraph(x.schema, rules).Dependents(path)
+		if len(dependents) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrRuleInUse, strings.Join(dependents, ", "))
+		}
+		items = append(items[:index], items[index+1:]...)
+	}
+
+	if err := x.save(items); err != nil {
+		return nil, err
+	}
+	if err := x.schema.Refresh(SOURCE_OSINTAMI_NAME); err != nil {
+		return nil, err
+	}
+
+	change.Version = x.version + 1
+	if err := x.audit(change); err != nil {
+		return nil, err
+	}
+	x.version = change.Version
+	log.Info().Str("component", "rules").Str("account", account).Str("action", action).Str("rule", path).Int64("version", change.Version).Msg("rule change")
+	return change, nil
+}
+
+// validate fills in the defaults and compiles the rule in place among the others, every
+// item it uses has to be in the schema and the rules it uses have to be sound
+func (x *RuleStore) validate(rule *Item, rules map[string]Item) error {
+	uri := NewItemSplitter(rule.Path)
+	if uri.CategoryName != CATEGORY_RULE || uri.SourceName != SOURCE_OSINTAMI_NAME || uri.ItemName == "" || strings.Contains(uri.ItemName, "/") || (strings.TrimSpace(rule.Query) == "") == (rule.Score == nil) {
+		return ErrInvalidRuleParam
+	}
+	if rule.TypeName == "" {
+		rule.TypeName = common.Boolean.String()
+		// NOTE:  a score answers with the score and the reasons
+		if rule.Score != nil {
+			rule.TypeName = common.JSON.String()
+		}
+	}
+	if rule.Type.ToDataType(rule.TypeName).String() != rule.TypeName || (rule.Score != nil && (rule.TypeName != common.JSON.String() || rule.Shadow != "")) {
+		return ErrInvalidRuleParam
+	}
+	if rule.Gjson == "" {
+		rule.Gjson = uri.ItemName
+	}
+	rule.CategoryName = CATEGORY_RULE
+	rule.SourceName = SOURCE_OSINTAMI_NAME
+	rule.Type = rule.Type.ToDataType(rule.TypeName)
+
+	rules[rule.Path] = *rule
+	compiled, _ := NewRuleGraph(x.schema, rules).Rule(rule.Path)
+	if compiled.Err != nil {
+		return compiled.Err
+	}
+	items := compiled.Items
+	if compiled.shadow != nil {
+		if compiled.shadow.Err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidShadow, compiled.shadow.Err.Error())
+		}
+		items = append(append([]string{}, items...), compiled.shadow.Items...)
+	}
+	for _, name := range items {
+		dataURI := NewItemSplitter(name)
+		if dataURI.CategoryName != CATEGORY_RULE && !x.schema.IsValidItem(dataURI) {
+			return fmt.Errorf("%w: %s", ErrUnknownRuleItem, name)
+		}
+	}
+	return nil
+}
+
+func (x *RuleStore) save(items []Item) error {
+	// NOTE:  keep && readable in the file
+	data := &bytes.Buffer{}
+	encoder := json.NewEncoder(data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(items); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(x.rulesFile), filepath.Base(x.rulesFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if info, err := os.Stat(x.rulesFile); err == nil {
+		tmp.Chmod(info.Mode())
+	}
+	if _, err := tmp.Write(data.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), x.rulesFile)
+}
+
+func (x *RuleStore) audit(change *RuleChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(x.auditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,163 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+// ruleStoreSchema copies the schema in ./test/ so the store can write to it
+func ruleStoreSchema(t *testing.T) (*DataSchema, string) {
+	dir := t.TempDir() + "/"
+	for _, name := range []string{"config.json", "osintami.json", "ipsum.json", "fakefilter.json"} {
+		data, err := os.ReadFile("./test/" + name)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(dir+name, data, 0644))
+	}
+	return NewDataSchema(NewMockWatcher(), NewMockCache(), dir, dir), dir
+}
+
+func TestRuleStore(t *testing.T) {
+	schema, dir := ruleStoreSchema(t)
+	store, err := NewRuleStore(schema, dir+"osintami.json", dir+"rules_audit.json")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), store.List().Version)
+	assert.Equal(t, 1, len(store.List().Rules))
+
+	// create
+	change, err := store.Create(Item{Path: "rule/osintami/isListed", Enabled: true, Query: "[ip/ipsum/blacklist.isBlacklisted] && true"}, "admin@osintami.com")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), change.Version)
+	assert.Equal(t, RULE_CREATE, change.Action)
+	assert.Nil(t, change.Before)
+	assert.Equal(t, "isListed", change.After.Gjson)
+	assert.Equal(t, "Boolean", change.After.TypeName)
+
+	// the schema has it, and the file is still readable by hand
+	item, err := schema.Item(NewItemSplitter("rule/osintami/isListed"))
+	assert.Nil(t, err)
+	assert.Equal(t, common.Boolean, item.Type)
+	data, _ := os.ReadFile(dir + "osintami.json")
+	assert.True(t, strings.Contains(string(data), "] && true"))
+	assert.True(t, strings.Contains(string(data), "browser/osintami/major"))
+
+	// update
+	change, err = store.Update("rule/osintami/isListed", Item{Enabled: true, Query: "[ip/ipsum/blacklist.isBlacklisted] || [rule/osintami/isBlacklisted]"}, "admin@osintami.com")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), change.Version)
+	assert.Equal(t, "[ip/ipsum/blacklist.isBlacklisted] && true", change.Before.Query)
+	assert.Equal(t, 2, len(store.List().Rules))
+
+	// a rule that's used can't go, the user of it can
+	_, err = store.Delete("rule/osintami/isBlacklisted", "admin@osintami.com")
+	assert.Equal(t, "rule is used by other rules: rule/osintami/isListed", err.Error())
+	change, err = store.Delete("rule/osintami/isListed", "ops@osintami.com")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), change.Version)
+	_, err = schema.Item(NewItemSplitter("rule/osintami/isListed"))
+	assert.Equal(t, ErrItemNotFound, err)
+
+	// the audit trail, whole and per rule
+	changes, err := store.Audit("")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, "ops@osintami.com", changes[2].Account)
+	assert.Equal(t, RULE_DELETE, changes[2].Action)
+	changes, _ = store.Audit("rule/osintami/isBlacklisted")
+	assert.Equal(t, 0, len(changes))
+
+	// the version survives a restart
+	store, err = NewRuleStore(schema, dir+"osintami.json", dir+"rules_audit.json")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), store.List().Version)
+}
+
+func TestRuleStoreRejects(t *testing.T) {
+	schema, dir := ruleStoreSchema(t)
+	store, _ := NewRuleStore(schema, dir+"osintami.json", dir+"rules_audit.json")
+	before, _ := os.ReadFile(dir + "osintami.json")
+
+	for _, test := range []struct {
+		rule Item
+		err  error
+	}{
+		{Item{Path: "rule/osintami/isBlacklisted", Query: "true"}, ErrRuleExists},
+		{Item{Path: "ip/osintami/isNope", Query: "true"}, ErrInvalidRuleParam},
+		{Item{Path: "rule/osintami/isNope"}, ErrInvalidRuleParam},
+		{Item{Path: "rule/osintami/isNope", Query: "true", TypeName: "Nope"}, ErrInvalidRuleParam},
+		{Item{Path: "rule/osintami/isNope", Query: "[ip/ipsum/blacklist.isBlacklisted] ||"}, ErrInvalidRule},
+		{Item{Path: "rule/osintami/isNope", Query: "[ip/nope/nope]"}, ErrUnknownRuleItem},
+		{Item{Path: "rule/osintami/isNope", Query: "[rule/osintami/isCloud]"}, ErrUnknownRule},
+		{Item{Path: "rule/osintami/isNope", Query: "[rule/osintami/isNope]"}, ErrRuleCycle},
+		{Item{Path: "rule/osintami/isNope", Query: "true", Score: &ScoreModel{Model: "risk", Version: 1}}, ErrInvalidRuleParam},
+		{Item{Path: "rule/osintami/riskV1", TypeName: "Boolean", Score: &ScoreModel{Model: "risk", Version: 1, Conditions: []ScoreCondition{{Query: "true", Weight: 1, Reason: "A"}}}}, ErrInvalidRuleParam},
+		{Item{Path: "rule/osintami/riskV1", Score: &ScoreModel{Model: "risk", Version: 1}}, ErrInvalidScore},
+	} {
+		_, err := store.Create(test.rule, "admin@osintami.com")
+		assert.True(t, errors.Is(err, test.err), test.rule.Query)
+	}
+	_, err := store.Update("rule/osintami/isNope", Item{Query: "true"}, "admin@osintami.com")
+	assert.Equal(t, ErrRuleNotFound, err)
+	_, err = store.Delete("rule/osintami/isNope", "admin@osintami.com")
+	assert.Equal(t, ErrRuleNotFound, err)
+
+	// nothing was written
+	after, _ := os.ReadFile(dir + "osintami.json")
+	assert.Equal(t, before, after)
+	_, err = os.Stat(filepath.Join(dir, "rules_audit.json"))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestRuleStoreScore(t *testing.T) {
+	schema, dir := ruleStoreSchema(t)
+	store, _ := NewRuleStore(schema, dir+"osintami.json", dir+"rules_audit.json")
+
+	score := &ScoreModel{Model: "risk", Version: 1, Conditions: []ScoreCondition{
+		{Query: "[rule/osintami/isBlacklisted]", Weight: 40, Reason: "BLACKLISTED"},
+		{Query: "[ip/ipsum/blacklist.isBlacklisted] && [ip/ipsum/blacklist.isBlacklisted]", Weight: 70, Reason: "LISTED_TWICE"},
+	}}
+	change, err := store.Create(Item{Path: "rule/osintami/riskV1", Enabled: true, Score: score}, "admin@osintami.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "JSON", change.After.TypeName)
+
+	// the model round trips through the file
+	item, err := schema.Item(NewItemSplitter("rule/osintami/riskV1"))
+	assert.Nil(t, err)
+	assert.Equal(t, score, item.Score)
+
+	// a rule a score uses can't go
+	_, err = store.Delete("rule/osintami/isBlacklisted", "admin@osintami.com")
+	assert.True(t, errors.Is(err, ErrRuleInUse))
+
+	rules := NewRuleProvider(NewMockDataRouter(false), schema)
+	data, err := rules.CategoryInfo(context.TODO(), CATEGORY_RULE, common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: "rule/osintami/riskV1"})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(100), gjson.GetBytes(data, "riskV1.Score").Float())
+	assert.Equal(t, "BLACKLISTED", gjson.GetBytes(data, "riskV1.Reasons.0.Reason").String())
+}
+
+func TestRuleStoreReloadsRules(t *testing.T) {
+	schema, dir := ruleStoreSchema(t)
+	store, _ := NewRuleStore(schema, dir+"osintami.json", dir+"rules_audit.json")
+	rules := NewRuleProvider(NewMockDataRouter(false), schema)
+
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: "rule/osintami/isBlacklisted"}
+	data, err := rules.CategoryInfo(context.TODO(), CATEGORY_RULE, inputs)
+	assert.Nil(t, err)
+	assert.True(t, gjson.GetBytes(data, "isBlacklisted").Bool())
+
+	// the provider picks the change up on the next evaluation
+	_, err = store.Update("rule/osintami/isBlacklisted", Item{Enabled: true, Query: "!([ip/ipsum/blacklist.isBlacklisted])"}, "admin@osintami.com")
+	assert.Nil(t, err)
+	data, err = rules.CategoryInfo(context.TODO(), CATEGORY_RULE, inputs)
+	assert.Nil(t, err)
+	assert.False(t, gjson.GetBytes(data, "isBlacklisted").Bool())
+}
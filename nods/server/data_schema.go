@@ -2,17 +2,22 @@
 package server
 
 import (
+	"fmt"
 	"sort"
+	"strings"
+	"sync/atomic"
 
 	"github.com/osintami/fingerprintz/common"
 	"github.com/osintami/fingerprintz/log"
 )
 
 type SourceInfo struct {
-	Name     string
-	Database string // mmdb, yaml, fast, api
-	Enabled  bool
-	API      *API `json:",omitempty"`
+	Name          string
+	Database      string // mmdb, yaml, fast, api, asn (mmdb and fast)
+	Enabled       bool
+	API           *API `json:",omitempty"`
+	MaxConcurrent int  `json:",omitempty"` // batch lookups in flight, 0 is unlimited
+	TimeoutMS     int  `json:",omitempty"` // category fan-out deadline, 0 is DEFAULT_SOURCE_TIMEOUT
 }
 
 type CategoryInfo struct {
@@ -44,6 +49,7 @@ type ServerInfo struct {
 
 type SchemaInfo struct {
 	Sources []SourceInfo
+	Lists   map[string][]string `json:",omitempty"` // named lists for in_list() in rules
 }
 
 type IDataSchema interface {
@@ -51,25 +57,33 @@ type IDataSchema interface {
 	IsValidItem(dataURI *DataURI) bool
 	IsValidCategory(categoryName string) bool
 	IsEnabled(sourceName string) bool
+	IsConfigured(sourceName string) bool
 	Source(sourceName string) (SourceInfo, error)
 	ListItems() []Item
 	ListSources() []SourceInfo
 	ListCategories() []string
 	ListItemsByCategory(categoryName string) []Item
 	ListRulesItems() map[string]Item
+	Refresh(sourceName string) error
+	Version() int64
+	InList(listName, value string) (bool, error)
 }
 
 type DataSchema struct {
+	watcher  common.IFileWatcher
+	version  int64
 	cache    common.IFastCache
 	schema   SchemaInfo
 	dataFile string
 	sources  map[SourceKey]*Source
+	lists    map[string]map[string]bool
 }
 
 const ONLY_LOAD_ENABLED = true
 
 func NewDataSchema(watcher common.IFileWatcher, cache common.IFastCache, configPath, dataPath string) *DataSchema {
 	x := &DataSchema{
+		watcher:  watcher,
 		cache:    cache,
 		dataFile: dataPath,
 		sources:  make(map[SourceKey]*Source),
@@ -90,7 +104,17 @@ func NewDataSchema(watcher common.IFileWatcher, cache common.IFastCache, configP
 		x.sources[dataSource.Key()] = dataSource
 
 		if watcher != nil {
-			watcher.Add(sourceFile, dataSource.Refresh)
+			name := source.Name
+			watcher.Add(sourceFile, func() { x.Refresh(name) })
+		}
+	}
+
+	// NOTE:  list lookups ignore case, emails and domains come in every which way
+	x.lists = make(map[string]map[string]bool, len(schema.Lists))
+	for name, values := range schema.Lists {
+		x.lists[name] = make(map[string]bool, len(values))
+		for _, value := range values {
+			x.lists[name][strings.ToLower(value)] = true
 		}
 	}
 
@@ -98,6 +122,37 @@ func NewDataSchema(watcher common.IFileWatcher, cache common.IFastCache, configP
 	return x
 }
 
+// InList checks the value against one of the named lists of the schema
+func (x *DataSchema) InList(listName, value string) (bool, error) {
+	list, ok := x.lists[listName]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", ErrUnknownList, listName)
+	}
+	return list[strings.ToLower(value)], nil
+}
+
+// Refresh reloads the items of a source and bumps the schema version, anything built from
+// the schema (ie. compiled rules) checks the version to know it is stale
+func (x *DataSchema) Refresh(sourceName string) error {
+	dataSource := x.sources[SourceKey(sourceName)]
+	if dataSource == nil {
+		return ErrSourceNotFound
+	}
+	dataSource.Refresh()
+	x.cache.Set("ListItems", x.listItems(), -1)
+	atomic.AddInt64(&x.version, 1)
+
+	// NOTE:  a file written with a rename is a new file as far as the watcher is concerned
+	if x.watcher != nil {
+		x.watcher.Add(dataSource.DataFile(), func() { x.Refresh(sourceName) })
+	}
+	return nil
+}
+
+func (x *DataSchema) Version() int64 {
+	return atomic.LoadInt64(&x.version)
+}
+
 func (x *DataSchema) Item(dataURI *DataURI) (Item, error) {
 	dataSource := x.sources[SourceKey(dataURI.SourceName)]
 	if dataSource == nil {
@@ -127,6 +182,16 @@ func (x *DataSchema) IsEnabled(sourceName string) bool {
 	return x.sources[SourceKey(sourceName)].Source().Enabled
 }
 
+// IsConfigured is true for any source in config.json, enabled or not
+func (x *DataSchema) IsConfigured(sourceName string) bool {
+	for _, source := range x.schema.Sources {
+		if source.Name == sourceName {
+			return true
+		}
+	}
+	return false
+}
+
 func (x *DataSchema) Source(sourceName string) (SourceInfo, error) {
 	source := x.sources[SourceKey(sourceName)]
 	if source == nil {
@@ -138,19 +203,24 @@ func (x *DataSchema) Source(sourceName string) (SourceInfo, error) {
 func (x *DataSchema) ListItems() []Item {
 	list, found := x.cache.Get("ListItems")
 	if !found {
-		items := []Item{}
-		for _, source := range x.sources {
-			items = append(items, source.ListItems()...)
-		}
-		sort.Slice(items, func(i, j int) bool {
-			return items[i].Path < items[j].Path
-		})
+		items := x.listItems()
 		x.cache.Set("ListItems", items, -1)
 		return items
 	}
 	return list.([]Item)
 }
 
+func (x *DataSchema) listItems() []Item {
+	items := []Item{}
+	for _, source := range x.sources {
+		items = append(items, source.ListItems()...)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Path < items[j].Path
+	})
+	return items
+}
+
 func (x *DataSchema) ListSources() []SourceInfo {
 	out := []SourceInfo{}
 	for _, source := range x.sources {
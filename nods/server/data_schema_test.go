@@ -39,7 +39,7 @@ func TestSchema(t *testing.T) {
 
 	// list all available categories
 	categories := schema.ListCategories()
-	assert.Equal(t, 7, len(categories))
+	assert.Equal(t, 9, len(categories))
 
 	// list all items by category
 	items = schema.ListItemsByCategory(CATEGORY_BROWSER)
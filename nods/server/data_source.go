@@ -1,6 +1,8 @@
 package server
 
 import (
+	"sync"
+
 	"github.com/osintami/fingerprintz/common"
 	"github.com/osintami/fingerprintz/log"
 	"golang.org/x/exp/maps"
@@ -17,9 +19,13 @@ type Item struct {
 	TypeName     string          `json:"Type"`
 	Type         common.DataType `json:"-"`
 	Query        string          `json:"Query,omitempty"`
+	Score        *ScoreModel     `json:"Score,omitempty"`
+	Missing      string          `json:"Missing,omitempty"`
+	Shadow       string          `json:"Shadow,omitempty"`
 }
 
 type Source struct {
+	mu       sync.RWMutex
 	items    map[ItemKey]Item
 	dataFile string
 	source   SourceInfo
@@ -38,9 +44,14 @@ func (x *Source) Key() SourceKey {
 	return SourceKey(x.source.Name)
 }
 
+// Refresh swaps in the items of the data file, items gone from the file are gone from the
+// source, a file that doesn't load leaves the current items alone
 func (x *Source) Refresh() {
 	items := []Item{}
-	common.LoadJson(x.dataFile, &items)
+	if err := common.LoadJson(x.dataFile, &items); err != nil {
+		return
+	}
+	loaded := make(map[ItemKey]Item, len(items))
 	for _, item := range items {
 		uri := NewItemSplitter(item.Path)
 		item.CategoryName = uri.CategoryName
@@ -50,11 +61,20 @@ func (x *Source) Refresh() {
 			item.Enabled = false
 		}
 		log.Debug().Str("component", "schema").Str("item", item.Path).Msg("item load")
-		x.items[item.Key()] = item
+		loaded[item.Key()] = item
 	}
+	x.mu.Lock()
+	x.items = loaded
+	x.mu.Unlock()
+}
+
+func (x *Source) DataFile() string {
+	return x.dataFile
 }
 
 func (x *Source) Item(dataURI *DataURI) (Item, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
 	if val, ok := x.items[ItemKey(dataURI.Key())]; ok {
 		return val, nil
 	}
@@ -62,6 +82,8 @@ func (x *Source) Item(dataURI *DataURI) (Item, error) {
 }
 
 func (x *Source) ListItems() []Item {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
 	return maps.Values(x.items)
 }
 
@@ -14,4 +14,10 @@ type Toolbox struct {
 	Schema   IDataSchema
 	Secrets  common.ISecrets
 	DataPath string
+	Shadows  *ShadowStore
+	Resolver string // host:port of the DNS server code sources ask, empty is the system resolver
+	// smtp source, the port mail exchangers listen on and who it says it is
+	SMTPPort  string
+	SMTPHello string
+	SMTPFrom  string
 }
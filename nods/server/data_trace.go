@@ -0,0 +1,109 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+)
+
+type traceContextKey struct{}
+
+// ItemTrace is one item looked up for an explain=true request, a rule item carries the
+// trace of its own evaluation so nested rules show up as a tree
+type ItemTrace struct {
+	Item      string
+	Value     interface{}
+	Type      string
+	Error     string `json:",omitempty"`
+	Default   string `json:",omitempty"`
+	LatencyMS float64
+	Rule      *RuleTrace `json:",omitempty"`
+}
+
+// RuleTrace is how a rule came to its answer, Expression is the query with the dates filled in
+// and Missing lists the inputs that had no data
+type RuleTrace struct {
+	Rule       string `json:",omitempty"`
+	Query      string
+	Expression string
+	Result     interface{}
+	Error      string   `json:",omitempty"`
+	Missing    []string `json:",omitempty"`
+	Items      []*ItemTrace
+}
+
+// ExplainedOutput is an item answer with the trace of how it was reached
+type ExplainedOutput struct {
+	*common.DataOutput
+	Explain *ItemTrace
+}
+
+// WithTrace asks the rules engine to record the evaluation of the item into node
+func WithTrace(ctx context.Context, node *ItemTrace) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, node)
+}
+
+func TraceFrom(ctx context.Context) *ItemTrace {
+	node, _ := ctx.Value(traceContextKey{}).(*ItemTrace)
+	return node
+}
+
+// Finish fills in the answer of the item
+func (x *ItemTrace) Finish(start time.Time, output *common.DataOutput, err error) {
+	x.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		x.Error = err.Error()
+	}
+	if output == nil {
+		return
+	}
+	x.Type = output.Result.Type.String()
+	x.Value = traceValue(&output.Result)
+	if x.Error == "" {
+		x.Error = output.Error
+	}
+}
+
+// traceValue is the value as a person would read it, dates stay dates
+func traceValue(result *common.DataResult) interface{} {
+	switch result.Type {
+	case common.Boolean:
+		if result.Bool != nil {
+			return *result.Bool
+		}
+	case common.Float, common.Integer:
+		if result.Num != nil {
+			return *result.Num
+		}
+	case common.String, common.Date, common.JSON:
+		if result.Str != nil {
+			return *result.Str
+		}
+	}
+	return nil
+}
+
+// defaulted notes a value the engine made up, a Null result stands in as false and a vendor
+// without an answer stands in with the empty result's default
+func (x *ItemTrace) defaulted(dataType common.DataType, err error, value interface{}) {
+	reasons := []string{}
+	if err != nil {
+		reasons = append(reasons, err.Error())
+	}
+	if dataType == common.Null {
+		reasons = append(reasons, "Null")
+	}
+	if len(reasons) > 0 {
+		x.Default = fmt.Sprintf("%s -> %v", strings.Join(reasons, ", "), value)
+	}
+}
+
+func (x *RuleTrace) fail(err error) {
+	if x != nil {
+		x.Error = err.Error()
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceRules(t *testing.T) {
+	schema := newMemoSchema()
+	schema.items = append(schema.items,
+		Item{Path: "ip/ipsum/missing", CategoryName: CATEGORY_IPADDR, SourceName: "ipsum", Gjson: "missing", Type: common.Null},
+		Item{Path: "rule/osintami/isRecent", CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: "isRecent", Type: common.Boolean,
+			Query: "[rule/osintami/isBot] && !([ip/ipsum/missing]) && @{1.years.ago} > '2000-01-01'"})
+	router, _ := memoSchemaRouter(schema)
+
+	root := &ItemTrace{Item: "rule/osintami/isRecent"}
+	uri := NewItemSplitter(root.Item)
+	output, err := router.DataValue(WithTrace(context.TODO(), root), uri, common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: uri.Key()})
+	assert.Nil(t, err)
+	assert.True(t, *output.Result.Bool)
+
+	trace := root.Rule
+	assert.Equal(t, "rule/osintami/isRecent", trace.Rule)
+	assert.Equal(t, true, trace.Result)
+	// dates are filled in
+	assert.False(t, strings.Contains(trace.Expression, "@{"))
+	assert.Equal(t, "[rule/osintami/isBot] && !([ip/ipsum/missing]) && '"+common.NewDateStuff().AgoStringToDate("1.years.ago")+"' > '2000-01-01'", trace.Expression)
+	assert.Equal(t, 2, len(trace.Items))
+
+	// made up values are called out
+	missing := trace.Items[1]
+	assert.Equal(t, "ip/ipsum/missing", missing.Item)
+	assert.Equal(t, "Null", missing.Type)
+	assert.Equal(t, common.ErrNoDataPresent.Error(), missing.Error)
+	assert.Equal(t, common.ErrNoDataPresent.Error()+", Null -> false", missing.Default)
+
+	// nested rules are a tree, isBot -> isProxy -> isTor
+	isBot := trace.Items[0]
+	assert.Equal(t, "rule/osintami/isBot", isBot.Item)
+	assert.Equal(t, true, isBot.Value)
+	assert.Equal(t, "Boolean", isBot.Type)
+	assert.GreaterOrEqual(t, isBot.LatencyMS, float64(0))
+	assert.Equal(t, "[rule/osintami/isCloudNode] && [rule/osintami/isProxy]", isBot.Rule.Query)
+	isProxy := isBot.Rule.Items[1]
+	assert.Equal(t, "rule/osintami/isProxy", isProxy.Item)
+	isTor := isProxy.Rule.Items[2]
+	assert.Equal(t, "rule/osintami/isTor", isTor.Item)
+	assert.Equal(t, []string{"ip/ipsum/field0", "ip/uhb/field1"}, []string{isTor.Rule.Items[0].Item, isTor.Rule.Items[1].Item})
+	assert.Equal(t, true, isTor.Rule.Items[0].Value)
+	assert.Nil(t, isTor.Rule.Items[0].Rule)
+
+	// no trace unless asked for
+	assert.Nil(t, TraceFrom(context.TODO()))
+}
+
+func TestTraceRuleErrors(t *testing.T) {
+	schema := newMemoSchema()
+	schema.items = append(schema.items, Item{Path: "rule/osintami/isHuman", CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: "isHuman", Type: common.Boolean,
+		Query: "!([rule/osintami/isBot] || [rule/osintami/isCloud])"})
+	router, _ := memoSchemaRouter(schema)
+
+	root := &ItemTrace{Item: "rule/osintami/isHuman"}
+	uri := NewItemSplitter(root.Item)
+	_, err := router.DataValue(WithTrace(context.TODO(), root), uri, common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: uri.Key()})
+	assert.Equal(t, common.ErrNoDataPresent, err)
+	assert.Equal(t, "rule references an unknown rule: rule/osintami/isCloud", root.Rule.Error)
+	assert.Nil(t, root.Rule.Result)
+}
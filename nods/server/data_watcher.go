@@ -0,0 +1,54 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/osintami/fingerprintz/common"
+)
+
+// SourceWatcher counts the reloads of every source, data files and dictionaries are named
+// after their source (ie. maxmind.mmdb, maxmind.json) so the file says whose it is
+type SourceWatcher struct {
+	mu          sync.RWMutex
+	watcher     common.IFileWatcher
+	generations map[string]int64
+}
+
+func NewSourceWatcher(watcher common.IFileWatcher) *SourceWatcher {
+	return &SourceWatcher{
+		watcher:     watcher,
+		generations: make(map[string]int64)}
+}
+
+func (x *SourceWatcher) Add(file string, refresh func()) error {
+	sourceName := SourceOfFile(file)
+	return x.watcher.Add(file, func() {
+		refresh()
+		x.Bump(sourceName)
+	})
+}
+
+func (x *SourceWatcher) Listen() {
+	x.watcher.Listen()
+}
+
+// Bump marks the source as reloaded, anything built from its old data is stale
+func (x *SourceWatcher) Bump(sourceName string) {
+	x.mu.Lock()
+	x.generations[sourceName]++
+	x.mu.Unlock()
+}
+
+func (x *SourceWatcher) Generation(sourceName string) int64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.generations[sourceName]
+}
+
+func SourceOfFile(file string) string {
+	name := filepath.Base(file)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
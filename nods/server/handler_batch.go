@@ -0,0 +1,307 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	BATCH_WORKERS  = 16
+	BATCH_MAX_ROWS = 1000
+)
+
+type BatchRequest struct {
+	Inputs     []common.DataInputs
+	Items      []string `json:",omitempty"`
+	Categories []string `json:",omitempty"`
+}
+
+type BatchRow struct {
+	Index   int
+	Outputs []*common.DataOutput
+	Error   string
+}
+
+type BatchResponse struct {
+	Rows []BatchRow
+}
+
+// BatchRunner fans a batch out through the router with a fixed number of workers, sources
+// with a MaxConcurrent setting get their own gate so a throttled vendor can't take every worker,
+// the gates ride along in the context so rules that reach a vendor wait on it too
+type BatchRunner struct {
+	router   IDataRouter
+	schema   IDataSchema
+	workers  int
+	maxRows  int
+	response *DataResponse
+	gates    map[string]chan bool
+	version  int64
+	mu       sync.RWMutex
+}
+
+func NewBatchRunner(router IDataRouter, schema IDataSchema, workers, maxRows int) *BatchRunner {
+	if workers <= 0 {
+		workers = BATCH_WORKERS
+	}
+	if maxRows <= 0 {
+		maxRows = BATCH_MAX_ROWS
+	}
+	return &BatchRunner{
+		router:   router,
+		schema:   schema,
+		workers:  workers,
+		maxRows:  maxRows,
+		response: NewDataResponse(),
+	}
+}
+
+type gatesContextKey struct{}
+
+type heldGateContextKey struct {
+	source string
+}
+
+// acquireGate waits for a turn at the source when the request carries gates, a lookup that
+// already holds the source's gate (ie. osintami inside osintami) doesn't wait on itself
+func acquireGate(ctx context.Context, sourceName string) (context.Context, func(), error) {
+	gates, _ := ctx.Value(gatesContextKey{}).(map[string]chan bool)
+	gate, ok := gates[sourceName]
+	if !ok || ctx.Value(heldGateContextKey{sourceName}) != nil {
+		return ctx, func() {}, nil
+	}
+	select {
+	case gate <- true:
+		return context.WithValue(ctx, heldGateContextKey{sourceName}, true), func() { <-gate }, nil
+	case <-ctx.Done():
+		log.Warn().Err(ctx.Err()).Str("component", "batch").Str("source", sourceName).Msg("waiting on source")
+		return ctx, nil, ctx.Err()
+	}
+}
+
+type batchTask struct {
+	row int
+	col int
+	uri *DataURI
+}
+
+func (x *BatchRunner) Run(ctx context.Context, batch *BatchRequest) (*BatchResponse, error) {
+	if len(batch.Inputs) == 0 || (len(batch.Items) == 0 && len(batch.Categories) == 0) {
+		return nil, ErrInvalidBatch
+	}
+	if len(batch.Inputs) > x.maxRows {
+		return nil, ErrBatchTooLarge
+	}
+	uris, err := x.expand(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &BatchResponse{Rows: make([]BatchRow, len(batch.Inputs))}
+	for i := range resp.Rows {
+		resp.Rows[i] = BatchRow{Index: i, Outputs: make([]*common.DataOutput, len(uris))}
+	}
+	errs := make([][]error, len(batch.Inputs))
+	for i := range errs {
+		errs[i] = make([]error, len(uris))
+	}
+
+	// items of one row share a memo, rows never share
+	ctx = context.WithValue(ctx, gatesContextKey{}, x.currentGates())
+	memos := make([]context.Context, len(batch.Inputs))
+	for i := range memos {
+		memos[i] = context.WithValue(ctx, memoContextKey{}, NewRequestMemo())
+	}
+
+	// NOTE:  a row without an input for any item is turned away before it takes a worker
+	rejected := make([]bool, len(batch.Inputs))
+	for row, inputs := range batch.Inputs {
+		if !x.hasInputs(uris, RouteURLInputs(inputs)) {
+			rejected[row] = true
+			resp.Rows[row].Error = ErrMissingInputs.Error()
+			for col, uri := range uris {
+				resp.Rows[row].Outputs[col] = x.strip(x.response.EmptyResponse(common.Null, uri.Key(), inputs, ErrMissingInputs))
+			}
+		}
+	}
+
+	tasks := make(chan batchTask)
+	wg := sync.WaitGroup{}
+	for i := 0; i < x.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				output, err := x.lookup(memos[task.row], task.uri, batch.Inputs[task.row])
+				resp.Rows[task.row].Outputs[task.col] = output
+				errs[task.row][task.col] = err
+			}
+		}()
+	}
+	for row := range batch.Inputs {
+		if rejected[row] {
+			continue
+		}
+		for col, uri := range uris {
+			tasks <- batchTask{row: row, col: col, uri: uri}
+		}
+	}
+	close(tasks)
+	wg.Wait()
+
+	// a row reports the first real failure, missing data is a normal answer
+	for row := range resp.Rows {
+		for _, err := range errs[row] {
+			if err != nil && err != common.ErrNoDataPresent {
+				resp.Rows[row].Error = err.Error()
+				break
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (x *BatchRunner) expand(batch *BatchRequest) ([]*DataURI, error) {
+	uris := []*DataURI{}
+	seen := make(map[string]bool)
+	add := func(uri *DataURI) {
+		if !seen[uri.Key()] {
+			seen[uri.Key()] = true
+			uris = append(uris, uri)
+		}
+	}
+	for _, itemName := range batch.Items {
+		uri := NewItemSplitter(itemName)
+		if uri.CategoryName == "unknown" {
+			return nil, ErrInvalidItemParam
+		}
+		add(uri)
+	}
+	for _, categoryName := range batch.Categories {
+		if categoryName == CATEGORY_RULE || !x.schema.IsValidCategory(categoryName) {
+			return nil, ErrInvalidCategoryParam
+		}
+		for _, item := range x.schema.ListItemsByCategory(categoryName) {
+			add(NewItemSplitter(item.Path))
+		}
+	}
+	return uris, nil
+}
+
+// hasInputs is true when the row has the input of one of the item categories, rules say
+// what they need themselves so any input will do for them
+func (x *BatchRunner) hasInputs(uris []*DataURI, inputs common.DataInputs) bool {
+	for _, uri := range uris {
+		if uri.CategoryName == CATEGORY_RULE {
+			for key, value := range inputs {
+				if value != "" && key != common.INPUT_ROLE && key != common.INPUT_KEY && key != common.INPUT_TYPE {
+					return true
+				}
+			}
+			continue
+		}
+		if inputs[uri.CategoryName] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// currentGates rebuilds the gates when the schema has changed since they were made
+func (x *BatchRunner) currentGates() map[string]chan bool {
+	var version int64
+	if x.schema != nil {
+		version = x.schema.Version()
+	}
+	x.mu.RLock()
+	if x.gates != nil && version == x.version {
+		defer x.mu.RUnlock()
+		return x.gates
+	}
+	x.mu.RUnlock()
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.gates == nil || version != x.version {
+		x.loadGates(version)
+	}
+	return x.gates
+}
+
+// loadGates makes a gate per limited source, a source whose limit didn't change keeps its gate
+// so lookups still holding it count against the new batches
+func (x *BatchRunner) loadGates(version int64) {
+	gates := make(map[string]chan bool)
+	if x.schema != nil {
+		for _, source := range x.schema.ListSources() {
+			if source.MaxConcurrent <= 0 {
+				continue
+			}
+			if gate, ok := x.gates[source.Name]; ok && cap(gate) == source.MaxConcurrent {
+				gates[source.Name] = gate
+				continue
+			}
+			gates[source.Name] = make(chan bool, source.MaxConcurrent)
+		}
+	}
+	x.gates = gates
+	x.version = version
+}
+
+func (x *BatchRunner) lookup(ctx context.Context, uri *DataURI, inputs common.DataInputs) (*common.DataOutput, error) {
+	// NOTE:  sources scribble on their inputs, every lookup gets its own copy
+	keys := common.DataInputs{}
+	for k, v := range inputs {
+		keys[k] = v
+	}
+	keys[common.INPUT_RULE] = uri.Key()
+
+	ctx, release, err := acquireGate(ctx, uri.SourceName)
+	if err != nil {
+		return x.strip(x.response.EmptyResponse(common.Null, uri.Key(), keys, err)), err
+	}
+	defer release()
+
+	output, err := x.router.DataValue(ctx, uri, keys)
+	if output == nil {
+		if err == nil {
+			err = ErrItemNotFound
+		}
+		output = x.response.EmptyResponse(common.Null, uri.Key(), keys, err)
+	}
+	return x.strip(output), err
+}
+
+func (x *BatchRunner) strip(output *common.DataOutput) *common.DataOutput {
+	// strip out internal use only keys from response
+	delete(output.Keys, common.INPUT_ROLE)
+	delete(output.Keys, common.INPUT_KEY)
+	delete(output.Keys, common.INPUT_TYPE)
+	delete(output.Keys, common.INPUT_RULE)
+	return output
+}
+
+func (x *NormalizedDataServer) PostBatchHandler(w http.ResponseWriter, r *http.Request) {
+	batch := &BatchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(batch); err != nil {
+		common.SendError(w, ErrInvalidBatch, http.StatusBadRequest)
+		return
+	}
+
+	resp, err := x.batch.Run(r.Context(), batch)
+	if err == ErrBatchTooLarge {
+		common.SendError(w, err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		common.SendError(w, err, http.StatusBadRequest)
+		return
+	}
+	common.SendJSON(w, resp)
+}
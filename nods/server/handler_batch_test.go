@@ -0,0 +1,240 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildBatchRequest(batch interface{}) *http.Request {
+	body, _ := json.Marshal(batch)
+	return httptest.NewRequest(http.MethodPost, "/v1/data/batch", bytes.NewReader(body))
+}
+
+func TestHandlerBatch(t *testing.T) {
+	server := nodsServer(false)
+
+	batch := &BatchRequest{
+		Inputs: []common.DataInputs{
+			{"ip": "1.2.3.4", "role": "test-role"},
+			{"ip": "5.6.7.8"},
+			{"email": "nope@nope.com"},
+		},
+		Items:      []string{"ip/ipsum/blacklist.isBlacklisted", "ip/nope/nope"},
+		Categories: []string{"ip"},
+	}
+	w := httptest.NewRecorder()
+	server.PostBatchHandler(w, buildBatchRequest(batch))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp := &BatchResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), resp))
+	assert.Equal(t, 3, len(resp.Rows))
+
+	// rows come back in order, duplicate items collapse
+	for i, row := range resp.Rows {
+		assert.Equal(t, i, row.Index)
+		assert.Equal(t, 3, len(row.Outputs))
+	}
+
+	row := resp.Rows[0]
+	assert.Equal(t, "ip/ipsum/blacklist.isBlacklisted", row.Outputs[0].Item)
+	assert.True(t, *row.Outputs[0].Result.Bool)
+	assert.Equal(t, common.DataInputs{"ip": "1.2.3.4"}, row.Outputs[0].Keys)
+	assert.Equal(t, ErrSourceNotFound.Error(), row.Outputs[1].Error)
+	assert.Equal(t, "ip/uhb/blacklist.isBlacklisted", row.Outputs[2].Item)
+	assert.Equal(t, ErrSourceNotFound.Error(), row.Error)
+
+	// no data is an answer, not a row failure
+	assert.Equal(t, common.ErrNoDataPresent.Error(), resp.Rows[1].Outputs[0].Error)
+	assert.Equal(t, ErrSourceNotFound.Error(), resp.Rows[1].Error)
+
+	// a row without an ip is turned away, every item says why
+	assert.Equal(t, ErrMissingInputs.Error(), resp.Rows[2].Error)
+	assert.Equal(t, 3, len(resp.Rows[2].Outputs))
+	assert.Equal(t, ErrMissingInputs.Error(), resp.Rows[2].Outputs[0].Error)
+	assert.Equal(t, "ip/ipsum/blacklist.isBlacklisted", resp.Rows[2].Outputs[0].Item)
+}
+
+func TestHandlerBatchBadRequests(t *testing.T) {
+	server := nodsServer(false)
+	server.SetBatchLimits(2, 2)
+
+	for _, test := range []struct {
+		batch  interface{}
+		status int
+		err    error
+	}{
+		{"nope", http.StatusBadRequest, ErrInvalidBatch},
+		{&BatchRequest{Items: []string{"ip/ipsum/blacklist.isBlacklisted"}}, http.StatusBadRequest, ErrInvalidBatch},
+		{&BatchRequest{Inputs: []common.DataInputs{{"ip": "1.2.3.4"}}}, http.StatusBadRequest, ErrInvalidBatch},
+		{&BatchRequest{Inputs: []common.DataInputs{{"ip": "1.2.3.4"}}, Items: []string{"nope"}}, http.StatusBadRequest, ErrInvalidItemParam},
+		{&BatchRequest{Inputs: []common.DataInputs{{"ip": "1.2.3.4"}}, Categories: []string{"rule"}}, http.StatusBadRequest, ErrInvalidCategoryParam},
+		{&BatchRequest{Inputs: []common.DataInputs{{}, {}, {}}, Categories: []string{"ip"}}, http.StatusRequestEntityTooLarge, ErrBatchTooLarge},
+	} {
+		w := httptest.NewRecorder()
+		server.PostBatchHandler(w, buildBatchRequest(test.batch))
+		assert.Equal(t, test.status, w.Code)
+		assert.Equal(t, common.BuildErrorResponse(test.err), w.Body.String())
+	}
+}
+
+type slowDataRouter struct {
+	MockDataRouter
+	inFlight int32
+	peak     int32
+}
+
+func (x *slowDataRouter) DataValue(ctx context.Context, dataURI *DataURI, inputs common.DataInputs) (*common.DataOutput, error) {
+	now := atomic.AddInt32(&x.inFlight, 1)
+	defer atomic.AddInt32(&x.inFlight, -1)
+	for {
+		peak := atomic.LoadInt32(&x.peak)
+		if now <= peak || atomic.CompareAndSwapInt32(&x.peak, peak, now) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return x.MockDataRouter.DataValue(ctx, dataURI, inputs)
+}
+
+func TestBatchRunnerSourceLimits(t *testing.T) {
+	router := &slowDataRouter{MockDataRouter: MockDataRouter{response: NewDataResponse()}}
+	runner := NewBatchRunner(router, NewMockDataSchema(), 8, 0)
+
+	batch := &BatchRequest{Items: []string{"email/pwned/breachCount"}}
+	for i := 0; i < 10; i++ {
+		batch.Inputs = append(batch.Inputs, common.DataInputs{"email": "nope@nope.com", "ip": "1.2.3.4"})
+	}
+	resp, err := runner.Run(context.TODO(), batch)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(resp.Rows))
+	assert.Equal(t, "3", resp.Rows[9].Outputs[0].Result.Raw)
+	// pwned allows one lookup at a time
+	assert.Equal(t, int32(1), router.peak)
+
+	// the rest of the sources use every worker
+	router.peak = 0
+	batch.Items = []string{"ip/ipsum/blacklist.isBlacklisted"}
+	_, err = runner.Run(context.TODO(), batch)
+	assert.Nil(t, err)
+	assert.Greater(t, router.peak, int32(1))
+
+	// waiting on a busy source gives up with the request
+	ctx, cancel := context.WithTimeout(context.TODO(), 12*time.Millisecond)
+	defer cancel()
+	batch.Items = []string{"email/pwned/breachCount"}
+	resp, err = runner.Run(ctx, batch)
+	assert.Nil(t, err)
+	assert.Equal(t, context.DeadlineExceeded.Error(), resp.Rows[9].Error)
+}
+
+// peakProvider holds every lookup a moment and remembers the most that were in at once
+type peakProvider struct {
+	countingProvider
+	inFlight int32
+	peak     int32
+}
+
+func (x *peakProvider) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	now := atomic.AddInt32(&x.inFlight, 1)
+	defer atomic.AddInt32(&x.inFlight, -1)
+	for {
+		peak := atomic.LoadInt32(&x.peak)
+		if now <= peak || atomic.CompareAndSwapInt32(&x.peak, peak, now) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return x.countingProvider.CategoryInfo(ctx, categoryName, inputs)
+}
+
+type gatedSchema struct {
+	*memoSchema
+}
+
+func (x *gatedSchema) ListSources() []SourceInfo {
+	return []SourceInfo{{Name: "maxmind", Database: "mmdb", Enabled: true, MaxConcurrent: 1}}
+}
+
+func TestBatchRunnerRuleSourceLimits(t *testing.T) {
+	router, _ := memoRouter()
+	schema := &gatedSchema{memoSchema: router.schema.(*memoSchema)}
+	router.schema = schema
+	maxmind := &peakProvider{}
+	router.instances["maxmind"] = NewDataInstance(router.tools, "maxmind", maxmind)
+	runner := NewBatchRunner(router, schema, 8, 0)
+
+	// isVPN reaches maxmind through the rule engine, the gate holds all the same
+	batch := &BatchRequest{Items: []string{"rule/osintami/isVPN"}}
+	for i := 0; i < 10; i++ {
+		batch.Inputs = append(batch.Inputs, common.DataInputs{"ip": fmt.Sprintf("1.2.3.%d", i)})
+	}
+	resp, err := runner.Run(context.TODO(), batch)
+	assert.Nil(t, err)
+	for _, row := range resp.Rows {
+		assert.Equal(t, "", row.Error)
+		assert.True(t, *row.Outputs[0].Result.Bool)
+	}
+	assert.Equal(t, int64(10), maxmind.calls)
+	assert.Equal(t, int32(1), maxmind.peak)
+
+	// without the batch nothing waits
+	maxmind.peak = 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			uri := NewItemSplitter("rule/osintami/isVPN")
+			router.DataValue(context.TODO(), uri, common.DataInputs{"ip": fmt.Sprintf("1.2.3.%d", i), common.INPUT_RULE: uri.Key()})
+		}(i)
+	}
+	wg.Wait()
+	assert.Greater(t, maxmind.peak, int32(1))
+}
+
+type reloadingSchema struct {
+	MockDataSchema
+	limit   int
+	version int64
+}
+
+func (x *reloadingSchema) ListSources() []SourceInfo {
+	return []SourceInfo{{Name: "maxmind", Database: "mmdb", Enabled: true, MaxConcurrent: x.limit}}
+}
+
+func (x *reloadingSchema) Version() int64 {
+	return x.version
+}
+
+func TestBatchRunnerGatesReload(t *testing.T) {
+	schema := &reloadingSchema{limit: 1}
+	runner := NewBatchRunner(NewMockDataRouter(false), schema, 4, 0)
+	gate := runner.currentGates()["maxmind"]
+	assert.Equal(t, 1, cap(gate))
+
+	// a reload that leaves the limit alone keeps the gate, lookups holding it still count
+	schema.version++
+	assert.Equal(t, gate, runner.currentGates()["maxmind"])
+
+	schema.limit = 3
+	assert.Equal(t, gate, runner.currentGates()["maxmind"])
+	schema.version++
+	assert.Equal(t, 3, cap(runner.currentGates()["maxmind"]))
+
+	schema.limit = 0
+	schema.version++
+	assert.Empty(t, runner.currentGates())
+}
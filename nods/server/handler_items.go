@@ -2,7 +2,10 @@
 package server
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/osintami/fingerprintz/common"
 )
@@ -42,6 +45,8 @@ func (x *NormalizedDataServer) ItemHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// NOTE:  a url stands in for the domain and ip inputs
+	keys = RouteURLInputs(keys)
 	if categoryName != CATEGORY_RULE && keys[categoryName] == "" {
 		common.SendError(w, ErrMissingInputs, http.StatusBadRequest)
 		return
@@ -50,23 +55,58 @@ func (x *NormalizedDataServer) ItemHandler(w http.ResponseWriter, r *http.Reques
 	// build item
 	item := NewDataURI(categoryName, vendorName, itemName)
 
+	budget, err := RequestBudget(keys)
+	if err != nil {
+		common.SendError(w, err, http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+	delete(keys, common.INPUT_BUDGET)
+
+	explain, _ := strconv.ParseBool(keys[common.INPUT_EXPLAIN])
+	delete(keys, common.INPUT_EXPLAIN)
+	var trace *ItemTrace
+	if explain {
+		trace = &ItemTrace{Item: item.Key()}
+		ctx = WithTrace(ctx, trace)
+	}
+
 	// used by some of the fancier sources for internal routing
 	keys[common.INPUT_RULE] = item.Key()
 	//	keys[common.INPUT_TYPE] = "Boolean"
 
 	var output *common.DataOutput
-	output, err := x.router.DataValue(r.Context(), item, keys)
+	start := time.Now()
+	output, err = x.router.DataValue(ctx, item, keys)
+
+	// explain answers even when the item fails, the reason is in the trace
+	if explain {
+		trace.Finish(start, output, err)
+		x.stripKeys(output.Keys)
+		common.SendPrettyJSON(w, &ExplainedOutput{DataOutput: output, Explain: trace})
+		return
+	}
 
 	// for some errors, return data
 	if err != nil && err != common.ErrNoDataPresent {
 		common.SendError(w, err, http.StatusNotFound)
 		return
 	}
-	// strip out internal use only keys from response
-	delete(output.Keys, common.INPUT_ROLE)
-	delete(output.Keys, common.INPUT_KEY)
-	delete(output.Keys, common.INPUT_TYPE)
-	delete(output.Keys, common.INPUT_RULE)
+	x.stripKeys(output.Keys)
 
 	common.SendPrettyJSON(w, output)
 }
+
+// stripKeys takes internal use only keys out of a response
+func (x *NormalizedDataServer) stripKeys(keys common.DataInputs) {
+	delete(keys, common.INPUT_ROLE)
+	delete(keys, common.INPUT_KEY)
+	delete(keys, common.INPUT_TYPE)
+	delete(keys, common.INPUT_RULE)
+	delete(keys, common.INPUT_MISSING)
+}
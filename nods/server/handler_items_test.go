@@ -2,6 +2,7 @@
 package server
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -104,6 +105,29 @@ func TestHandlerItemsCategoryMismatch(t *testing.T) {
 	assert.Equal(t, content, w.Body.String())
 }
 
+func TestHandlerItemURL(t *testing.T) {
+	server := nodsServer(false)
+
+	pParams := make(map[string]string)
+	pParams["category"] = "domain"
+	pParams["vendor"] = "fakefilter"
+	pParams["item"] = "IsFake"
+
+	// the domain item takes the host of the url
+	qParams := make(map[string]string)
+	qParams["url"] = "https://login.example.tk/verify"
+
+	r := buildItemRequest(pParams, qParams)
+	w := httptest.NewRecorder()
+
+	server.GetItemHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	output := common.DataOutput{}
+	json.Unmarshal(w.Body.Bytes(), &output)
+	assert.True(t, *output.Result.Bool)
+	assert.Equal(t, "example.tk", output.Keys["domain"])
+}
+
 func TestHandlerItemsMissingKeys(t *testing.T) {
 	server := nodsServer(false)
 
@@ -199,3 +223,77 @@ func TestHandlerItemsMissingItem(t *testing.T) {
 	content := common.BuildErrorResponse(ErrInvalidItemParam)
 	assert.Equal(t, content, w.Body.String())
 }
+
+func TestHandlerItemsBudget(t *testing.T) {
+	server := nodsServer(false)
+
+	pParams := make(map[string]string)
+	pParams["category"] = "ip"
+	pParams["vendor"] = "ipsum"
+	pParams["item"] = "blacklist.isBlacklisted"
+
+	qParams := make(map[string]string)
+	qParams["ip"] = "1.2.3.4"
+	qParams["budget"] = "nope"
+
+	r := buildItemRequest(pParams, qParams)
+	w := httptest.NewRecorder()
+	server.GetItemHandler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, common.BuildErrorResponse(ErrInvalidBudget), w.Body.String())
+
+	// a good budget is not echoed back
+	qParams["budget"] = "500ms"
+	r = buildItemRequest(pParams, qParams)
+	w = httptest.NewRecorder()
+	server.GetItemHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "budget")
+}
+
+func TestHandlerItemExplain(t *testing.T) {
+	server := nodsServer(false)
+
+	pParams := map[string]string{"category": "ip", "vendor": "ipsum", "item": "blacklist.isBlacklisted"}
+	qParams := map[string]string{"ip": "1.2.3.4", "explain": "true"}
+	w := httptest.NewRecorder()
+	server.GetItemHandler(w, buildItemRequest(pParams, qParams))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	output := &ExplainedOutput{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), output))
+	assert.Equal(t, common.DataInputs{"ip": "1.2.3.4"}, output.Keys)
+	assert.Equal(t, "ip/ipsum/blacklist.isBlacklisted", output.Explain.Item)
+	assert.Equal(t, true, output.Explain.Value)
+	assert.Equal(t, "Boolean", output.Explain.Type)
+	assert.Nil(t, output.Explain.Rule)
+
+	// failures answer with the reason
+	pParams["vendor"] = "nope"
+	w = httptest.NewRecorder()
+	server.GetItemHandler(w, buildItemRequest(pParams, qParams))
+	assert.Equal(t, http.StatusOK, w.Code)
+	output = &ExplainedOutput{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), output))
+	assert.Equal(t, ErrSourceNotFound.Error(), output.Explain.Error)
+}
+
+func TestHandlerItemExplainRule(t *testing.T) {
+	router, _ := memoRouter()
+	server := NewNormalizedDataServer(router.schema, router, NewMockSecretsManager(), nil)
+
+	pParams := map[string]string{"category": "rule", "vendor": "osintami", "item": "isBot"}
+	qParams := map[string]string{"ip": "1.2.3.4", "explain": "true"}
+	w := httptest.NewRecorder()
+	server.GetItemHandler(w, buildItemRequest(pParams, qParams))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	output := &ExplainedOutput{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), output))
+	assert.True(t, *output.Result.Bool)
+	trace := output.Explain.Rule
+	assert.Equal(t, "[rule/osintami/isCloudNode] && [rule/osintami/isProxy]", trace.Expression)
+	assert.Equal(t, true, trace.Result)
+	assert.Equal(t, 3, len(trace.Items[0].Rule.Items))
+	assert.Equal(t, "rule/osintami/isTor", trace.Items[1].Rule.Items[2].Item)
+}
@@ -0,0 +1,88 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+const JOB_OUTPUT_POLL = 500 * time.Millisecond
+
+// PostJobHandler takes the file as the request body, options ride on the query string
+//
+//	?format=csv&map=ip:client_ip,email:email_address&items=rule/osintami/isBot&categories=ip
+func (x *NormalizedDataServer) PostJobHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	spec := &EnrichJob{
+		Format:     query.Get("format"),
+		Mapping:    make(map[string]string),
+		Items:      splitList(query.Get("items")),
+		Categories: splitList(query.Get("categories")),
+	}
+	for _, pair := range splitList(query.Get("map")) {
+		categoryName, column, found := strings.Cut(pair, ":")
+		if !found || categoryName == "" || column == "" {
+			common.SendError(w, ErrInvalidJob, http.StatusBadRequest)
+			return
+		}
+		spec.Mapping[categoryName] = column
+	}
+
+	job, err := x.jobs.Submit(spec, r.Body)
+	if err == ErrJobQueueFull {
+		common.SendError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	if err == ErrJobTooLarge {
+		common.SendError(w, err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		common.SendError(w, err, http.StatusBadRequest)
+		return
+	}
+	common.SendJSON(w, job)
+}
+
+func (x *NormalizedDataServer) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := x.jobs.Status(common.PathParam(r, "id"))
+	if err != nil {
+		common.SendError(w, err, http.StatusNotFound)
+		return
+	}
+	common.SendJSON(w, job)
+}
+
+// GetJobOutputHandler streams finished chunks and keeps the response open until the job ends
+func (x *NormalizedDataServer) GetJobOutputHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := x.jobs.Status(common.PathParam(r, "id"))
+	if err != nil {
+		common.SendError(w, err, http.StatusNotFound)
+		return
+	}
+
+	if job.Format == JOB_FORMAT_CSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if err := x.jobs.Output(r.Context(), job.ID, w, JOB_OUTPUT_POLL); err != nil {
+		log.Warn().Err(err).Str("component", "jobs").Str("job", job.ID).Msg("output")
+	}
+}
+
+func splitList(value string) []string {
+	out := []string{}
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -2,7 +2,11 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/osintami/fingerprintz/common"
 )
@@ -16,15 +20,51 @@ func (x *NormalizedDataServer) PostEvaluateHandler(w http.ResponseWriter, r *htt
 		common.SendError(w, ErrMissingInputs, http.StatusBadRequest)
 		return
 	}
-	keys[common.INPUT_TYPE] = "JSON"
 	x.EvaluateHandler(w, r, keys)
 }
 
 func (x NormalizedDataServer) EvaluateHandler(w http.ResponseWriter, r *http.Request, keys common.DataInputs) {
+	explain, _ := strconv.ParseBool(keys[common.INPUT_EXPLAIN])
+	delete(keys, common.INPUT_EXPLAIN)
+	if explain {
+		x.ExplainHandler(w, r, keys)
+		return
+	}
+
 	value, err := x.rules.CategoryInfo(r.Context(), CATEGORY_RULE, keys)
-	if err != nil {
+	switch {
+	case err == nil:
+		common.SendJSON(w, value)
+	case errors.Is(err, ErrRuleInputMissing):
+		common.SendError(w, err, http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrInvalidRule), errors.Is(err, ErrInvalidRuleDate), errors.Is(err, ErrInvalidRuleReference),
+		errors.Is(err, ErrUnknownRule), errors.Is(err, ErrUnknownRuleItem), errors.Is(err, ErrRuleCycle),
+		errors.Is(err, ErrRuleDependency), errors.Is(err, ErrInvalidMissingPolicy):
+		common.SendError(w, err, http.StatusBadRequest)
+	default:
 		common.SendError(w, err, http.StatusInternalServerError)
-		return
 	}
-	common.SendJSON(w, value)
+}
+
+// ExplainHandler evaluates the rule with a trace, a rule that fails still answers with the trace
+func (x NormalizedDataServer) ExplainHandler(w http.ResponseWriter, r *http.Request, keys common.DataInputs) {
+	trace := &ItemTrace{Item: keys[common.INPUT_RULE]}
+	start := time.Now()
+	value, err := x.rules.CategoryInfo(WithTrace(r.Context(), trace), CATEGORY_RULE, keys)
+	trace.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+
+	output := &common.DataOutput{Item: trace.Item, Keys: keys}
+	if err == nil {
+		err = json.Unmarshal(value, output)
+	}
+	if err != nil {
+		x.stripKeys(keys)
+		output.Error = err.Error()
+	}
+	if trace.Rule != nil {
+		trace.Value = trace.Rule.Result
+		trace.Error = trace.Rule.Error
+	}
+	trace.Type = output.Result.Type.String()
+	common.SendJSON(w, &ExplainedOutput{DataOutput: output, Explain: trace})
 }
@@ -0,0 +1,117 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/osintami/fingerprintz/common"
+)
+
+// NOTE:  the gateway sets role and account from the API key, the account ends up in the audit log
+func (x *NormalizedDataServer) ruleAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.URL.Query().Get(common.INPUT_ROLE) != "admin" {
+		common.SendError(w, ErrInvalidUserRole, http.StatusForbidden)
+		return "", false
+	}
+	if x.ruleStore == nil {
+		common.SendError(w, ErrNotImplemented, http.StatusNotImplemented)
+		return "", false
+	}
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		account = "unknown"
+	}
+	return account, true
+}
+
+func (x *NormalizedDataServer) ListRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := x.ruleAdmin(w, r); !ok {
+		return
+	}
+	common.SendJSON(w, x.ruleStore.List())
+}
+
+func (x *NormalizedDataServer) CreateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := x.ruleAdmin(w, r)
+	if !ok {
+		return
+	}
+	rule := Item{}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		common.SendError(w, ErrInvalidRuleParam, http.StatusBadRequest)
+		return
+	}
+	change, err := x.ruleStore.Create(rule, account)
+	x.sendRuleChange(w, change, err)
+}
+
+func (x *NormalizedDataServer) UpdateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := x.ruleAdmin(w, r)
+	if !ok {
+		return
+	}
+	rule := Item{}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		common.SendError(w, ErrInvalidRuleParam, http.StatusBadRequest)
+		return
+	}
+	path := CATEGORY_RULE + "/" + SOURCE_OSINTAMI_NAME + "/" + common.PathParam(r, "name")
+	change, err := x.ruleStore.Update(path, rule, account)
+	x.sendRuleChange(w, change, err)
+}
+
+func (x *NormalizedDataServer) DeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := x.ruleAdmin(w, r)
+	if !ok {
+		return
+	}
+	path := CATEGORY_RULE + "/" + SOURCE_OSINTAMI_NAME + "/" + common.PathParam(r, "name")
+	change, err := x.ruleStore.Delete(path, account)
+	x.sendRuleChange(w, change, err)
+}
+
+// RuleAuditHandler lists every change, or the changes of one rule with ?rule=rule/osintami/isBot
+func (x *NormalizedDataServer) RuleAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := x.ruleAdmin(w, r); !ok {
+		return
+	}
+	changes, err := x.ruleStore.Audit(r.URL.Query().Get(common.INPUT_RULE))
+	if err != nil {
+		common.SendError(w, err, http.StatusInternalServerError)
+		return
+	}
+	common.SendJSON(w, changes)
+}
+
+// RuleShadowHandler lists how the shadow queries compare to their rules, or one of them with
+// ?rule=rule/osintami/isBot
+func (x *NormalizedDataServer) RuleShadowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get(common.INPUT_ROLE) != "admin" {
+		common.SendError(w, ErrInvalidUserRole, http.StatusForbidden)
+		return
+	}
+	if x.shadows == nil {
+		common.SendError(w, ErrNotImplemented, http.StatusNotImplemented)
+		return
+	}
+	common.SendJSON(w, x.shadows.Stats(r.URL.Query().Get(common.INPUT_RULE)))
+}
+
+func (x *NormalizedDataServer) sendRuleChange(w http.ResponseWriter, change *RuleChange, err error) {
+	switch {
+	case err == nil:
+		common.SendJSON(w, change)
+	case errors.Is(err, ErrRuleNotFound):
+		common.SendError(w, err, http.StatusNotFound)
+	case errors.Is(err, ErrRuleExists), errors.Is(err, ErrRuleInUse):
+		common.SendError(w, err, http.StatusConflict)
+	case errors.Is(err, ErrInvalidRuleParam), errors.Is(err, ErrInvalidRule), errors.Is(err, ErrInvalidRuleDate),
+		errors.Is(err, ErrInvalidRuleReference), errors.Is(err, ErrUnknownRule), errors.Is(err, ErrUnknownRuleItem),
+		errors.Is(err, ErrRuleCycle), errors.Is(err, ErrRuleDependency), errors.Is(err, ErrInvalidShadow):
+		common.SendError(w, err, http.StatusBadRequest)
+	default:
+		common.SendError(w, err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildRuleRequest(method, name, query, body string) *http.Request {
+	r := httptest.NewRequest(method, "/v1/data/rules?"+query, strings.NewReader(body))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("name", name)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestHandlerRulesAdmin(t *testing.T) {
+	server := nodsServer(false)
+
+	// admins only, and only with a store
+	w := httptest.NewRecorder()
+	server.ListRulesHandler(w, buildRuleRequest(http.MethodGet, "", "role=user", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = httptest.NewRecorder()
+	server.ListRulesHandler(w, buildRuleRequest(http.MethodGet, "", "role=admin", ""))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	schema, dir := ruleStoreSchema(t)
+	store, _ := NewRuleStore(schema, dir+"osintami.json", dir+"rules_audit.json")
+	server.SetRuleStore(store)
+
+	admin := "role=admin&account=admin%40osintami.com"
+	for _, test := range []struct {
+		handler func(w http.ResponseWriter, r *http.Request)
+		method  string
+		name    string
+		body    string
+		status  int
+	}{
+		{server.CreateRuleHandler, http.MethodPost, "", `{"Item":"rule/osintami/isListed","Enabled":true,"Query":"[ip/ipsum/blacklist.isBlacklisted]"}`, http.StatusOK},
+		{server.CreateRuleHandler, http.MethodPost, "", `{"Item":"rule/osintami/isListed","Query":"true"}`, http.StatusConflict},
+		{server.CreateRuleHandler, http.MethodPost, "", `nope`, http.StatusBadRequest},
+		{server.CreateRuleHandler, http.MethodPost, "", `{"Item":"rule/osintami/isLoop","Query":"[rule/osintami/isLoop]"}`, http.StatusBadRequest},
+		{server.UpdateRuleHandler, http.MethodPut, "isListed", `{"Enabled":true,"Query":"!([ip/ipsum/blacklist.isBlacklisted])"}`, http.StatusOK},
+		{server.UpdateRuleHandler, http.MethodPut, "isNope", `{"Query":"true"}`, http.StatusNotFound},
+		{server.DeleteRuleHandler, http.MethodDelete, "isListed", ``, http.StatusOK},
+		{server.DeleteRuleHandler, http.MethodDelete, "isListed", ``, http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		test.handler(w, buildRuleRequest(test.method, test.name, admin, test.body))
+		assert.Equal(t, test.status, w.Code, test.body)
+	}
+
+	w = httptest.NewRecorder()
+	server.RuleAuditHandler(w, buildRuleRequest(http.MethodGet, "", admin+"&rule=rule/osintami/isListed", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	changes := []RuleChange{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, "admin@osintami.com", changes[0].Account)
+	assert.Equal(t, int64(3), changes[2].Version)
+
+	w = httptest.NewRecorder()
+	server.ListRulesHandler(w, buildRuleRequest(http.MethodGet, "", admin, ""))
+	list := &RuleList{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), list))
+	assert.Equal(t, int64(3), list.Version)
+	assert.Equal(t, "rule/osintami/isBlacklisted", list.Rules[0].Path)
+
+	// a missing account is still recorded
+	w = httptest.NewRecorder()
+	server.CreateRuleHandler(w, buildRuleRequest(http.MethodPost, "", "role=admin", `{"Item":"rule/osintami/isListed","Query":"true"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	change := &RuleChange{}
+	json.Unmarshal(w.Body.Bytes(), change)
+	assert.Equal(t, "unknown", change.Account)
+	assert.Equal(t, common.Boolean, change.After.Type.ToDataType(change.After.TypeName))
+}
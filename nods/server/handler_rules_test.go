@@ -2,8 +2,11 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/osintami/fingerprintz/common"
@@ -23,7 +26,8 @@ func TestHandlerRuleNoData(t *testing.T) {
 	server.GetEvaluateHandler(w, r)
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
-	content := "{\"Item\":\"ip/uhb/blacklist.isBlacklisted\",\"Result\":{\"Type\":0,\"Bool\":true},\"Keys\":{\"ip\":\"1.2.3.4\"},\"Error\":\"\"}\n"
+	// the item is the rule that was asked for
+	content := "{\"Item\":\"[ip/ipsum/blacklist.isBlacklisted] || [ip/uhb/blacklist.isBlacklisted]\",\"Result\":{\"Type\":1,\"Bool\":true},\"Keys\":{\"ip\":\"1.2.3.4\"},\"Error\":\"\"}\n"
 	assert.Equal(t, content, w.Body.String())
 }
 
@@ -54,3 +58,74 @@ func TestHandlerRuleBadRule(t *testing.T) {
 	content := common.BuildErrorResponse(ErrMissingInputs)
 	assert.Equal(t, content, w.Body.String())
 }
+
+func TestHandlerRuleMissingFail(t *testing.T) {
+	server := nodsServer(false)
+
+	// the mock router has nothing for uhb, so the fail policy fails the rule
+	qParams := make(map[string]string)
+	qParams["ip"] = "1.2.3.4"
+	qParams["rule"] = "[ip/ipsum/blacklist.isBlacklisted] && [ip/uhb/blacklist.isBlacklisted]"
+	qParams["missing"] = RULE_MISSING_FAIL
+	r := common.BuildRequest(http.MethodGet, "/v1/data/rule", nil, qParams)
+	w := httptest.NewRecorder()
+	server.GetEvaluateHandler(w, r)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	content := common.BuildErrorResponse(fmt.Errorf("%w: %s", ErrRuleInputMissing, "ip/uhb/blacklist.isBlacklisted"))
+	assert.Equal(t, content, w.Body.String())
+
+	// a rule that doesn't parse is the caller's mistake
+	qParams["rule"] = "[ip/ipsum/blacklist.isBlacklisted] ||"
+	r = common.BuildRequest(http.MethodGet, "/v1/data/rule", nil, qParams)
+	w = httptest.NewRecorder()
+	server.GetEvaluateHandler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ErrInvalidRule.Error())
+
+	// and so is a policy that isn't one
+	qParams["rule"] = "[ip/ipsum/blacklist.isBlacklisted]"
+	qParams["missing"] = "nope"
+	r = common.BuildRequest(http.MethodGet, "/v1/data/rule", nil, qParams)
+	w = httptest.NewRecorder()
+	server.GetEvaluateHandler(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), ErrInvalidMissingPolicy.Error())
+}
+
+func TestHandlerRuleExplain(t *testing.T) {
+	server := nodsServer(false)
+
+	qParams := make(map[string]string)
+	qParams["ip"] = "1.2.3.4"
+	qParams["explain"] = "true"
+	qParams["rule"] = "[ip/ipsum/blacklist.isBlacklisted] || [ip/nope/blacklist.isBlacklisted]"
+
+	r := common.BuildRequest(http.MethodGet, "/v1/data/rule", nil, qParams)
+	w := httptest.NewRecorder()
+	server.GetEvaluateHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	output := &ExplainedOutput{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), output))
+	assert.True(t, *output.Result.Bool)
+	assert.Equal(t, "Boolean", output.Explain.Type)
+	assert.Equal(t, common.DataInputs{"ip": "1.2.3.4"}, output.Keys)
+	trace := output.Explain.Rule
+	assert.Equal(t, qParams["rule"], trace.Expression)
+	assert.Equal(t, true, trace.Result)
+	assert.Equal(t, true, trace.Items[0].Value)
+	// the unknown vendor stood in with a default
+	assert.Equal(t, ErrSourceNotFound.Error(), trace.Items[1].Error)
+	assert.Equal(t, "vendor not found, Null -> false", trace.Items[1].Default)
+
+	// a rule that doesn't parse still explains itself
+	qParams["rule"] = "[ip/ipsum/blacklist.isBlacklisted] ||"
+	r = common.BuildRequest(http.MethodGet, "/v1/data/rule", nil, qParams)
+	w = httptest.NewRecorder()
+	server.GetEvaluateHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	output = &ExplainedOutput{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), output))
+	assert.True(t, strings.HasPrefix(output.Error, "rule does not parse"))
+	assert.True(t, strings.HasPrefix(output.Explain.Error, "rule does not parse"))
+}
@@ -30,6 +30,16 @@ func (x *NormalizedDataServer) DictionaryHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
+	// the functions rules can use, instead of the items
+	if wantFunctions, _ := strconv.ParseBool(r.URL.Query().Get("functions")); wantFunctions {
+		if !wantCSV {
+			common.SendJSON(w, RULE_FUNCTIONS)
+		} else {
+			x.FunctionsToCSV(w, RULE_FUNCTIONS)
+		}
+		return
+	}
+
 	adminList := x.schema.ListItems()
 	sort.Slice(adminList, func(i, j int) bool {
 		return adminList[i].Path < adminList[j].Path
@@ -92,3 +102,15 @@ func (x *NormalizedDataServer) ItemToCSV(w http.ResponseWriter, items []ItemInfo
 	}
 	_ = writer.Flush()
 }
+
+func (x *NormalizedDataServer) FunctionsToCSV(w http.ResponseWriter, functions []RuleFunctionInfo) {
+	writer := tabwriter.NewWriter(w, 0, 8, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(writer, "FUNCTION,\tUSAGE,\tDESCRIPTION\n")
+	for _, function := range functions {
+		_, _ = fmt.Fprintf(writer, "%s,\t%s,\t%s\n",
+			function.Name,
+			function.Usage,
+			function.Description)
+	}
+	_ = writer.Flush()
+}
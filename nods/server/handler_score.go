@@ -0,0 +1,44 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"net/http"
+
+	"github.com/osintami/fingerprintz/common"
+)
+
+func (x *NormalizedDataServer) GetScoreHandler(w http.ResponseWriter, r *http.Request) {
+	x.PostScoreHandler(w, r)
+}
+func (x *NormalizedDataServer) PostScoreHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := x.params.Keys(r)
+	if len(keys) == 0 || err != nil {
+		common.SendError(w, ErrMissingInputs, http.StatusBadRequest)
+		return
+	}
+	x.ScoreHandler(w, r, keys)
+}
+
+// ScoreHandler runs every enabled version of a score model against the same inputs, the
+// vendors the versions share are looked up once
+func (x *NormalizedDataServer) ScoreHandler(w http.ResponseWriter, r *http.Request, keys common.DataInputs) {
+	models := ScoreModels(x.schema, common.PathParam(r, "model"))
+	if len(models) == 0 {
+		common.SendError(w, ErrScoreModelNotFound, http.StatusNotFound)
+		return
+	}
+
+	ctx := WithMemo(r.Context())
+	outputs := []*common.DataOutput{}
+	for _, model := range models {
+		inputs := common.DataInputs{}
+		for k, v := range keys {
+			inputs[k] = v
+		}
+		inputs[common.INPUT_RULE] = model.Path
+		output, _ := x.router.DataValue(ctx, NewItemSplitter(model.Path), inputs)
+		x.stripKeys(output.Keys)
+		outputs = append(outputs, output)
+	}
+	common.SendPrettyJSON(w, outputs)
+}
@@ -40,6 +40,8 @@ func (x *NormalizedDataServer) WhoamiHandler(w http.ResponseWriter, r *http.Requ
 func (x *NormalizedDataServer) WhoamiInfo(ctx context.Context, keys map[string]string) *common.WhoamiInfo {
 
 	out := &common.WhoamiInfo{IpAddr: keys[CATEGORY_IPADDR], LastSeen: time.Now().Format(common.GO_DEFAULT_DATE)}
+	// the whoami rules overlap heavily, look each source up once
+	ctx = WithMemo(ctx)
 
 	uri := NewItemSplitter("rule/osintami/isTor")
 	keys[common.INPUT_RULE] = uri.Key()
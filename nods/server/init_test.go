@@ -39,5 +39,6 @@ func nodsServer(dataFail bool) *NormalizedDataServer {
 		schema:  schema,
 		secrets: NewMockSecretsManager(),
 		params:  common.NewParameterHelper(),
-		rules:   NewComplexProvider(rules)}
+		rules:   NewComplexProvider(rules),
+		batch:   NewBatchRunner(router, schema, BATCH_WORKERS, BATCH_MAX_ROWS)}
 }
@@ -33,7 +33,7 @@ func (x *MockDataRouter) DataValue(ctx context.Context, dataURI *DataURI, inputs
 	}
 
 	// source not found
-	if dataURI.SourceName != "ipsum" && dataURI.SourceName != "uhb" && dataURI.SourceName != "osintami" && dataURI.SourceName != "maxmind" && dataURI.SourceName != "pwned" {
+	if dataURI.SourceName != "ipsum" && dataURI.SourceName != "uhb" && dataURI.SourceName != "osintami" && dataURI.SourceName != "maxmind" && dataURI.SourceName != "pwned" && dataURI.SourceName != "fakefilter" {
 		return x.response.EmptyResponse(common.Null, dataURI.ItemName, inputs, ErrSourceNotFound), ErrSourceNotFound
 	}
 
@@ -88,6 +88,36 @@ func (x *MockDataRouter) DataValue(ctx context.Context, dataURI *DataURI, inputs
 		return output, nil
 	}
 
+	if dataURI.URI == "domain/fakefilter/IsFake" && inputs["domain"] != "" {
+		value := true
+		output := &common.DataOutput{
+			Item: "domain/fakefilter/IsFake",
+			Result: common.DataResult{
+				Type: common.Boolean,
+				Raw:  "true",
+				Bool: &value,
+			},
+			Keys:  inputs,
+			Error: "",
+		}
+		return output, nil
+	}
+
+	if dataURI.URI == "ip/maxmind/country" {
+		value := "MX"
+		output := &common.DataOutput{
+			Item: "ip/maxmind/country",
+			Result: common.DataResult{
+				Type: common.String,
+				Raw:  value,
+				Str:  &value,
+			},
+			Keys:  inputs,
+			Error: "",
+		}
+		return output, nil
+	}
+
 	if dataURI.URI == "ip/maxmind/location" {
 		value := "{\"city\":\"Council Bluffs\",\"continent\":\"NA\",\"country\":\"US\",\"latitude\":41.2591,\"longitude\":-95.8517}"
 		output := &common.DataOutput{
@@ -1,7 +1,11 @@
 // Copyright © 2023 OSINTAMI. This is not yours.
 package server
 
-import "github.com/osintami/fingerprintz/common"
+import (
+	"fmt"
+
+	"github.com/osintami/fingerprintz/common"
+)
 
 type MockDataSchema struct {
 }
@@ -92,6 +96,15 @@ func (x *MockDataSchema) IsEnabled(sourceName string) bool {
 	return false
 }
 
+func (x *MockDataSchema) IsConfigured(sourceName string) bool {
+	for _, source := range x.ListSources() {
+		if source.Name == sourceName {
+			return true
+		}
+	}
+	return false
+}
+
 func (x *MockDataSchema) Source(sourceName string) (SourceInfo, error) {
 	return SourceInfo{}, nil
 }
@@ -210,6 +223,14 @@ func (x *MockDataSchema) ListSources() []SourceInfo {
 
 	out = append(out, info)
 
+	info = SourceInfo{
+		Name:          "pwned",
+		Database:      "code",
+		Enabled:       true,
+		MaxConcurrent: 1}
+
+	out = append(out, info)
+
 	info = SourceInfo{
 		Name:     "disabled",
 		Database: "nope",
@@ -243,3 +264,18 @@ func (x *MockDataSchema) ListRulesItems() map[string]Item {
 	}
 	return rules
 }
+
+func (x *MockDataSchema) Refresh(sourceName string) error {
+	return nil
+}
+
+func (x *MockDataSchema) InList(listName, value string) (bool, error) {
+	if listName != "tor" {
+		return false, fmt.Errorf("%w: %s", ErrUnknownList, listName)
+	}
+	return value == "1.2.3.4" || value == "5.6.7.8", nil
+}
+
+func (x *MockDataSchema) Version() int64 {
+	return 0
+}
@@ -0,0 +1,123 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// MockDNSServer answers over UDP from a table, a name it knows without a record of the asked type
+// gets an empty answer, a name it doesn't know gets NXDOMAIN and a failing name gets SERVFAIL
+type MockDNSServer struct {
+	mu      sync.Mutex
+	conn    net.PacketConn
+	records map[string][]dnsmessage.Resource
+	names   map[string]bool
+	failing map[string]bool
+}
+
+func NewMockDNSServer(t *testing.T) *MockDNSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := &MockDNSServer{
+		conn:    conn,
+		records: make(map[string][]dnsmessage.Resource),
+		names:   make(map[string]bool),
+		failing: make(map[string]bool)}
+	go x.serve()
+	t.Cleanup(func() { conn.Close() })
+	return x
+}
+
+func (x *MockDNSServer) Address() string {
+	return x.conn.LocalAddr().String()
+}
+
+func (x *MockDNSServer) add(name string, recordType dnsmessage.Type, body dnsmessage.ResourceBody) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	name = strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+	key := name + "/" + recordType.String()
+	header := dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: recordType, Class: dnsmessage.ClassINET, TTL: 60}
+	x.records[key] = append(x.records[key], dnsmessage.Resource{Header: header, Body: body})
+	x.names[name] = true
+}
+
+func (x *MockDNSServer) A(name, address string) {
+	ip := net.ParseIP(address)
+	if ip4 := ip.To4(); ip4 != nil {
+		a := dnsmessage.AResource{}
+		copy(a.A[:], ip4)
+		x.add(name, dnsmessage.TypeA, &a)
+		return
+	}
+	aaaa := dnsmessage.AAAAResource{}
+	copy(aaaa.AAAA[:], ip.To16())
+	x.add(name, dnsmessage.TypeAAAA, &aaaa)
+}
+
+func (x *MockDNSServer) MX(name, host string, pref uint16) {
+	x.add(name, dnsmessage.TypeMX, &dnsmessage.MXResource{Pref: pref, MX: dnsmessage.MustNewName(strings.TrimSuffix(host, ".") + ".")})
+}
+
+func (x *MockDNSServer) NS(name, host string) {
+	x.add(name, dnsmessage.TypeNS, &dnsmessage.NSResource{NS: dnsmessage.MustNewName(host + ".")})
+}
+
+func (x *MockDNSServer) TXT(name string, txt ...string) {
+	x.add(name, dnsmessage.TypeTXT, &dnsmessage.TXTResource{TXT: txt})
+}
+
+func (x *MockDNSServer) Fail(name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.failing[strings.ToLower(name)+"."] = true
+}
+
+func (x *MockDNSServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := x.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if out, ok := x.answer(buf[:n]); ok {
+			x.conn.WriteTo(out, addr)
+		}
+	}
+}
+
+func (x *MockDNSServer) answer(query []byte) ([]byte, bool) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, false
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, false
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	name := strings.ToLower(question.Name.String())
+	response := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true, RecursionDesired: header.RecursionDesired, RecursionAvailable: true},
+		Questions: []dnsmessage.Question{question}}
+	switch {
+	case x.failing[name]:
+		response.Header.RCode = dnsmessage.RCodeServerFailure
+	case !x.names[name]:
+		response.Header.RCode = dnsmessage.RCodeNameError
+	default:
+		response.Answers = x.records[name+"/"+question.Type.String()]
+	}
+	out, err := response.Pack()
+	return out, err == nil
+}
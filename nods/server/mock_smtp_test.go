@@ -0,0 +1,118 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// MockSMTPServer answers RCPT TO from a table of mailboxes, a domain's other mailboxes get the
+// domain's code (ie. 250 for a catch-all) and everything else 550
+type MockSMTPServer struct {
+	mu          sync.Mutex
+	listener    net.Listener
+	mailboxes   map[string]int
+	domains     map[string]int
+	connections int64
+}
+
+func NewMockSMTPServer(t *testing.T) *MockSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := &MockSMTPServer{
+		listener:  listener,
+		mailboxes: make(map[string]int),
+		domains:   make(map[string]int)}
+	go x.serve()
+	t.Cleanup(func() { listener.Close() })
+	return x
+}
+
+func (x *MockSMTPServer) Port() string {
+	_, port, _ := net.SplitHostPort(x.listener.Addr().String())
+	return port
+}
+
+func (x *MockSMTPServer) Mailbox(email string, code int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.mailboxes[strings.ToLower(email)] = code
+}
+
+func (x *MockSMTPServer) Domain(domain string, code int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.domains[strings.ToLower(domain)] = code
+}
+
+func (x *MockSMTPServer) Connections() int64 {
+	return atomic.LoadInt64(&x.connections)
+}
+
+func (x *MockSMTPServer) serve() {
+	for {
+		conn, err := x.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&x.connections, 1)
+		go x.session(conn)
+	}
+}
+
+func (x *MockSMTPServer) session(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(code int, text string) {
+		fmt.Fprintf(conn, "%d %s\r\n", code, text)
+	}
+
+	reply(220, "mock ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			reply(250, "mock")
+		case "MAIL", "RSET", "NOOP":
+			reply(250, "ok")
+		case "RCPT":
+			code := x.rcpt(line)
+			if code < 300 {
+				reply(code, "ok")
+			} else {
+				reply(code, "mailbox says no")
+			}
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "not implemented")
+		}
+	}
+}
+
+func (x *MockSMTPServer) rcpt(line string) int {
+	email := line[strings.Index(line, "<")+1 : strings.LastIndex(line, ">")]
+	email = strings.ToLower(email)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if code, ok := x.mailboxes[email]; ok {
+		return code
+	}
+	if code, ok := x.domains[email[strings.Index(email, "@")+1:]]; ok {
+		return code
+	}
+	return 550
+}
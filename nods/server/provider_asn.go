@@ -0,0 +1,56 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/osintami/fingerprintz/common"
+)
+
+// ASNProvider serves the asn ETLr output, the mmdb answers for an ip and the fast db next to it
+// answers for an AS number
+type ASNProvider struct {
+	prefixes IDataProvider
+	numbers  IDataProvider
+}
+
+func NewASNProvider(tools *Toolbox, reader common.IMaxmindReader, dbName string) (IDataProvider, error) {
+	numbers, err := NewFastDBProvider(tools, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return &ASNProvider{
+		prefixes: NewMMDBProvider(tools.Watcher, reader),
+		numbers:  numbers}, nil
+}
+
+func (x *ASNProvider) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	switch categoryName {
+	case CATEGORY_IPADDR:
+		return x.prefixes.CategoryInfo(ctx, categoryName, inputs)
+	case CATEGORY_ASN:
+		number, err := ASNumber(inputs[categoryName])
+		if err != nil {
+			return nil, err
+		}
+		return x.numbers.CategoryInfo(ctx, categoryName, common.DataInputs{CATEGORY_ASN: number})
+	}
+	return nil, ErrNotImplemented
+}
+
+func (x *ASNProvider) IsCached() bool {
+	return false
+}
+
+// ASNumber takes an AS number the way people write it (ie. AS13335, as13335, 13335)
+func ASNumber(value string) (string, error) {
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "AS")
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || number == 0 {
+		return "", ErrInvalidASN
+	}
+	return strconv.FormatUint(number, 10), nil
+}
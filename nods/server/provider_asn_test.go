@@ -0,0 +1,53 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func asnProvider(t *testing.T) IDataProvider {
+	tools := mockToolbox()
+	tools.DataPath = "./test/"
+	reader, err := common.NewMaxmindReader("./test/asn.mmdb")
+	assert.Nil(t, err)
+	provider, err := NewASNProvider(tools, reader, "asn")
+	assert.Nil(t, err)
+	assert.False(t, provider.IsCached())
+	return provider
+}
+
+func TestASNProvider(t *testing.T) {
+	provider := asnProvider(t)
+
+	data, err := provider.CategoryInfo(context.TODO(), CATEGORY_IPADDR, common.DataInputs{CATEGORY_IPADDR: "1.2.3.4"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(64500), gjson.GetBytes(data, "asn.number").Int())
+	assert.Equal(t, "hosting", gjson.GetBytes(data, "asn.type").String())
+
+	for _, number := range []string{"64500", "AS64500", "as64500", " 64500 "} {
+		data, err = provider.CategoryInfo(context.TODO(), CATEGORY_ASN, common.DataInputs{CATEGORY_ASN: number})
+		assert.Nil(t, err, number)
+		assert.Equal(t, "EXAMPLE-HOSTING-LLC - Example Hosting, LLC", gjson.GetBytes(data, "asn.org").String(), number)
+	}
+}
+
+func TestASNProviderNoResults(t *testing.T) {
+	provider := asnProvider(t)
+
+	data, err := provider.CategoryInfo(context.TODO(), CATEGORY_ASN, common.DataInputs{CATEGORY_ASN: "AS64501"})
+	assert.Equal(t, common.ErrNoDataPresent, err)
+	assert.Nil(t, data)
+
+	for _, number := range []string{"", "AS", "ASN64500", "0", "4294967296"} {
+		_, err = provider.CategoryInfo(context.TODO(), CATEGORY_ASN, common.DataInputs{CATEGORY_ASN: number})
+		assert.Equal(t, ErrInvalidASN, err, number)
+	}
+
+	_, err = provider.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, common.DataInputs{CATEGORY_DOMAIN: "nope.com"})
+	assert.Equal(t, ErrNotImplemented, err)
+}
@@ -5,29 +5,19 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/osintami/fingerprintz/common"
 	"github.com/tidwall/gjson"
 )
 
-type EvalResult struct {
-	Type int
-	Bool bool
-}
-
-type EvalOutput struct {
-	Item   string
-	Result EvalResult
-	Keys   common.DataInputs
-	Error  string
-}
-
 type ComplexProvider struct {
-	rules IRuleProvider
+	rules    IRuleProvider
+	response *DataResponse
 }
 
 func NewComplexProvider(rules IRuleProvider) IDataProvider {
-	return &ComplexProvider{rules: rules}
+	return &ComplexProvider{rules: rules, response: NewDataResponse()}
 }
 
 func (x *ComplexProvider) IsCached() bool {
@@ -60,29 +50,76 @@ func (x *ComplexProvider) CategoryInfo(ctx context.Context, categoryName string,
 
 	nods := Item{
 		Query:    query,
-		TypeName: inputs["type"],
+		TypeName: inputs[common.INPUT_TYPE],
+		Missing:  inputs[common.INPUT_MISSING],
 		Gjson:    "Value"}
 
+	item := inputs[common.INPUT_RULE]
+
+	// NOTE:  the error goes back as is, the handler picks the status from it
 	data, err := x.rules.Evaluate(ctx, nods, inputs)
 	if err != nil {
-		return nil, ErrItemNotFound
+		return nil, err
 	}
 
-	item := inputs[common.INPUT_RULE]
-
 	delete(inputs, common.INPUT_ROLE)
 	delete(inputs, common.INPUT_KEY)
 	delete(inputs, common.INPUT_TYPE)
 	delete(inputs, common.INPUT_RULE)
+	delete(inputs, common.INPUT_MISSING)
 
 	value := gjson.GetBytes(data, "Value")
-	// TODO:  figure out how to handle any type output from a rule
-	result := EvalResult{Type: 0, Bool: value.Bool()}
-	output := EvalOutput{
+	dataType := ruleOutputType(nods.TypeName, value)
+	output := common.DataOutput{
 		Item:   item,
-		Result: result,
+		Result: x.response.MarshalResult(ruleOutputValue(dataType, value), dataType),
 		Keys:   inputs,
 		Error:  ""}
+	if value.Type == gjson.Null {
+		output.Result.Unknown = true
+		output.Error = ErrRuleUnknown.Error()
+	}
 
 	return json.Marshal(output)
 }
+
+// ruleOutputType is the type asked for, otherwise whatever govaluate came back with
+func ruleOutputType(typeName string, value gjson.Result) common.DataType {
+	if dataType := common.Null.ToDataType(typeName); dataType.String() == typeName {
+		return dataType
+	}
+	switch value.Type {
+	case gjson.True, gjson.False:
+		return common.Boolean
+	case gjson.Number:
+		return common.Float
+	case gjson.String:
+		return common.String
+	case gjson.JSON:
+		return common.JSON
+	}
+	return common.Null
+}
+
+// ruleOutputValue bends the value into the shape MarshalResult expects for the type
+func ruleOutputValue(dataType common.DataType, value gjson.Result) gjson.Result {
+	switch dataType {
+	case common.Date:
+		// NOTE:  dates are unix times inside a rule, turn them back into dates
+		if value.Type == gjson.Number {
+			date := time.Unix(value.Int(), 0).In(time.Local).Format("2006-01-02 15:04:05")
+			return gjson.Result{Type: gjson.String, Raw: date, Str: date}
+		}
+		fallthrough
+	case common.String:
+		if value.Type != gjson.String {
+			return gjson.Result{Type: gjson.String, Raw: value.Raw, Str: value.String()}
+		}
+	case common.JSON:
+		// JSON items come through rules as strings
+		if value.Type == gjson.String && gjson.Valid(value.Str) {
+			return gjson.Parse(value.Str)
+		}
+	}
+	return value
+}
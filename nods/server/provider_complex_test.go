@@ -4,7 +4,9 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/osintami/fingerprintz/common"
 	"github.com/stretchr/testify/assert"
@@ -16,15 +18,64 @@ func TestComplexProvider(t *testing.T) {
 
 	inputs := make(map[string]string)
 	inputs[CATEGORY_IPADDR] = "1.2.3.4"
-	inputs[common.INPUT_TYPE] = "JSON"
 	inputs[common.INPUT_RULE] = "[ip/ipsum/blacklist.isBlacklisted]||[ip/uhb/blacklist.isBlacklisted]"
 
 	data, err := provider.CategoryInfo(context.TODO(), CATEGORY_RULE, inputs)
 	assert.Nil(t, err)
 
-	var eval EvalOutput
-	json.Unmarshal(data, &eval)
-	assert.Equal(t, true, eval.Result.Bool)
+	var output common.DataOutput
+	json.Unmarshal(data, &output)
+	assert.Equal(t, common.Boolean, output.Result.Type)
+	assert.Equal(t, true, *output.Result.Bool)
+	assert.Equal(t, common.DataInputs{CATEGORY_IPADDR: "1.2.3.4"}, output.Keys)
+}
+
+// valueRuleProvider answers every rule with the same govaluate result
+type valueRuleProvider struct {
+	MockRuleProvider
+	value string
+}
+
+func (x *valueRuleProvider) Evaluate(ctx context.Context, nods Item, inputs common.DataInputs) ([]byte, error) {
+	return []byte(`{"Value":` + x.value + `}`), nil
+}
+
+func TestComplexProviderTypes(t *testing.T) {
+	date := time.Date(2023, 4, 1, 12, 30, 0, 0, time.Local)
+	for _, test := range []struct {
+		value    string
+		typeName string
+		dataType common.DataType
+		result   string
+	}{
+		// inferred from the result
+		{`true`, "", common.Boolean, `{"Type":1,"Bool":true}`},
+		{`42.5`, "", common.Float, `{"Type":2,"Num":42.5}`},
+		{`"tor"`, "", common.String, `{"Type":4,"Str":"tor"}`},
+		{`{"score":3}`, "", common.JSON, `{"Type":6,"Str":"{\"score\":3}"}`},
+		{`null`, "", common.Null, `{"Type":0,"Unknown":true}`},
+		// asked for
+		{`42`, "Integer", common.Integer, `{"Type":3,"Num":42}`},
+		{`42`, "String", common.String, `{"Type":4,"Str":"42"}`},
+		{`1`, "Boolean", common.Boolean, `{"Type":1,"Bool":true}`},
+		{`"{\"score\":3}"`, "JSON", common.JSON, `{"Type":6,"Str":"{\"score\":3}"}`},
+		{strconv.FormatInt(date.Unix(), 10), "Date", common.Date, `{"Type":5,"Str":"2023-04-01 12:30:00"}`},
+		{`"2023-04-01"`, "Date", common.Date, `{"Type":5,"Str":"2023-04-01"}`},
+		// not a type, falls back to the result
+		{`7`, "Nope", common.Float, `{"Type":2,"Num":7}`},
+	} {
+		provider := NewComplexProvider(&valueRuleProvider{value: test.value})
+		inputs := common.DataInputs{common.INPUT_RULE: "[ip/ipsum/score]", common.INPUT_TYPE: test.typeName}
+
+		data, err := provider.CategoryInfo(context.TODO(), CATEGORY_RULE, inputs)
+		assert.Nil(t, err)
+		var output common.DataOutput
+		assert.Nil(t, json.Unmarshal(data, &output))
+		assert.Equal(t, test.dataType, output.Result.Type, test.value)
+		result, _ := json.Marshal(output.Result)
+		assert.Equal(t, test.result, string(result), test.value)
+		assert.Equal(t, "[ip/ipsum/score]", output.Item)
+	}
 }
 
 func TestComplexProviderInvalidCategory(t *testing.T) {
@@ -0,0 +1,62 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+// SSTDBProvider serves sorted block key/value files, only the block index is held in memory
+type SSTDBProvider struct {
+	dbFile string
+	reader *common.SSTableReader
+	mu     sync.RWMutex
+}
+
+func NewSSTDBProvider(tools *Toolbox, dbName string) (IDataProvider, error) {
+	x := &SSTDBProvider{
+		dbFile: tools.DataPath + dbName + ".sst",
+	}
+	x.refresh()
+	if tools.Watcher != nil {
+		_ = tools.Watcher.Add(x.dbFile, x.refresh)
+	}
+	return x, nil
+}
+
+func (x *SSTDBProvider) refresh() {
+	reader, err := common.NewSSTableReader(x.dbFile)
+	if err != nil {
+		log.Error().Err(err).Str("component", "sstdb item provider").Str("source", x.dbFile).Msg("refresh")
+		return
+	}
+	x.mu.Lock()
+	old := x.reader
+	x.reader = reader
+	x.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
+
+func (x *SSTDBProvider) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.reader == nil {
+		return nil, common.ErrNoDataPresent
+	}
+	result, found := x.reader.Get(inputs[categoryName])
+	if !found {
+		log.Warn().Str("component", "sstdb item provider").Str("source", x.dbFile).Str("key", inputs[categoryName]).Msg("category info")
+		return nil, common.ErrNoDataPresent
+	}
+	return result, nil
+}
+
+func (x *SSTDBProvider) IsCached() bool {
+	return false
+}
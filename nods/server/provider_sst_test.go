@@ -0,0 +1,59 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestSSTProvider(t *testing.T) {
+	writer, err := common.NewSSTableWriter("/tmp/fakefilter.sst")
+	assert.Nil(t, err)
+	assert.Nil(t, writer.Append("nope.com", []byte(`{"Result":{"IsFake":true}}`)))
+	assert.Nil(t, writer.Close())
+	defer os.Remove("/tmp/fakefilter.sst")
+
+	tools := mockToolbox()
+	tools.DataPath = "/tmp/"
+	provider, err := NewSSTDBProvider(tools, "fakefilter")
+	assert.Nil(t, err)
+	assert.False(t, provider.IsCached())
+
+	inputs := common.DataInputs{}
+	inputs[CATEGORY_DOMAIN] = "nope.com"
+	data, err := provider.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, inputs)
+	assert.Nil(t, err)
+	assert.True(t, gjson.GetBytes(data, "Result.IsFake").Bool())
+
+	inputs[CATEGORY_DOMAIN] = "nunya.com"
+	data, err = provider.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, inputs)
+	assert.Equal(t, common.ErrNoDataPresent, err)
+	assert.Nil(t, data)
+
+	// a new snapshot replaces the reader
+	writer, _ = common.NewSSTableWriter("/tmp/fakefilter.sst")
+	writer.Append("nunya.com", []byte(`{"Result":{"IsFake":false}}`))
+	writer.Close()
+	provider.(*SSTDBProvider).refresh()
+	data, err = provider.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, inputs)
+	assert.Nil(t, err)
+	assert.False(t, gjson.GetBytes(data, "Result.IsFake").Bool())
+}
+
+func TestSSTProviderMissingFile(t *testing.T) {
+	tools := mockToolbox()
+	tools.DataPath = "./test/"
+	provider, err := NewSSTDBProvider(tools, "nope")
+	assert.Nil(t, err)
+
+	inputs := common.DataInputs{}
+	inputs[CATEGORY_DOMAIN] = "nope.com"
+	data, err := provider.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, inputs)
+	assert.Equal(t, common.ErrNoDataPresent, err)
+	assert.Nil(t, data)
+}
@@ -8,3 +8,15 @@ var ErrInvalidSourceParam = errors.New("source parameter missing or invalid")
 var ErrInvalidCategoryParam = errors.New("category parameter missing or invalid")
 var ErrInvalidCategoryInputParam = errors.New("path parameter category does not match query parameter name")
 var ErrInvalidUserRole = errors.New("role parameter missing or invalid")
+var ErrInvalidBatch = errors.New("batch needs inputs and items or categories")
+var ErrBatchTooLarge = errors.New("batch has too many rows")
+var ErrInvalidJob = errors.New("job needs csv or ndjson, a category mapping and items or categories")
+var ErrJobNotFound = errors.New("job not found")
+var ErrJobQueueFull = errors.New("too many jobs waiting, try again later")
+var ErrJobTooLarge = errors.New("job upload is too large")
+var ErrInvalidRow = errors.New("invalid row")
+var ErrInvalidRuleParam = errors.New("rule needs an item named rule/osintami/{name}, a query or a score and a known type")
+var ErrRuleExists = errors.New("rule already exists")
+var ErrRuleNotFound = errors.New("rule not found")
+var ErrRuleInUse = errors.New("rule is used by other rules")
+var ErrScoreModelNotFound = errors.New("score model not found")
@@ -13,6 +13,22 @@ type ServerConfig struct {
 	PathPrefix string `env:"PATH_PREFIX" envDefault:"/"`
 	ListenAddr string `env:"LISTEN_ADDR,required" envDefault:"127.0.0.1:8082"`
 	LogLevel   string `env:"LOG_LEVEL" envDefault:"INFO"`
+	// batch lookups
+	BatchWorkers int `env:"BATCH_WORKERS" envDefault:"16"`
+	BatchMaxRows int `env:"BATCH_MAX_ROWS" envDefault:"1000"`
+	// bulk enrichment jobs
+	JobsPath     string `env:"JOBS_PATH" envDefault:"/home/osintami/jobs/"`
+	JobWorkers   int    `env:"JOB_WORKERS" envDefault:"1"`
+	JobChunkRows int    `env:"JOB_CHUNK_ROWS" envDefault:"500"`
+	JobMaxUpload int64  `env:"JOB_MAX_UPLOAD" envDefault:"1073741824"`
+	// rule management
+	RulesAuditFile string `env:"RULES_AUDIT_FILE" envDefault:"/home/osintami/logs/rules_audit.json"`
+	// dns source, host:port or empty for the system resolver
+	DNSResolver string `env:"DNS_RESOLVER" envDefault:""`
+	// smtp source, EHLO name and MAIL FROM address mail exchangers see
+	SMTPPort  string `env:"SMTP_PORT" envDefault:"25"`
+	SMTPHello string `env:"SMTP_HELLO" envDefault:"osintami.com"`
+	SMTPFrom  string `env:"SMTP_FROM" envDefault:"verify@osintami.com"`
 }
 
 type NormalizedDataServer struct {
@@ -21,6 +37,11 @@ type NormalizedDataServer struct {
 	secrets common.ISecrets
 	params  common.IParameterHelper
 	rules   IDataProvider
+	batch   *BatchRunner
+	jobs    *JobManager
+	// NOTE:  named apart from rules, the provider that evaluates ad-hoc rules
+	ruleStore *RuleStore
+	shadows   *ShadowStore
 }
 
 func NewNormalizedDataServer(schema IDataSchema, router IDataRouter, secrets common.ISecrets, rules IDataProvider) *NormalizedDataServer {
@@ -29,5 +50,26 @@ func NewNormalizedDataServer(schema IDataSchema, router IDataRouter, secrets com
 		schema:  schema,
 		secrets: secrets,
 		params:  common.NewParameterHelper(),
-		rules:   rules}
+		rules:   rules,
+		batch:   NewBatchRunner(router, schema, BATCH_WORKERS, BATCH_MAX_ROWS)}
+}
+
+func (x *NormalizedDataServer) SetBatchLimits(workers, maxRows int) {
+	x.batch = NewBatchRunner(x.router, x.schema, workers, maxRows)
+}
+
+func (x *NormalizedDataServer) Batch() *BatchRunner {
+	return x.batch
+}
+
+func (x *NormalizedDataServer) SetJobManager(jobs *JobManager) {
+	x.jobs = jobs
+}
+
+func (x *NormalizedDataServer) SetRuleStore(store *RuleStore) {
+	x.ruleStore = store
+}
+
+func (x *NormalizedDataServer) SetShadowStore(shadows *ShadowStore) {
+	x.shadows = shadows
 }
@@ -0,0 +1,235 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+	"golang.org/x/net/publicsuffix"
+)
+
+// the rule deciding whether an address the domain resolves to is in the cloud
+const DNS_CLOUD_RULE = "rule/osintami/isCloudNode"
+
+type dnsProvider struct {
+	host     *regexp.Regexp
+	provider string
+}
+
+// hostSuffix matches a host that is, or is under, one of the domains
+func hostSuffix(domains ...string) *regexp.Regexp {
+	for i := range domains {
+		domains[i] = regexp.QuoteMeta(domains[i])
+	}
+	return regexp.MustCompile(`(^|\.)(` + strings.Join(domains, "|") + `)$`)
+}
+
+var dnsMailProviders = []dnsProvider{
+	{hostSuffix("google.com", "googlemail.com"), "google"},
+	{hostSuffix("outlook.com", "hotmail.com"), "microsoft"},
+	{hostSuffix("yahoodns.net"), "yahoo"},
+	{hostSuffix("icloud.com"), "apple"},
+	{hostSuffix("zoho.com", "zoho.eu"), "zoho"},
+	{hostSuffix("protonmail.ch"), "proton"},
+	{hostSuffix("messagingengine.com"), "fastmail"},
+	{hostSuffix("yandex.net", "yandex.ru"), "yandex"},
+	{hostSuffix("mimecast.com"), "mimecast"},
+	{hostSuffix("pphosted.com", "ppe-hosted.com"), "proofpoint"},
+	{hostSuffix("barracudanetworks.com"), "barracuda"},
+	{hostSuffix("secureserver.net"), "godaddy"},
+	{hostSuffix("amazonaws.com"), "amazon"},
+}
+
+var dnsNameServerProviders = []dnsProvider{
+	{regexp.MustCompile(`(^|\.)awsdns-[0-9]+\.(com|net|org|co\.uk)$`), "amazon"},
+	{hostSuffix("cloudflare.com"), "cloudflare"},
+	{hostSuffix("googledomains.com", "google.com"), "google"},
+	{hostSuffix("azure-dns.com", "azure-dns.net", "azure-dns.org", "azure-dns.info"), "microsoft"},
+	{hostSuffix("domaincontrol.com"), "godaddy"},
+	{hostSuffix("registrar-servers.com"), "namecheap"},
+	{hostSuffix("digitalocean.com"), "digitalocean"},
+	{hostSuffix("nsone.net"), "ns1"},
+	{hostSuffix("dynect.net"), "dyn"},
+	{hostSuffix("ultradns.com", "ultradns.net", "ultradns.org", "ultradns.biz"), "ultradns"},
+}
+
+// name servers of domain parking and for-sale services
+var dnsParkingNameServers = hostSuffix("sedoparking.com", "parkingcrew.net", "bodis.com", "above.com", "parklogic.com", "dan.com", "afternic.com", "uniregistrymarket.link", "parked.com")
+
+type DNSInfo struct {
+	Domain          string
+	Resolves        bool
+	Addresses       []string
+	HasMX           bool
+	MX              []string
+	MXProvider      string
+	NS              []string
+	NSProvider      string
+	HasSPF          bool
+	SPF             string
+	HasDMARC        bool
+	DMARCPolicy     string
+	IsParked        bool
+	ResolvesToCloud bool
+}
+
+type DNSSource struct {
+	resolver common.IResolver
+	router   IDataRouter
+}
+
+func NewDNSSource(tools *Toolbox, resolver common.IResolver, router IDataRouter) IDataSource {
+	return NewDataInstance(tools, SOURCE_DNS_NAME, &DNSSource{resolver: resolver, router: router})
+}
+
+func (x *DNSSource) IsCached() bool {
+	return true
+}
+
+func (x *DNSSource) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	switch categoryName {
+	case CATEGORY_DOMAIN:
+		return x.getDomainInfo(ctx, inputs[categoryName])
+	}
+	return nil, ErrNotImplemented
+}
+
+func (x *DNSSource) getDomainInfo(ctx context.Context, domain string) (json.RawMessage, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	info := &DNSInfo{Domain: domain}
+
+	// NOTE:  a missing record is an answer, a lookup that failed isn't and keeps this out of the cache
+	var mu sync.Mutex
+	var failed error
+	lookup := func(wg *sync.WaitGroup, recordType string, fn func() error) {
+		defer wg.Done()
+		if err := fn(); err != nil && !common.IsDNSNotFound(err) {
+			log.Warn().Err(err).Str("component", "dns").Str("domain", domain).Str("type", recordType).Msg("lookup")
+			mu.Lock()
+			failed = err
+			mu.Unlock()
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(5)
+	go lookup(wg, "A", func() error {
+		addresses, err := x.resolver.LookupHost(ctx, domain)
+		sort.Strings(addresses)
+		info.Addresses = addresses
+		return err
+	})
+	go lookup(wg, "MX", func() error {
+		records, err := x.resolver.LookupMX(ctx, domain)
+		for _, record := range records {
+			// NOTE:  a null MX (RFC 7505) says the domain takes no mail
+			if host := strings.TrimSuffix(strings.ToLower(record.Host), "."); host != "" {
+				info.MX = append(info.MX, host)
+			}
+		}
+		return err
+	})
+	go lookup(wg, "NS", func() error {
+		records, err := x.resolver.LookupNS(ctx, domain)
+		for _, record := range records {
+			info.NS = append(info.NS, strings.TrimSuffix(strings.ToLower(record.Host), "."))
+		}
+		sort.Strings(info.NS)
+		return err
+	})
+	go lookup(wg, "TXT", func() error {
+		records, err := x.resolver.LookupTXT(ctx, domain)
+		for _, record := range records {
+			if strings.HasPrefix(strings.ToLower(record), "v=spf1") {
+				info.SPF = record
+			}
+		}
+		return err
+	})
+	go lookup(wg, "DMARC", func() error {
+		records, err := x.resolver.LookupTXT(ctx, "_dmarc."+domain)
+		for _, record := range records {
+			if policy, ok := dmarcPolicy(record); ok {
+				info.DMARCPolicy = policy
+			}
+		}
+		return err
+	})
+	wg.Wait()
+
+	info.Resolves = len(info.Addresses) > 0
+	info.HasMX = len(info.MX) > 0
+	info.HasSPF = info.SPF != ""
+	info.HasDMARC = info.DMARCPolicy != ""
+	info.MXProvider = hostProvider(info.MX, dnsMailProviders)
+	info.NSProvider = hostProvider(info.NS, dnsNameServerProviders)
+	for _, host := range info.NS {
+		if dnsParkingNameServers.MatchString(host) {
+			info.IsParked = true
+		}
+	}
+	info.ResolvesToCloud = x.resolvesToCloud(ctx, info.Addresses)
+
+	out, _ := json.Marshal(info)
+	if failed != nil {
+		return out, fmt.Errorf("%w: %s", ErrDNSLookup, failed.Error())
+	}
+	return out, nil
+}
+
+// resolvesToCloud asks the cloud rule about every address, the rule knows the cloud vendors
+func (x *DNSSource) resolvesToCloud(ctx context.Context, addresses []string) bool {
+	if x.router == nil {
+		return false
+	}
+	for _, address := range addresses {
+		inputs := common.DataInputs{CATEGORY_IPADDR: address, common.INPUT_RULE: DNS_CLOUD_RULE}
+		output, err := x.router.DataValue(ctx, NewItemSplitter(DNS_CLOUD_RULE), inputs)
+		if err == nil && output.Result.Bool != nil && *output.Result.Bool {
+			return true
+		}
+	}
+	return false
+}
+
+// hostProvider names the provider behind the hosts, hosts nobody knows go by the registered
+// domain of the first one (ie. mx1.example.net is example.net)
+func hostProvider(hosts []string, providers []dnsProvider) string {
+	if len(hosts) == 0 {
+		return ""
+	}
+	for _, host := range hosts {
+		for _, provider := range providers {
+			if provider.host.MatchString(host) {
+				return provider.provider
+			}
+		}
+	}
+	registered, err := publicsuffix.EffectiveTLDPlusOne(hosts[0])
+	if err != nil {
+		return hosts[0]
+	}
+	return registered
+}
+
+// dmarcPolicy reads p= out of a DMARC record (ie. v=DMARC1; p=reject; rua=mailto:...)
+func dmarcPolicy(record string) (string, bool) {
+	tags := strings.Split(record, ";")
+	if !strings.EqualFold(strings.TrimSpace(tags[0]), "v=DMARC1") {
+		return "", false
+	}
+	for _, tag := range tags[1:] {
+		name, value, found := strings.Cut(strings.TrimSpace(tag), "=")
+		if found && strings.EqualFold(strings.TrimSpace(name), "p") {
+			return strings.ToLower(strings.TrimSpace(value)), true
+		}
+	}
+	return "", false
+}
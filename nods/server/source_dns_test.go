@@ -0,0 +1,121 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func dnsServer(t *testing.T) *MockDNSServer {
+	server := NewMockDNSServer(t)
+	server.A("example.com", "1.2.3.4")
+	server.A("example.com", "2606:4700::1")
+	server.MX("example.com", "alt1.aspmx.l.google.com", 5)
+	server.MX("example.com", "aspmx.l.google.com", 1)
+	server.NS("example.com", "ns-1.awsdns-01.com")
+	server.NS("example.com", "ns-2.awsdns-02.net")
+	server.TXT("example.com", "google-site-verification=nope")
+	server.TXT("example.com", "v=spf1 include:_spf.google.com ~all")
+	server.TXT("_dmarc.example.com", "v=DMARC1; p=Reject; rua=mailto:dmarc@example.com")
+
+	server.A("forsale.net", "4.3.2.1")
+	server.NS("forsale.net", "ns1.sedoparking.com")
+	server.NS("forsale.net", "ns2.sedoparking.com")
+	server.TXT("_dmarc.forsale.net", "not a dmarc record")
+
+	server.MX("nope.org", "mail.nope.org", 10)
+	server.NS("nope.org", "ns1.nope-dns.co.uk")
+	server.MX("nomail.org", ".", 0)
+
+	server.Fail("broken.com")
+	return server
+}
+
+func TestDNSSource(t *testing.T) {
+	server := dnsServer(t)
+	source := NewDNSSource(mockToolbox(), common.NewResolver(server.Address()), NewMockDataRouter(false))
+	assert.True(t, source.IsCached())
+
+	data, err := source.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, common.DataInputs{CATEGORY_DOMAIN: "Example.COM."})
+	assert.Nil(t, err)
+	info := gjson.ParseBytes(data)
+	assert.Equal(t, "example.com", info.Get("Domain").String())
+	assert.True(t, info.Get("HasMX").Bool())
+	assert.Equal(t, "aspmx.l.google.com", info.Get("MX.0").String())
+	assert.Equal(t, "google", info.Get("MXProvider").String())
+	assert.Equal(t, "amazon", info.Get("NSProvider").String())
+	assert.True(t, info.Get("HasSPF").Bool())
+	assert.Equal(t, "v=spf1 include:_spf.google.com ~all", info.Get("SPF").String())
+	assert.True(t, info.Get("HasDMARC").Bool())
+	assert.Equal(t, "reject", info.Get("DMARCPolicy").String())
+	assert.Equal(t, `["1.2.3.4","2606:4700::1"]`, info.Get("Addresses").Raw)
+	assert.False(t, info.Get("IsParked").Bool())
+	// the mock router has 1.2.3.4 in the cloud
+	assert.True(t, info.Get("ResolvesToCloud").Bool())
+
+	data, err = source.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, common.DataInputs{CATEGORY_DOMAIN: "forsale.net"})
+	assert.Nil(t, err)
+	info = gjson.ParseBytes(data)
+	assert.True(t, info.Get("IsParked").Bool())
+	assert.False(t, info.Get("HasMX").Bool())
+	assert.False(t, info.Get("HasDMARC").Bool())
+	assert.Equal(t, "", info.Get("MXProvider").String())
+	assert.False(t, info.Get("ResolvesToCloud").Bool())
+
+	// nobody we know, the registered domain of the host
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, common.DataInputs{CATEGORY_DOMAIN: "nope.org"})
+	info = gjson.ParseBytes(data)
+	assert.Equal(t, "nope.org", info.Get("MXProvider").String())
+	assert.Equal(t, "nope-dns.co.uk", info.Get("NSProvider").String())
+	assert.False(t, info.Get("Resolves").Bool())
+
+	// a null MX takes no mail
+	data, err = source.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, common.DataInputs{CATEGORY_DOMAIN: "nomail.org"})
+	assert.Nil(t, err)
+	assert.False(t, gjson.GetBytes(data, "HasMX").Bool())
+
+	// no such domain is an answer
+	data, err = source.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, common.DataInputs{CATEGORY_DOMAIN: "nxdomain.com"})
+	assert.Nil(t, err)
+	assert.False(t, gjson.GetBytes(data, "Resolves").Bool())
+
+	_, err = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "18001234567"})
+	assert.Equal(t, ErrNotImplemented, err)
+}
+
+func TestDNSSourceFailure(t *testing.T) {
+	server := dnsServer(t)
+	tools := mockToolbox()
+	tools.Cache = common.NewFastCache()
+	source := NewDNSSource(tools, common.NewResolver(server.Address()), nil)
+
+	// a lookup that failed isn't cached, an answer is
+	_, err := source.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, common.DataInputs{CATEGORY_DOMAIN: "broken.com"})
+	assert.True(t, errors.Is(err, ErrDNSLookup))
+	_, found := tools.Cache.Get(CATEGORY_DOMAIN + "/" + SOURCE_DNS_NAME + "/broken.com")
+	assert.False(t, found)
+
+	data, err := source.CategoryInfo(context.TODO(), CATEGORY_DOMAIN, common.DataInputs{CATEGORY_DOMAIN: "example.com"})
+	assert.Nil(t, err)
+	assert.False(t, gjson.GetBytes(data, "ResolvesToCloud").Bool())
+	_, found = tools.Cache.Get(CATEGORY_DOMAIN + "/" + SOURCE_DNS_NAME + "/example.com")
+	assert.True(t, found)
+}
+
+func TestDMARCPolicy(t *testing.T) {
+	for record, policy := range map[string]string{
+		"v=DMARC1; p=none":                    "none",
+		"v=DMARC1;p=quarantine;pct=50":        "quarantine",
+		" V=DMARC1 ; sp=none ; P = reject ":   "reject",
+		"v=DMARC1; rua=mailto:d@example.com":  "",
+		"v=spf1 include:_spf.google.com ~all": "",
+	} {
+		found, _ := dmarcPolicy(record)
+		assert.Equal(t, policy, found, record)
+	}
+}
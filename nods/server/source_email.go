@@ -4,59 +4,186 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"regexp"
 	"strings"
+	"sync"
 	"unicode"
 
 	"github.com/mcnijman/go-emailaddress"
 	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	// input with the name the account was opened under, optional
+	INPUT_NAME = "name"
+	// a list of top level domains in the data path (ie. ["xyz", "tk"])
+	RISKY_TLDS_FILE = "risky.tlds.json"
+	// local part scores at or over these are flagged
+	EMAIL_KEYBOARD_WALK_SCORE = 0.5
+	EMAIL_GIBBERISH_SCORE     = 0.5
+	EMAIL_NAME_MATCH_SCORE    = 0.75
 )
 
 type EmailInfo struct {
 	Email              string
+	CanonicalEmail     string
 	IsWeirdUserName    bool
 	IsWeirdDomainName  bool
 	IsEmailAlias       bool
 	IsValidEmail       bool
 	IsValidIcannSuffix bool
 	IsNefariusDomain   bool
+	IsFreeProvider     bool
+	IsRoleAccount      bool
+	KeyboardWalkScore  float64
+	IsKeyboardWalk     bool
+	GibberishScore     float64
+	IsGibberish        bool
+	// NOTE:  left out without a name input, rules see them as missing
+	NameSimilarity *float64 `json:",omitempty"`
+	IsNameMatch    *bool    `json:",omitempty"`
 }
 
-var riskyTopLevelDomains = []string{
-	".xyz",
-	".de",
-	".icu",
-	".ru",
-	".cn",
-	".uk",
-	".tk",
-	".ga",
-	".cf",
-	".org",
-	".ml",
-	".pw",
-	".top",
-	".icu",
-	".info",
-	".co",
-	".work",
-	".net",
-	".club",
-	".gq",
-	".zw",
-	".bd",
-	".ke",
-	".am",
-	".sbs",
-	".date",
-	".quest",
-	".cd",
-	".bid"}
+var freeEmailProviders = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+	"yahoo.com":      true,
+	"yahoo.co.uk":    true,
+	"yahoo.fr":       true,
+	"ymail.com":      true,
+	"rocketmail.com": true,
+	"hotmail.com":    true,
+	"hotmail.co.uk":  true,
+	"hotmail.fr":     true,
+	"outlook.com":    true,
+	"live.com":       true,
+	"msn.com":        true,
+	"aol.com":        true,
+	"icloud.com":     true,
+	"me.com":         true,
+	"mac.com":        true,
+	"protonmail.com": true,
+	"proton.me":      true,
+	"pm.me":          true,
+	"tutanota.com":   true,
+	"gmx.com":        true,
+	"gmx.de":         true,
+	"gmx.net":        true,
+	"web.de":         true,
+	"mail.com":       true,
+	"zoho.com":       true,
+	"yandex.com":     true,
+	"yandex.ru":      true,
+	"mail.ru":        true,
+	"qq.com":         true,
+	"163.com":        true,
+	"126.com":        true,
+	"fastmail.com":   true,
+	"hushmail.com":   true,
+	"inbox.com":      true,
+	"rediffmail.com": true,
+	"libero.it":      true,
+	"orange.fr":      true,
+	"free.fr":        true,
+	"t-online.de":    true}
+
+// shared mailboxes, nobody in particular signs up with these
+var roleAccounts = map[string]bool{
+	"abuse":         true,
+	"accounts":      true,
+	"admin":         true,
+	"administrator": true,
+	"billing":       true,
+	"careers":       true,
+	"contact":       true,
+	"enquiries":     true,
+	"feedback":      true,
+	"hello":         true,
+	"help":          true,
+	"hostmaster":    true,
+	"hr":            true,
+	"info":          true,
+	"jobs":          true,
+	"marketing":     true,
+	"newsletter":    true,
+	"no-reply":      true,
+	"noreply":       true,
+	"office":        true,
+	"postmaster":    true,
+	"root":          true,
+	"sales":         true,
+	"security":      true,
+	"service":       true,
+	"support":       true,
+	"team":          true,
+	"webmaster":     true}
+
+var (
+	gmailDomains   = regexp.MustCompile(`^(gmail|googlemail)\.com$`)
+	outlookDomains = regexp.MustCompile(`^((outlook|hotmail|live)\.[a-z.]+|msn\.com)$`)
+)
+
+// keyboard rows, a walk is a run of neighbours along one of them either way
+var keyboardRows = []string{
+	"1234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm"}
+
+// RiskyTLDs is the list of top level domains with a bad reputation, it is reloaded when the
+// file in the data path changes
+type RiskyTLDs struct {
+	mu   sync.RWMutex
+	file string
+	tlds []string
+}
+
+func NewRiskyTLDs(tools *Toolbox) *RiskyTLDs {
+	x := &RiskyTLDs{file: tools.DataPath + RISKY_TLDS_FILE}
+	x.refresh()
+	if tools.Watcher != nil {
+		_ = tools.Watcher.Add(x.file, x.refresh)
+	}
+	return x
+}
+
+func (x *RiskyTLDs) refresh() {
+	tlds := []string{}
+	if err := common.LoadJson(x.file, &tlds); err != nil {
+		log.Error().Err(err).Str("component", SOURCE_OSINTAMI_NAME).Str("file", x.file).Msg("risky tlds")
+		return
+	}
+	for i := range tlds {
+		tlds[i] = "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(tlds[i])), ".")
+	}
+	x.mu.Lock()
+	x.tlds = tlds
+	x.mu.Unlock()
+}
+
+// IsRisky is true for a domain under one of the top level domains, a nil list has none
+func (x *RiskyTLDs) IsRisky(domain string) bool {
+	if x == nil {
+		return false
+	}
+	domain = strings.ToLower(domain)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for _, tld := range x.tlds {
+		if strings.HasSuffix(domain, tld) {
+			return true
+		}
+	}
+	return false
+}
 
 type EmailSource struct {
+	tlds *RiskyTLDs
 }
 
-func NewEmailSource(tools *Toolbox) IDataSource {
-	return NewDataInstance(tools, SOURCE_OSINTAMI_NAME, &EmailSource{})
+func NewEmailSource(tools *Toolbox, tlds *RiskyTLDs) IDataSource {
+	return NewDataInstance(tools, SOURCE_OSINTAMI_NAME, &EmailSource{tlds: tlds})
 }
 
 func (x *EmailSource) IsCached() bool {
@@ -67,13 +194,13 @@ func (x *EmailSource) CategoryInfo(ctx context.Context, categoryName string, inp
 	switch categoryName {
 	case CATEGORY_EMAIL:
 
-		return x.getEmailInfo(inputs[categoryName])
+		return x.getEmailInfo(inputs[categoryName], inputs[INPUT_NAME])
 	}
 
 	return nil, ErrNotImplemented
 }
 
-func (x *EmailSource) getEmailInfo(email string) (json.RawMessage, error) {
+func (x *EmailSource) getEmailInfo(email, name string) (json.RawMessage, error) {
 	var emailInfo EmailInfo
 	emailInfo.Email = email
 	em, err := emailaddress.Parse(email)
@@ -82,12 +209,28 @@ func (x *EmailSource) getEmailInfo(email string) (json.RawMessage, error) {
 		return json.Marshal(emailInfo)
 	}
 
+	domain := strings.ToLower(em.Domain)
+	localPart, _, _ := strings.Cut(strings.ToLower(em.LocalPart), "+")
+
 	emailInfo.IsValidEmail = true
 	emailInfo.IsWeirdUserName = x.isNonHumanUserName(em.LocalPart)
 	emailInfo.IsWeirdDomainName = x.isNonHumanDomainName(em.Domain)
 	emailInfo.IsEmailAlias = strings.Contains(em.LocalPart, "+")
 	emailInfo.IsValidIcannSuffix = (em.ValidateIcanSuffix() == nil)
-	emailInfo.IsNefariusDomain = x.isRiskyTopLevelDomain(em.Domain)
+	emailInfo.IsNefariusDomain = x.isRiskyTopLevelDomain(domain)
+	emailInfo.IsFreeProvider = freeEmailProviders[domain]
+	emailInfo.IsRoleAccount = roleAccounts[localPart]
+	emailInfo.CanonicalEmail = x.canonicalEmail(em.LocalPart, domain)
+	emailInfo.KeyboardWalkScore = x.keyboardWalkScore(localPart)
+	emailInfo.IsKeyboardWalk = emailInfo.KeyboardWalkScore >= EMAIL_KEYBOARD_WALK_SCORE
+	emailInfo.GibberishScore = x.gibberishScore(localPart)
+	emailInfo.IsGibberish = emailInfo.GibberishScore >= EMAIL_GIBBERISH_SCORE
+	if strings.TrimSpace(name) != "" {
+		similarity := x.nameSimilarity(name, localPart)
+		isMatch := similarity >= EMAIL_NAME_MATCH_SCORE
+		emailInfo.NameSimilarity = &similarity
+		emailInfo.IsNameMatch = &isMatch
+	}
 
 	return json.Marshal(emailInfo)
 }
@@ -119,12 +262,7 @@ func (x *EmailSource) isNonHumanDomainName(name string) bool {
 }
 
 func (x *EmailSource) isRiskyTopLevelDomain(domain string) bool {
-	for _, tld := range riskyTopLevelDomains {
-		if strings.HasSuffix(domain, tld) {
-			return true
-		}
-	}
-	return false
+	return x.tlds.IsRisky(domain)
 }
 
 func (x *EmailSource) numerics(name string) int {
@@ -140,3 +278,176 @@ func (x *EmailSource) numerics(name string) int {
 		strings.Count(name, "9")
 	return count
 }
+
+// canonicalEmail is the mailbox the address delivers to, gmail ignores dots and both gmail and
+// outlook take anything after a plus, everyone else is only case insensitive
+func (x *EmailSource) canonicalEmail(localPart, domain string) string {
+	localPart = strings.ToLower(localPart)
+	switch {
+	case gmailDomains.MatchString(domain):
+		localPart, _, _ = strings.Cut(localPart, "+")
+		localPart = strings.ReplaceAll(localPart, ".", "")
+		domain = "gmail.com"
+	case outlookDomains.MatchString(domain):
+		localPart, _, _ = strings.Cut(localPart, "+")
+	}
+	return localPart + "@" + domain
+}
+
+// keyboardWalkScore is the share of the local part made of runs of 3 or more keyboard
+// neighbours (ie. qwerty, asdf, 4321)
+func (x *EmailSource) keyboardWalkScore(localPart string) float64 {
+	keys := []rune{}
+	for _, key := range localPart {
+		if unicode.IsLetter(key) || unicode.IsDigit(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return 0
+	}
+
+	walked := 0
+	run := 1
+	for i := 1; i <= len(keys); i++ {
+		if i < len(keys) && x.isKeyboardNeighbour(keys[i-1], keys[i]) {
+			run++
+			continue
+		}
+		if run >= 3 {
+			walked += run
+		}
+		run = 1
+	}
+	return float64(walked) / float64(len(keys))
+}
+
+func (x *EmailSource) isKeyboardNeighbour(a, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		j := strings.IndexRune(row, b)
+		if i >= 0 && j >= 0 && (i-j == 1 || j-i == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// gibberishScore weighs long consonant runs and too few or too many vowels in the letters of
+// the local part, names and words score low, mashed keys score high
+func (x *EmailSource) gibberishScore(localPart string) float64 {
+	letters := []rune{}
+	for _, letter := range localPart {
+		if letter >= 'a' && letter <= 'z' {
+			letters = append(letters, letter)
+		}
+	}
+	// NOTE:  too short to tell (ie. jd, bob)
+	if len(letters) < 5 {
+		return 0
+	}
+
+	vowels := 0
+	inRuns := 0
+	run := 0
+	for i := 0; i <= len(letters); i++ {
+		if i < len(letters) && !strings.ContainsRune("aeiouy", letters[i]) {
+			run++
+			continue
+		}
+		if i < len(letters) {
+			vowels++
+		}
+		if run >= 4 {
+			inRuns += run
+		}
+		run = 0
+	}
+
+	ratio := float64(vowels) / float64(len(letters))
+	vowelPenalty := 0.0
+	if ratio < 0.2 {
+		vowelPenalty = (0.2 - ratio) / 0.2
+	} else if ratio > 0.7 {
+		vowelPenalty = (ratio - 0.7) / 0.3
+	}
+	return 0.6*float64(inRuns)/float64(len(letters)) + 0.4*vowelPenalty
+}
+
+// nameSimilarity compares the local part with the usual ways of writing a name into one (ie.
+// janedoe, jdoe, doe.jane), 1 is an exact match
+func (x *EmailSource) nameSimilarity(name, localPart string) float64 {
+	parts := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return !unicode.IsLetter(r) })
+	letters := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return r
+		}
+		return -1
+	}, localPart)
+	if len(parts) == 0 || letters == "" {
+		return 0
+	}
+
+	first := parts[0]
+	last := parts[len(parts)-1]
+	initial := string([]rune(first)[:1])
+	lastInitial := string([]rune(last)[:1])
+	candidates := []string{first, strings.Join(parts, "")}
+	if len(parts) > 1 {
+		candidates = append(candidates,
+			last,
+			first+last,
+			last+first,
+			initial+last,
+			first+lastInitial,
+			last+initial)
+	}
+
+	best := 0.0
+	for _, candidate := range candidates {
+		if score := similarity(candidate, letters); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// similarity is 1 less the edit distance over the longer length
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(rb)])/float64(longest)
+}
+
+func minInt(values ...int) int {
+	out := values[0]
+	for _, value := range values[1:] {
+		if value < out {
+			out = value
+		}
+	}
+	return out
+}
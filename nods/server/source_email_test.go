@@ -9,9 +9,15 @@ import (
 	"github.com/tidwall/gjson"
 )
 
+func emailSource() IDataSource {
+	tools := mockToolbox()
+	tools.DataPath = "./test/"
+	return NewEmailSource(tools, NewRiskyTLDs(tools))
+}
+
 func TestEmail(t *testing.T) {
 
-	source := NewEmailSource(mockToolbox())
+	source := emailSource()
 
 	inputs := make(map[string]string)
 	inputs[CATEGORY_EMAIL] = "12345678+alias@gmail.com"
@@ -24,6 +30,33 @@ func TestEmail(t *testing.T) {
 	result = gjson.GetBytes(data, "IsEmailAlias")
 	assert.Equal(t, true, result.Bool())
 
+	result = gjson.GetBytes(data, "IsFreeProvider")
+	assert.Equal(t, true, result.Bool())
+
+	// no name, no name match
+	assert.False(t, gjson.GetBytes(data, "NameSimilarity").Exists())
+	assert.False(t, gjson.GetBytes(data, "IsNameMatch").Exists())
+
+	inputs[CATEGORY_EMAIL] = "Jane.Doe+shop@GoogleMail.com"
+	inputs[INPUT_NAME] = "Jane Doe"
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, inputs)
+	assert.Equal(t, "janedoe@gmail.com", gjson.GetBytes(data, "CanonicalEmail").String())
+	assert.True(t, gjson.GetBytes(data, "IsNameMatch").Bool())
+	assert.Equal(t, 1.0, gjson.GetBytes(data, "NameSimilarity").Float())
+	assert.False(t, gjson.GetBytes(data, "IsGibberish").Bool())
+
+	inputs[CATEGORY_EMAIL] = "Support@Example.xyz"
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, inputs)
+	assert.True(t, gjson.GetBytes(data, "IsRoleAccount").Bool())
+	assert.True(t, gjson.GetBytes(data, "IsNefariusDomain").Bool())
+	assert.False(t, gjson.GetBytes(data, "IsFreeProvider").Bool())
+	assert.False(t, gjson.GetBytes(data, "IsNameMatch").Bool())
+
+	inputs[CATEGORY_EMAIL] = "qwertyasdf@example.com"
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, inputs)
+	assert.True(t, gjson.GetBytes(data, "IsKeyboardWalk").Bool())
+	assert.Equal(t, 1.0, gjson.GetBytes(data, "KeyboardWalkScore").Float())
+
 	// test error path
 	inputs[CATEGORY_PHONE] = "18001234567"
 	data, err = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, inputs)
@@ -32,7 +65,9 @@ func TestEmail(t *testing.T) {
 }
 
 func TestEmailMethods(t *testing.T) {
-	email := &EmailSource{}
+	tools := mockToolbox()
+	tools.DataPath = "./test/"
+	email := &EmailSource{tlds: NewRiskyTLDs(tools)}
 	check := "abc12345!*#XYZ^"
 
 	assert.Equal(t, 4, email.nonAlphanumerics(check))
@@ -42,4 +77,23 @@ func TestEmailMethods(t *testing.T) {
 	assert.True(t, email.isNonHumanDomainName(check))
 	assert.True(t, email.isRiskyTopLevelDomain("1.xyz"))
 	assert.False(t, email.isRiskyTopLevelDomain("nope.com"))
+
+	// no list, nothing is risky
+	email.tlds = NewRiskyTLDs(mockToolbox())
+	assert.False(t, email.isRiskyTopLevelDomain("1.xyz"))
+
+	assert.Equal(t, "jane.doe@outlook.com", email.canonicalEmail("Jane.Doe+news", "outlook.com"))
+	assert.Equal(t, "jane+news@example.com", email.canonicalEmail("Jane+news", "example.com"))
+
+	assert.Equal(t, 0.0, email.keyboardWalkScore("janedoe"))
+	assert.Equal(t, 0.5, email.keyboardWalkScore("jane4321"))
+
+	for localPart, gibberish := range map[string]bool{"xkcdqwvbnm": true, "zzxqjkptr": true, "johnsmith": false, "christopher": false, "jd": false} {
+		assert.Equal(t, gibberish, email.gibberishScore(localPart) >= EMAIL_GIBBERISH_SCORE, localPart)
+	}
+
+	for localPart, match := range map[string]bool{"jdoe": true, "doejane": true, "janed": true, "jane": true, "bob.smith": false, "xkcd": false} {
+		assert.Equal(t, match, email.nameSimilarity("Jane Q. Doe", localPart) >= EMAIL_NAME_MATCH_SCORE, localPart)
+	}
+	assert.Equal(t, 0.0, email.nameSimilarity("", "jane"))
 }
@@ -11,14 +11,18 @@ type InternalSource struct {
 	browser IDataSource
 	phone   IDataSource
 	email   IDataSource
+	url     IDataSource
 	rules   IDataSource
 }
 
 func NewInternalSource(router IDataRouter, tools *Toolbox) IDataSource {
+	// NOTE:  email and url share the one list and the one watch on its file
+	tlds := NewRiskyTLDs(tools)
 	return NewDataInstance(tools, SOURCE_OSINTAMI_NAME, &InternalSource{
 		browser: NewBrowserSource(tools),
-		phone:   NewPhoneSource(tools),
-		email:   NewEmailSource(tools),
+		phone:   NewPhoneSource(tools, router),
+		email:   NewEmailSource(tools, tlds),
+		url:     NewURLSource(tools, tlds),
 		rules:   NewRuleSource(tools, router, tools.Schema)})
 }
 
@@ -27,18 +31,22 @@ func (x *InternalSource) IsCached() bool {
 }
 
 func (x *InternalSource) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	// NOTE:  the sources below are DataInstances named osintami too, this one memoizes for them
+	child := withoutMemo(ctx)
 	switch categoryName {
 	case CATEGORY_BROWSER:
 		// NOTE:  a missing or corrupt user-agent regex file can cause this
 		if x.browser != nil {
-			return x.browser.CategoryInfo(ctx, categoryName, inputs)
+			return x.browser.CategoryInfo(child, categoryName, inputs)
 		}
 	case CATEGORY_EMAIL:
-		return x.email.CategoryInfo(ctx, categoryName, inputs)
+		return x.email.CategoryInfo(child, categoryName, inputs)
 	// case CATEGORY_DOMAIN:
 	// 	return x.email.CategoryInfo(ctx, categoryName, inputs)
 	case CATEGORY_PHONE:
-		return x.phone.CategoryInfo(ctx, categoryName, inputs)
+		return x.phone.CategoryInfo(child, categoryName, inputs)
+	case CATEGORY_URL:
+		return x.url.CategoryInfo(child, categoryName, inputs)
 	case CATEGORY_RULE:
 		return x.rules.CategoryInfo(ctx, categoryName, inputs)
 	}
@@ -28,6 +28,10 @@ func TestOsintamiSourceMissingInputs(t *testing.T) {
 	assert.Equal(t, ErrMissingInputs, err)
 	assert.Equal(t, "{}", string(data))
 
+	data, err = source.CategoryInfo(context.TODO(), CATEGORY_URL, inputs)
+	assert.Equal(t, ErrMissingInputs, err)
+	assert.Equal(t, "{}", string(data))
+
 	data, err = source.CategoryInfo(context.TODO(), CATEGORY_RULE, inputs)
 	assert.Equal(t, ErrMissingInputs, err)
 	assert.Equal(t, "{}", string(data))
@@ -3,29 +3,71 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"strings"
+	"unicode"
 
-	"github.com/davegardnerisme/phonegeocode"
 	"github.com/nyaruka/phonenumbers"
 	"github.com/osintami/fingerprintz/common"
 	"github.com/osintami/fingerprintz/log"
 )
 
+const (
+	// input with the country the number is from (ie. MX), optional
+	INPUT_REGION = "region"
+	// the country of the ip input stands in for a missing region
+	PHONE_IP_COUNTRY_ITEM = "ip/maxmind/country"
+	// last resort for a number without a country code, a region or an ip
+	PHONE_DEFAULT_REGION = "US"
+	// where the country of the number came from
+	PHONE_REGION_NUMBER  = "number"
+	PHONE_REGION_HINT    = "region"
+	PHONE_REGION_IP      = "ip"
+	PHONE_REGION_DEFAULT = "default"
+)
+
+var phoneNumberTypes = map[phonenumbers.PhoneNumberType]string{
+	phonenumbers.FIXED_LINE:           "fixed_line",
+	phonenumbers.MOBILE:               "mobile",
+	phonenumbers.FIXED_LINE_OR_MOBILE: "fixed_line_or_mobile",
+	phonenumbers.TOLL_FREE:            "toll_free",
+	phonenumbers.PREMIUM_RATE:         "premium_rate",
+	phonenumbers.SHARED_COST:          "shared_cost",
+	phonenumbers.VOIP:                 "voip",
+	phonenumbers.PERSONAL_NUMBER:      "personal_number",
+	phonenumbers.PAGER:                "pager",
+	phonenumbers.UAN:                  "uan",
+	phonenumbers.VOICEMAIL:            "voicemail",
+	phonenumbers.UNKNOWN:              "unknown"}
+
 type PhoneInfo struct {
 	RawPhoneNumber      string
 	IsValid             bool
+	IsPossible          bool
 	IsTollFree          bool
+	IsMobile            bool
+	IsFixedLine         bool
+	IsVOIP              bool
+	IsPremiumRate       bool
+	IsPager             bool
+	NumberType          string
 	CountryISOCode      string
+	RegionSource        string
 	CountryCode         int32
 	PhoneNumber         uint64
+	E164                string
 	NationalNumber      string
 	InternationalNumber string
+	// NOTE:  offline metadata, not every country has carriers or places
+	Carrier  string `json:",omitempty"`
+	Location string `json:",omitempty"`
 }
 
 type PhoneSource struct {
+	router IDataRouter
 }
 
-func NewPhoneSource(tools *Toolbox) IDataSource {
-	return NewDataInstance(tools, SOURCE_OSINTAMI_NAME, &PhoneSource{})
+func NewPhoneSource(tools *Toolbox, router IDataRouter) IDataSource {
+	return NewDataInstance(tools, SOURCE_OSINTAMI_NAME, &PhoneSource{router: router})
 }
 
 func (x *PhoneSource) IsCached() bool {
@@ -35,47 +77,124 @@ func (x *PhoneSource) IsCached() bool {
 func (x *PhoneSource) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
 	switch categoryName {
 	case CATEGORY_PHONE:
-		return x.getPhoneInfo(inputs[categoryName])
+		return x.getPhoneInfo(ctx, inputs[categoryName], inputs)
 	}
 
 	return nil, ErrNotImplemented
 }
 
-func (x *PhoneSource) getPhoneInfo(phone string) (json.RawMessage, error) {
+type phoneCandidate struct {
+	number string
+	region string
+	source string
+}
+
+// candidates are the ways to read the number, in order, a number with a country code is read as
+// such, the rest take the region or the ip's country first, then more than 10 digits may be a
+// country code without the +
+func (x *PhoneSource) candidates(ctx context.Context, phone string, inputs common.DataInputs) []phoneCandidate {
+	phone = strings.TrimSpace(phone)
+	if strings.HasPrefix(phone, "+") {
+		return []phoneCandidate{{phone, phonenumbers.UNKNOWN_REGION, PHONE_REGION_NUMBER}}
+	}
+
+	out := []phoneCandidate{}
+	if region := strings.ToUpper(strings.TrimSpace(inputs[INPUT_REGION])); x.isRegion(region) {
+		out = append(out, phoneCandidate{phone, region, PHONE_REGION_HINT})
+	} else if region := x.ipRegion(ctx, inputs); region != "" {
+		out = append(out, phoneCandidate{phone, region, PHONE_REGION_IP})
+	}
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) > 10 {
+		out = append(out, phoneCandidate{"+" + digits, phonenumbers.UNKNOWN_REGION, PHONE_REGION_NUMBER})
+	}
+	return append(out, phoneCandidate{phone, PHONE_DEFAULT_REGION, PHONE_REGION_DEFAULT})
+}
+
+func (x *PhoneSource) isRegion(region string) bool {
+	return region != "" && phonenumbers.GetCountryCodeForRegion(region) != 0
+}
+
+// ipRegion is the country of the ip input
+func (x *PhoneSource) ipRegion(ctx context.Context, inputs common.DataInputs) string {
+	if x.router == nil || inputs[CATEGORY_IPADDR] == "" {
+		return ""
+	}
+	keys := common.DataInputs{CATEGORY_IPADDR: inputs[CATEGORY_IPADDR], common.INPUT_RULE: PHONE_IP_COUNTRY_ITEM}
+	output, err := x.router.DataValue(ctx, NewItemSplitter(PHONE_IP_COUNTRY_ITEM), keys)
+	if err != nil || output.Result.Str == nil {
+		return ""
+	}
+	region := strings.ToUpper(*output.Result.Str)
+	if !x.isRegion(region) {
+		return ""
+	}
+	return region
+}
+
+func (x *PhoneSource) getPhoneInfo(ctx context.Context, phone string, inputs common.DataInputs) (json.RawMessage, error) {
 	info := PhoneInfo{}
 	info.RawPhoneNumber = phone
-	if len(info.RawPhoneNumber) > 10 {
-		cc, err := phonegeocode.New().Country(info.RawPhoneNumber)
-		if err != nil {
-			log.Error().Err(err).Str("component", SOURCE_OSINTAMI_NAME).Msg("country code")
-			info.IsValid = false
-			data, _ := json.Marshal(info)
-			return data, common.ErrNoDataPresent
+
+	// NOTE:  the first valid reading wins, then the first possible one
+	var number *phonenumbers.PhoneNumber
+	var source string
+	for _, candidate := range x.candidates(ctx, phone, inputs) {
+		// NOTE:  all zeros is possible by length and no number anywhere
+		parsed, err := phonenumbers.Parse(candidate.number, candidate.region)
+		if err != nil || parsed.GetNationalNumber() == 0 {
+			continue
+		}
+		if phonenumbers.IsValidNumber(parsed) {
+			number, source = parsed, candidate.source
+			break
+		}
+		if number == nil && phonenumbers.IsPossibleNumber(parsed) {
+			number, source = parsed, candidate.source
 		}
-		info.CountryISOCode = cc
-	} else {
-		// NOTE:  not sure this is a good guess
-		info.CountryISOCode = "US"
 	}
-
-	pns, err := phonenumbers.Parse(info.RawPhoneNumber, info.CountryISOCode)
-	if err != nil {
-		log.Error().Err(err).Str("component", SOURCE_OSINTAMI_NAME).Msg("country ISO code")
+	if number == nil {
+		log.Error().Err(common.ErrNoDataPresent).Str("component", SOURCE_OSINTAMI_NAME).Str("phone", phone).Msg("parse phone")
 		info.IsValid = false
 		data, _ := json.Marshal(info)
 		return data, common.ErrNoDataPresent
-	} else {
-		info.CountryCode = *pns.CountryCode
-		info.PhoneNumber = *pns.NationalNumber
-		info.NationalNumber = phonenumbers.Format(pns, phonenumbers.NATIONAL)
-		info.InternationalNumber = phonenumbers.Format(pns, phonenumbers.INTERNATIONAL)
-		info.IsValid = true
-
-		tollFreeCheck := info.NationalNumber[1:4]
-		if tollFreeCheck == "800" || tollFreeCheck == "888" || tollFreeCheck == "867" || tollFreeCheck == "866" {
-			info.IsTollFree = true
-		}
 	}
 
+	info.IsPossible = true
+	info.IsValid = phonenumbers.IsValidNumber(number)
+	info.RegionSource = source
+	info.CountryCode = number.GetCountryCode()
+	info.PhoneNumber = number.GetNationalNumber()
+	info.CountryISOCode = phonenumbers.GetRegionCodeForNumber(number)
+	if info.CountryISOCode == "" {
+		// NOTE:  an invalid number has no region of its own, the main one of its country code
+		info.CountryISOCode = phonenumbers.GetRegionCodeForCountryCode(int(info.CountryCode))
+	}
+	info.E164 = phonenumbers.Format(number, phonenumbers.E164)
+	info.NationalNumber = phonenumbers.Format(number, phonenumbers.NATIONAL)
+	info.InternationalNumber = phonenumbers.Format(number, phonenumbers.INTERNATIONAL)
+	if !info.IsValid {
+		info.NumberType = phoneNumberTypes[phonenumbers.UNKNOWN]
+		return json.Marshal(info)
+	}
+
+	// NOTE:  some countries can't tell mobile from fixed line numbers, those are both
+	numberType := phonenumbers.GetNumberType(number)
+	info.NumberType = phoneNumberTypes[numberType]
+	info.IsMobile = numberType == phonenumbers.MOBILE || numberType == phonenumbers.FIXED_LINE_OR_MOBILE
+	info.IsFixedLine = numberType == phonenumbers.FIXED_LINE || numberType == phonenumbers.FIXED_LINE_OR_MOBILE
+	info.IsTollFree = numberType == phonenumbers.TOLL_FREE
+	info.IsVOIP = numberType == phonenumbers.VOIP
+	info.IsPremiumRate = numberType == phonenumbers.PREMIUM_RATE
+	info.IsPager = numberType == phonenumbers.PAGER
+
+	info.Carrier, _ = phonenumbers.GetCarrierForNumber(number, "en")
+	info.Location, _ = phonenumbers.GetGeocodingForNumber(number, "en")
+
 	return json.Marshal(info)
 }
@@ -12,7 +12,7 @@ import (
 
 func TestPhoneMexico(t *testing.T) {
 
-	source := NewPhoneSource(mockToolbox())
+	source := NewPhoneSource(mockToolbox(), NewMockDataRouter(false))
 	inputs := make(map[string]string)
 
 	// test Mexico
@@ -52,7 +52,7 @@ func TestPhoneMexico(t *testing.T) {
 
 func TestPhoneTollFree(t *testing.T) {
 
-	source := NewPhoneSource(mockToolbox())
+	source := NewPhoneSource(mockToolbox(), NewMockDataRouter(false))
 	inputs := make(map[string]string)
 
 	// test toll free
@@ -78,7 +78,7 @@ func TestPhoneTollFree(t *testing.T) {
 
 func TestPhoneErrors(t *testing.T) {
 
-	source := NewPhoneSource(mockToolbox())
+	source := NewPhoneSource(mockToolbox(), NewMockDataRouter(false))
 	inputs := make(map[string]string)
 
 	// test bogus number
@@ -109,3 +109,81 @@ func TestPhoneErrors(t *testing.T) {
 	assert.False(t, result.Bool())
 
 }
+
+func TestPhoneRegion(t *testing.T) {
+
+	source := NewPhoneSource(mockToolbox(), NewMockDataRouter(false))
+
+	// no country code, no region, no ip
+	data, err := source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "3220543290"})
+	assert.Nil(t, err)
+	assert.Equal(t, "default", gjson.GetBytes(data, "RegionSource").Str)
+	assert.Equal(t, "US", gjson.GetBytes(data, "CountryISOCode").Str)
+	assert.False(t, gjson.GetBytes(data, "IsValid").Bool())
+
+	// the region says Mexico
+	data, err = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "322 054 3290", INPUT_REGION: "mx"})
+	assert.Nil(t, err)
+	assert.Equal(t, "region", gjson.GetBytes(data, "RegionSource").Str)
+	assert.Equal(t, "+523220543290", gjson.GetBytes(data, "E164").Str)
+	assert.Equal(t, "Jalisco", gjson.GetBytes(data, "Location").Str)
+	assert.True(t, gjson.GetBytes(data, "IsValid").Bool())
+
+	// so does the ip
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "3220543290", CATEGORY_IPADDR: "1.2.3.4"})
+	assert.Equal(t, "ip", gjson.GetBytes(data, "RegionSource").Str)
+	assert.Equal(t, "MX", gjson.GetBytes(data, "CountryISOCode").Str)
+
+	// a country code beats both
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "+1 888 234 5678", CATEGORY_IPADDR: "1.2.3.4", INPUT_REGION: "MX"})
+	assert.Equal(t, "number", gjson.GetBytes(data, "RegionSource").Str)
+	assert.Equal(t, "US", gjson.GetBytes(data, "CountryISOCode").Str)
+
+	// without the + the region goes first, these are mobiles in Brazil not numbers in France,
+	// Germany and Japan
+	for _, phone := range []string{"33 98765 4321", "49 98765 4321", "81 98765 4321"} {
+		data, _ = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: phone, INPUT_REGION: "BR"})
+		assert.Equal(t, "region", gjson.GetBytes(data, "RegionSource").Str, phone)
+		assert.Equal(t, "BR", gjson.GetBytes(data, "CountryISOCode").Str, phone)
+		assert.Equal(t, "mobile", gjson.GetBytes(data, "NumberType").Str, phone)
+	}
+
+	// a number that isn't one under the region may still carry its country code
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "44 20 7946 0958", INPUT_REGION: "BR"})
+	assert.Equal(t, "number", gjson.GetBytes(data, "RegionSource").Str)
+	assert.Equal(t, "GB", gjson.GetBytes(data, "CountryISOCode").Str)
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "1 888 234 5678"})
+	assert.Equal(t, "US", gjson.GetBytes(data, "CountryISOCode").Str)
+	assert.True(t, gjson.GetBytes(data, "IsValid").Bool())
+}
+
+func TestPhoneTypes(t *testing.T) {
+
+	source := NewPhoneSource(mockToolbox(), nil)
+
+	for phone, numberType := range map[string]string{
+		"+447911123456": "mobile",
+		"+19002001234":  "premium_rate",
+		"+18005551234":  "toll_free",
+		"+14155552671":  "fixed_line_or_mobile",
+		"+442079460000": "fixed_line",
+		"+445612345678": "voip",
+	} {
+		data, err := source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: phone})
+		assert.Nil(t, err, phone)
+		assert.Equal(t, numberType, gjson.GetBytes(data, "NumberType").Str, phone)
+		assert.Equal(t, phone, gjson.GetBytes(data, "E164").Str, phone)
+	}
+
+	data, _ := source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "+447911123456"})
+	assert.True(t, gjson.GetBytes(data, "IsMobile").Bool())
+	assert.False(t, gjson.GetBytes(data, "IsFixedLine").Bool())
+	assert.Equal(t, "JT", gjson.GetBytes(data, "Carrier").Str)
+
+	// the right length for the country, not a number anyone has
+	data, err := source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "+1 099 555 0100"})
+	assert.Nil(t, err)
+	assert.True(t, gjson.GetBytes(data, "IsPossible").Bool())
+	assert.False(t, gjson.GetBytes(data, "IsValid").Bool())
+	assert.Equal(t, "unknown", gjson.GetBytes(data, "NumberType").Str)
+}
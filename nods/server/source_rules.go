@@ -4,14 +4,16 @@ package server
 import (
 	"context"
 	"encoding/json"
-	"regexp"
+	"fmt"
+	"reflect"
 	"strings"
-
-	"github.com/Knetic/govaluate"
+	"sync"
+	"time"
 
 	"github.com/osintami/fingerprintz/common"
 	"github.com/osintami/fingerprintz/log"
 	"github.com/tidwall/sjson"
+	"golang.org/x/exp/slices"
 )
 
 type IRuleProvider interface {
@@ -21,26 +23,79 @@ type IRuleProvider interface {
 }
 
 type RuleProvider struct {
-	router IDataRouter
-	rules  map[string]Item
-	dregex *regexp.Regexp
-	sregex *regexp.Regexp
-	dates  common.DateStuff
+	mu      sync.RWMutex
+	router  IDataRouter
+	schema  IDataSchema
+	rules   map[string]Item
+	graph   *RuleGraph
+	version int64
+	dates   common.DateStuff
+	shadows *ShadowStore
+	results *RuleCache
 }
 
+// NewRuleSource is the schema rules, their shadow queries run when the toolbox has a store and
+// their outputs are cached when the watcher can tell which vendors were reloaded
 func NewRuleSource(tools *Toolbox, router IDataRouter, schema IDataSchema) IDataSource {
-	return NewDataInstance(tools, SOURCE_OSINTAMI_NAME, NewRuleProvider(router, schema))
+	x := newRuleProvider(router, schema)
+	x.shadows = tools.Shadows
+	if sources, ok := tools.Watcher.(*SourceWatcher); ok && tools.Cache != nil {
+		x.results = NewRuleCache(tools.Cache, sources)
+	}
+	return NewDataInstance(tools, SOURCE_OSINTAMI_NAME, x)
 }
 
 func NewRuleProvider(router IDataRouter, schema IDataSchema) IRuleProvider {
-	return &RuleProvider{
+	return newRuleProvider(router, schema)
+}
+
+func newRuleProvider(router IDataRouter, schema IDataSchema) *RuleProvider {
+	x := &RuleProvider{
 		router: router,
-		rules:  schema.ListRulesItems(),
-		sregex: regexp.MustCompile(`\[(.*?)\]`),
-		dregex: regexp.MustCompile(`@{(.*?)}`),
+		schema: schema,
 		dates:  *common.NewDateStuff()}
+	x.reload(schema.Version())
+	return x
+}
+
+// reload compiles the rules of the schema, broken rules are logged here once and answer
+// with their error every time they are asked for
+func (x *RuleProvider) reload(version int64) {
+	rules := x.schema.ListRulesItems()
+	graph := NewRuleGraph(x.schema, rules)
+	for _, path := range graph.Order() {
+		rule, _ := graph.Rule(path)
+		if rule.Err != nil {
+			log.Error().Err(rule.Err).Str("component", "rules engine").Str("rule", path).Str("query", rule.Item.Query).Msg("rule compile")
+		}
+		if rule.shadow != nil && rule.shadow.Err != nil {
+			log.Error().Err(rule.shadow.Err).Str("component", "rules engine").Str("rule", path).Str("shadow", rule.Item.Shadow).Msg("shadow compile")
+		}
+	}
+	x.rules = rules
+	x.graph = graph
+	x.version = version
 }
 
+// current recompiles the rules when the schema has changed since they were compiled
+func (x *RuleProvider) current() (map[string]Item, *RuleGraph) {
+	version := x.schema.Version()
+	x.mu.RLock()
+	if version == x.version {
+		defer x.mu.RUnlock()
+		return x.rules, x.graph
+	}
+	x.mu.RUnlock()
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if version != x.version {
+		x.reload(version)
+	}
+	return x.rules, x.graph
+}
+
+// IsCached is false, rule outputs are cached by the rule cache not by the source cache
 func (x *RuleProvider) IsCached() bool {
 	return false
 }
@@ -50,8 +105,21 @@ func (x *RuleProvider) CategoryInfo(ctx context.Context, categoryName string, in
 		return []byte("{}"), ErrNotImplemented
 	}
 
-	if nods, ok := x.rules[inputs[common.INPUT_RULE]]; ok {
-		output, err := x.Evaluate(ctx, nods, inputs)
+	rules, graph := x.current()
+	if nods, ok := rules[inputs[common.INPUT_RULE]]; ok {
+		ctx, health := NewRuleHealth(ctx)
+		evaluate := func() (json.RawMessage, error) {
+			return x.Evaluate(ctx, nods, inputs)
+		}
+		// NOTE:  explain=true wants to see the work, not a cached answer, and a cached answer
+		//        doesn't run the shadow, shadow counts are of the rules that were evaluated
+		var output json.RawMessage
+		var err error
+		if rule, _ := graph.Rule(nods.Path); x.results != nil && rule.Err == nil && TraceFrom(ctx) == nil {
+			output, err = x.results.Lookup(rule, inputs, health, evaluate)
+		} else {
+			output, err = evaluate()
+		}
 		if err != nil {
 			return []byte("{}"), err
 		}
@@ -60,65 +128,83 @@ func (x *RuleProvider) CategoryInfo(ctx context.Context, categoryName string, in
 	return []byte("{}"), common.ErrNoDataPresent
 }
 
-func (x *RuleProvider) Evaluate(ctx context.Context, nods Item, inputs common.DataInputs) ([]byte, error) {
-	// insert dates into rule
-	matches := x.dregex.FindAllStringSubmatch(nods.Query, -1)
-	for i, match := range matches {
-		date := x.dates.AgoStringToDate(match[1])
-		nods.Query = strings.ReplaceAll(nods.Query, matches[i][0], "'"+date+"'")
-	}
-
-	// insert item values into the parameter map
-	parameters := make(map[string]interface{}, 8)
-	matches = x.sregex.FindAllStringSubmatch(nods.Query, -1)
-	for _, match := range matches {
-		// NOTE:  internal source item routing requires the item to be passed with
-		//   the other parameters for some sources (ie. thing and nods sources)
-		item := match[1]
-		inputs[common.INPUT_RULE] = item
-		result, err := x.router.DataValue(ctx, NewItemSplitter(item), inputs)
-		log.Debug().Str("component", "rules engine").Str("rule", nods.Query).Str(common.INPUT_RULE, item).Str("result", result.Result.Raw).Msg("rule partial eval")
-		// TODO:  this only works because we create empty result sets with smart defaults, at some point this will BITE us
-		if err == nil || err == ErrSourceNotFound || err == common.ErrNoDataPresent {
-			param := match[1]
-			switch result.Result.Type {
-			case common.Boolean:
-				parameters[param] = *result.Result.Bool
-			case common.Date:
-				// NOTE:  greater/less operators don't like strings when parameters
-				//   are used so do the substitution manually, otherwise let
-				//   govaluate handle it
-				nods.Query = strings.ReplaceAll(nods.Query, match[0], "'"+*result.Result.Str+"'")
-
-			case common.String:
-				parameters[param] = *result.Result.Str
-			case common.Float:
-				parameters[param] = *result.Result.Num
-			case common.Integer:
-				parameters[param] = *result.Result.Num
-			case common.JSON:
-				parameters[param] = *result.Result.Str
-			case common.Null:
-				// HACK:  really should figure out what the type was supposed to be and take the proper default, might be a string or a number
-				parameters[param] = false
-			}
+// compiled finds the schema rule, ad-hoc rules are compiled on the spot
+func (x *RuleProvider) compiled(nods Item) *CompiledRule {
+	rules, graph := x.current()
+	if rule, ok := graph.Rule(nods.Path); ok && rule.Item.Query == nods.Query {
+		return rule
+	}
+	rule := CompileRule(nods, x.schema, rules)
+	for _, dep := range rule.Rules {
+		if known, _ := graph.Rule(dep); rule.Err == nil && known.Err != nil {
+			rule.Err = fmt.Errorf("%w: %s", ErrRuleDependency, dep)
 		}
 	}
+	return rule
+}
 
-	// NOTE:  this is slow (no precompile) but requires all parameters to work
-	//   exactly as govaluate wants and we are bending the rules a bit with dates
-	expression, err := govaluate.NewEvaluableExpression(nods.Query)
-	if err != nil {
-		log.Error().Err(err).Str("component", "rules engine").Str("rule", nods.Query).Msg("rule expression")
-		return nil, err
+func (x *RuleProvider) Evaluate(ctx context.Context, nods Item, inputs common.DataInputs) ([]byte, error) {
+	// nested rules share the memo of the outermost rule
+	ctx = WithMemo(ctx)
+
+	// explain=true leaves a node for this rule to record into
+	var trace *RuleTrace
+	if node := TraceFrom(ctx); node != nil {
+		trace = &RuleTrace{Rule: nods.Path, Query: nods.Query, Expression: nods.Query}
+		node.Rule = trace
+	}
+
+	rule := x.compiled(nods)
+	if rule.Err != nil {
+		log.Error().Err(rule.Err).Str("component", "rules engine").Str("rule", nods.Query).Msg("rule expression")
+		trace.fail(rule.Err)
+		return nil, rule.Err
 	}
 
-	// evaluate the expression, returns an interface
-	govaluateResult, err := expression.Evaluate(parameters)
+	dates := make(map[string]string, len(rule.Dates))
+	for _, date := range rule.Dates {
+		dates[date] = x.dates.AgoStringToDate(date)
+	}
+	if trace != nil {
+		trace.Expression = dateRegex.ReplaceAllStringFunc(nods.Query, func(match string) string {
+			return "'" + dates[match[2:len(match)-1]] + "'"
+		})
+	}
+
+	parameters, missing := x.resolve(ctx, rule, inputs, trace)
+	for date, value := range dates {
+		parameters["@{"+date+"}"] = ruleDate(value)
+	}
+
+	// the candidate gets the parameters as they were resolved, deciding fills in missing inputs
+	var shadowParameters map[string]interface{}
+	runShadow := x.shadows != nil && rule.shadow != nil && rule.shadow.Err == nil && !InShadow(ctx)
+	if runShadow {
+		shadowParameters = make(map[string]interface{}, len(parameters))
+		for name, value := range parameters {
+			shadowParameters[name] = value
+		}
+	}
+
+	// evaluate the expression, returns an interface, nil when the rule can't decide
+	govaluateResult, err := rule.decide(parameters, missing)
+	if runShadow && err == nil && x.shadows.Acquire(rule.Item) {
+		// NOTE:  the caller is free to change its inputs once the rule has answered
+		keys := common.DataInputs{}
+		for k, v := range inputs {
+			keys[k] = v
+		}
+		go x.shadow(ctx, rule, keys, shadowParameters, govaluateResult)
+	}
 	if err != nil {
 		log.Error().Err(err).Str("component", "rules engine").Str("rule", nods.Query).Msg("rule evaluation")
+		trace.fail(err)
 		return nil, err
 	}
+	if trace != nil {
+		trace.Result = govaluateResult
+		trace.Missing = missing
+	}
 
 	// leverage sjson to handle putting the interface into proper formatted JSON
 	if nods.Gjson != PASSTHROUGH {
@@ -128,3 +214,144 @@ func (x *RuleProvider) Evaluate(ctx context.Context, nods Item, inputs common.Da
 		return json.Marshal(govaluateResult)
 	}
 }
+
+// resolve looks up every item of the rule at the same time, nested rules fan out the same way
+// and the request memo keeps a vendor shared by several items down to one lookup
+func (x *RuleProvider) resolve(ctx context.Context, rule *CompiledRule, inputs common.DataInputs, trace *RuleTrace) (map[string]interface{}, []string) {
+	values := make([]interface{}, len(rule.Items))
+	present := make([]bool, len(rule.Items))
+	if trace != nil {
+		trace.Items = make([]*ItemTrace, len(rule.Items))
+		for i, item := range rule.Items {
+			trace.Items[i] = &ItemTrace{Item: item}
+		}
+	}
+	wg := sync.WaitGroup{}
+	for i, item := range rule.Items {
+		wg.Add(1)
+		go func(i int, item string) {
+			defer wg.Done()
+			// NOTE:  internal source item routing requires the item to be passed with
+			//   the other parameters for some sources (ie. thing and nods sources), every
+			//   item gets its own copy since they run side by side
+			keys := common.DataInputs{}
+			for k, v := range inputs {
+				keys[k] = v
+			}
+			keys[common.INPUT_RULE] = item
+
+			itemCtx := ctx
+			var node *ItemTrace
+			if trace != nil {
+				node = trace.Items[i]
+				itemCtx = WithTrace(ctx, node)
+			}
+			start := time.Now()
+			result, err := x.router.DataValue(itemCtx, NewItemSplitter(item), keys)
+			if node != nil {
+				node.Finish(start, result, err)
+			}
+			RuleHealthFrom(ctx).Item(result, err)
+			if result == nil {
+				return
+			}
+			log.Debug().Str("component", "rules engine").Str("rule", rule.Item.Query).Str(common.INPUT_RULE, item).Str("result", result.Result.Raw).Msg("rule partial eval")
+			// NOTE:  the empty result sets carry smart defaults, that's what a missing input is
+			//   under the "false" policy, the other policies look at present instead
+			present[i] = err == nil && !result.Result.Unknown
+			if err == nil || err == ErrSourceNotFound || err == common.ErrNoDataPresent {
+				values[i] = ruleParameter(&result.Result)
+				if node != nil {
+					node.defaulted(result.Result.Type, err, values[i])
+				}
+			}
+		}(i, item)
+	}
+	wg.Wait()
+
+	parameters := make(map[string]interface{}, 2*len(rule.Items)+len(rule.Dates))
+	for i, item := range rule.Items {
+		if values[i] != nil {
+			parameters[item] = values[i]
+		}
+		parameters[EXISTS_PREFIX+item] = present[i]
+	}
+
+	// NOTE:  an item only used through exists() isn't missing, exists() is the answer
+	missing := []string{}
+	for i, item := range rule.Items {
+		if !present[i] && slices.Contains(rule.Values, item) {
+			missing = append(missing, item)
+		}
+	}
+	return parameters, missing
+}
+
+const (
+	// a missing input stands in as false, the way rules always worked
+	RULE_MISSING_FALSE = "false"
+	// a missing input makes the rule unknown, unless the rule doesn't depend on it
+	RULE_MISSING_UNKNOWN = "unknown"
+	// a missing input fails the rule
+	RULE_MISSING_FAIL = "fail"
+)
+
+// more missing inputs than this and an unknown rule doesn't try to decide
+const RULE_UNKNOWN_MAX_INPUTS = 8
+
+func (x *CompiledRule) evaluate(parameters map[string]interface{}) (interface{}, error) {
+	if x.Item.Score != nil {
+		return x.score(parameters)
+	}
+	return x.expression.Evaluate(parameters)
+}
+
+// decide evaluates the rule under its missing policy, with "unknown" the answer only stands when
+// no value of the missing inputs could change it, ie. true || unknown is true
+func (x *CompiledRule) decide(parameters map[string]interface{}, missing []string) (interface{}, error) {
+	switch {
+	case len(missing) == 0 || x.Item.Missing == "" || x.Item.Missing == RULE_MISSING_FALSE:
+		return x.evaluate(parameters)
+	case x.Item.Missing == RULE_MISSING_FAIL:
+		return nil, fmt.Errorf("%w: %s", ErrRuleInputMissing, strings.Join(missing, ", "))
+	case len(missing) > RULE_UNKNOWN_MAX_INPUTS:
+		return nil, nil
+	}
+
+	var decided interface{}
+	for combination := 0; combination < 1<<len(missing); combination++ {
+		for i, item := range missing {
+			parameters[item] = combination&(1<<i) != 0
+		}
+		// NOTE:  a missing input that isn't a boolean (ie. [x] > 5) errors out, that's unknown too
+		result, err := x.evaluate(parameters)
+		if err != nil || (combination > 0 && !reflect.DeepEqual(result, decided)) {
+			return nil, nil
+		}
+		decided = result
+	}
+	return decided, nil
+}
+
+func ruleParameter(result *common.DataResult) interface{} {
+	switch result.Type {
+	case common.Boolean:
+		return *result.Bool
+	case common.Date:
+		// NOTE:  greater/less operators don't like strings, hand govaluate the unix time
+		//   it would have made from a date literal
+		return ruleDate(*result.Str)
+	case common.String:
+		return *result.Str
+	case common.Float:
+		return *result.Num
+	case common.Integer:
+		return *result.Num
+	case common.JSON:
+		return *result.Str
+	case common.Null:
+		// NOTE:  rules that can't live with this say Missing "unknown" or "fail"
+		return false
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// reloads invalidate right away, the TTL is for vendors without files (ie. APIs) and for rules
+	// with relative dates, which drift by at most this much
+	RULE_CACHE_TTL = time.Hour
+	// an answer made with items that had no data, a vendor may have it soon
+	RULE_CACHE_ABSENT_TTL = 5 * time.Minute
+)
+
+type ruleHealthContextKey struct{}
+
+// RuleHealth is how the items of one evaluation came back, a failed item (ie. a timeout) left
+// a default in the answer and an absent one had no data, nested rules report to their parent
+type RuleHealth struct {
+	parent *RuleHealth
+	failed int32
+	absent int32
+}
+
+func NewRuleHealth(ctx context.Context) (context.Context, *RuleHealth) {
+	parent, _ := ctx.Value(ruleHealthContextKey{}).(*RuleHealth)
+	x := &RuleHealth{parent: parent}
+	return context.WithValue(ctx, ruleHealthContextKey{}, x), x
+}
+
+func RuleHealthFrom(ctx context.Context) *RuleHealth {
+	health, _ := ctx.Value(ruleHealthContextKey{}).(*RuleHealth)
+	return health
+}
+
+// Item records how one item came back, no data and a missing input are answers of a sort,
+// anything else is a failure
+func (x *RuleHealth) Item(output *common.DataOutput, err error) {
+	if x == nil || (err == nil && output != nil && !output.Result.Unknown) {
+		return
+	}
+	if output != nil && (err == ErrSourceNotFound || output.Error == common.ErrNoDataPresent.Error() || output.Error == ErrMissingInputs.Error() || output.Error == ErrRuleUnknown.Error()) {
+		for health := x; health != nil; health = health.parent {
+			atomic.AddInt32(&health.absent, 1)
+		}
+		return
+	}
+	for health := x; health != nil; health = health.parent {
+		atomic.AddInt32(&health.failed, 1)
+	}
+}
+
+func (x *RuleHealth) Failed() bool {
+	return x != nil && atomic.LoadInt32(&x.failed) > 0
+}
+
+func (x *RuleHealth) Absent() bool {
+	return x != nil && atomic.LoadInt32(&x.absent) > 0
+}
+
+// RuleCache keeps rule outputs, an output is good until one of the vendors under the rule is
+// reloaded or the rule, or a rule it uses, changes
+type RuleCache struct {
+	cache   common.IFastCache
+	sources *SourceWatcher
+}
+
+type ruleCacheEntry struct {
+	data        json.RawMessage
+	generations []int64
+}
+
+func NewRuleCache(cache common.IFastCache, sources *SourceWatcher) *RuleCache {
+	return &RuleCache{
+		cache:   cache,
+		sources: sources}
+}
+
+// key is the rule as it is defined now and the inputs it can see, the rule name and the
+// inputs no source under it reads don't change the answer
+func (x *RuleCache) key(rule *CompiledRule, inputs common.DataInputs) string {
+	key := &strings.Builder{}
+	key.WriteString(CATEGORY_RULE + "/" + rule.fingerprint)
+	for _, input := range rule.inputs {
+		key.WriteString("/" + input + "=" + inputs[input])
+	}
+	return key.String()
+}
+
+func (x *RuleCache) generations(rule *CompiledRule) []int64 {
+	out := make([]int64, len(rule.sources))
+	for i, source := range rule.sources {
+		out[i] = x.sources.Generation(source)
+	}
+	return out
+}
+
+// Lookup answers from the cache or evaluates, outputs with an error or a failed item aren't kept
+// and outputs with an absent item are kept for a short while
+func (x *RuleCache) Lookup(rule *CompiledRule, inputs common.DataInputs, health *RuleHealth, evaluate func() (json.RawMessage, error)) (json.RawMessage, error) {
+	key := x.key(rule, inputs)
+	generations := x.generations(rule)
+	if value, found := x.cache.Get(key); found {
+		if entry, ok := value.(*ruleCacheEntry); ok && slices.Equal(entry.generations, generations) {
+			return entry.data, nil
+		}
+	}
+
+	// NOTE:  generations are taken before evaluating, a reload in the middle leaves a stale entry
+	data, err := evaluate()
+	if err != nil || health.Failed() {
+		return data, err
+	}
+	ttl := RULE_CACHE_TTL
+	if health.Absent() {
+		ttl = RULE_CACHE_ABSENT_TTL
+	}
+	x.cache.Set(key, &ruleCacheEntry{data: data, generations: generations}, ttl)
+	return data, err
+}
@@ -0,0 +1,231 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// versionedSchema lets a test change a rule the way a reload of osintami.json would
+type versionedSchema struct {
+	*memoSchema
+	version int64
+}
+
+func (x *versionedSchema) Version() int64 {
+	return atomic.LoadInt64(&x.version)
+}
+
+func (x *versionedSchema) setQuery(path, query string) {
+	for i := range x.items {
+		if x.items[i].Path == path {
+			x.items[i].Query = query
+		}
+	}
+	atomic.AddInt64(&x.version, 1)
+}
+
+func cachedRuleRouter() (*DataRouter, *countingProvider, *SourceWatcher, *versionedSchema) {
+	schema := &versionedSchema{memoSchema: newMemoSchema()}
+	sources := NewSourceWatcher(NewMockWatcher())
+	tools := mockToolbox()
+	tools.Schema = schema
+	tools.Watcher = sources
+	tools.Cache = common.NewFastCache()
+	router := NewDataRouter(tools)
+	provider := &countingProvider{}
+	for _, source := range []string{"ipsum", "uhb", "maxmind"} {
+		router.instances[source] = NewDataInstance(tools, source, provider)
+	}
+	router.instances[SOURCE_OSINTAMI_NAME] = NewRuleSource(tools, router, schema)
+	return router, provider, sources, schema
+}
+
+func TestRuleCache(t *testing.T) {
+	router, provider, sources, schema := cachedRuleRouter()
+	calls := func() int64 { return atomic.LoadInt64(&provider.calls) }
+
+	output, err := ruleValue(t, router, "rule/osintami/isBot")
+	assert.Nil(t, err)
+	assert.True(t, *output.Result.Bool)
+	assert.Equal(t, int64(3), calls())
+
+	// the rule and every rule under it come from the cache
+	for _, path := range []string{"rule/osintami/isBot", "rule/osintami/isTor", "rule/osintami/isProxy"} {
+		output, err = ruleValue(t, router, path)
+		assert.Nil(t, err, path)
+		assert.True(t, *output.Result.Bool, path)
+	}
+	assert.Equal(t, int64(3), calls())
+
+	// other inputs are other answers
+	router.DataValue(context.TODO(), NewItemSplitter("rule/osintami/isTor"), common.DataInputs{"ip": "4.3.2.1", common.INPUT_RULE: "rule/osintami/isTor"})
+	assert.Equal(t, int64(5), calls())
+	// and inputs the rule doesn't use aren't
+	router.DataValue(context.TODO(), NewItemSplitter("rule/osintami/isTor"), common.DataInputs{"ip": "4.3.2.1", "email": "jane@example.com", common.INPUT_RULE: "rule/osintami/isTor"})
+	assert.Equal(t, int64(5), calls())
+
+	// a vendor no rule uses changes nothing, a vendor under the rule does
+	sources.Bump("nope")
+	ruleValue(t, router, "rule/osintami/isTor")
+	assert.Equal(t, int64(5), calls())
+	sources.Bump("uhb")
+	ruleValue(t, router, "rule/osintami/isTor")
+	assert.Equal(t, int64(7), calls())
+	ruleValue(t, router, "rule/osintami/isVPN")
+	assert.Equal(t, int64(9), calls())
+	ruleValue(t, router, "rule/osintami/isVPN")
+	assert.Equal(t, int64(9), calls())
+
+	// a changed rule, and the rules that use it, start over
+	schema.setQuery("rule/osintami/isTor", "[ip/ipsum/field0] && [ip/uhb/field1]")
+	ruleValue(t, router, "rule/osintami/isVPN")
+	assert.Equal(t, int64(9), calls())
+	ruleValue(t, router, "rule/osintami/isProxy")
+	assert.Equal(t, int64(11), calls())
+
+	// explain always does the work
+	root := &ItemTrace{Item: "rule/osintami/isProxy"}
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: root.Item}
+	router.DataValue(WithTrace(context.TODO(), root), NewItemSplitter(root.Item), inputs)
+	assert.Equal(t, int64(13), calls())
+	assert.NotNil(t, root.Rule)
+}
+
+func TestRuleCacheErrors(t *testing.T) {
+	router, provider, _, schema := cachedRuleRouter()
+	schema.items = append(schema.items, Item{Path: "rule/osintami/isFailing", CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: "isFailing", Type: common.Boolean, Query: "[ip/nope/field0] > 5", Missing: RULE_MISSING_FAIL})
+	atomic.AddInt64(&schema.version, 1)
+
+	for i := 0; i < 2; i++ {
+		_, err := ruleValue(t, router, "rule/osintami/isFailing")
+		assert.Equal(t, common.ErrNoDataPresent, err)
+	}
+	assert.Equal(t, int64(0), atomic.LoadInt64(&provider.calls))
+
+	// no cache without a source watcher
+	router, provider = memoRouter()
+	ruleValue(t, router, "rule/osintami/isTor")
+	ruleValue(t, router, "rule/osintami/isTor")
+	assert.Equal(t, int64(4), atomic.LoadInt64(&provider.calls))
+}
+
+// flakyProvider fails the first lookups, like a vendor that timed out, then answers
+type flakyProvider struct {
+	countingProvider
+	failures int64
+}
+
+func (x *flakyProvider) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	if atomic.AddInt64(&x.failures, -1) >= 0 {
+		atomic.AddInt64(&x.calls, 1)
+		return nil, context.DeadlineExceeded
+	}
+	return x.countingProvider.CategoryInfo(ctx, categoryName, inputs)
+}
+
+func TestRuleCacheFailedItems(t *testing.T) {
+	router, _, _, _ := cachedRuleRouter()
+	uhb := &flakyProvider{failures: 1}
+	router.instances["uhb"] = NewDataInstance(router.tools, "uhb", uhb)
+
+	// the answer stands on ipsum, but uhb timed out so it isn't kept
+	output, err := ruleValue(t, router, "rule/osintami/isTor")
+	assert.Nil(t, err)
+	assert.True(t, *output.Result.Bool)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&uhb.calls))
+
+	// the next call evaluates again, and that answer is kept
+	ruleValue(t, router, "rule/osintami/isTor")
+	assert.Equal(t, int64(2), atomic.LoadInt64(&uhb.calls))
+	ruleValue(t, router, "rule/osintami/isTor")
+	assert.Equal(t, int64(2), atomic.LoadInt64(&uhb.calls))
+
+	// a failure under a nested rule counts for the rule that uses it
+	uhb.failures = 1
+	ruleValue(t, router, "rule/osintami/isProxy")
+	ruleValue(t, router, "rule/osintami/isProxy")
+	assert.Equal(t, int64(4), atomic.LoadInt64(&uhb.calls))
+
+	health := &RuleHealth{}
+	health.Item(&common.DataOutput{Error: common.ErrNoDataPresent.Error()}, common.ErrNoDataPresent)
+	assert.True(t, health.Absent())
+	assert.False(t, health.Failed())
+	health.Item(&common.DataOutput{Error: ErrSourceTimeout.Error()}, common.ErrNoDataPresent)
+	assert.True(t, health.Failed())
+}
+
+func TestRuleGraphDependencies(t *testing.T) {
+	schema := newMemoSchema()
+	graph := NewRuleGraph(schema, schema.ListRulesItems())
+
+	bot, _ := graph.Rule("rule/osintami/isBot")
+	assert.Equal(t, []string{"ipsum", "maxmind", "uhb"}, bot.sources)
+	assert.Equal(t, []string{CATEGORY_IPADDR}, bot.inputs)
+	vpn, _ := graph.Rule("rule/osintami/isVPN")
+	assert.Equal(t, []string{"ipsum", "maxmind"}, vpn.sources)
+
+	// a change to a rule changes the rules that use it
+	rules := schema.ListRulesItems()
+	tor := rules["rule/osintami/isTor"]
+	tor.Query = "[ip/ipsum/field0]"
+	rules[tor.Path] = tor
+	changed := NewRuleGraph(schema, rules)
+	for path, same := range map[string]bool{"rule/osintami/isTor": false, "rule/osintami/isProxy": false, "rule/osintami/isBot": false, "rule/osintami/isVPN": true} {
+		before, _ := graph.Rule(path)
+		after, _ := changed.Rule(path)
+		assert.Equal(t, same, before.fingerprint == after.fingerprint, path)
+	}
+
+	// the name input changes the email answers, region and the ip's country the phone ones
+	rules["rule/osintami/isNamed"] = Item{Path: "rule/osintami/isNamed", CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: "isNamed", Type: common.Boolean, Query: "[email/osintami/isNameMatch]"}
+	rules["rule/osintami/isCell"] = Item{Path: "rule/osintami/isCell", CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: "isCell", Type: common.Boolean, Query: "[phone/osintami/isMobile] && [rule/osintami/isNamed]"}
+	changed = NewRuleGraph(schema, rules)
+	named, _ := changed.Rule("rule/osintami/isNamed")
+	assert.Nil(t, named.Err)
+	assert.Equal(t, []string{CATEGORY_EMAIL, INPUT_NAME}, named.inputs)
+	cell, _ := changed.Rule("rule/osintami/isCell")
+	assert.Equal(t, []string{CATEGORY_EMAIL, CATEGORY_IPADDR, INPUT_NAME, CATEGORY_PHONE, INPUT_REGION}, cell.inputs)
+	assert.Equal(t, []string{"maxmind", SOURCE_OSINTAMI_NAME}, cell.sources)
+
+	cache := NewRuleCache(NewMockCache(), nil)
+	jane := common.DataInputs{CATEGORY_EMAIL: "jane.doe@example.com", INPUT_NAME: "Jane Doe"}
+	john := common.DataInputs{CATEGORY_EMAIL: "jane.doe@example.com", INPUT_NAME: "John Smith"}
+	assert.NotEqual(t, cache.key(named, jane), cache.key(named, john))
+	assert.NotEqual(t, cache.key(cell, common.DataInputs{CATEGORY_PHONE: "3220543290", CATEGORY_IPADDR: "1.2.3.4"}), cache.key(cell, common.DataInputs{CATEGORY_PHONE: "3220543290", CATEGORY_IPADDR: "4.3.2.1"}))
+}
+
+// mockCallbackWatcher hands back the callbacks so a test can play the file system
+type mockCallbackWatcher struct {
+	refresh map[string]func()
+}
+
+func (x *mockCallbackWatcher) Add(file string, refresh func()) error {
+	x.refresh[file] = refresh
+	return nil
+}
+
+func (x *mockCallbackWatcher) Listen() {
+}
+
+func TestSourceWatcher(t *testing.T) {
+	files := &mockCallbackWatcher{refresh: make(map[string]func())}
+	sources := NewSourceWatcher(files)
+
+	reloaded := 0
+	sources.Add("/home/osintami/data/udger.bot.mmdb", func() { reloaded++ })
+	sources.Add("/home/osintami/data/udger.bot.json", func() { reloaded++ })
+	assert.Equal(t, int64(0), sources.Generation("udger.bot"))
+
+	files.refresh["/home/osintami/data/udger.bot.mmdb"]()
+	files.refresh["/home/osintami/data/udger.bot.json"]()
+	assert.Equal(t, 2, reloaded)
+	assert.Equal(t, int64(2), sources.Generation("udger.bot"))
+	assert.Equal(t, int64(0), sources.Generation("udger"))
+	assert.Equal(t, "ipsum", SourceOfFile("ipsum.fast"))
+}
@@ -0,0 +1,257 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/tidwall/gjson"
+)
+
+// RuleFixture is one case for a rule, the items listed stand in for the vendors and the items
+// that aren't listed have no data, an Expect of null is an unknown result and an item value
+// like @{1.months.ago} is a date relative to the run, same as in a query
+type RuleFixture struct {
+	Name   string
+	Rule   string
+	Inputs common.DataInputs
+	Items  map[string]interface{}
+	Expect interface{}
+	Error  string `json:",omitempty"`
+}
+
+type RuleFixtureResult struct {
+	Fixture *RuleFixture
+	Got     interface{}
+	Error   string
+	Passed  bool
+}
+
+// FixtureRouter answers items from the fixture, rules are evaluated for real against them
+type FixtureRouter struct {
+	schema   IDataSchema
+	items    map[string]interface{}
+	rules    IDataSource
+	response *DataResponse
+	dates    common.DateStuff
+}
+
+func NewFixtureRouter(schema IDataSchema, items map[string]interface{}) *FixtureRouter {
+	x := &FixtureRouter{
+		schema:   schema,
+		items:    items,
+		response: NewDataResponse(),
+		dates:    *common.NewDateStuff()}
+	x.rules = NewRuleSource(&Toolbox{Schema: schema}, x, schema)
+	return x
+}
+
+func (x *FixtureRouter) Init() {
+}
+
+func (x *FixtureRouter) DataValue(ctx context.Context, uri *DataURI, inputs common.DataInputs) (*common.DataOutput, error) {
+	path := uri.Key()
+	if uri.CategoryName == CATEGORY_RULE {
+		item, err := x.schema.Item(uri)
+		if err != nil {
+			return x.response.EmptyResponse(common.Null, path, inputs, ErrItemNotFound), ErrItemNotFound
+		}
+		return x.rules.ItemValue(ctx, item, inputs)
+	}
+
+	// NOTE:  vendors without an item file in the schema take the type of the value
+	dataType := common.Null
+	if item, err := x.schema.Item(uri); err == nil {
+		dataType = item.Type
+	}
+	value, ok := x.items[path]
+	if !ok {
+		return x.response.EmptyResponse(dataType, path, inputs, common.ErrNoDataPresent), common.ErrNoDataPresent
+	}
+	if date, ok := value.(string); ok {
+		if match := dateRegex.FindStringSubmatch(date); match != nil && match[0] == date {
+			value = x.dates.AgoStringToDate(match[1])
+		}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return x.response.EmptyResponse(dataType, path, inputs, ErrBadData), ErrBadData
+	}
+	out := gjson.ParseBytes(data)
+	if dataType == common.Null {
+		dataType = ruleOutputType("", out)
+	}
+	return &common.DataOutput{
+		Item:   path,
+		Keys:   inputs,
+		Result: x.response.MarshalResult(ruleOutputValue(dataType, out), dataType)}, nil
+}
+
+func (x *FixtureRouter) CategoryValues(ctx context.Context, categoryName string, inputs common.DataInputs) ([]*common.DataOutput, error) {
+	return nil, ErrNotImplemented
+}
+
+func LoadRuleFixtures(fixturesFile string) ([]*RuleFixture, error) {
+	fixtures := []*RuleFixture{}
+	if err := common.LoadJson(fixturesFile, &fixtures); err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+// RunRuleFixture evaluates the rule of the fixture against the items of the fixture
+func RunRuleFixture(schema IDataSchema, fixture *RuleFixture) *RuleFixtureResult {
+	inputs := common.DataInputs{}
+	for k, v := range fixture.Inputs {
+		inputs[k] = v
+	}
+	inputs[common.INPUT_RULE] = fixture.Rule
+
+	result := &RuleFixtureResult{Fixture: fixture}
+	output, err := NewFixtureRouter(schema, fixture.Items).DataValue(context.Background(), NewItemSplitter(fixture.Rule), inputs)
+	if err != nil && output.Error != "" && !output.Result.Unknown {
+		result.Error = output.Error
+	} else if !output.Result.Unknown {
+		result.Got = fixtureValue(&output.Result)
+	}
+
+	if fixture.Error != "" {
+		result.Passed = strings.Contains(result.Error, fixture.Error)
+	} else {
+		result.Passed = result.Error == "" && reflect.DeepEqual(fixture.Expect, result.Got)
+	}
+	return result
+}
+
+// fixtureValue is the result the way it reads in a fixture file
+func fixtureValue(result *common.DataResult) interface{} {
+	value := traceValue(result)
+	if result.Type == common.JSON && value != nil {
+		var parsed interface{}
+		if json.Unmarshal([]byte(value.(string)), &parsed) == nil {
+			return parsed
+		}
+	}
+	return value
+}
+
+// LintRules checks the rules file against the schema, a rule that is in the file twice, that
+// doesn't compile, that uses a vendor config.json doesn't have or an item its vendor doesn't
+// have is an error, shadows included
+func LintRules(schema IDataSchema, rulesFile string) []error {
+	items := []Item{}
+	if err := common.LoadJson(rulesFile, &items); err != nil {
+		return []error{err}
+	}
+
+	errs := []error{}
+	rules := make(map[string]Item)
+	for _, item := range items {
+		uri := NewItemSplitter(item.Path)
+		item.CategoryName = uri.CategoryName
+		item.SourceName = uri.SourceName
+		item.Type = item.Type.ToDataType(item.TypeName)
+		if item.CategoryName != CATEGORY_RULE && item.Query == "" {
+			continue
+		}
+		if _, ok := rules[item.Path]; ok {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrDuplicateRule, item.Path))
+		}
+		rules[item.Path] = item
+	}
+
+	// NOTE:  a vendor without an item file (ie. ETLr output not on this box) can't be checked
+	vendors := make(map[string]bool)
+	for _, item := range schema.ListItems() {
+		vendors[item.SourceName] = true
+	}
+
+	graph := NewRuleGraph(schema, rules)
+	for _, path := range graph.Order() {
+		rule, _ := graph.Rule(path)
+		if rule.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, rule.Err))
+			continue
+		}
+		items := rule.Items
+		if rule.shadow != nil {
+			if rule.shadow.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w: %s", path, ErrInvalidShadow, rule.shadow.Err.Error()))
+				continue
+			}
+			items = append(append([]string{}, items...), rule.shadow.Items...)
+		}
+		for _, name := range items {
+			uri := NewItemSplitter(name)
+			switch {
+			case uri.CategoryName == CATEGORY_RULE:
+			case !schema.IsConfigured(uri.SourceName):
+				errs = append(errs, fmt.Errorf("%s: %w: %s", path, ErrUnknownRuleSource, name))
+			case vendors[uri.SourceName] && !schema.IsValidItem(uri):
+				errs = append(errs, fmt.Errorf("%s: %w: %s", path, ErrUnknownRuleItem, name))
+			}
+		}
+	}
+	return errs
+}
+
+// RunRulesTest lints the rules and runs every fixture, it is the nods rules test command
+func RunRulesTest(w io.Writer, schema IDataSchema, rulesFile, fixturesFile string) bool {
+	lint := LintRules(schema, rulesFile)
+	for _, err := range lint {
+		fmt.Fprintf(w, "LINT  %s\n", err.Error())
+	}
+
+	fixtures, err := LoadRuleFixtures(fixturesFile)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL  %s: %s\n", fixturesFile, err.Error())
+		return false
+	}
+	sort.SliceStable(fixtures, func(i, j int) bool {
+		return fixtures[i].Rule < fixtures[j].Rule
+	})
+
+	passed := 0
+	for _, fixture := range fixtures {
+		result := RunRuleFixture(schema, fixture)
+		if result.Passed {
+			passed++
+			fmt.Fprintf(w, "PASS  %s  %s\n", fixture.Rule, fixture.Name)
+			continue
+		}
+		expected, _ := json.Marshal(fixture.Expect)
+		got, _ := json.Marshal(result.Got)
+		if fixture.Error != "" {
+			expected = []byte("error " + fixture.Error)
+		}
+		if result.Error != "" {
+			got = []byte("error " + result.Error)
+		}
+		fmt.Fprintf(w, "FAIL  %s  %s  expected %s, got %s\n", fixture.Rule, fixture.Name, expected, got)
+	}
+
+	// rules without a single fixture are called out, not failed
+	tested := make(map[string]bool)
+	for _, fixture := range fixtures {
+		tested[fixture.Rule] = true
+	}
+	untested := []string{}
+	for path := range schema.ListRulesItems() {
+		if !tested[path] {
+			untested = append(untested, path)
+		}
+	}
+	sort.Strings(untested)
+	for _, path := range untested {
+		fmt.Fprintf(w, "SKIP  %s  no fixtures\n", path)
+	}
+
+	fmt.Fprintf(w, "%d passed, %d failed, %d lint errors\n", passed, len(fixtures)-passed, len(lint))
+	return len(lint) == 0 && passed == len(fixtures)
+}
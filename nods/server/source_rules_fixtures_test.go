@@ -0,0 +1,118 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRulesFixtures runs the production rules against their fixtures, same as nods rules test
+func TestRulesFixtures(t *testing.T) {
+	schema := NewDataSchema(NewMockWatcher(), NewMockCache(), "../", "../schema/")
+	out := &bytes.Buffer{}
+	passed := RunRulesTest(out, schema, "../schema/osintami.json", "../schema/osintami.fixtures.json")
+	assert.True(t, passed, out.String())
+	assert.False(t, strings.Contains(out.String(), "SKIP"), out.String())
+}
+
+func TestRuleFixture(t *testing.T) {
+	schema := missingSchema(RULE_MISSING_UNKNOWN)
+
+	for _, test := range []struct {
+		fixture RuleFixture
+		got     interface{}
+		passed  bool
+	}{
+		{RuleFixture{Rule: "rule/osintami/isBlacklisted", Items: map[string]interface{}{"ip/ipsum/field5": true}, Expect: true}, true, true},
+		{RuleFixture{Rule: "rule/osintami/isBlacklisted", Items: map[string]interface{}{}, Expect: true}, false, false},
+		// vendors without items take the type of the value
+		{RuleFixture{Rule: "rule/osintami/isCounted", Items: map[string]interface{}{"ip/nope/field0": 6}, Expect: true}, true, true},
+		// null is unknown
+		{RuleFixture{Rule: "rule/osintami/isDown", Items: map[string]interface{}{}, Expect: nil}, nil, true},
+		{RuleFixture{Rule: "rule/osintami/isCovered", Items: map[string]interface{}{"ip/ipsum/field0": true}, Expect: nil}, true, false},
+		{RuleFixture{Rule: "rule/osintami/isNope", Items: map[string]interface{}{}, Error: ErrItemNotFound.Error()}, nil, true},
+	} {
+		test.fixture.Inputs = common.DataInputs{"ip": "1.2.3.4"}
+		result := RunRuleFixture(schema, &test.fixture)
+		assert.Equal(t, test.got, result.Got, test.fixture.Rule)
+		assert.Equal(t, test.passed, result.Passed, test.fixture.Rule)
+	}
+}
+
+func TestRuleFixtureRouter(t *testing.T) {
+	schema := newMemoSchema()
+	router := NewFixtureRouter(schema, map[string]interface{}{
+		"ip/ipsum/field5":  true,
+		"ip/nope/location": map[string]interface{}{"city": "Omaha"},
+		"ip/nope/seen":     "@{1.days.ago}",
+		"ip/nope/note":     "seen @{1.days.ago}"})
+
+	output, err := ruleValue(t, router, "ip/ipsum/field5")
+	assert.Nil(t, err)
+	assert.True(t, *output.Result.Bool)
+	output, _ = ruleValue(t, router, "ip/nope/location")
+	assert.Equal(t, common.JSON, output.Result.Type)
+	output, _ = ruleValue(t, router, "ip/nope/seen")
+	assert.Equal(t, 10, len(*output.Result.Str))
+	output, _ = ruleValue(t, router, "ip/nope/note")
+	assert.Equal(t, "seen @{1.days.ago}", *output.Result.Str)
+	_, err = ruleValue(t, router, "ip/uhb/field0")
+	assert.Equal(t, common.ErrNoDataPresent, err)
+	output, err = ruleValue(t, router, "rule/osintami/isBlacklisted")
+	assert.Nil(t, err)
+	assert.True(t, *output.Result.Bool)
+}
+
+func TestLintRules(t *testing.T) {
+	schema, dir := ruleStoreSchema(t)
+	rules := `[
+	{"Item": "rule/osintami/isListed", "Enabled": true, "GJSON": "isListed", "Query": "[ip/ipsum/blacklist.isBlacklisted]", "Type": "Boolean"},
+	{"Item": "rule/osintami/isListed", "Enabled": true, "GJSON": "isListed", "Query": "[ip/ipsum/blacklist.isBlacklisted]", "Type": "Boolean"},
+	{"Item": "rule/osintami/isTypo", "Enabled": true, "GJSON": "isTypo", "Query": "[ip/ipsum/blacklist.isBlacklistd]", "Type": "Boolean"},
+	{"Item": "rule/osintami/isOrphan", "Enabled": true, "GJSON": "isOrphan", "Query": "[rule/osintami/isNope]", "Type": "Boolean"},
+	{"Item": "rule/osintami/isBroken", "Enabled": true, "GJSON": "isBroken", "Query": "[ip/ipsum/blacklist.isBlacklisted]]", "Type": "Boolean"},
+	{"Item": "rule/osintami/isShadowed", "Enabled": true, "GJSON": "isShadowed", "Query": "true", "Shadow": "[ip/ipsum/blacklist.isBlacklistd]", "Type": "Boolean"},
+	{"Item": "rule/osintami/isElsewhere", "Enabled": true, "GJSON": "isElsewhere", "Query": "[ip/nope/vpn.isVPN]", "Type": "Boolean"},
+	{"Item": "rule/osintami/isMisspelled", "Enabled": true, "GJSON": "isMisspelled", "Query": "[ip/ipsom/blacklist.isBlacklisted] || [ip/ipsum/blacklist.isBlacklisted]", "Type": "Boolean"},
+	{"Item": "browser/osintami/family", "Enabled": true, "GJSON": "UserAgent.Family", "Type": "String"}
+]`
+	assert.Nil(t, os.WriteFile(dir+"lint.json", []byte(rules), 0644))
+
+	errs := LintRules(schema, dir+"lint.json")
+	assert.Equal(t, 6, len(errs), errs)
+	for _, target := range []error{ErrDuplicateRule, ErrUnknownRuleItem, ErrUnknownRule, ErrInvalidRule, ErrUnknownRuleSource} {
+		found := false
+		for _, err := range errs {
+			found = found || errors.Is(err, target)
+		}
+		assert.True(t, found, target)
+	}
+
+	assert.Empty(t, LintRules(schema, dir+"osintami.json"))
+	assert.Equal(t, 1, len(LintRules(schema, dir+"nope.json")))
+}
+
+func TestRunRulesTest(t *testing.T) {
+	schema, dir := ruleStoreSchema(t)
+	fixtures := `[
+	{"Name": "listed", "Rule": "rule/osintami/isBlacklisted", "Inputs": {"ip": "1.2.3.4"}, "Items": {"ip/ipsum/blacklist.isBlacklisted": true}, "Expect": true},
+	{"Name": "wrong", "Rule": "rule/osintami/isBlacklisted", "Inputs": {"ip": "1.2.3.4"}, "Items": {}, "Expect": true}
+]`
+	assert.Nil(t, os.WriteFile(dir+"osintami.fixtures.json", []byte(fixtures), 0644))
+
+	out := &bytes.Buffer{}
+	assert.False(t, RunRulesTest(out, schema, dir+"osintami.json", dir+"osintami.fixtures.json"))
+	assert.True(t, strings.Contains(out.String(), "PASS  rule/osintami/isBlacklisted  listed\n"))
+	assert.True(t, strings.Contains(out.String(), "FAIL  rule/osintami/isBlacklisted  wrong  expected true, got false\n"))
+	assert.True(t, strings.HasSuffix(out.String(), "1 passed, 1 failed, 0 lint errors\n"))
+
+	out.Reset()
+	assert.False(t, RunRulesTest(out, schema, dir+"osintami.json", dir+"nope.json"))
+	assert.True(t, strings.HasPrefix(out.String(), "FAIL  "+dir+"nope.json"))
+}
@@ -0,0 +1,269 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Knetic/govaluate"
+)
+
+// RuleFunctionInfo is how a rule function shows up in the data dictionary
+type RuleFunctionInfo struct {
+	Name        string
+	Usage       string
+	Description string
+}
+
+var RULE_FUNCTIONS = []RuleFunctionInfo{
+	{"cidr_match", `cidr_match(ip, "10.0.0.0/8")`, "The IP address is inside the network."},
+	{"in_list", `in_list(value, "listname")`, "The value is on one of the named lists in config.json, case is ignored."},
+	{"regex", `regex(str, "(?i)headless")`, "The string matches the regular expression."},
+	{"days_since", `days_since(date)`, "Whole days from the date until now."},
+	{"distance_km", `distance_km(lat1, lon1, lat2, lon2)`, "Great circle distance in kilometers between two points."},
+	{"coalesce", `coalesce(a, b, "default")`, "The first value that is not missing, false or empty, otherwise the last value."},
+	{"exists", `exists([item])`, "The item has data, the item itself stands in as false when it doesn't."},
+	{"lower", `lower(str)`, "The string in lower case."},
+	{"domain_of", `domain_of(email)`, "The domain of an email address, in lower case."},
+}
+
+// existsRegex finds exists([item]), it is rewritten into the variable ([exists:item]) since a
+// missing item never makes it to a function
+var existsRegex = regexp.MustCompile(`exists\(\s*\[([^\]]+)\]\s*\)`)
+
+// listRegex finds the lists a rule uses so a misspelled list fails when the rule compiles
+var listRegex = regexp.MustCompile(`in_list\([^,]+,\s*["']([^"']+)["']\s*\)`)
+
+// patternRegex finds the constant patterns and networks of regex() and cidr_match() so they are
+// compiled once with the rule
+var patternRegex = regexp.MustCompile(`\b(regex|cidr_match)\([^,]+,\s*["']([^"']+)["']\s*\)`)
+
+const EXISTS_PREFIX = "exists:"
+
+// rulePatterns are the constant patterns and networks of one rule, a pattern that comes from
+// an item is compiled when it is used and not kept
+type rulePatterns struct {
+	regexes  map[string]*regexp.Regexp
+	networks map[string]*net.IPNet
+}
+
+func compilePatterns(query string) (*rulePatterns, error) {
+	patterns := &rulePatterns{regexes: make(map[string]*regexp.Regexp), networks: make(map[string]*net.IPNet)}
+	for _, match := range patternRegex.FindAllStringSubmatch(query, -1) {
+		// NOTE:  the function sees the string the way govaluate reads it, escapes and all
+		pattern := unescapeRuleString(match[2])
+		if match[1] == "regex" {
+			expression, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%w: regex: %s", ErrInvalidRule, err.Error())
+			}
+			patterns.regexes[pattern] = expression
+			continue
+		}
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cidr_match: %s", ErrInvalidRule, err.Error())
+		}
+		patterns.networks[pattern] = network
+	}
+	return patterns, nil
+}
+
+func unescapeRuleString(value string) string {
+	out := strings.Builder{}
+	escaped := false
+	for _, r := range value {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+func (x *rulePatterns) regex(pattern string) (*regexp.Regexp, error) {
+	if x != nil {
+		if expression, ok := x.regexes[pattern]; ok {
+			return expression, nil
+		}
+	}
+	return regexp.Compile(pattern)
+}
+
+func (x *rulePatterns) network(cidr string) (*net.IPNet, error) {
+	if x != nil {
+		if network, ok := x.networks[cidr]; ok {
+			return network, nil
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return network, err
+}
+
+func ruleFunctions(schema IDataSchema, patterns *rulePatterns) map[string]govaluate.ExpressionFunction {
+	return map[string]govaluate.ExpressionFunction{
+		"cidr_match":  func(args ...interface{}) (interface{}, error) { return ruleCidrMatch(patterns, args...) },
+		"in_list":     func(args ...interface{}) (interface{}, error) { return ruleInList(schema, args...) },
+		"regex":       func(args ...interface{}) (interface{}, error) { return ruleRegex(patterns, args...) },
+		"days_since":  ruleDaysSince,
+		"distance_km": ruleDistanceKm,
+		"coalesce":    ruleCoalesce,
+		"exists":      ruleExists,
+		"lower":       ruleLower,
+		"domain_of":   ruleDomainOf,
+	}
+}
+
+func ruleFunctionError(name, needs string) error {
+	return fmt.Errorf("%w: %s needs %s", ErrRuleFunction, name, needs)
+}
+
+func ruleCidrMatch(patterns *rulePatterns, args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, ruleFunctionError("cidr_match", "an ip and a cidr")
+	}
+	cidr, ok := args[1].(string)
+	if !ok {
+		return nil, ruleFunctionError("cidr_match", "a cidr string")
+	}
+	network, err := patterns.network(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cidr_match: %s", ErrRuleFunction, err.Error())
+	}
+	// a missing ip is false, not an error
+	ip, _ := args[0].(string)
+	addr := net.ParseIP(ip)
+	return addr != nil && network.Contains(addr), nil
+}
+
+func ruleInList(schema IDataSchema, args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, ruleFunctionError("in_list", "a value and a list name")
+	}
+	listName, ok := args[1].(string)
+	if !ok {
+		return nil, ruleFunctionError("in_list", "a list name string")
+	}
+	switch value := args[0].(type) {
+	case string:
+		return schema.InList(listName, value)
+	case float64:
+		return schema.InList(listName, fmt.Sprint(value))
+	}
+	// a missing value is on no list, the list still has to exist
+	_, err := schema.InList(listName, "")
+	return false, err
+}
+
+func ruleRegex(patterns *rulePatterns, args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, ruleFunctionError("regex", "a string and a pattern")
+	}
+	pattern, ok := args[1].(string)
+	if !ok {
+		return nil, ruleFunctionError("regex", "a pattern string")
+	}
+	expression, err := patterns.regex(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: regex: %s", ErrRuleFunction, err.Error())
+	}
+	value, ok := args[0].(string)
+	return ok && expression.MatchString(value), nil
+}
+
+func ruleDaysSince(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, ruleFunctionError("days_since", "a date")
+	}
+	// NOTE:  dates reach functions as unix times, same as the operators see them
+	value := args[0]
+	if date, ok := value.(string); ok {
+		value = ruleDate(date)
+	}
+	unix, ok := value.(float64)
+	if !ok {
+		return nil, ruleFunctionError("days_since", "a date")
+	}
+	return math.Floor(time.Since(time.Unix(int64(unix), 0)).Hours() / 24), nil
+}
+
+const EARTH_RADIUS_KM = 6371.0
+
+func ruleDistanceKm(args ...interface{}) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, ruleFunctionError("distance_km", "two latitude and longitude pairs")
+	}
+	radians := make([]float64, 4)
+	for i, arg := range args {
+		degrees, ok := arg.(float64)
+		if !ok {
+			return nil, ruleFunctionError("distance_km", "numbers")
+		}
+		radians[i] = degrees * math.Pi / 180
+	}
+	// haversine
+	dLat := radians[2] - radians[0]
+	dLon := radians[3] - radians[1]
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(radians[0])*math.Cos(radians[2])*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EARTH_RADIUS_KM * math.Asin(math.Sqrt(a)), nil
+}
+
+func ruleCoalesce(args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, ruleFunctionError("coalesce", "at least one value")
+	}
+	for _, arg := range args {
+		if ruleHasValue(arg) {
+			return arg, nil
+		}
+	}
+	return args[len(args)-1], nil
+}
+
+// ruleExists is exists() of anything other than an item, items are rewritten to a variable
+func ruleExists(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, ruleFunctionError("exists", "one item")
+	}
+	return ruleHasValue(args[0]), nil
+}
+
+// ruleHasValue is false for the stand in values of missing items
+func ruleHasValue(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return value
+	case string:
+		return value != ""
+	}
+	return true
+}
+
+func ruleLower(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, ruleFunctionError("lower", "one string")
+	}
+	if value, ok := args[0].(string); ok {
+		return strings.ToLower(value), nil
+	}
+	return args[0], nil
+}
+
+func ruleDomainOf(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, ruleFunctionError("domain_of", "an email address")
+	}
+	email, ok := args[0].(string)
+	if !ok {
+		return "", nil
+	}
+	return strings.ToLower(strings.TrimSpace(email[strings.LastIndex(email, "@")+1:])), nil
+}
@@ -0,0 +1,147 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleFunctions(t *testing.T) {
+	functions := ruleFunctions(NewMockDataSchema(), nil)
+	for _, info := range RULE_FUNCTIONS {
+		assert.NotNil(t, functions[info.Name], info.Name)
+	}
+	assert.Equal(t, len(RULE_FUNCTIONS), len(functions))
+
+	yesterday := float64(time.Now().Add(-36 * time.Hour).Unix())
+	for _, test := range []struct {
+		name   string
+		args   []interface{}
+		result interface{}
+		err    error
+	}{
+		{"cidr_match", []interface{}{"10.1.2.3", "10.0.0.0/8"}, true, nil},
+		{"cidr_match", []interface{}{"11.1.2.3", "10.0.0.0/8"}, false, nil},
+		{"cidr_match", []interface{}{"2001:db8::1", "2001:db8::/32"}, true, nil},
+		{"cidr_match", []interface{}{false, "10.0.0.0/8"}, false, nil},
+		{"cidr_match", []interface{}{"10.1.2.3", "10.0.0.0/88"}, nil, ErrRuleFunction},
+		{"cidr_match", []interface{}{"10.1.2.3"}, nil, ErrRuleFunction},
+		{"in_list", []interface{}{"1.2.3.4", "tor"}, true, nil},
+		{"in_list", []interface{}{"4.3.2.1", "tor"}, false, nil},
+		{"in_list", []interface{}{false, "tor"}, false, nil},
+		{"in_list", []interface{}{"1.2.3.4", "nope"}, false, ErrUnknownList},
+		{"regex", []interface{}{"HeadlessChrome/114", "(?i)headless"}, true, nil},
+		{"regex", []interface{}{"Chrome/114", "(?i)headless"}, false, nil},
+		{"regex", []interface{}{"Chrome/114", "("}, nil, ErrRuleFunction},
+		{"days_since", []interface{}{yesterday}, float64(1), nil},
+		{"days_since", []interface{}{time.Now().AddDate(0, 0, -10).Format("2006-01-02 15:04:05")}, float64(10), nil},
+		{"days_since", []interface{}{"nope"}, nil, ErrRuleFunction},
+		{"days_since", []interface{}{false}, nil, ErrRuleFunction},
+		{"distance_km", []interface{}{41.26, -95.86, 41.26, -95.86}, float64(0), nil},
+		{"distance_km", []interface{}{41.26, -95.86, "nope", -95.86}, nil, ErrRuleFunction},
+		{"coalesce", []interface{}{false, "", "US", "CA"}, "US", nil},
+		{"coalesce", []interface{}{false, "CA"}, "CA", nil},
+		{"coalesce", []interface{}{false, false}, false, nil},
+		{"coalesce", []interface{}{}, nil, ErrRuleFunction},
+		{"exists", []interface{}{"x"}, true, nil},
+		{"exists", []interface{}{false}, false, nil},
+		{"lower", []interface{}{"Gmail.COM"}, "gmail.com", nil},
+		{"lower", []interface{}{float64(1)}, float64(1), nil},
+		{"domain_of", []interface{}{"Jane.Doe@Gmail.COM"}, "gmail.com", nil},
+		{"domain_of", []interface{}{"gmail.com"}, "gmail.com", nil},
+		{"domain_of", []interface{}{false}, "", nil},
+	} {
+		result, err := functions[test.name](test.args...)
+		assert.True(t, errors.Is(err, test.err), test.name, err)
+		assert.Equal(t, test.result, result, test.name, test.args)
+	}
+
+	// omaha to new york
+	km, _ := ruleDistanceKm(41.26, -95.86, 40.71, -74.01)
+	assert.Equal(t, float64(1830), math.Round(km.(float64)/10)*10)
+}
+
+func TestRuleFunctionsCompile(t *testing.T) {
+	schema := newMemoSchema()
+	rule := CompileRule(Item{Query: "exists([ip/ipsum/field0]) && [ip/ipsum/field0] || exists([ip/uhb/field1])"}, schema, schema.ListRulesItems())
+	assert.Nil(t, rule.Err)
+	assert.Equal(t, []string{"ip/ipsum/field0", "ip/uhb/field1"}, rule.Items)
+	assert.Equal(t, []string{"ip/ipsum/field0", "ip/uhb/field1"}, rule.Exists)
+
+	// lists are checked up front, functions are not variables
+	rule = CompileRule(Item{Query: "in_list([ip/ipsum/field0], 'nope')"}, schema, schema.ListRulesItems())
+	assert.True(t, errors.Is(rule.Err, ErrUnknownList))
+	rule = CompileRule(Item{Query: "in_list(lower([ip/ipsum/field0]), \"tor\")"}, schema, schema.ListRulesItems())
+	assert.Nil(t, rule.Err)
+	assert.Equal(t, []string{"ip/ipsum/field0"}, rule.Items)
+	rule = CompileRule(Item{Query: "nope([ip/ipsum/field0])"}, schema, schema.ListRulesItems())
+	assert.True(t, errors.Is(rule.Err, ErrInvalidRule))
+
+	// constant patterns and networks are compiled with the rule, escapes read the way govaluate reads them
+	rule = CompileRule(Item{Query: `regex([ip/ipsum/field0], '^\\d+$') && cidr_match([ip/ipsum/field0], "10.0.0.0/8") && regex(lower([ip/ipsum/field0]), [ip/uhb/field1])`}, schema, schema.ListRulesItems())
+	assert.Nil(t, rule.Err)
+	assert.Equal(t, 1, len(rule.patterns.regexes))
+	assert.True(t, rule.patterns.regexes[`^\d+$`].MatchString("42"))
+	assert.Equal(t, "10.0.0.0/8", rule.patterns.networks["10.0.0.0/8"].String())
+	rule = CompileRule(Item{Query: "regex([ip/ipsum/field0], '(')"}, schema, schema.ListRulesItems())
+	assert.True(t, errors.Is(rule.Err, ErrInvalidRule))
+	rule = CompileRule(Item{Query: "cidr_match([ip/ipsum/field0], '10.0.0.0/88')"}, schema, schema.ListRulesItems())
+	assert.True(t, errors.Is(rule.Err, ErrInvalidRule))
+}
+
+func TestRuleFunctionsEvaluate(t *testing.T) {
+	schema := newMemoSchema()
+	schema.items = append(schema.items,
+		Item{Path: "ip/ipsum/missing", CategoryName: CATEGORY_IPADDR, SourceName: "ipsum", Gjson: "missing", Type: common.String},
+		Item{Path: "ip/maxmind/city", CategoryName: CATEGORY_IPADDR, SourceName: "maxmind", Gjson: "location.city", Type: common.String})
+	router, _ := memoSchemaRouter(schema)
+	rules := NewRuleProvider(router, schema)
+
+	for _, test := range []struct {
+		query  string
+		result interface{}
+	}{
+		{"exists([ip/ipsum/field0]) && !exists([ip/ipsum/missing])", true},
+		{"exists([ip/nope/field0])", false},
+		{"coalesce([ip/ipsum/missing], [ip/maxmind/city], 'Omaha')", "Council Bluffs"},
+		{"lower([ip/maxmind/city]) == 'council bluffs'", true},
+		{"regex([ip/maxmind/city], '^Council') && cidr_match('10.1.2.3', '10.0.0.0/8')", true},
+		{`regex('42', '^\\d+$') && !regex([ip/maxmind/city], [ip/ipsum/missing] + '^Omaha')`, true},
+		{"in_list('1.2.3.4', 'tor') && !in_list([ip/ipsum/missing], 'tor')", true},
+		{"days_since(@{1.years.ago}) >= 365", true},
+		{"distance_km(41.26, -95.86, 41.26, -95.86) < 1", true},
+		{"domain_of('jane@Example.com') == 'example.com'", true},
+	} {
+		data, err := rules.Evaluate(context.TODO(), Item{Query: test.query, Gjson: "Value"}, common.DataInputs{"ip": "1.2.3.4"})
+		assert.Nil(t, err, test.query)
+		output := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal(data, &output))
+		assert.Equal(t, test.result, output["Value"], test.query)
+	}
+}
+
+func TestHandlerSchemaFunctions(t *testing.T) {
+	server := nodsServer(false)
+
+	r := common.BuildRequest(http.MethodGet, "/v1/data/items", nil, map[string]string{"role": "user", "functions": "true"})
+	w := httptest.NewRecorder()
+	server.DictionaryHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "[{\"Name\":\"cidr_match\""))
+
+	r = common.BuildRequest(http.MethodGet, "/v1/data/items", nil, map[string]string{"role": "user", "functions": "true", "csv": "true"})
+	w = httptest.NewRecorder()
+	server.DictionaryHandler(w, r)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "FUNCTION,"))
+	assert.True(t, strings.Contains(w.Body.String(), "domain_of,"))
+}
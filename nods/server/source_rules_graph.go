@@ -0,0 +1,322 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Knetic/govaluate"
+	"golang.org/x/exp/maps"
+)
+
+// CompiledRule is a rule parsed once at schema load, the expression is reused for every
+// evaluation and the dependencies are known up front
+type CompiledRule struct {
+	Item       Item
+	Items      []string
+	Rules      []string
+	Dates      []string
+	Exists     []string
+	Values     []string // items used for their value, not only through exists()
+	Err        error
+	expression *govaluate.EvaluableExpression
+	patterns   *rulePatterns
+	conditions []*CompiledRule
+	shadow     *CompiledRule
+	// the vendors and inputs behind the rule and the rules it uses, and a hash of their
+	// definitions, filled in by the graph for the rule cache
+	sources     []string
+	inputs      []string
+	fingerprint string
+}
+
+// sourceInputs are what a source reads besides the input of its category, the phone source
+// takes the country of the ip input from maxmind when there is no region
+var sourceInputs = map[string]struct {
+	inputs  []string
+	sources []string
+}{
+	CATEGORY_EMAIL + "/" + SOURCE_OSINTAMI_NAME: {inputs: []string{INPUT_NAME}},
+	CATEGORY_PHONE + "/" + SOURCE_OSINTAMI_NAME: {
+		inputs:  []string{INPUT_REGION, CATEGORY_IPADDR},
+		sources: []string{NewItemSplitter(PHONE_IP_COUNTRY_ITEM).SourceName}}}
+
+// RuleGraph holds every compiled rule and the rule to rule dependencies between them
+type RuleGraph struct {
+	rules map[string]*CompiledRule
+	order []string
+}
+
+// Dependents lists the rules that use the given rule directly
+func (x *RuleGraph) Dependents(path string) []string {
+	out := []string{}
+	for _, name := range x.order {
+		for _, dep := range x.rules[name].Rules {
+			if dep == path {
+				out = append(out, name)
+			}
+		}
+	}
+	return out
+}
+
+var dateRegex = regexp.MustCompile(`@{(.*?)}`)
+
+// CompileRule parses the query, dates are relative to the time of evaluation so @{1.years.ago}
+// becomes a variable named [@{1.years.ago}] and is filled in with the other parameters, the
+// same goes for exists([item]) which becomes ([exists:item])
+func CompileRule(nods Item, schema IDataSchema, rules map[string]Item) *CompiledRule {
+	switch nods.Missing {
+	case "", RULE_MISSING_FALSE, RULE_MISSING_UNKNOWN, RULE_MISSING_FAIL:
+	default:
+		return &CompiledRule{Item: nods, Err: fmt.Errorf("%w: %s", ErrInvalidMissingPolicy, nods.Missing)}
+	}
+	if nods.Score != nil {
+		return compileScore(nods, schema, rules)
+	}
+	rule := &CompiledRule{Item: nods}
+
+	query := nods.Query
+	for _, match := range dateRegex.FindAllStringSubmatch(query, -1) {
+		parts := strings.Split(match[1], ".")
+		if len(parts) != 3 {
+			rule.Err = fmt.Errorf("%w: %s", ErrInvalidRuleDate, match[0])
+			return rule
+		}
+	}
+	for _, match := range listRegex.FindAllStringSubmatch(query, -1) {
+		if _, err := schema.InList(match[1], ""); err != nil {
+			rule.Err = err
+			return rule
+		}
+	}
+	patterns, err := compilePatterns(query)
+	if err != nil {
+		rule.Err = err
+		return rule
+	}
+	rule.patterns = patterns
+	query = dateRegex.ReplaceAllString(query, "[@{$1}]")
+	query = existsRegex.ReplaceAllString(query, "(["+EXISTS_PREFIX+"$1])")
+
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(query, ruleFunctions(schema, patterns))
+	if err != nil {
+		rule.Err = fmt.Errorf("%w: %s", ErrInvalidRule, err.Error())
+		return rule
+	}
+	rule.expression = expression
+
+	seen := make(map[string]bool)
+	valued := make(map[string]bool)
+	for _, name := range expression.Vars() {
+		if !strings.HasPrefix(name, "@{") && !strings.HasPrefix(name, EXISTS_PREFIX) {
+			valued[name] = true
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if strings.HasPrefix(name, "@{") {
+			rule.Dates = append(rule.Dates, strings.TrimSuffix(strings.TrimPrefix(name, "@{"), "}"))
+			continue
+		}
+		if strings.HasPrefix(name, EXISTS_PREFIX) {
+			name = strings.TrimPrefix(name, EXISTS_PREFIX)
+			rule.Exists = append(rule.Exists, name)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+		}
+
+		dataURI := NewItemSplitter(name)
+		if dataURI.CategoryName == "unknown" || !schema.IsValidCategory(dataURI.CategoryName) {
+			rule.Err = fmt.Errorf("%w: %s", ErrInvalidRuleReference, name)
+			return rule
+		}
+		if dataURI.CategoryName == CATEGORY_RULE {
+			if _, ok := rules[name]; !ok {
+				rule.Err = fmt.Errorf("%w: %s", ErrUnknownRule, name)
+				return rule
+			}
+			rule.Rules = append(rule.Rules, name)
+		}
+		// NOTE:  items of disabled or unloaded vendors are allowed, they evaluate to their
+		//   defaults just like a vendor that has no data
+		rule.Items = append(rule.Items, name)
+	}
+	for _, name := range rule.Items {
+		if valued[name] {
+			rule.Values = append(rule.Values, name)
+		}
+	}
+
+	// NOTE:  the candidate query never answers for the rule, so it isn't part of the rule's
+	//   items or dependencies and a broken one doesn't break the rule
+	if nods.Shadow != "" {
+		rule.shadow = CompileRule(Item{Path: nods.Path, Query: nods.Shadow, Missing: nods.Missing}, schema, rules)
+	}
+	return rule
+}
+
+// NewRuleGraph compiles every rule, rules that don't parse, reference unknown rules, sit on
+// a cycle or depend on any of those are kept with an error so they fail loudly when asked for
+func NewRuleGraph(schema IDataSchema, rules map[string]Item) *RuleGraph {
+	x := &RuleGraph{rules: make(map[string]*CompiledRule, len(rules))}
+
+	paths := make([]string, 0, len(rules))
+	for path, nods := range rules {
+		x.rules[path] = CompileRule(nods, schema, rules)
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// depth first walk, a rule still on the stack when we reach it again is a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(rules))
+	stack := []string{}
+	var visit func(path string)
+	visit = func(path string) {
+		state[path] = visiting
+		stack = append(stack, path)
+		rule := x.rules[path]
+		for _, dep := range rule.Rules {
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				start := 0
+				for i, name := range stack {
+					if name == dep {
+						start = i
+					}
+				}
+				cycle := append(append([]string{}, stack[start:]...), dep)
+				err := fmt.Errorf("%w: %s", ErrRuleCycle, strings.Join(cycle, " -> "))
+				for _, name := range stack[start:] {
+					if x.rules[name].Err == nil {
+						x.rules[name].Err = err
+					}
+				}
+			}
+			if rule.Err == nil && x.rules[dep].Err != nil {
+				rule.Err = fmt.Errorf("%w: %s", ErrRuleDependency, dep)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[path] = visited
+		x.order = append(x.order, path)
+	}
+	for _, path := range paths {
+		if state[path] == unvisited {
+			visit(path)
+		}
+	}
+	x.dependencies()
+	return x
+}
+
+// dependencies follows every rule down to the vendor items under it, the order has the rules
+// a rule uses ahead of it
+func (x *RuleGraph) dependencies() {
+	for _, path := range x.order {
+		rule := x.rules[path]
+		if rule.Err != nil {
+			continue
+		}
+		sources := make(map[string]bool)
+		inputs := make(map[string]bool)
+		definition, _ := json.Marshal(rule.Item)
+		hash := sha1.New()
+		hash.Write(definition)
+		for _, name := range rule.Items {
+			uri := NewItemSplitter(name)
+			if uri.CategoryName != CATEGORY_RULE {
+				sources[uri.SourceName] = true
+				inputs[uri.CategoryName] = true
+				extra := sourceInputs[uri.CategoryName+"/"+uri.SourceName]
+				for _, input := range extra.inputs {
+					inputs[input] = true
+				}
+				for _, source := range extra.sources {
+					sources[source] = true
+				}
+			}
+		}
+		for _, name := range rule.Rules {
+			dep := x.rules[name]
+			for _, source := range dep.sources {
+				sources[source] = true
+			}
+			for _, input := range dep.inputs {
+				inputs[input] = true
+			}
+			hash.Write([]byte(dep.fingerprint))
+		}
+		rule.sources = maps.Keys(sources)
+		sort.Strings(rule.sources)
+		rule.inputs = maps.Keys(inputs)
+		sort.Strings(rule.inputs)
+		rule.fingerprint = hex.EncodeToString(hash.Sum(nil))
+	}
+}
+
+func (x *RuleGraph) Rule(path string) (*CompiledRule, bool) {
+	rule, ok := x.rules[path]
+	return rule, ok
+}
+
+// Order lists the rules with dependencies ahead of the rules that use them
+func (x *RuleGraph) Order() []string {
+	return x.order
+}
+
+// Errors lists the rules that failed to compile
+func (x *RuleGraph) Errors() map[string]error {
+	out := make(map[string]error)
+	for path, rule := range x.rules {
+		if rule.Err != nil {
+			out[path] = rule.Err
+		}
+	}
+	return out
+}
+
+// the same layouts govaluate uses for date literals, a value it wouldn't parse stays a string
+// so comparisons fail the same way they always have
+var ruleDateLayouts = []string{
+	time.ANSIC,
+	time.UnixDate,
+	time.RubyDate,
+	time.Kitchen,
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02T15Z0700",
+	"2006-01-02T15:04Z0700",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05.999999999Z0700",
+}
+
+func ruleDate(date string) interface{} {
+	for _, layout := range ruleDateLayouts {
+		if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			return float64(t.Unix())
+		}
+	}
+	return date
+}
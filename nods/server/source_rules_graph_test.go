@@ -0,0 +1,127 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func graphRules(queries map[string]string) map[string]Item {
+	rules := make(map[string]Item)
+	for name, query := range queries {
+		path := "rule/osintami/" + name
+		rules[path] = Item{Path: path, CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: name, Type: common.Boolean, Query: query}
+	}
+	return rules
+}
+
+func TestCompileRule(t *testing.T) {
+	rules := graphRules(map[string]string{"isTor": "[ip/ipsum/blacklist.isBlacklisted]"})
+
+	rule := CompileRule(Item{Query: "[email/pwned/breachCount] > 0 && [email/pwned/breachAgeDate] > @{1.years.ago} && [rule/osintami/isTor] && [email/pwned/breachCount] < 10"}, NewMockDataSchema(), rules)
+	assert.Nil(t, rule.Err)
+	// each dependency once, in the order they show up
+	assert.Equal(t, []string{"email/pwned/breachCount", "email/pwned/breachAgeDate", "rule/osintami/isTor"}, rule.Items)
+	assert.Equal(t, []string{"rule/osintami/isTor"}, rule.Rules)
+	assert.Equal(t, []string{"1.years.ago"}, rule.Dates)
+
+	for _, test := range []struct {
+		query string
+		err   error
+	}{
+		{"[ip/ipsum/blacklist.isBlacklisted] ||", ErrInvalidRule},
+		{"[ip/ipsum/blacklist.isBlacklisted]]", ErrInvalidRule},
+		{"1<=nope", ErrInvalidRuleReference},
+		{"[nope/ipsum/blacklist.isBlacklisted]", ErrInvalidRuleReference},
+		{"[rule/osintami/isCloud]", ErrUnknownRule},
+		{"[email/pwned/breachAgeDate] > @{1.year}", ErrInvalidRuleDate},
+	} {
+		rule := CompileRule(Item{Query: test.query}, NewMockDataSchema(), rules)
+		assert.True(t, errors.Is(rule.Err, test.err), test.query)
+	}
+}
+
+func TestRuleGraph(t *testing.T) {
+	graph := NewRuleGraph(NewMockDataSchema(), graphRules(map[string]string{
+		"isTor":       "[ip/ipsum/blacklist.isBlacklisted]",
+		"isProxy":     "[ip/uhb/blacklist.isBlacklisted] || [rule/osintami/isTor]",
+		"isAnonymous": "[rule/osintami/isProxy] || [rule/osintami/isTor]",
+		"isA":         "[rule/osintami/isB]",
+		"isB":         "[rule/osintami/isC] && [rule/osintami/isTor]",
+		"isC":         "[rule/osintami/isA]",
+		"isSelf":      "[rule/osintami/isSelf]",
+		"isHuman":     "!([rule/osintami/isCloud])",
+		"isBroken":    "[rule/osintami/isHuman] || [rule/osintami/isTor]",
+	}))
+
+	errs := graph.Errors()
+	assert.Equal(t, 6, len(errs))
+	assert.Equal(t, "rule dependency cycle: rule/osintami/isA -> rule/osintami/isB -> rule/osintami/isC -> rule/osintami/isA", errs["rule/osintami/isA"].Error())
+	assert.True(t, errors.Is(errs["rule/osintami/isB"], ErrRuleCycle))
+	assert.True(t, errors.Is(errs["rule/osintami/isC"], ErrRuleCycle))
+	assert.Equal(t, "rule dependency cycle: rule/osintami/isSelf -> rule/osintami/isSelf", errs["rule/osintami/isSelf"].Error())
+	assert.Equal(t, "rule references an unknown rule: rule/osintami/isCloud", errs["rule/osintami/isHuman"].Error())
+	assert.Equal(t, "rule depends on a broken rule: rule/osintami/isHuman", errs["rule/osintami/isBroken"].Error())
+
+	// dependencies come first
+	position := make(map[string]int)
+	for i, path := range graph.Order() {
+		position[path] = i
+	}
+	assert.Equal(t, 9, len(position))
+	assert.Less(t, position["rule/osintami/isTor"], position["rule/osintami/isProxy"])
+	assert.Less(t, position["rule/osintami/isProxy"], position["rule/osintami/isAnonymous"])
+}
+
+func TestRulesRejected(t *testing.T) {
+	schema := &memoSchema{}
+	schema.items = append(newMemoSchema().items, Item{Path: "rule/osintami/isLoop", CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: "isLoop", Type: common.Boolean, Query: "[rule/osintami/isLoop] || [ip/ipsum/field0]"})
+	rules := NewRuleProvider(NewMockDataRouter(false), schema)
+
+	// a rule that would recurse forever fails instead
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: "rule/osintami/isLoop"}
+	_, err := rules.CategoryInfo(context.TODO(), CATEGORY_RULE, inputs)
+	assert.True(t, errors.Is(err, ErrRuleCycle))
+
+	// so does an ad-hoc rule that leans on it
+	_, err = rules.Evaluate(context.TODO(), Item{Query: "[rule/osintami/isTor] && [rule/osintami/isLoop]", Gjson: "Value"}, inputs)
+	assert.Equal(t, "rule depends on a broken rule: rule/osintami/isLoop", err.Error())
+}
+
+func TestRulesConcurrentItems(t *testing.T) {
+	router := &slowDataRouter{MockDataRouter: MockDataRouter{response: NewDataResponse()}}
+	rules := NewRuleProvider(router, NewMockDataSchema())
+
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: "rule/osintami/isBlacklisted"}
+	data, err := rules.CategoryInfo(context.TODO(), CATEGORY_RULE, inputs)
+	assert.Nil(t, err)
+	assert.True(t, gjson.GetBytes(data, "isBlacklisted").Bool())
+	// both items were in flight together
+	assert.Equal(t, int32(2), router.peak)
+	// the caller's inputs are left alone
+	assert.Equal(t, "rule/osintami/isBlacklisted", inputs[common.INPUT_RULE])
+}
+
+func TestRuleDate(t *testing.T) {
+	date := time.Date(2023, 10, 1, 0, 0, 0, 0, time.Local)
+	assert.Equal(t, float64(date.Unix()), ruleDate("2023-10-01"))
+	assert.Equal(t, float64(date.Unix()), ruleDate("2023-10-01 00:00:00"))
+	assert.Equal(t, "0000-00-00 00:00:00", ruleDate("0000-00-00 00:00:00"))
+}
+
+// go test -run XXX -bench BenchmarkRules
+func BenchmarkRules(b *testing.B) {
+	router, _ := memoRouter()
+	rules := NewRuleProvider(router, router.schema)
+	nods := router.schema.ListRulesItems()["rule/osintami/isBot"]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rules.Evaluate(context.TODO(), nods, common.DataInputs{"ip": "1.2.3.4"})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// missingSchema has rules over the vendor nope, which is never there
+func missingSchema(missing string) *memoSchema {
+	schema := newMemoSchema()
+	rules := map[string]string{
+		"isDown":     "[ip/nope/field0]",
+		"isHuman":    "!([rule/osintami/isBot] || [rule/osintami/isDown])",
+		"isUnsure":   "!([rule/osintami/isDown])",
+		"isCovered":  "[ip/nope/field0] || [ip/ipsum/field0]",
+		"isCounted":  "[ip/nope/field0] > 5",
+		"isChecked":  "!exists([ip/nope/field0]) && [ip/ipsum/field0]",
+		"isExplicit": "[ip/nope/field0] == [ip/nope/field1]",
+	}
+	for name, query := range rules {
+		schema.items = append(schema.items, Item{Path: "rule/osintami/" + name, CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Gjson: name, Type: common.Boolean, Query: query, Missing: missing})
+	}
+	return schema
+}
+
+func ruleValue(t *testing.T, router IDataRouter, path string) (*common.DataOutput, error) {
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: path}
+	return router.DataValue(context.TODO(), NewItemSplitter(path), inputs)
+}
+
+func TestRulesMissingUnknown(t *testing.T) {
+	router, _ := memoSchemaRouter(missingSchema(RULE_MISSING_UNKNOWN))
+
+	for _, test := range []struct {
+		rule    string
+		unknown bool
+		value   bool
+	}{
+		// nothing to go on
+		{"rule/osintami/isDown", true, false},
+		{"rule/osintami/isUnsure", true, false},
+		{"rule/osintami/isCounted", true, false},
+		{"rule/osintami/isExplicit", true, false},
+		// the known inputs decide, whatever the missing ones are
+		{"rule/osintami/isHuman", false, false},
+		{"rule/osintami/isCovered", false, true},
+		// exists() is an answer, not a missing input
+		{"rule/osintami/isChecked", false, true},
+	} {
+		output, err := ruleValue(t, router, test.rule)
+		assert.Equal(t, test.unknown, output.Result.Unknown, test.rule)
+		if test.unknown {
+			assert.Equal(t, common.ErrNoDataPresent, err, test.rule)
+			assert.Equal(t, ErrRuleUnknown.Error(), output.Error)
+		} else {
+			assert.Nil(t, err, test.rule)
+			assert.Equal(t, test.value, *output.Result.Bool, test.rule)
+		}
+	}
+
+	// a human can't be a human only because the sources were down
+	schema := missingSchema(RULE_MISSING_UNKNOWN)
+	for i := range schema.items {
+		if schema.items[i].Path == "rule/osintami/isBot" {
+			schema.items[i].Query = "false"
+		}
+	}
+	router, _ = memoSchemaRouter(schema)
+	output, _ := ruleValue(t, router, "rule/osintami/isHuman")
+	assert.True(t, output.Result.Unknown)
+
+	root := &ItemTrace{Item: "rule/osintami/isHuman"}
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: root.Item}
+	router.DataValue(WithTrace(context.TODO(), root), NewItemSplitter(root.Item), inputs)
+	assert.Equal(t, []string{"rule/osintami/isDown"}, root.Rule.Missing)
+	assert.Nil(t, root.Rule.Result)
+	assert.Equal(t, []string{"ip/nope/field0"}, root.Rule.Items[1].Rule.Missing)
+}
+
+func TestRulesMissingPolicies(t *testing.T) {
+	// the old way, missing is false
+	router, _ := memoSchemaRouter(missingSchema(""))
+	output, err := ruleValue(t, router, "rule/osintami/isUnsure")
+	assert.Nil(t, err)
+	assert.True(t, *output.Result.Bool)
+	assert.False(t, output.Result.Unknown)
+
+	router, _ = memoSchemaRouter(missingSchema(RULE_MISSING_FAIL))
+	output, err = ruleValue(t, router, "rule/osintami/isDown")
+	assert.Equal(t, common.ErrNoDataPresent, err)
+	assert.False(t, output.Result.Unknown)
+	rules := NewRuleProvider(router, router.schema)
+	_, err = rules.Evaluate(context.TODO(), Item{Query: "[ip/nope/field0] || [ip/ipsum/field0]", Missing: RULE_MISSING_FAIL, Gjson: "Value"}, common.DataInputs{"ip": "1.2.3.4"})
+	assert.Equal(t, "rule input missing: ip/nope/field0", err.Error())
+
+	rule := CompileRule(Item{Query: "true", Missing: "maybe"}, router.schema, nil)
+	assert.True(t, errors.Is(rule.Err, ErrInvalidMissingPolicy))
+}
+
+func TestHandlerRuleMissing(t *testing.T) {
+	server := nodsServer(false)
+
+	qParams := map[string]string{"ip": "1.2.3.4", "missing": "unknown", "rule": "!([ip/nope/blacklist.isBlacklisted])"}
+	r := common.BuildRequest(http.MethodGet, "/v1/data/rule", nil, qParams)
+	w := httptest.NewRecorder()
+	server.GetEvaluateHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	output := &common.DataOutput{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), output))
+	assert.True(t, output.Result.Unknown)
+	assert.Equal(t, ErrRuleUnknown.Error(), output.Error)
+	assert.Equal(t, common.DataInputs{"ip": "1.2.3.4"}, output.Keys)
+}
@@ -0,0 +1,150 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"fmt"
+	"sort"
+)
+
+const SCORE_MAX = 100
+
+// ScoreModel turns a set of weighted conditions into a single risk score, several versions of
+// a model can live in the dictionary side by side as rule/osintami/{model}V{version} items
+type ScoreModel struct {
+	Model      string
+	Version    int
+	Min        float64 `json:",omitempty"`
+	Max        float64 `json:",omitempty"`
+	Conditions []ScoreCondition
+}
+
+// ScoreCondition adds its weight to the score when the query holds, the reason code says why
+type ScoreCondition struct {
+	Query       string
+	Weight      float64
+	Reason      string
+	Description string `json:",omitempty"`
+}
+
+type ScoreReason struct {
+	Reason      string
+	Weight      float64
+	Description string `json:",omitempty"`
+}
+
+type ScoreResult struct {
+	Model   string
+	Version int
+	Score   float64
+	Reasons []ScoreReason
+}
+
+// compileScore compiles every condition of the model, the score depends on the union of
+// the items, rules and dates of its conditions
+func compileScore(nods Item, schema IDataSchema, rules map[string]Item) *CompiledRule {
+	rule := &CompiledRule{Item: nods}
+	model := nods.Score
+	if model.Model == "" || model.Version <= 0 || len(model.Conditions) == 0 || model.Max < 0 || model.Min > model.cap() {
+		rule.Err = ErrInvalidScore
+		return rule
+	}
+
+	seen := make(map[string]bool)
+	for _, condition := range model.Conditions {
+		if condition.Reason == "" || condition.Query == "" {
+			rule.Err = fmt.Errorf("%w: %s", ErrInvalidScore, condition.Query)
+			return rule
+		}
+		compiled := CompileRule(Item{Path: nods.Path, Query: condition.Query}, schema, rules)
+		if compiled.Err != nil {
+			rule.Err = compiled.Err
+			return rule
+		}
+		rule.conditions = append(rule.conditions, compiled)
+
+		for _, name := range compiled.Items {
+			if !seen[name] {
+				seen[name] = true
+				rule.Items = append(rule.Items, name)
+			}
+		}
+		for _, name := range compiled.Rules {
+			if !seen["rule:"+name] {
+				seen["rule:"+name] = true
+				rule.Rules = append(rule.Rules, name)
+			}
+		}
+		for _, date := range compiled.Dates {
+			if !seen["@{"+date+"}"] {
+				seen["@{"+date+"}"] = true
+				rule.Dates = append(rule.Dates, date)
+			}
+		}
+		for _, name := range compiled.Values {
+			if !seen["value:"+name] {
+				seen["value:"+name] = true
+				rule.Values = append(rule.Values, name)
+			}
+		}
+		for _, name := range compiled.Exists {
+			if !seen[EXISTS_PREFIX+name] {
+				seen[EXISTS_PREFIX+name] = true
+				rule.Exists = append(rule.Exists, name)
+			}
+		}
+	}
+	return rule
+}
+
+// cap is the top of the score, 100 unless the model says otherwise
+func (x *ScoreModel) cap() float64 {
+	if x.Max == 0 {
+		return SCORE_MAX
+	}
+	return x.Max
+}
+
+// score adds up the weights of the conditions that hold, a condition holds when it evaluates
+// to true, the total is kept between the Min and Max of the model
+func (x *CompiledRule) score(parameters map[string]interface{}) (*ScoreResult, error) {
+	model := x.Item.Score
+	result := &ScoreResult{
+		Model:   model.Model,
+		Version: model.Version,
+		Reasons: []ScoreReason{}}
+
+	for i, condition := range x.conditions {
+		value, err := condition.expression.Evaluate(parameters)
+		if err != nil {
+			return nil, err
+		}
+		if fired, _ := value.(bool); fired {
+			result.Score += model.Conditions[i].Weight
+			result.Reasons = append(result.Reasons, ScoreReason{
+				Reason:      model.Conditions[i].Reason,
+				Weight:      model.Conditions[i].Weight,
+				Description: model.Conditions[i].Description})
+		}
+	}
+	if result.Score > model.cap() {
+		result.Score = model.cap()
+	}
+	if result.Score < model.Min {
+		result.Score = model.Min
+	}
+	return result, nil
+}
+
+// ScoreModels lists the enabled versions of a model, oldest first
+func ScoreModels(schema IDataSchema, model string) []Item {
+	out := []Item{}
+	for _, item := range schema.ListRulesItems() {
+		if item.Enabled && item.Score != nil && item.Score.Model == model {
+			out = append(out, item)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Score.Version < out[j].Score.Version
+	})
+	return out
+}
@@ -0,0 +1,120 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// scoreSchema has two versions of the risk model, every vendor field is true
+func scoreSchema() *memoSchema {
+	schema := newMemoSchema()
+	riskV1 := &ScoreModel{Model: "risk", Version: 1, Max: 60, Conditions: []ScoreCondition{
+		{Query: "[rule/osintami/isTor]", Weight: 40, Reason: "TOR_EXIT", Description: "Tor exit node"},
+		{Query: "[ip/ipsum/field6] && @{1.years.ago} > '2000-01-01'", Weight: 30, Reason: "LISTED"},
+		{Query: "!([ip/uhb/field7])", Weight: 20, Reason: "NOT_SEEN"},
+	}}
+	riskV2 := &ScoreModel{Model: "risk", Version: 2, Min: 10, Conditions: []ScoreCondition{
+		{Query: "[rule/osintami/isTor]", Weight: 25, Reason: "TOR_EXIT"},
+		{Query: "[ip/uhb/field7] == false", Weight: 50, Reason: "NOT_SEEN"},
+	}}
+	schema.items = append(schema.items,
+		Item{Path: "rule/osintami/riskV1", CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Enabled: true, Gjson: "riskV1", Type: common.JSON, Score: riskV1},
+		Item{Path: "rule/osintami/riskV2", CategoryName: CATEGORY_RULE, SourceName: SOURCE_OSINTAMI_NAME, Enabled: true, Gjson: "riskV2", Type: common.JSON, Score: riskV2})
+	return schema
+}
+
+func scoreValue(t *testing.T, output *common.DataOutput) *ScoreResult {
+	assert.Equal(t, common.JSON, output.Result.Type)
+	result := &ScoreResult{}
+	assert.Nil(t, json.Unmarshal([]byte(*output.Result.Str), result))
+	return result
+}
+
+func TestScore(t *testing.T) {
+	router, _ := memoSchemaRouter(scoreSchema())
+
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: "rule/osintami/riskV1"}
+	output, err := router.DataValue(context.TODO(), NewItemSplitter("rule/osintami/riskV1"), inputs)
+	assert.Nil(t, err)
+	result := scoreValue(t, output)
+	assert.Equal(t, "risk", result.Model)
+	assert.Equal(t, 1, result.Version)
+	// 40 + 30 is capped at 60
+	assert.Equal(t, float64(60), result.Score)
+	assert.Equal(t, []ScoreReason{{Reason: "TOR_EXIT", Weight: 40, Description: "Tor exit node"}, {Reason: "LISTED", Weight: 30}}, result.Reasons)
+
+	// nothing fires, the floor holds
+	inputs = common.DataInputs{"ip": "1.2.3.4", common.INPUT_RULE: "rule/osintami/riskV2"}
+	schema := scoreSchema()
+	for i := range schema.items {
+		if schema.items[i].Path == "rule/osintami/isTor" {
+			schema.items[i].Query = "false"
+		}
+	}
+	router, _ = memoSchemaRouter(schema)
+	output, err = router.DataValue(context.TODO(), NewItemSplitter("rule/osintami/riskV2"), inputs)
+	assert.Nil(t, err)
+	result = scoreValue(t, output)
+	assert.Equal(t, float64(10), result.Score)
+	assert.Equal(t, []ScoreReason{}, result.Reasons)
+}
+
+func TestCompileScore(t *testing.T) {
+	schema := scoreSchema()
+	graph := NewRuleGraph(schema, schema.ListRulesItems())
+	assert.Equal(t, 0, len(graph.Errors()))
+	rule, _ := graph.Rule("rule/osintami/riskV1")
+	assert.Equal(t, []string{"rule/osintami/isTor", "ip/ipsum/field6", "ip/uhb/field7"}, rule.Items)
+	assert.Equal(t, []string{"rule/osintami/isTor"}, rule.Rules)
+	assert.Equal(t, []string{"1.years.ago"}, rule.Dates)
+	assert.Equal(t, []string{"rule/osintami/isProxy", "rule/osintami/riskV1", "rule/osintami/riskV2"}, graph.Dependents("rule/osintami/isTor"))
+
+	for _, test := range []struct {
+		model *ScoreModel
+		err   error
+	}{
+		{&ScoreModel{Version: 1, Conditions: []ScoreCondition{{Query: "true", Weight: 1, Reason: "A"}}}, ErrInvalidScore},
+		{&ScoreModel{Model: "risk", Conditions: []ScoreCondition{{Query: "true", Weight: 1, Reason: "A"}}}, ErrInvalidScore},
+		{&ScoreModel{Model: "risk", Version: 1}, ErrInvalidScore},
+		{&ScoreModel{Model: "risk", Version: 1, Min: 50, Max: 40, Conditions: []ScoreCondition{{Query: "true", Weight: 1, Reason: "A"}}}, ErrInvalidScore},
+		{&ScoreModel{Model: "risk", Version: 1, Conditions: []ScoreCondition{{Query: "true", Weight: 1}}}, ErrInvalidScore},
+		{&ScoreModel{Model: "risk", Version: 1, Conditions: []ScoreCondition{{Query: "[ip/ipsum/field0] ||", Weight: 1, Reason: "A"}}}, ErrInvalidRule},
+		{&ScoreModel{Model: "risk", Version: 1, Conditions: []ScoreCondition{{Query: "[rule/osintami/isCloud]", Weight: 1, Reason: "A"}}}, ErrUnknownRule},
+	} {
+		rule := CompileRule(Item{Path: "rule/osintami/riskV3", Score: test.model}, schema, schema.ListRulesItems())
+		assert.True(t, errors.Is(rule.Err, test.err), rule.Err)
+	}
+}
+
+func TestHandlerScore(t *testing.T) {
+	schema := scoreSchema()
+	router, provider := memoSchemaRouter(schema)
+	server := &NormalizedDataServer{router: router, schema: schema, params: common.NewParameterHelper()}
+
+	// every version at once, the vendors are asked once
+	r := common.BuildRequest(http.MethodGet, "/v1/data/score/risk", map[string]string{"model": "risk"}, map[string]string{"ip": "1.2.3.4"})
+	w := httptest.NewRecorder()
+	server.GetScoreHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	outputs := []*common.DataOutput{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &outputs))
+	assert.Equal(t, 2, len(outputs))
+	assert.Equal(t, "rule/osintami/riskV1", outputs[0].Item)
+	assert.Equal(t, common.DataInputs{"ip": "1.2.3.4"}, outputs[0].Keys)
+	assert.Equal(t, float64(60), scoreValue(t, outputs[0]).Score)
+	assert.Equal(t, float64(25), scoreValue(t, outputs[1]).Score)
+	assert.Equal(t, int64(2), provider.calls)
+
+	r = common.BuildRequest(http.MethodGet, "/v1/data/score/nope", map[string]string{"model": "nope"}, map[string]string{"ip": "1.2.3.4"})
+	w = httptest.NewRecorder()
+	server.GetScoreHandler(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
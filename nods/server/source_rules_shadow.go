@@ -0,0 +1,219 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+)
+
+const (
+	// the divergences kept per rule, newest first
+	SHADOW_SAMPLES = 25
+	// shadows run after the response, these keep a slow vendor from piling them up
+	SHADOW_TIMEOUT        = DEFAULT_SOURCE_TIMEOUT
+	SHADOW_MAX_CONCURRENT = 32
+)
+
+type ShadowSample struct {
+	Time    time.Time
+	Inputs  common.DataInputs
+	Primary interface{}
+	Shadow  interface{}
+	Error   string `json:",omitempty"`
+}
+
+// ShadowStats is how a candidate query has done against the rule it would replace, a change to
+// either query starts the counts over
+type ShadowStats struct {
+	Rule        string
+	Query       string
+	Shadow      string
+	Since       time.Time
+	Evaluations int64
+	Divergences int64
+	Errors      int64
+	// shadows not run because too many were running already
+	Skipped int64
+	Samples []ShadowSample
+}
+
+// ShadowStore keeps the shadow results in memory, they are for deciding on a rule change not
+// for an audit trail
+type ShadowStore struct {
+	mu      sync.Mutex
+	samples int
+	stats   map[string]*ShadowStats
+	slots   chan bool
+}
+
+func NewShadowStore(samples int) *ShadowStore {
+	return &ShadowStore{
+		samples: samples,
+		stats:   make(map[string]*ShadowStats),
+		slots:   make(chan bool, SHADOW_MAX_CONCURRENT)}
+}
+
+// ruleStats is the stats of the rule's current candidate, the caller holds the lock
+func (x *ShadowStore) ruleStats(rule Item) *ShadowStats {
+	stats, ok := x.stats[rule.Path]
+	if !ok || stats.Query != rule.Query || stats.Shadow != rule.Shadow {
+		stats = &ShadowStats{
+			Rule:    rule.Path,
+			Query:   rule.Query,
+			Shadow:  rule.Shadow,
+			Since:   time.Now(),
+			Samples: []ShadowSample{}}
+		x.stats[rule.Path] = stats
+	}
+	return stats
+}
+
+// Acquire takes a slot for one shadow, a shadow without one is counted as skipped and the
+// caller doesn't run it
+func (x *ShadowStore) Acquire(rule Item) bool {
+	select {
+	case x.slots <- true:
+		return true
+	default:
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.ruleStats(rule).Skipped++
+	return false
+}
+
+func (x *ShadowStore) Release() {
+	<-x.slots
+}
+
+// Record counts one shadow evaluation, a divergence or an error keeps the inputs as a sample
+func (x *ShadowStore) Record(rule Item, inputs common.DataInputs, primary, shadow interface{}, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	stats := x.ruleStats(rule)
+	stats.Evaluations++
+
+	sample := ShadowSample{Time: time.Now(), Primary: primary, Shadow: shadow}
+	switch {
+	case err != nil:
+		stats.Errors++
+		sample.Error = err.Error()
+	case !reflect.DeepEqual(primary, shadow):
+		stats.Divergences++
+	default:
+		return
+	}
+
+	// NOTE:  internal keys and the api key stay out of the samples
+	sample.Inputs = common.DataInputs{}
+	for k, v := range inputs {
+		sample.Inputs[k] = v
+	}
+	for _, key := range []string{common.INPUT_ROLE, common.INPUT_KEY, common.INPUT_TYPE, common.INPUT_RULE, common.INPUT_MISSING} {
+		delete(sample.Inputs, key)
+	}
+	stats.Samples = append([]ShadowSample{sample}, stats.Samples...)
+	if len(stats.Samples) > x.samples {
+		stats.Samples = stats.Samples[:x.samples]
+	}
+}
+
+// Stats lists the shadowed rules, or only the given one
+func (x *ShadowStore) Stats(path string) []ShadowStats {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	out := []ShadowStats{}
+	for name, stats := range x.stats {
+		if path != "" && name != path {
+			continue
+		}
+		copied := *stats
+		copied.Samples = append([]ShadowSample{}, stats.Samples...)
+		out = append(out, copied)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Rule < out[j].Rule
+	})
+	return out
+}
+
+type shadowContextKey struct{}
+
+// shadowContext keeps the request memo and the source gates and drops the deadline, the trace
+// and the rest of the request, a shadow finishes after the response has gone out and has a
+// deadline of its own
+type shadowContext struct {
+	context.Context
+	memo  *RequestMemo
+	gates interface{}
+}
+
+func newShadowContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, cancel := context.WithTimeout(context.Background(), SHADOW_TIMEOUT)
+	return &shadowContext{Context: timeout, memo: MemoFrom(ctx), gates: ctx.Value(gatesContextKey{})}, cancel
+}
+
+func (x *shadowContext) Value(key interface{}) interface{} {
+	switch key.(type) {
+	case memoContextKey:
+		return x.memo
+	case shadowContextKey:
+		return true
+	case gatesContextKey:
+		return x.gates
+	}
+	// NOTE:  a gate the request held is given back before the shadow runs, the shadow waits its own turn
+	return nil
+}
+
+// InShadow is true while a shadow query is evaluated, rules used by a shadow don't run their
+// own shadows so a shadow can't set off another
+func InShadow(ctx context.Context) bool {
+	shadow, _ := ctx.Value(shadowContextKey{}).(bool)
+	return shadow
+}
+
+// shadow evaluates the candidate query of the rule with the parameters the rule already has,
+// items only the candidate uses are looked up here, the result only goes to the shadow store
+func (x *RuleProvider) shadow(ctx context.Context, rule *CompiledRule, inputs common.DataInputs, parameters map[string]interface{}, primary interface{}) {
+	defer x.shadows.Release()
+	candidate := rule.shadow
+	ctx, cancel := newShadowContext(ctx)
+	defer cancel()
+
+	extra := &CompiledRule{Item: candidate.Item}
+	for _, item := range candidate.Items {
+		if _, ok := parameters[EXISTS_PREFIX+item]; !ok {
+			extra.Items = append(extra.Items, item)
+		}
+	}
+	if len(extra.Items) > 0 {
+		values, _ := x.resolve(ctx, extra, inputs, nil)
+		for name, value := range values {
+			parameters[name] = value
+		}
+	}
+	for _, date := range candidate.Dates {
+		parameters["@{"+date+"}"] = ruleDate(x.dates.AgoStringToDate(date))
+	}
+
+	missing := []string{}
+	for _, item := range candidate.Values {
+		if present, _ := parameters[EXISTS_PREFIX+item].(bool); !present {
+			missing = append(missing, item)
+		}
+	}
+	result, err := candidate.decide(parameters, missing)
+	if err != nil {
+		log.Debug().Err(err).Str("component", "rules engine").Str("rule", rule.Item.Path).Str("shadow", candidate.Item.Query).Msg("shadow evaluation")
+	}
+	x.shadows.Record(rule.Item, inputs, primary, result, err)
+}
@@ -0,0 +1,227 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// shadowSchemaRouter is the memo router with shadow queries on a few rules
+func shadowSchemaRouter(shadows *ShadowStore) (*DataRouter, *countingProvider) {
+	schema := newMemoSchema()
+	rules := []Item{
+		{Path: "rule/osintami/isSame", Query: "[ip/ipsum/field0]", Shadow: "[ip/ipsum/field0] && [ip/ipsum/field1]"},
+		{Path: "rule/osintami/isChanged", Query: "[ip/ipsum/field0]", Shadow: "!([ip/ipsum/field0])"},
+		{Path: "rule/osintami/isWider", Query: "[ip/ipsum/field0]", Shadow: "[ip/maxmind/field7] && [ip/nope/field0]"},
+		{Path: "rule/osintami/isBroken", Query: "[ip/ipsum/field0]", Shadow: "[ip/ipsum/field0"},
+		{Path: "rule/osintami/isOuter", Query: "true", Shadow: "[rule/osintami/isChanged]"},
+	}
+	for _, rule := range rules {
+		rule.CategoryName = CATEGORY_RULE
+		rule.SourceName = SOURCE_OSINTAMI_NAME
+		rule.Gjson = NewItemSplitter(rule.Path).ItemName
+		rule.Type = common.Boolean
+		schema.items = append(schema.items, rule)
+	}
+	router, provider := memoSchemaRouter(schema)
+	tools := *router.tools
+	tools.Shadows = shadows
+	router.instances[SOURCE_OSINTAMI_NAME] = NewRuleSource(&tools, router, schema)
+	return router, provider
+}
+
+func shadowStats(shadows *ShadowStore, path string) *ShadowStats {
+	stats := shadows.Stats(path)
+	if len(stats) == 0 {
+		return nil
+	}
+	return &stats[0]
+}
+
+func TestRulesShadow(t *testing.T) {
+	shadows := NewShadowStore(SHADOW_SAMPLES)
+	router, provider := shadowSchemaRouter(shadows)
+
+	// the response is the rule's, whatever the shadow says
+	for _, path := range []string{"rule/osintami/isSame", "rule/osintami/isChanged", "rule/osintami/isWider", "rule/osintami/isBroken"} {
+		output, err := ruleValue(t, router, path)
+		assert.Nil(t, err, path)
+		assert.True(t, *output.Result.Bool, path)
+	}
+	assert.Eventually(t, func() bool { return len(shadows.Stats("")) == 3 }, time.Second, time.Millisecond)
+
+	// same inputs, no extra lookups
+	stats := shadowStats(shadows, "rule/osintami/isSame")
+	assert.Equal(t, int64(1), stats.Evaluations)
+	assert.Equal(t, int64(0), stats.Divergences)
+	assert.Empty(t, stats.Samples)
+
+	stats = shadowStats(shadows, "rule/osintami/isChanged")
+	assert.Equal(t, int64(1), stats.Divergences)
+	assert.Equal(t, ShadowSample{Time: stats.Samples[0].Time, Inputs: common.DataInputs{"ip": "1.2.3.4"}, Primary: true, Shadow: false}, stats.Samples[0])
+
+	// items only the shadow uses are looked up by the shadow, a missing one is false like always
+	stats = shadowStats(shadows, "rule/osintami/isWider")
+	assert.Equal(t, int64(1), stats.Divergences)
+	assert.Equal(t, false, stats.Samples[0].Shadow)
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&provider.calls) == 5 }, time.Second, time.Millisecond)
+
+	// a broken shadow is never run
+	assert.Nil(t, shadowStats(shadows, "rule/osintami/isBroken"))
+
+	// rules a shadow uses don't run their own shadows
+	ruleValue(t, router, "rule/osintami/isOuter")
+	assert.Eventually(t, func() bool { return shadowStats(shadows, "rule/osintami/isOuter") != nil }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(0), shadowStats(shadows, "rule/osintami/isOuter").Divergences)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(1), shadowStats(shadows, "rule/osintami/isChanged").Evaluations)
+
+	// without a store nothing runs
+	router, _ = memoSchemaRouter(newMemoSchema())
+	_, err := ruleValue(t, router, "rule/osintami/isBot")
+	assert.Nil(t, err)
+}
+
+func TestRulesShadowLimits(t *testing.T) {
+	// no slots, every shadow is skipped and counted
+	shadows := NewShadowStore(SHADOW_SAMPLES)
+	shadows.slots = make(chan bool)
+	router, _ := shadowSchemaRouter(shadows)
+	for i := 0; i < 3; i++ {
+		ruleValue(t, router, "rule/osintami/isChanged")
+	}
+	stats := shadowStats(shadows, "rule/osintami/isChanged")
+	assert.Equal(t, int64(3), stats.Skipped)
+	assert.Equal(t, int64(0), stats.Evaluations)
+
+	// a shadow keeps the memo and has a deadline of its own, not the request's
+	request, cancel := context.WithCancel(WithMemo(context.TODO()))
+	ctx, done := newShadowContext(request)
+	defer done()
+	cancel()
+	assert.Nil(t, ctx.Err())
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(SHADOW_TIMEOUT), deadline, time.Second)
+	assert.Equal(t, MemoFrom(request), MemoFrom(ctx))
+	assert.True(t, InShadow(ctx))
+}
+
+func TestRulesShadowGates(t *testing.T) {
+	// the request holds the only turn at maxmind
+	gates := map[string]chan bool{"maxmind": make(chan bool, 1)}
+	request, release, err := acquireGate(context.WithValue(context.TODO(), gatesContextKey{}, gates), "maxmind")
+	assert.Nil(t, err)
+
+	// the shadow keeps the gates but not the turn, it waits like any other lookup
+	ctx, done := newShadowContext(request)
+	defer done()
+	wait, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, _, err = acquireGate(wait, "maxmind")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	release()
+	_, release, err = acquireGate(ctx, "maxmind")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(gates["maxmind"]))
+	release()
+}
+
+func TestShadowStore(t *testing.T) {
+	shadows := NewShadowStore(2)
+	rule := Item{Path: "rule/osintami/isBot", Query: "[ip/ipsum/field0]", Shadow: "[ip/uhb/field0]"}
+	inputs := common.DataInputs{"ip": "1.2.3.4", common.INPUT_KEY: "secret", common.INPUT_ROLE: "user", common.INPUT_RULE: rule.Path}
+
+	shadows.Record(rule, inputs, true, true, nil)
+	shadows.Record(rule, inputs, true, false, nil)
+	shadows.Record(rule, inputs, false, nil, errors.New("nope"))
+	shadows.Record(rule, common.DataInputs{"ip": "4.3.2.1"}, nil, true, nil)
+
+	stats := shadowStats(shadows, rule.Path)
+	assert.Equal(t, int64(4), stats.Evaluations)
+	assert.Equal(t, int64(2), stats.Divergences)
+	assert.Equal(t, int64(1), stats.Errors)
+	assert.Equal(t, 2, len(stats.Samples))
+	assert.Equal(t, "4.3.2.1", stats.Samples[0].Inputs["ip"])
+	assert.Equal(t, "nope", stats.Samples[1].Error)
+	assert.Equal(t, common.DataInputs{"ip": "1.2.3.4"}, stats.Samples[1].Inputs)
+	assert.Equal(t, "secret", inputs[common.INPUT_KEY])
+
+	// a new candidate starts over
+	rule.Shadow = "[ip/uhb/field1]"
+	shadows.Record(rule, inputs, true, true, nil)
+	stats = shadowStats(shadows, rule.Path)
+	assert.Equal(t, int64(1), stats.Evaluations)
+	assert.Empty(t, stats.Samples)
+
+	shadows.Record(Item{Path: "rule/osintami/isAI"}, inputs, true, true, nil)
+	assert.Equal(t, 2, len(shadows.Stats("")))
+	assert.Equal(t, "rule/osintami/isAI", shadows.Stats("")[0].Rule)
+	assert.Empty(t, shadows.Stats("rule/osintami/isNope"))
+}
+
+func TestRulesShadowCompile(t *testing.T) {
+	schema := newMemoSchema()
+	rule := CompileRule(Item{Query: "[ip/ipsum/field0]", Shadow: "[rule/osintami/isBot] || [ip/uhb/field3]"}, schema, schema.ListRulesItems())
+	assert.Nil(t, rule.Err)
+	// the shadow isn't part of the rule
+	assert.Equal(t, []string{"ip/ipsum/field0"}, rule.Items)
+	assert.Empty(t, rule.Rules)
+	assert.Equal(t, []string{"rule/osintami/isBot", "ip/uhb/field3"}, rule.shadow.Items)
+
+	rule = CompileRule(Item{Query: "[ip/ipsum/field0]", Shadow: "[rule/osintami/isNope]"}, schema, schema.ListRulesItems())
+	assert.Nil(t, rule.Err)
+	assert.True(t, errors.Is(rule.shadow.Err, ErrUnknownRule))
+}
+
+func TestRuleStoreShadow(t *testing.T) {
+	schema, dir := ruleStoreSchema(t)
+	store, _ := NewRuleStore(schema, dir+"osintami.json", dir+"rules_audit.json")
+
+	_, err := store.Create(Item{Path: "rule/osintami/isListed", Enabled: true, Query: "[ip/ipsum/blacklist.isBlacklisted]", Shadow: "[ip/ipsum/blacklist.isBlacklisted] && [rule/osintami/isBlacklisted]"}, "admin@osintami.com")
+	assert.Nil(t, err)
+	item, _ := schema.Item(NewItemSplitter("rule/osintami/isListed"))
+	assert.Equal(t, "[ip/ipsum/blacklist.isBlacklisted] && [rule/osintami/isBlacklisted]", item.Shadow)
+
+	for _, shadow := range []string{"[ip/ipsum/blacklist.isBlacklistd]", "[ip/ipsum/blacklist.isBlacklisted", "[rule/osintami/isNope]"} {
+		_, err = store.Update("rule/osintami/isListed", Item{Enabled: true, Query: "[ip/ipsum/blacklist.isBlacklisted]", Shadow: shadow}, "admin@osintami.com")
+		assert.NotNil(t, err, shadow)
+	}
+	_, err = store.Create(Item{Path: "rule/osintami/isScored", Score: &ScoreModel{Model: "risk", Version: 1, Conditions: []ScoreCondition{{Query: "true", Weight: 1, Reason: "R1"}}}, Shadow: "true"}, "admin@osintami.com")
+	assert.Equal(t, ErrInvalidRuleParam, err)
+}
+
+func TestHandlerRuleShadow(t *testing.T) {
+	server := nodsServer(false)
+
+	w := httptest.NewRecorder()
+	server.RuleShadowHandler(w, buildRuleRequest(http.MethodGet, "", "role=user", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = httptest.NewRecorder()
+	server.RuleShadowHandler(w, buildRuleRequest(http.MethodGet, "", "role=admin", ""))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	shadows := NewShadowStore(SHADOW_SAMPLES)
+	shadows.Record(Item{Path: "rule/osintami/isBot", Query: "true", Shadow: "false"}, common.DataInputs{"ip": "1.2.3.4"}, true, false, nil)
+	shadows.Record(Item{Path: "rule/osintami/isAI", Query: "true", Shadow: "true"}, common.DataInputs{"ip": "1.2.3.4"}, true, true, nil)
+	server.SetShadowStore(shadows)
+
+	w = httptest.NewRecorder()
+	server.RuleShadowHandler(w, buildRuleRequest(http.MethodGet, "", "role=admin&rule=rule%2Fosintami%2FisBot", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	stats := []ShadowStats{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, int64(1), stats[0].Divergences)
+	assert.Equal(t, false, stats[0].Samples[0].Shadow)
+}
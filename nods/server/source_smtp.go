@@ -0,0 +1,262 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcnijman/go-emailaddress"
+	"github.com/osintami/fingerprintz/common"
+	"github.com/osintami/fingerprintz/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	SMTP_STATUS_DELIVERABLE   = "deliverable"
+	SMTP_STATUS_UNDELIVERABLE = "undeliverable"
+	SMTP_STATUS_CATCH_ALL     = "catch-all"
+	SMTP_STATUS_GREYLISTED    = "greylisted"
+	// one connection per MX host this often, with a couple in hand
+	SMTP_MX_INTERVAL = 2 * time.Second
+	SMTP_MX_BURST    = 2
+	// a mailbox answer holds for a day, greylisting clears up in minutes
+	SMTP_CACHE_TTL      = 24 * time.Hour
+	SMTP_GREYLISTED_TTL = 15 * time.Minute
+)
+
+type SMTPInfo struct {
+	Email         string
+	Domain        string
+	MX            string
+	Status        string
+	IsDeliverable bool
+	IsCatchAll    bool
+	IsGreylisted  bool
+	Code          int
+	Message       string
+}
+
+// SMTPSource asks the domain's mail exchanger about a mailbox, EHLO, MAIL FROM and RCPT TO and
+// then QUIT, nothing is ever sent
+type SMTPSource struct {
+	resolver common.IResolver
+	cache    common.IFastCache
+	port     string
+	hello    string
+	from     string
+	mu       sync.Mutex
+	limits   map[string]*rate.Limiter
+	interval time.Duration
+	burst    int
+}
+
+func NewSMTPSource(tools *Toolbox, resolver common.IResolver) IDataSource {
+	return NewDataInstance(tools, SOURCE_SMTP_NAME, &SMTPSource{
+		resolver: resolver,
+		cache:    tools.Cache,
+		port:     tools.SMTPPort,
+		hello:    tools.SMTPHello,
+		from:     tools.SMTPFrom,
+		limits:   make(map[string]*rate.Limiter),
+		interval: SMTP_MX_INTERVAL,
+		burst:    SMTP_MX_BURST})
+}
+
+// NOTE:  not the CachedProvider, it would hold on to a greylisted answer for a day
+func (x *SMTPSource) IsCached() bool {
+	return false
+}
+
+func (x *SMTPSource) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	switch categoryName {
+	case CATEGORY_EMAIL:
+		email := strings.ToLower(strings.TrimSpace(inputs[categoryName]))
+		key := categoryName + "/" + SOURCE_SMTP_NAME + "/" + email
+		if out, found := x.cache.Get(key); found {
+			return out.(json.RawMessage), nil
+		}
+		info, err := x.getMailboxInfo(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		ttl := SMTP_CACHE_TTL
+		if info.IsGreylisted {
+			ttl = SMTP_GREYLISTED_TTL
+		}
+		out, _ := json.Marshal(info)
+		x.cache.Set(key, json.RawMessage(out), ttl)
+		return out, nil
+	}
+	return nil, ErrNotImplemented
+}
+
+func (x *SMTPSource) getMailboxInfo(ctx context.Context, email string) (*SMTPInfo, error) {
+	em, err := emailaddress.Parse(email)
+	if err != nil {
+		return nil, ErrInvalidEmail
+	}
+	info := &SMTPInfo{Email: email, Domain: strings.ToLower(em.Domain)}
+
+	hosts, err := x.mailExchangers(ctx, info.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		info.Status = SMTP_STATUS_UNDELIVERABLE
+		info.Message = "no mail exchanger"
+		return info, nil
+	}
+
+	// NOTE:  the next MX is only tried when one can't be reached, an answer is an answer
+	for _, host := range hosts {
+		info.MX = host
+		err = x.verify(ctx, host, email, info)
+		if err == nil {
+			return info, nil
+		}
+		log.Warn().Err(err).Str("component", SOURCE_SMTP_NAME).Str("mx", host).Str("email", email).Msg("verify")
+		if ctx.Err() != nil || !errors.Is(err, ErrSMTPConnect) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// mailExchangers lists the MX hosts by preference, a domain without MX records takes mail at its
+// own address (RFC 5321) and a null MX takes none
+func (x *SMTPSource) mailExchangers(ctx context.Context, domain string) ([]string, error) {
+	records, err := x.resolver.LookupMX(ctx, domain)
+	if err != nil && !common.IsDNSNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", ErrDNSLookup, err.Error())
+	}
+	if len(records) == 0 {
+		if _, err := x.resolver.LookupHost(ctx, domain); err != nil {
+			if common.IsDNSNotFound(err) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%w: %s", ErrDNSLookup, err.Error())
+		}
+		return []string{domain}, nil
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	hosts := []string{}
+	for _, record := range records {
+		if host := strings.TrimSuffix(strings.ToLower(record.Host), "."); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// limiter is the politeness limit of one MX host, shared by every address it handles
+func (x *SMTPSource) limiter(host string) *rate.Limiter {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	limit, ok := x.limits[host]
+	if !ok {
+		limit = rate.NewLimiter(rate.Every(x.interval), x.burst)
+		x.limits[host] = limit
+	}
+	return limit
+}
+
+func (x *SMTPSource) dial(ctx context.Context, host string) (*smtp.Client, error) {
+	if err := x.limiter(host).Wait(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSMTPConnect, err.Error())
+	}
+	addresses, err := x.resolver.LookupHost(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return nil, fmt.Errorf("%w: %s has no address", ErrSMTPConnect, host)
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addresses[0], x.port))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSMTPConnect, err.Error())
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrSMTPConnect, err.Error())
+	}
+	return client, nil
+}
+
+// verify runs the envelope up to RCPT TO, a mailbox that is taken is asked again with a made
+// up name to tell a real mailbox from a server that takes everything
+func (x *SMTPSource) verify(ctx context.Context, host, email string, info *SMTPInfo) error {
+	client, err := x.dial(ctx, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Hello(x.hello); err != nil {
+		return fmt.Errorf("%w: %s", ErrSMTPRejected, err.Error())
+	}
+	if err := client.Mail(x.from); err != nil {
+		return fmt.Errorf("%w: %s", ErrSMTPRejected, err.Error())
+	}
+
+	code, message, err := smtpReply(client.Rcpt(email))
+	if err != nil {
+		return err
+	}
+	info.Code = code
+	info.Message = message
+	switch {
+	case code >= 400 && code < 500:
+		info.Status = SMTP_STATUS_GREYLISTED
+		info.IsGreylisted = true
+	case code >= 500:
+		info.Status = SMTP_STATUS_UNDELIVERABLE
+	default:
+		info.Status = SMTP_STATUS_DELIVERABLE
+		info.IsDeliverable = true
+		nobody, _, err := smtpReply(client.Rcpt(smtpNobody() + "@" + info.Domain))
+		if err == nil && nobody < 300 {
+			info.Status = SMTP_STATUS_CATCH_ALL
+			info.IsDeliverable = false
+			info.IsCatchAll = true
+		}
+	}
+
+	// NOTE:  the answer is in, a server that hangs up on QUIT changes nothing
+	client.Reset()
+	client.Quit()
+	return nil
+}
+
+// smtpReply splits a RCPT TO reply into its code and text, anything that isn't a reply is an error
+func smtpReply(err error) (int, string, error) {
+	if err == nil {
+		return 250, "", nil
+	}
+	reply := &textproto.Error{}
+	if errors.As(err, &reply) {
+		return reply.Code, reply.Msg, nil
+	}
+	return 0, "", fmt.Errorf("%w: %s", ErrSMTPConnect, err.Error())
+}
+
+// smtpNobody is a mailbox name nobody has
+func smtpNobody() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return "osintami-" + hex.EncodeToString(buf)
+}
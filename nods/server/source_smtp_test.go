@@ -0,0 +1,132 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+// ttlCache remembers how long every entry was meant to last
+type ttlCache struct {
+	common.IFastCache
+	ttls map[string]time.Duration
+}
+
+func (x *ttlCache) Set(key string, value interface{}, duration time.Duration) {
+	x.ttls[key] = duration
+	x.IFastCache.Set(key, value, duration)
+}
+
+func smtpServers(t *testing.T) (*MockDNSServer, *MockSMTPServer) {
+	dns := NewMockDNSServer(t)
+	for _, domain := range []string{"example.com", "catchall.net", "grey.org"} {
+		dns.MX(domain, "mx."+domain, 10)
+		dns.A("mx."+domain, "127.0.0.1")
+	}
+	// the first MX is down, the second one answers
+	dns.MX("unreachable.com", "mx1.unreachable.com", 1)
+	dns.MX("unreachable.com", "mx2.unreachable.com", 2)
+	dns.A("mx1.unreachable.com", "127.0.0.2")
+	dns.A("mx2.unreachable.com", "127.0.0.1")
+	// no MX, mail goes to the domain itself
+	dns.A("nomx.io", "127.0.0.1")
+	dns.MX("nullmx.com", ".", 0)
+	dns.Fail("broken.com")
+
+	smtp := NewMockSMTPServer(t)
+	smtp.Mailbox("jane@example.com", 250)
+	smtp.Mailbox("jane@grey.org", 451)
+	smtp.Mailbox("jane@unreachable.com", 250)
+	smtp.Mailbox("jane@nomx.io", 250)
+	smtp.Domain("catchall.net", 250)
+	return dns, smtp
+}
+
+func smtpTools(smtp *MockSMTPServer) *Toolbox {
+	tools := mockToolbox()
+	tools.Cache = &ttlCache{IFastCache: NewMockCache(), ttls: make(map[string]time.Duration)}
+	tools.SMTPPort = smtp.Port()
+	tools.SMTPHello = "test.osintami.com"
+	tools.SMTPFrom = "verify@osintami.com"
+	return tools
+}
+
+func TestSMTPSource(t *testing.T) {
+	dns, smtp := smtpServers(t)
+	tools := smtpTools(smtp)
+	source := NewSMTPSource(tools, common.NewResolver(dns.Address()))
+	assert.False(t, source.IsCached())
+
+	for email, status := range map[string]string{
+		"Jane@Example.com":     SMTP_STATUS_DELIVERABLE,
+		"john@example.com":     SMTP_STATUS_UNDELIVERABLE,
+		"anyone@catchall.net":  SMTP_STATUS_CATCH_ALL,
+		"jane@grey.org":        SMTP_STATUS_GREYLISTED,
+		"jane@unreachable.com": SMTP_STATUS_DELIVERABLE,
+		"jane@nomx.io":         SMTP_STATUS_DELIVERABLE,
+		"jane@nullmx.com":      SMTP_STATUS_UNDELIVERABLE,
+		"jane@nxdomain.com":    SMTP_STATUS_UNDELIVERABLE,
+	} {
+		data, err := source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: email})
+		assert.Nil(t, err, email)
+		info := gjson.ParseBytes(data)
+		assert.Equal(t, status, info.Get("Status").String(), email)
+		assert.Equal(t, status == SMTP_STATUS_DELIVERABLE, info.Get("IsDeliverable").Bool(), email)
+		assert.Equal(t, status == SMTP_STATUS_CATCH_ALL, info.Get("IsCatchAll").Bool(), email)
+		assert.Equal(t, status == SMTP_STATUS_GREYLISTED, info.Get("IsGreylisted").Bool(), email)
+	}
+
+	data, _ := source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "jane@unreachable.com"})
+	assert.Equal(t, "mx2.unreachable.com", gjson.GetBytes(data, "MX").String())
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "john@example.com"})
+	assert.Equal(t, int64(550), gjson.GetBytes(data, "Code").Int())
+
+	// answers come from the cache, greylisting not for long
+	connections := smtp.Connections()
+	source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "jane@example.com"})
+	assert.Equal(t, connections, smtp.Connections())
+	ttls := tools.Cache.(*ttlCache).ttls
+	assert.Equal(t, SMTP_CACHE_TTL, ttls["email/smtp/jane@example.com"])
+	assert.Equal(t, SMTP_GREYLISTED_TTL, ttls["email/smtp/jane@grey.org"])
+
+	// a lookup that failed is an error and isn't cached
+	_, err := source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "jane@broken.com"})
+	assert.True(t, errors.Is(err, ErrDNSLookup))
+	_, found := ttls["email/smtp/jane@broken.com"]
+	assert.False(t, found)
+
+	_, err = source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "nope"})
+	assert.Equal(t, ErrInvalidEmail, err)
+
+	_, err = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, common.DataInputs{CATEGORY_PHONE: "18001234567"})
+	assert.Equal(t, ErrNotImplemented, err)
+}
+
+func TestSMTPSourcePoliteness(t *testing.T) {
+	dns, smtp := smtpServers(t)
+	tools := smtpTools(smtp)
+	source := NewSMTPSource(tools, common.NewResolver(dns.Address())).(*DataInstance).provider.(*SMTPSource)
+	source.interval = time.Hour
+	source.burst = 1
+
+	_, err := source.CategoryInfo(context.TODO(), CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "john@example.com"})
+	assert.Nil(t, err)
+
+	// the MX host has had its turn, waiting an hour is out of the question
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	connections := smtp.Connections()
+	_, err = source.CategoryInfo(ctx, CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "jane@example.com"})
+	assert.True(t, errors.Is(err, ErrSMTPConnect))
+	assert.Equal(t, connections, smtp.Connections())
+
+	// other MX hosts have their own limits
+	_, err = source.CategoryInfo(ctx, CATEGORY_EMAIL, common.DataInputs{CATEGORY_EMAIL: "jane@grey.org"})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,293 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/osintami/fingerprintz/common"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// more subdomains than this is a host dressed up as someone else (ie. paypal.com.login.secure.example.tk)
+const URL_MAX_SUBDOMAINS = 3
+
+var urlShorteners = []string{
+	"bit.ly",
+	"bit.do",
+	"bl.ink",
+	"buff.ly",
+	"cutt.ly",
+	"goo.gl",
+	"is.gd",
+	"lnkd.in",
+	"ow.ly",
+	"rb.gy",
+	"rebrand.ly",
+	"s.id",
+	"shorturl.at",
+	"t.co",
+	"t.ly",
+	"tiny.cc",
+	"tinyurl.com",
+	"v.gd",
+	"adf.ly",
+	"shorte.st"}
+
+// path and query words of login and account pages, the usual bait
+var urlCredentialWords = []string{
+	"login",
+	"logon",
+	"signin",
+	"sign-in",
+	"password",
+	"passwd",
+	"credential",
+	"verify",
+	"verification",
+	"unlock",
+	"webscr",
+	"wp-login",
+	"wp-admin",
+	"banking",
+	"recover"}
+
+// Cyrillic and Greek letters that pass for Latin ones
+var urlConfusables = map[rune]rune{
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'ӏ': 'l', 'о': 'o',
+	'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'ԝ': 'w', 'х': 'x', 'у': 'y',
+	'α': 'a', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x'}
+
+type URLInfo struct {
+	URL                    string
+	IsValidURL             bool
+	Scheme                 string
+	Host                   string
+	Domain                 string
+	IP                     string
+	IsShortener            bool
+	IsIPHost               bool
+	IsPunycode             bool
+	IsHomograph            bool
+	IsRiskyTLD             bool
+	SubdomainCount         int
+	HasExcessiveSubdomains bool
+	HasCredentialPath      bool
+	HasUserInfo            bool
+}
+
+type URLSource struct {
+	tlds *RiskyTLDs
+}
+
+func NewURLSource(tools *Toolbox, tlds *RiskyTLDs) IDataSource {
+	return NewDataInstance(tools, SOURCE_OSINTAMI_NAME, &URLSource{tlds: tlds})
+}
+
+func (x *URLSource) IsCached() bool {
+	return false
+}
+
+func (x *URLSource) CategoryInfo(ctx context.Context, categoryName string, inputs common.DataInputs) (json.RawMessage, error) {
+	switch categoryName {
+	case CATEGORY_URL:
+		return x.getURLInfo(inputs[categoryName])
+	}
+
+	return nil, ErrNotImplemented
+}
+
+func (x *URLSource) getURLInfo(rawURL string) (json.RawMessage, error) {
+	info := ParseURL(rawURL)
+	if !info.IsValidURL {
+		return json.Marshal(info)
+	}
+
+	info.IsShortener = x.isShortener(info.Host, info.Domain)
+	info.IsRiskyTLD = x.tlds.IsRisky(info.Host)
+	info.HasExcessiveSubdomains = info.SubdomainCount > URL_MAX_SUBDOMAINS
+	info.HasCredentialPath = x.hasCredentialPath(info.URL)
+	if !info.IsIPHost {
+		info.IsHomograph = x.isHomograph(info.Host)
+	}
+
+	return json.Marshal(info)
+}
+
+// ParseURL normalizes a URL, a missing scheme is http, and fills in what the host is
+func ParseURL(rawURL string) *URLInfo {
+	info := &URLInfo{URL: rawURL}
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return info
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	port := parsed.Port()
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		info.IsIPHost = true
+		info.IP = ip.String()
+		host = info.IP
+	} else if ip, ok := ipLiteral(host); ok {
+		// NOTE:  browsers take 0x7f.1, 2130706433 and friends as addresses, so do phishers
+		info.IsIPHost = true
+		info.IP = ip
+		host = ip
+	} else {
+		// NOTE:  unicode hosts are kept in their punycode form, that is what DNS and whois know
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return info
+		}
+		host = ascii
+		info.IsPunycode = strings.HasPrefix(host, "xn--") || strings.Contains(host, ".xn--")
+		domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+		if err != nil {
+			return info
+		}
+		info.Domain = domain
+		if host != domain {
+			info.SubdomainCount = strings.Count(strings.TrimSuffix(host, domain), ".")
+		}
+	}
+
+	info.IsValidURL = true
+	info.Scheme = scheme
+	info.Host = host
+	info.HasUserInfo = parsed.User != nil
+
+	normalized := &url.URL{Scheme: scheme, Host: host, Path: parsed.EscapedPath(), RawQuery: parsed.RawQuery}
+	if info.IsIPHost && strings.Contains(host, ":") {
+		normalized.Host = "[" + host + "]"
+	}
+	if port != "" {
+		normalized.Host += ":" + port
+	}
+	if normalized.Path == "" {
+		normalized.Path = "/"
+	}
+	info.URL = normalized.String()
+	return info
+}
+
+// RouteURLInputs hands the host of the url input to the domain and ip items, an input the
+// caller gave wins
+func RouteURLInputs(inputs common.DataInputs) common.DataInputs {
+	rawURL := inputs[CATEGORY_URL]
+	if rawURL == "" || (inputs[CATEGORY_DOMAIN] != "" && inputs[CATEGORY_IPADDR] != "") {
+		return inputs
+	}
+	info := ParseURL(rawURL)
+	if info.Domain == "" && info.IP == "" {
+		return inputs
+	}
+
+	// NOTE:  callers hold on to their inputs, the routed ones are a copy
+	out := common.DataInputs{}
+	for k, v := range inputs {
+		out[k] = v
+	}
+	if out[CATEGORY_DOMAIN] == "" && info.Domain != "" {
+		out[CATEGORY_DOMAIN] = info.Domain
+	}
+	if out[CATEGORY_IPADDR] == "" && info.IP != "" {
+		out[CATEGORY_IPADDR] = info.IP
+	}
+	return out
+}
+
+func (x *URLSource) isShortener(host, domain string) bool {
+	for _, shortener := range urlShorteners {
+		if host == shortener || domain == shortener {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *URLSource) hasCredentialPath(normalized string) bool {
+	parsed, err := url.Parse(normalized)
+	if err != nil {
+		return false
+	}
+	path := strings.ToLower(parsed.Path + "?" + parsed.RawQuery)
+	for _, word := range urlCredentialWords {
+		if strings.Contains(path, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHomograph looks for punycode labels that mix Latin with look-alike letters of another
+// script, or that are look-alike letters only (ie. xn--80ak6aa92e is аррӏе)
+func (x *URLSource) isHomograph(host string) bool {
+	for _, label := range strings.Split(host, ".") {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		decoded, err := idna.Punycode.ToUnicode(label)
+		if err != nil {
+			continue
+		}
+		latin, confusable, other := 0, 0, 0
+		for _, letter := range decoded {
+			switch {
+			case !unicode.IsLetter(letter):
+			case letter < unicode.MaxASCII:
+				latin++
+			case urlConfusables[letter] != 0:
+				confusable++
+			case unicode.In(letter, unicode.Cyrillic, unicode.Greek):
+				other++
+			}
+		}
+		if confusable > 0 && (latin > 0 || other == 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipLiteral reads the inet_aton forms of an IPv4 address, hex, octal, and fewer than four parts
+// with the last one filling the rest (ie. 0x7f.1 and 2130706433 are 127.0.0.1)
+func ipLiteral(host string) (string, bool) {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return "", false
+	}
+	address := uint64(0)
+	for i, part := range parts {
+		value, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return "", false
+		}
+		if i < len(parts)-1 {
+			if value > 0xff {
+				return "", false
+			}
+			address |= value << (8 * (3 - i))
+			continue
+		}
+		if value >= 1<<(8*(4-i)) {
+			return "", false
+		}
+		address |= value
+	}
+	return net.IPv4(byte(address>>24), byte(address>>16), byte(address>>8), byte(address)).String(), true
+}
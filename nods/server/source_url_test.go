@@ -0,0 +1,103 @@
+// Copyright © 2023 OSINTAMI. This is not yours.
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/osintami/fingerprintz/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestURL(t *testing.T) {
+	tools := mockToolbox()
+	tools.DataPath = "./test/"
+	source := NewURLSource(tools, NewRiskyTLDs(tools))
+
+	inputs := make(map[string]string)
+	inputs[CATEGORY_URL] = "HTTPS://Secure.Login.Account.PayPal.com.Example.TK:443/webscr?cmd=_login#top"
+	data, err := source.CategoryInfo(context.TODO(), CATEGORY_URL, inputs)
+	assert.Nil(t, err)
+	info := gjson.ParseBytes(data)
+	assert.True(t, info.Get("IsValidURL").Bool())
+	assert.Equal(t, "https://secure.login.account.paypal.com.example.tk/webscr?cmd=_login", info.Get("URL").String())
+	assert.Equal(t, "example.tk", info.Get("Domain").String())
+	assert.Equal(t, int64(5), info.Get("SubdomainCount").Int())
+	assert.True(t, info.Get("HasExcessiveSubdomains").Bool())
+	assert.True(t, info.Get("HasCredentialPath").Bool())
+	assert.True(t, info.Get("IsRiskyTLD").Bool())
+	assert.False(t, info.Get("IsShortener").Bool())
+
+	inputs[CATEGORY_URL] = "bit.ly/3xYz"
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_URL, inputs)
+	info = gjson.ParseBytes(data)
+	assert.True(t, info.Get("IsShortener").Bool())
+	assert.Equal(t, "http://bit.ly/3xYz", info.Get("URL").String())
+
+	inputs[CATEGORY_URL] = "http://paypal.com@0x7f.1:8080/login"
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_URL, inputs)
+	info = gjson.ParseBytes(data)
+	assert.True(t, info.Get("IsIPHost").Bool())
+	assert.True(t, info.Get("HasUserInfo").Bool())
+	assert.Equal(t, "127.0.0.1", info.Get("IP").String())
+	assert.Equal(t, "http://127.0.0.1:8080/login", info.Get("URL").String())
+
+	inputs[CATEGORY_URL] = "https://аррӏе.com/"
+	data, _ = source.CategoryInfo(context.TODO(), CATEGORY_URL, inputs)
+	info = gjson.ParseBytes(data)
+	assert.Equal(t, "xn--80ak6aa92e.com", info.Get("Host").String())
+	assert.True(t, info.Get("IsPunycode").Bool())
+	assert.True(t, info.Get("IsHomograph").Bool())
+
+	inputs[CATEGORY_URL] = "http://["
+	data, err = source.CategoryInfo(context.TODO(), CATEGORY_URL, inputs)
+	assert.Nil(t, err)
+	assert.False(t, gjson.GetBytes(data, "IsValidURL").Bool())
+
+	// test error path
+	inputs[CATEGORY_PHONE] = "18001234567"
+	data, err = source.CategoryInfo(context.TODO(), CATEGORY_PHONE, inputs)
+	assert.Equal(t, ErrNotImplemented, err)
+	assert.Nil(t, data)
+}
+
+func TestURLMethods(t *testing.T) {
+	url := &URLSource{}
+
+	// mixed scripts and look-alikes only are homographs, a real Cyrillic name isn't
+	assert.True(t, url.isHomograph("xn--pypal-4ve.com"))
+	assert.True(t, url.isHomograph("xn--80ak6aa92e.com"))
+	assert.False(t, url.isHomograph("xn--e1afmkfd.xn--p1ai"))
+	assert.False(t, url.isHomograph("xn--mnchen-3ya.de"))
+	assert.False(t, url.isHomograph("paypal.com"))
+
+	for host, ip := range map[string]string{"2130706433": "127.0.0.1", "0x7f000001": "127.0.0.1", "0177.0.0.01": "127.0.0.1", "10.1": "10.0.0.1"} {
+		found, ok := ipLiteral(host)
+		assert.True(t, ok, host)
+		assert.Equal(t, ip, found, host)
+	}
+	for _, host := range []string{"example.com", "256.1.1.1", "1.2.3.4.5", "10.0x1000000"} {
+		_, ok := ipLiteral(host)
+		assert.False(t, ok, host)
+	}
+
+	assert.True(t, url.hasCredentialPath("https://example.com/wp-login.php"))
+	assert.False(t, url.hasCredentialPath("https://example.com/blog/"))
+}
+
+func TestRouteURLInputs(t *testing.T) {
+	inputs := common.DataInputs{CATEGORY_URL: "https://www.example.co.uk/path"}
+	routed := RouteURLInputs(inputs)
+	assert.Equal(t, "example.co.uk", routed[CATEGORY_DOMAIN])
+	assert.Equal(t, "", routed[CATEGORY_IPADDR])
+	// the caller's inputs are left alone
+	assert.Equal(t, "", inputs[CATEGORY_DOMAIN])
+
+	routed = RouteURLInputs(common.DataInputs{CATEGORY_URL: "http://[2606:4700::1]/", CATEGORY_DOMAIN: "example.com"})
+	assert.Equal(t, "example.com", routed[CATEGORY_DOMAIN])
+	assert.Equal(t, "2606:4700::1", routed[CATEGORY_IPADDR])
+
+	inputs = common.DataInputs{CATEGORY_IPADDR: "1.2.3.4"}
+	assert.Equal(t, inputs, RouteURLInputs(inputs))
+}
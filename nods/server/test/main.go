@@ -13,6 +13,7 @@ func main() {
 	CreateIpsumMMDB()
 	CreateUHBMMDB()
 	CreateMaxmindDB()
+	CreateASNDBs()
 }
 
 func CreateIpsumMMDB() {
@@ -143,3 +144,26 @@ func CreateMaxmindDB() {
 
 	mmdbwriter.Close("maxmind.mmdb")
 }
+
+func CreateASNDBs() {
+	_, cidr, _ := net.ParseCIDR("1.2.3.0/24")
+
+	mmdbwriter := common.NewMaxmindWriter("asn")
+	entry := mmdbtype.Map{
+		"asn": mmdbtype.Map{
+			"number":    mmdbtype.Uint32(64500),
+			"org":       mmdbtype.String("EXAMPLE-HOSTING-LLC - Example Hosting, LLC"),
+			"type":      mmdbtype.String("hosting"),
+			"country":   mmdbtype.String("US"),
+			"registry":  mmdbtype.String("arin"),
+			"allocated": mmdbtype.String("2015-06-01"),
+		},
+	}
+	mmdbwriter.Insert(cidr, entry)
+	mmdbwriter.Close("asn.mmdb")
+
+	cache := common.NewPersistentCache("asn.fast")
+	raw := `{"asn":{"number":64500,"org":"EXAMPLE-HOSTING-LLC - Example Hosting, LLC","type":"hosting","country":"US","registry":"arin","allocated":"2015-06-01"}}`
+	cache.Set("64500", []byte(raw), -1)
+	cache.Persist()
+}